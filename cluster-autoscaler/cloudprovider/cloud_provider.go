@@ -37,6 +37,8 @@ const (
 	BaiducloudProviderName = "baiducloud"
 	// DigitalOceanProviderName gets the provider name of digitalocean
 	DigitalOceanProviderName = "digitalocean"
+	// VsphereProviderName gets the provider name of vsphere
+	VsphereProviderName = "vsphere"
 	// GceProviderName gets the provider name of gce
 	GceProviderName = "gce"
 	// MagnumProviderName gets the provider name of magnum
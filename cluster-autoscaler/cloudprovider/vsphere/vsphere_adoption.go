@@ -0,0 +1,148 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// adoptTagPrefix + node group name marks a manually created VM an operator
+// wants the autoscaler to claim for that group, e.g. pre-created emergency
+// capacity. The tag is detached once the VM is adopted.
+const adoptTagPrefix = "k8s-adopt-"
+
+// adoptVMs claims untracked VMs into a node group: VMs carrying the
+// group's k8s-adopt-<group> tag and VMs matching the group's
+// adopt-pattern. Adopted VMs get the cluster and group tags, count
+// towards the group's size and can be scaled down like any other node;
+// lacking a created-at stamp they rank as the oldest members. VMs already
+// belonging to the cluster (any group) and excluded VMs are never
+// touched. Only meaningful for tag membership — folder and resource pool
+// groups define membership by placement.
+func (mgr *vsphereManagerGovmomi) adoptVMs(ctx context.Context, nodegroup string) error {
+	if mgr.config.membershipFor(nodegroup) != membershipTags {
+		return nil
+	}
+
+	candidates := make(map[types.ManagedObjectReference]bool)
+	fromTag := make(map[types.ManagedObjectReference]bool)
+
+	adoptTagID, err := mgr.activeTags().GetTagID(ctx, adoptTagPrefix+nodegroup)
+	if err != nil && !isTagNotFound(err) {
+		// No adopt tag at all is the normal case, not an error.
+		return fmt.Errorf("could not look up adopt tag: %v", err)
+	}
+	if adoptTagID != "" {
+		attached, err := mgr.activeTags().ListAttachedObjects(ctx, adoptTagID)
+		if err != nil {
+			return fmt.Errorf("could not list adopt-tagged VMs: %v", err)
+		}
+		for _, ref := range attached {
+			if ref.Reference().Type == "VirtualMachine" {
+				candidates[ref.Reference()] = true
+				fromTag[ref.Reference()] = true
+			}
+		}
+	}
+
+	if pattern := mgr.config.adoptPatternFor(nodegroup); pattern != "" {
+		refs, err := mgr.vmsMatchingPattern(ctx, pattern)
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			candidates[ref] = true
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	clusterTagID, err := mgr.activeTags().GetTagID(ctx, mgr.clusterTagName())
+	if err != nil {
+		return err
+	}
+	clusterRefs, err := mgr.activeTags().ListAttachedObjects(ctx, clusterTagID)
+	if err != nil {
+		return fmt.Errorf("could not list objects with cluster tag: %v", err)
+	}
+	inCluster := make(map[types.ManagedObjectReference]bool, len(clusterRefs))
+	for _, ref := range clusterRefs {
+		inCluster[ref.Reference()] = true
+	}
+	excluded, err := mgr.excludedVMs(ctx)
+	if err != nil {
+		return err
+	}
+
+	var adopted []string
+	for ref := range candidates {
+		if inCluster[ref] || excluded[ref] {
+			continue
+		}
+		if err := mgr.tagNode(ctx, nodegroup, ref); err != nil {
+			return fmt.Errorf("could not adopt VM %s: %v", ref.Value, err)
+		}
+		// Mark the VM as managed, or the created-by check would refuse to
+		// scale it down later.
+		if err := mgr.stampCreatedBy(ctx, ref); err != nil {
+			klog.Warningf("Could not stamp created-by marker on adopted VM %s: %v", ref.Value, err)
+		}
+		if fromTag[ref] {
+			if err := mgr.activeTags().DetachTag(ctx, adoptTagID, ref); err != nil {
+				klog.Warningf("Could not detach adopt tag from %s: %v", ref.Value, err)
+			}
+		}
+		adopted = append(adopted, ref.Value)
+	}
+	if len(adopted) > 0 {
+		klog.V(0).Infof("Adopted %d VMs into node group %s: %s", len(adopted), nodegroup, strings.Join(adopted, ", "))
+	}
+	return nil
+}
+
+// vmsMatchingPattern lists the VMs whose inventory path matches the glob
+// pattern, dropping templates. No match is not an error — the pattern is
+// a standing instruction, usually with nothing to adopt.
+func (mgr *vsphereManagerGovmomi) vmsMatchingPattern(ctx context.Context, pattern string) ([]types.ManagedObjectReference, error) {
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vms, err := finder.VirtualMachineList(ctx, pattern)
+	if err != nil {
+		if _, notFound := err.(*find.NotFoundError); notFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not list VMs matching %s: %v", pattern, err)
+	}
+	refs := make([]types.ManagedObjectReference, 0, len(vms))
+	for _, vm := range vms {
+		if !mgr.config.pathAllowed(vm.InventoryPath) {
+			klog.V(4).Infof("Skipping %s matching %s: outside the allowed paths", vm.InventoryPath, pattern)
+			continue
+		}
+		refs = append(refs, vm.Reference())
+	}
+	return mgr.withoutTemplates(ctx, refs)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// pathAllowed reports whether an inventory path lies under one of the
+// configured allowed paths. With no allowed paths configured everything
+// is allowed.
+func (cfg *ConfigVsphere) pathAllowed(path string) bool {
+	if len(cfg.Global.AllowedPaths) == 0 {
+		return true
+	}
+	for _, allowed := range cfg.Global.AllowedPaths {
+		allowed = strings.TrimSuffix(allowed, "/")
+		if path == allowed || strings.HasPrefix(path, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPathAllowed rejects an inventory path outside the allowed paths.
+func (cfg *ConfigVsphere) checkPathAllowed(kind, path string) error {
+	if cfg.pathAllowed(path) {
+		return nil
+	}
+	return fmt.Errorf("%s %s is outside the allowed paths", kind, path)
+}
+
+// checkNodeGroupPathsAllowed verifies that the folder and every resource
+// pool a node group may place VMs into lie under the allowed paths. It is
+// run at startup for configured node groups and again before each clone,
+// which also covers groups added later through the CRD source.
+func (cfg *ConfigVsphere) checkNodeGroupPathsAllowed(nodegroup string) error {
+	if len(cfg.Global.AllowedPaths) == 0 {
+		return nil
+	}
+	if err := cfg.checkPathAllowed("folder", cfg.folderFor(nodegroup)); err != nil {
+		return err
+	}
+	pools := append([]string{cfg.resourcePoolFor(nodegroup)}, cfg.fallbackResourcePoolsFor(nodegroup)...)
+	for _, pool := range pools {
+		if err := cfg.checkPathAllowed("resource pool", pool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkVMPathAllowed resolves a VM's inventory path and rejects VMs
+// outside the allowed paths, so a bad node group config cannot tag or
+// delete VMs that belong to somebody else.
+func (mgr *vsphereManagerGovmomi) checkVMPathAllowed(ctx context.Context, ref types.ManagedObjectReference) error {
+	if len(mgr.config.Global.AllowedPaths) == 0 {
+		return nil
+	}
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		return err
+	}
+	element, err := finder.Element(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("could not resolve inventory path of %s: %v", ref.Value, err)
+	}
+	return mgr.config.checkPathAllowed("VM", element.Path)
+}
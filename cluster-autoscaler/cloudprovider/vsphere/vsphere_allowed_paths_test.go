@@ -0,0 +1,68 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathAllowed(t *testing.T) {
+	cfg := &ConfigVsphere{}
+	assert.True(t, cfg.pathAllowed("/dc1/vm/anything"), "empty list allows everything")
+
+	cfg.Global.AllowedPaths = []string{"/dc1/vm/team-a/", "/dc1/host/cluster1/Resources/team-a"}
+	for path, allowed := range map[string]bool{
+		"/dc1/vm/team-a":                          true,
+		"/dc1/vm/team-a/node-1":                   true,
+		"/dc1/vm/team-a/sub/node-1":               true,
+		"/dc1/vm/team-ab/node-1":                  false,
+		"/dc1/vm/team-b/node-1":                   false,
+		"/dc1/host/cluster1/Resources/team-a/k8s": true,
+		"/dc1/host/cluster1/Resources":            false,
+	} {
+		assert.Equal(t, allowed, cfg.pathAllowed(path), path)
+	}
+
+	err := cfg.checkPathAllowed("VM", "/dc1/vm/team-b/node-1")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "/dc1/vm/team-b/node-1")
+	}
+}
+
+func TestCheckNodeGroupPathsAllowed(t *testing.T) {
+	cfg := &ConfigVsphere{
+		NodeGroup: map[string]*ConfigNodeGroup{
+			"ng1": {
+				Folder:                "/dc1/vm/team-a/k8s",
+				ResourcePool:          "/dc1/host/cluster1/Resources/team-a",
+				FallbackResourcePools: []string{"/dc1/host/cluster2/Resources/team-b"},
+			},
+		},
+	}
+	assert.NoError(t, cfg.checkNodeGroupPathsAllowed("ng1"), "no allowed paths configured")
+
+	cfg.Global.AllowedPaths = []string{"/dc1/vm/team-a", "/dc1/host/cluster1/Resources/team-a"}
+	err := cfg.checkNodeGroupPathsAllowed("ng1")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "/dc1/host/cluster2/Resources/team-b")
+	}
+
+	cfg.NodeGroup["ng1"].FallbackResourcePools = nil
+	assert.NoError(t, cfg.checkNodeGroupPathsAllowed("ng1"))
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// avoidedHosts returns the hosts currently running VMs that carry the node
+// group's avoid-vms-tagged tag, keyed by host reference value.
+func (mgr *vsphereManagerGovmomi) avoidedHosts(ctx context.Context, nodegroup string) (map[string]bool, error) {
+	tagName := mgr.config.avoidVMsTaggedFor(nodegroup)
+	tagID, err := mgr.activeTags().GetTagID(ctx, tagName)
+	if isTagNotFound(err) {
+		// The tag does not exist, so nothing carries it.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not look up tag %s: %v", tagName, err)
+	}
+	attached, err := mgr.activeTags().ListAttachedObjects(ctx, tagID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list VMs tagged %s: %v", tagName, err)
+	}
+	var vmRefs []types.ManagedObjectReference
+	for _, ref := range attached {
+		if ref.Reference().Type == "VirtualMachine" {
+			vmRefs = append(vmRefs, ref.Reference())
+		}
+	}
+	if len(vmRefs) == 0 {
+		return nil, nil
+	}
+
+	vms, err := mgr.retrieveVMProperties(ctx, vmRefs, []string{"runtime.host"})
+	if err != nil {
+		return nil, err
+	}
+	hosts := make(map[string]bool)
+	for _, vm := range vms {
+		if vm.Runtime.Host != nil {
+			hosts[vm.Runtime.Host.Value] = true
+		}
+	}
+	return hosts, nil
+}
+
+// antiAffinityHost picks the clone target host for a node group avoiding
+// tagged VMs: the least loaded host behind the given resource pool that
+// runs none of them, skipping hosts in maintenance mode. Returns nil when
+// every host runs a tagged VM, leaving placement to DRS — a node on a
+// shared host beats no node at all.
+func (mgr *vsphereManagerGovmomi) antiAffinityHost(ctx context.Context, poolRef types.ManagedObjectReference, nodegroup string) (*types.ManagedObjectReference, error) {
+	avoided, err := mgr.avoidedHosts(ctx, nodegroup)
+	if err != nil {
+		return nil, err
+	}
+
+	collector := property.DefaultCollector(mgr.activeClient().Vim)
+	var pool mo.ResourcePool
+	if err := collector.RetrieveOne(ctx, poolRef, []string{"owner"}, &pool); err != nil {
+		return nil, fmt.Errorf("could not get owner of resource pool %s: %v", poolRef.Value, err)
+	}
+	var cluster mo.ComputeResource
+	if err := collector.RetrieveOne(ctx, pool.Owner, []string{"host"}, &cluster); err != nil {
+		return nil, fmt.Errorf("could not list hosts of %s: %v", pool.Owner.Value, err)
+	}
+	if len(cluster.Host) == 0 {
+		return nil, fmt.Errorf("no hosts behind resource pool %s", poolRef.Value)
+	}
+
+	var hosts []mo.HostSystem
+	if err := collector.Retrieve(ctx, cluster.Host, []string{"vm", "runtime.inMaintenanceMode"}, &hosts); err != nil {
+		return nil, fmt.Errorf("could not get host load: %v", err)
+	}
+
+	var best *types.ManagedObjectReference
+	bestLoad := 0
+	for i := range hosts {
+		host := &hosts[i]
+		if avoided[host.Self.Value] || host.Runtime.InMaintenanceMode {
+			continue
+		}
+		if best == nil || len(host.Vm) < bestLoad {
+			best = &host.Self
+			bestLoad = len(host.Vm)
+		}
+	}
+	if best == nil {
+		klog.Warningf("Every host behind pool %s runs VMs tagged %s, leaving placement of %s nodes to DRS",
+			poolRef.Value, mgr.config.avoidVMsTaggedFor(nodegroup), nodegroup)
+	}
+	return best, nil
+}
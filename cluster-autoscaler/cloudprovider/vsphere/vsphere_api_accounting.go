@@ -0,0 +1,121 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+// apiUsage accumulates vCenter API usage between the per-loop summaries:
+// SOAP and vAPI call counts, inventory cache hits and misses, and the
+// total wall time spent waiting on vCenter. The counters make slow
+// autoscaler loops attributable to vCenter and show whether the caches
+// actually absorb calls.
+type apiUsage struct {
+	mutex       sync.Mutex
+	soapCalls   int
+	vapiCalls   int
+	cacheHits   int
+	cacheMisses int
+	vcenterTime time.Duration
+}
+
+func (u *apiUsage) recordSOAP(elapsed time.Duration) {
+	u.mutex.Lock()
+	u.soapCalls++
+	u.vcenterTime += elapsed
+	u.mutex.Unlock()
+}
+
+func (u *apiUsage) recordVAPI(elapsed time.Duration) {
+	u.mutex.Lock()
+	u.vapiCalls++
+	u.vcenterTime += elapsed
+	u.mutex.Unlock()
+}
+
+// RecordVAPICall implements vsphereapi.CallRecorder, so an instrumented
+// client reports its vAPI calls here.
+func (u *apiUsage) RecordVAPICall(elapsed time.Duration) {
+	u.recordVAPI(elapsed)
+}
+
+func (u *apiUsage) recordCacheHit() {
+	u.mutex.Lock()
+	u.cacheHits++
+	u.mutex.Unlock()
+}
+
+func (u *apiUsage) recordCacheMiss() {
+	u.mutex.Lock()
+	u.cacheMisses++
+	u.mutex.Unlock()
+}
+
+// summaryAndReset returns a one-line usage summary and starts the next
+// accounting period.
+func (u *apiUsage) summaryAndReset() string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	summary := fmt.Sprintf("%d SOAP calls, %d vAPI calls, %d cache hits, %d misses, %s in vCenter",
+		u.soapCalls, u.vapiCalls, u.cacheHits, u.cacheMisses, u.vcenterTime.Round(time.Millisecond))
+	u.soapCalls = 0
+	u.vapiCalls = 0
+	u.cacheHits = 0
+	u.cacheMisses = 0
+	u.vcenterTime = 0
+	return summary
+}
+
+// apiUsageReporter is implemented by managers that account their vCenter
+// API usage; the provider logs the summary once per loop.
+type apiUsageReporter interface {
+	apiUsageSummary() string
+}
+
+// apiUsageSummary returns the usage since the last loop and resets the
+// counters.
+func (mgr *vsphereManagerGovmomi) apiUsageSummary() string {
+	return mgr.usage.summaryAndReset()
+}
+
+// accountingRoundTripper counts every SOAP round trip and the time it
+// takes before handing it to the real transport.
+type accountingRoundTripper struct {
+	next  soap.RoundTripper
+	usage *apiUsage
+}
+
+func (rt *accountingRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	start := time.Now()
+	err := rt.next.RoundTrip(ctx, req, res)
+	rt.usage.recordSOAP(time.Since(start))
+	return err
+}
+
+// instrumentClient starts accounting the client's API usage: SOAP calls
+// are counted by wrapping the round tripper, vAPI calls through the
+// client's call recorder hook.
+func instrumentClient(c *VsphereClient, usage *apiUsage) {
+	c.Vim.RoundTripper = &accountingRoundTripper{next: c.Vim.RoundTripper, usage: usage}
+	c.SetCallRecorder(usage)
+}
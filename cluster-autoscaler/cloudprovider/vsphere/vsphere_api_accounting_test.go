@@ -0,0 +1,39 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIUsageSummary(t *testing.T) {
+	usage := &apiUsage{}
+	usage.recordSOAP(100 * time.Millisecond)
+	usage.recordSOAP(50 * time.Millisecond)
+	usage.recordVAPI(25 * time.Millisecond)
+	usage.recordCacheHit()
+	usage.recordCacheHit()
+	usage.recordCacheMiss()
+
+	assert.Equal(t, "2 SOAP calls, 1 vAPI calls, 2 cache hits, 1 misses, 175ms in vCenter", usage.summaryAndReset())
+
+	// The summary starts a fresh accounting period.
+	assert.Equal(t, "0 SOAP calls, 0 vAPI calls, 0 cache hits, 0 misses, 0s in vCenter", usage.summaryAndReset())
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// auditEntry is one line of the audit trail: a single mutating vCenter
+// operation with its context and outcome.
+type auditEntry struct {
+	Time      string `json:"time"`
+	Action    string `json:"action"`
+	NodeGroup string `json:"nodeGroup,omitempty"`
+	Node      string `json:"node,omitempty"`
+	// Reason is why the operation happened, e.g. "scale-up" or
+	// "scale-down".
+	Reason string `json:"reason,omitempty"`
+	// TaskID is the vCenter task reference, empty when the operation never
+	// produced a task.
+	TaskID string `json:"taskId,omitempty"`
+	// Outcome is "ok" or the error the operation failed with.
+	Outcome string `json:"outcome"`
+}
+
+// auditLog is an append-only JSON-lines file recording every mutating
+// vCenter operation, for compliance review. Write failures are logged but
+// never fail the operation being recorded.
+type auditLog struct {
+	sync.Mutex
+	file *os.File
+}
+
+// newAuditLog opens (or creates) the audit log file for appending.
+func newAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log %s: %v", path, err)
+	}
+	return &auditLog{file: file}, nil
+}
+
+// record appends one entry to the audit log.
+func (a *auditLog) record(entry auditEntry) {
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		klog.Errorf("Could not marshal audit entry: %v", err)
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	if _, err := a.file.Write(append(data, '\n')); err != nil {
+		klog.Errorf("Could not write audit entry: %v", err)
+	}
+}
+
+// auditRecord records a mutating operation in the audit log, if one is
+// configured.
+func (mgr *vsphereManagerGovmomi) auditRecord(action, nodegroup, node, reason, taskID string, opErr error) {
+	if mgr.audit == nil {
+		return
+	}
+	outcome := "ok"
+	if opErr != nil {
+		outcome = opErr.Error()
+	}
+	mgr.audit.record(auditEntry{
+		Time:      time.Now().UTC().Format(time.RFC3339),
+		Action:    action,
+		NodeGroup: nodegroup,
+		Node:      node,
+		Reason:    reason,
+		TaskID:    taskID,
+		Outcome:   outcome,
+	})
+}
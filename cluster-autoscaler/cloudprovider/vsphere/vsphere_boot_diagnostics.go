@@ -0,0 +1,148 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+const (
+	// bootDiagnosticsTimeout bounds the whole capture: diagnostics for a
+	// node that already timed out must not hold up the scale-up loop.
+	bootDiagnosticsTimeout = 2 * time.Minute
+	// serialLogTailLines is how much of the end of the serial log is
+	// pulled into the autoscaler log.
+	serialLogTailLines = 40
+)
+
+// captureBootDiagnostics grabs a console screenshot of a VM whose
+// provisioning timed out and locates its serial log, returning a short
+// reference to the captured files for the failure message. The serial log
+// tail is also written to the autoscaler log, so boot problems are
+// debuggable without datastore access. Everything is best effort: an
+// empty string means nothing could be captured.
+func (mgr *vsphereManagerGovmomi) captureBootDiagnostics(ctx context.Context, finder *find.Finder, name string) string {
+	if mgr.activeClient() == nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, bootDiagnosticsTimeout)
+	defer cancel()
+
+	vm, err := finder.VirtualMachine(ctx, name)
+	if err != nil {
+		klog.Warningf("Could not find VM %s for boot diagnostics: %v", name, err)
+		return ""
+	}
+
+	var refs []string
+	if path, err := mgr.captureScreenshot(ctx, vm); err != nil {
+		klog.Warningf("Could not capture a screenshot of %s: %v", name, err)
+	} else {
+		refs = append(refs, fmt.Sprintf("screenshot %s", path))
+	}
+	if path, err := mgr.serialLogPath(ctx, vm); err != nil {
+		klog.Warningf("Could not locate the serial log of %s: %v", name, err)
+	} else if path != "" {
+		refs = append(refs, fmt.Sprintf("serial log %s", path))
+		mgr.logSerialTail(ctx, finder, name, path)
+	}
+	return strings.Join(refs, ", ")
+}
+
+// captureScreenshot takes a console screenshot of a VM and returns the
+// datastore path of the image. govmomi 0.20 has no object wrapper for
+// CreateScreenshot, so the method is called directly.
+func (mgr *vsphereManagerGovmomi) captureScreenshot(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+	res, err := methods.CreateScreenshot_Task(ctx, mgr.activeClient().Vim, &types.CreateScreenshot_Task{This: vm.Reference()})
+	if err != nil {
+		return "", err
+	}
+	info, err := object.NewTask(mgr.activeClient().Vim, res.Returnval).WaitForResult(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	path, ok := info.Result.(string)
+	if !ok {
+		return "", fmt.Errorf("screenshot task returned unexpected result %T", info.Result)
+	}
+	return path, nil
+}
+
+// serialLogPath returns the datastore path of the file backing the VM's
+// first file-backed serial port, or empty when it has none. Templates
+// whose boot output should be capturable need a serial port with file
+// backing; the clones inherit it.
+func (mgr *vsphereManagerGovmomi) serialLogPath(ctx context.Context, vm *object.VirtualMachine) (string, error) {
+	vms, err := mgr.retrieveVMProperties(ctx, []types.ManagedObjectReference{vm.Reference()}, []string{"config.hardware.device"})
+	if err != nil {
+		return "", err
+	}
+	if len(vms) != 1 || vms[0].Config == nil {
+		return "", fmt.Errorf("could not get hardware of %s", vm.Reference().Value)
+	}
+	for _, device := range vms[0].Config.Hardware.Device {
+		port, ok := device.(*types.VirtualSerialPort)
+		if !ok {
+			continue
+		}
+		if backing, ok := port.Backing.(*types.VirtualSerialPortFileBackingInfo); ok {
+			return backing.FileName, nil
+		}
+	}
+	return "", nil
+}
+
+// logSerialTail downloads the end of a VM's serial log from the datastore
+// and writes it to the autoscaler log.
+func (mgr *vsphereManagerGovmomi) logSerialTail(ctx context.Context, finder *find.Finder, name, path string) {
+	var dsPath object.DatastorePath
+	if !dsPath.FromString(path) {
+		klog.Warningf("Could not parse serial log path %s of %s", path, name)
+		return
+	}
+	datastore, err := finder.Datastore(ctx, dsPath.Datastore)
+	if err != nil {
+		klog.Warningf("Could not find datastore %s holding the serial log of %s: %v", dsPath.Datastore, name, err)
+		return
+	}
+	file, err := datastore.Open(ctx, dsPath.Path)
+	if err != nil {
+		klog.Warningf("Could not open serial log %s of %s: %v", path, name, err)
+		return
+	}
+	defer file.Close()
+	if err := file.Tail(serialLogTailLines); err != nil {
+		klog.Warningf("Could not seek to the end of serial log %s of %s: %v", path, name, err)
+		return
+	}
+	tail, err := ioutil.ReadAll(file)
+	if err != nil {
+		klog.Warningf("Could not read serial log %s of %s: %v", path, name, err)
+		return
+	}
+	klog.Warningf("Last %d lines of the serial log of %s (%s):\n%s", serialLogTailLines, name, path, string(tail))
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog"
+)
+
+const (
+	// defaultBootstrapTokenTTL is how long a minted bootstrap token stays
+	// valid; long enough for a clone to boot and join, short enough that a
+	// leaked token is of little use.
+	defaultBootstrapTokenTTL = 15 * time.Minute
+
+	// bootstrapTokenChars are the characters kubeadm accepts in token IDs
+	// and secrets.
+	bootstrapTokenChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// bootstrapTokenMinter creates short-lived kubeadm bootstrap tokens via the
+// Kubernetes API, so that each cloned VM joins the cluster with fresh
+// credentials instead of a long-lived token baked into the template.
+type bootstrapTokenMinter struct {
+	client kubernetes.Interface
+	ttl    time.Duration
+}
+
+// newBootstrapTokenMinter builds a minter using the same kubeconfig the
+// autoscaler itself runs with (in-cluster config when the path is empty).
+func newBootstrapTokenMinter(kubeConfigPath string, ttl time.Duration) (*bootstrapTokenMinter, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not build kubernetes client config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not create kubernetes client: %v", err)
+	}
+	if ttl <= 0 {
+		ttl = defaultBootstrapTokenTTL
+	}
+	return &bootstrapTokenMinter{
+		client: client,
+		ttl:    ttl,
+	}, nil
+}
+
+// mintToken creates a bootstrap token secret in kube-system and returns the
+// token in the <id>.<secret> form expected by kubeadm join.
+func (m *bootstrapTokenMinter) mintToken() (string, error) {
+	tokenID, err := randomBootstrapTokenString(6)
+	if err != nil {
+		return "", err
+	}
+	tokenSecret, err := randomBootstrapTokenString(16)
+	if err != nil {
+		return "", err
+	}
+
+	expiration := time.Now().Add(m.ttl).UTC().Format(time.RFC3339)
+
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("bootstrap-token-%s", tokenID),
+			Namespace: metav1.NamespaceSystem,
+		},
+		Type: apiv1.SecretTypeBootstrapToken,
+		StringData: map[string]string{
+			"token-id":                       tokenID,
+			"token-secret":                   tokenSecret,
+			"expiration":                     expiration,
+			"usage-bootstrap-authentication": "true",
+			"usage-bootstrap-signing":        "true",
+			"auth-extra-groups":              "system:bootstrappers:kubeadm:default-node-token",
+		},
+	}
+
+	if _, err := m.client.CoreV1().Secrets(metav1.NamespaceSystem).Create(context.TODO(), secret, metav1.CreateOptions{}); err != nil {
+		return "", fmt.Errorf("could not create bootstrap token secret: %v", err)
+	}
+
+	klog.V(2).Infof("Minted bootstrap token %s expiring at %s", tokenID, expiration)
+
+	return fmt.Sprintf("%s.%s", tokenID, tokenSecret), nil
+}
+
+// randomBootstrapTokenString returns a cryptographically random string of
+// the given length from the kubeadm token alphabet.
+func randomBootstrapTokenString(length int) (string, error) {
+	result := make([]byte, length)
+	max := big.NewInt(int64(len(bootstrapTokenChars)))
+	for i := range result {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("could not generate random token: %v", err)
+		}
+		result[i] = bootstrapTokenChars[n.Int64()]
+	}
+	return string(result), nil
+}
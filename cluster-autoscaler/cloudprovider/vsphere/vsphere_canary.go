@@ -0,0 +1,68 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// canaryChecker is implemented by managers that can cap a canary node
+// group at its configured fraction of the cluster.
+type canaryChecker interface {
+	checkCanaryFraction(nodegroup string, newSize int) error
+}
+
+// canaryFractionFor returns a node group's canary fraction; zero when the
+// group is not a canary. Validity is checked at startup.
+func (cfg *ConfigVsphere) canaryFractionFor(nodegroup string) float64 {
+	ng, ok := cfg.NodeGroup[nodegroup]
+	if !ok || ng.CanaryFraction == "" {
+		return 0
+	}
+	fraction, err := strconv.ParseFloat(ng.CanaryFraction, 64)
+	if err != nil {
+		return 0
+	}
+	return fraction
+}
+
+// checkCanaryFraction rejects a scale-up that would grow a canary node
+// group beyond its configured fraction of the cluster's nodes, so a
+// misbehaving canary image cannot spread past its blast radius even
+// within the group's max size.
+func (mgr *vsphereManagerGovmomi) checkCanaryFraction(nodegroup string, newSize int) error {
+	fraction := mgr.config.canaryFractionFor(nodegroup)
+	if fraction <= 0 {
+		return nil
+	}
+	others := 0
+	for name := range mgr.config.NodeGroup {
+		if name == nodegroup {
+			continue
+		}
+		size, err := mgr.nodeGroupSize(name)
+		if err != nil {
+			return fmt.Errorf("could not size node group %s for the canary cap of %s: %v", name, nodegroup, err)
+		}
+		others += size
+	}
+	if float64(newSize) > fraction*float64(others+newSize) {
+		return fmt.Errorf("node group %s is a canary capped at %.0f%% of the cluster, refusing to grow to %d of %d nodes", nodegroup, fraction*100, newSize, others+newSize)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCanaryFraction(t *testing.T) {
+	ctx := context.TODO()
+	tags := newFakeTagService(
+		clusterTagPrefix+"test",
+		nodeGroupTagPrefix+"stable",
+		nodeGroupTagPrefix+"canary",
+	)
+	mgr := testManager(tags)
+	mgr.config.NodeGroup["stable"] = &ConfigNodeGroup{}
+	mgr.config.NodeGroup["canary"] = &ConfigNodeGroup{CanaryFraction: "0.1"}
+
+	clusterTagID, _ := tags.GetTagID(ctx, clusterTagPrefix+"test")
+	stableTagID, _ := tags.GetTagID(ctx, nodeGroupTagPrefix+"stable")
+	for i := 0; i < 9; i++ {
+		ref := vmRef(fmt.Sprintf("vm-%d", i))
+		assert.NoError(t, tags.AttachTag(ctx, clusterTagID, ref))
+		assert.NoError(t, tags.AttachTag(ctx, stableTagID, ref))
+	}
+
+	// 1 of 10 nodes is exactly the 10% cap.
+	assert.NoError(t, mgr.checkCanaryFraction("canary", 1))
+
+	// 2 of 11 would exceed it.
+	err := mgr.checkCanaryFraction("canary", 2)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "canary")
+	}
+
+	// The stable pool is not capped.
+	assert.NoError(t, mgr.checkCanaryFraction("stable", 100))
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// childPoolSpec builds the resource configuration of a node group's child
+// pool: the per-node reservations from the group's hardware overrides
+// multiplied by the node count, expandable so bursts borrow from the
+// parent instead of failing admission.
+func childPoolSpec(ng *ConfigNodeGroup, nodes int) types.ResourceConfigSpec {
+	allocation := func(perNode int64) types.ResourceAllocationInfo {
+		return types.ResourceAllocationInfo{
+			Reservation:           types.NewInt64(perNode * int64(nodes)),
+			ExpandableReservation: types.NewBool(true),
+			Limit:                 types.NewInt64(-1),
+			Shares:                &types.SharesInfo{Level: types.SharesLevelNormal},
+		}
+	}
+	var cpuPerNode, memoryPerNode int64
+	if ng != nil {
+		cpuPerNode = ng.CPUReservation
+		memoryPerNode = ng.MemoryReservation
+	}
+	return types.ResourceConfigSpec{
+		CpuAllocation:    allocation(cpuPerNode),
+		MemoryAllocation: allocation(memoryPerNode),
+	}
+}
+
+// ensureChildPool finds or creates the child resource pool of a node
+// group under its configured parent pool. The child is named after the
+// group, giving vCenter admins one pool per pool of nodes.
+func (mgr *vsphereManagerGovmomi) ensureChildPool(ctx context.Context, finder *find.Finder, nodegroup string, parentPath string) (*object.ResourcePool, error) {
+	childPath := parentPath + "/" + nodegroup
+	child, err := finder.ResourcePool(ctx, childPath)
+	if err == nil {
+		return child, nil
+	}
+	if _, notFound := err.(*find.NotFoundError); !notFound {
+		return nil, fmt.Errorf("could not look up child resource pool %s: %v", childPath, err)
+	}
+
+	parent, err := finder.ResourcePool(ctx, parentPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not find resource pool %s: %v", parentPath, err)
+	}
+	size, _ := mgr.nodeGroupSize(nodegroup)
+	child, err = parent.Create(ctx, nodegroup, childPoolSpec(mgr.config.NodeGroup[nodegroup], size))
+	if err != nil {
+		return nil, fmt.Errorf("could not create child resource pool %s: %v", childPath, err)
+	}
+	klog.V(1).Infof("Created child resource pool %s for node group %s", childPath, nodegroup)
+	return child, nil
+}
+
+// resizeChildPool re-sizes the reservations of a node group's child pool
+// after the group changed size. Best effort: scaling succeeded either
+// way, the pool merely keeps its previous reservation until the next
+// size change.
+func (mgr *vsphereManagerGovmomi) resizeChildPool(ctx context.Context, nodegroup string) {
+	ng := mgr.config.NodeGroup[nodegroup]
+	if ng == nil || (ng.CPUReservation == 0 && ng.MemoryReservation == 0) {
+		// Nothing reserved per node: the child pool stays expandable with
+		// no reservation of its own.
+		return
+	}
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		klog.Warningf("Could not resize child resource pool of %s: %v", nodegroup, err)
+		return
+	}
+	childPath := mgr.config.resourcePoolFor(nodegroup) + "/" + nodegroup
+	child, err := finder.ResourcePool(ctx, childPath)
+	if err != nil {
+		klog.Warningf("Could not resize child resource pool %s: %v", childPath, err)
+		return
+	}
+	size, err := mgr.nodeGroupSize(nodegroup)
+	if err != nil {
+		klog.Warningf("Could not resize child resource pool %s: %v", childPath, err)
+		return
+	}
+	spec := childPoolSpec(ng, size)
+	if err := child.UpdateConfig(ctx, "", &spec); err != nil {
+		klog.Warningf("Could not resize child resource pool %s: %v", childPath, err)
+		return
+	}
+	klog.V(2).Infof("Sized child resource pool %s for %d nodes", childPath, size)
+}
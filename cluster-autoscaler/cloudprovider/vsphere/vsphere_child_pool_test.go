@@ -0,0 +1,36 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChildPoolSpec(t *testing.T) {
+	spec := childPoolSpec(&ConfigNodeGroup{CPUReservation: 2000, MemoryReservation: 4096}, 3)
+	assert.Equal(t, int64(6000), *spec.CpuAllocation.Reservation)
+	assert.Equal(t, int64(12288), *spec.MemoryAllocation.Reservation)
+	assert.True(t, *spec.CpuAllocation.ExpandableReservation)
+	assert.Equal(t, int64(-1), *spec.CpuAllocation.Limit)
+
+	// No per-node reservations: the pool reserves nothing of its own.
+	spec = childPoolSpec(nil, 5)
+	assert.Equal(t, int64(0), *spec.CpuAllocation.Reservation)
+	assert.Equal(t, int64(0), *spec.MemoryAllocation.Reservation)
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider/vsphere/vsphereapi"
+	"k8s.io/klog"
+)
+
+const (
+	// loginRetryAttempts is how often a failed vCenter login is retried
+	// before the error is handed up to the caller.
+	loginRetryAttempts = 3
+	// loginRetryInitialBackoff is the wait after the first failed login
+	// attempt; it doubles per attempt.
+	loginRetryInitialBackoff = 5 * time.Second
+)
+
+// The client and tag layer lives in the exported vsphereapi package so
+// other tools can embed it; this package only adds the cloudprovider
+// glue. The aliases keep the unexported names the rest of this package
+// grew up with.
+
+// VsphereClient holds the SOAP and REST clients used to talk to vCenter.
+type VsphereClient = vsphereapi.Client
+
+// tagService is the subset of the vSphere tagging API the govmomi manager
+// depends on; tests substitute an in-memory fake (the govmomi simulator
+// is not vendored yet).
+type tagService = vsphereapi.TagService
+
+// TagNotFoundError is returned by GetTagID when no tag with the requested
+// name exists.
+type TagNotFoundError = vsphereapi.TagNotFoundError
+
+// isTagNotFound reports whether an error is a TagNotFoundError.
+func isTagNotFound(err error) bool {
+	return vsphereapi.IsTagNotFound(err)
+}
+
+// NewVsphereClient connects and logs in to vCenter with the credentials from
+// the cloud config, for both the SOAP and the REST endpoints.
+func NewVsphereClient(cfg ConfigGlobal) (*VsphereClient, error) {
+	minVersion, err := minTLSVersion(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := parseTLSCipherSuites(cfg.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	client, err := vsphereapi.Connect(context.TODO(), vsphereapi.ConnectOptions{
+		VCenter:       cfg.VCenter,
+		User:          cfg.User,
+		Password:      cfg.Password,
+		Insecure:      cfg.InsecureFlag,
+		MinTLSVersion: minVersion,
+		CipherSuites:  cipherSuites,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Tag lookups sweep only the autoscaler's category; on vCenters with
+	// tens of thousands of tags the unscoped sweep times out.
+	category := cfg.TagCategory
+	if category == "" {
+		category = defaultTagCategory
+	}
+	client.ScopeTagsToCategory(category)
+	return client, nil
+}
+
+// newVsphereClientWithRetry is NewVsphereClient with a few retries and
+// doubling backoff. Startup often races a vCenter restart that resolves
+// within seconds; outages longer than the retry window are left to the
+// caller (the deferred provider keeps rebuilding, see
+// vsphere_deferred_build.go).
+func newVsphereClientWithRetry(cfg ConfigGlobal) (*VsphereClient, error) {
+	backoff := loginRetryInitialBackoff
+	var err error
+	for attempt := 1; attempt <= loginRetryAttempts; attempt++ {
+		var client *VsphereClient
+		client, err = NewVsphereClient(cfg)
+		if err == nil {
+			return client, nil
+		}
+		if attempt < loginRetryAttempts {
+			klog.Warningf("Could not log in to vCenter (attempt %d/%d), retrying in %v: %v", attempt, loginRetryAttempts, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// CloneSpecBuilder decorates the VirtualMachineConfigSpec the provider
+// assembles for a new clone, as the last step before the clone task
+// starts. Implementations may mutate the spec or return a replacement —
+// extra guestinfo keys, video memory, devices the config options do not
+// cover — without forking the provider.
+type CloneSpecBuilder interface {
+	// BuildConfigSpec receives the spec built from the node group's
+	// config options and returns the spec to clone with. nodegroup and
+	// name identify the VM being created.
+	BuildConfigSpec(nodegroup, name string, base *types.VirtualMachineConfigSpec) (*types.VirtualMachineConfigSpec, error)
+}
+
+// defaultCloneSpecBuilder uses the built-in spec unchanged.
+type defaultCloneSpecBuilder struct{}
+
+// BuildConfigSpec returns the base spec as is.
+func (defaultCloneSpecBuilder) BuildConfigSpec(nodegroup, name string, base *types.VirtualMachineConfigSpec) (*types.VirtualMachineConfigSpec, error) {
+	return base, nil
+}
+
+var (
+	cloneSpecBuildersMutex sync.Mutex
+	cloneSpecBuilders      = map[string]CloneSpecBuilder{
+		"": defaultCloneSpecBuilder{},
+	}
+)
+
+// RegisterCloneSpecBuilder makes a builder selectable through the
+// clone-spec-builder config option under the given name. Builds that
+// embed the provider call this from an init function; registering a name
+// twice replaces the earlier builder.
+func RegisterCloneSpecBuilder(name string, builder CloneSpecBuilder) {
+	cloneSpecBuildersMutex.Lock()
+	defer cloneSpecBuildersMutex.Unlock()
+	cloneSpecBuilders[name] = builder
+}
+
+// lookupCloneSpecBuilder returns the builder registered under a name.
+func lookupCloneSpecBuilder(name string) (CloneSpecBuilder, error) {
+	cloneSpecBuildersMutex.Lock()
+	defer cloneSpecBuildersMutex.Unlock()
+	builder, ok := cloneSpecBuilders[name]
+	if !ok {
+		return nil, fmt.Errorf("no clone spec builder registered under %q", name)
+	}
+	return builder, nil
+}
+
+// cloneSpecBuilderFor returns the name of the clone spec builder for a
+// node group, falling back to the global one.
+func (cfg *ConfigVsphere) cloneSpecBuilderFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.CloneSpecBuilder != "" {
+		return ng.CloneSpecBuilder
+	}
+	return cfg.Global.CloneSpecBuilder
+}
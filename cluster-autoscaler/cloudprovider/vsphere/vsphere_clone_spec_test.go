@@ -0,0 +1,69 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// videoMemoryBuilder is the kind of tweak the interface exists for: an
+// extraConfig key no config option covers.
+type videoMemoryBuilder struct{}
+
+func (videoMemoryBuilder) BuildConfigSpec(nodegroup, name string, base *types.VirtualMachineConfigSpec) (*types.VirtualMachineConfigSpec, error) {
+	base.ExtraConfig = append(base.ExtraConfig,
+		&types.OptionValue{Key: "svga.vramSize", Value: "16777216"})
+	return base, nil
+}
+
+func TestCloneSpecBuilder(t *testing.T) {
+	RegisterCloneSpecBuilder("video-memory", videoMemoryBuilder{})
+
+	mgr := testManager(newFakeTagService())
+	mgr.config.NodeGroup["ng1"] = &ConfigNodeGroup{CloneSpecBuilder: "video-memory"}
+
+	spec, err := mgr.buildConfigSpec("ng1", "ng1-abcdef")
+	assert.NoError(t, err)
+	found := false
+	for _, option := range spec.ExtraConfig {
+		if value, ok := option.(*types.OptionValue); ok && value.Key == "svga.vramSize" {
+			found = true
+		}
+	}
+	assert.True(t, found, "the registered builder should have decorated the spec")
+
+	// Groups without a builder configured use the built-in spec unchanged.
+	plain, err := mgr.buildConfigSpec("ng2", "ng2-abcdef")
+	assert.NoError(t, err)
+	for _, option := range plain.ExtraConfig {
+		if value, ok := option.(*types.OptionValue); ok {
+			assert.NotEqual(t, "svga.vramSize", value.Key)
+		}
+	}
+}
+
+func TestLookupCloneSpecBuilder(t *testing.T) {
+	builder, err := lookupCloneSpecBuilder("")
+	assert.NoError(t, err)
+	assert.NotNil(t, builder)
+
+	_, err = lookupCloneSpecBuilder("no-such-builder")
+	assert.Error(t, err)
+}
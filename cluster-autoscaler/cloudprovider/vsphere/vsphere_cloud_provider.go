@@ -0,0 +1,401 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/autoscaler/cluster-autoscaler/config/dynamic"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+	"k8s.io/klog"
+)
+
+const (
+	// ProviderName is the cloud provider name for vsphere
+	ProviderName = cloudprovider.VsphereProviderName
+	// GPULabel is the label added to nodes with GPU resource.
+	GPULabel = "vsphere.vmware.com/gpu-accelerator"
+)
+
+var (
+	availableGPUTypes = map[string]struct{}{}
+)
+
+// vsphereCloudProvider implements CloudProvider interface from cluster-autoscaler/cloudprovider module.
+type vsphereCloudProvider struct {
+	vsphereManager  vsphereManager
+	resourceLimiter *cloudprovider.ResourceLimiter
+	nodeGroups      []vsphereNodeGroup
+
+	clusterUpdateMutex *sync.Mutex
+}
+
+func buildVsphereCloudProvider(vsphereManager vsphereManager, resourceLimiter *cloudprovider.ResourceLimiter) (cloudprovider.CloudProvider, error) {
+	vcp := &vsphereCloudProvider{
+		vsphereManager:  vsphereManager,
+		resourceLimiter: resourceLimiter,
+		nodeGroups:      []vsphereNodeGroup{},
+	}
+	return vcp, nil
+}
+
+// Name returns the name of the cloud provider.
+func (vcp *vsphereCloudProvider) Name() string {
+	return ProviderName
+}
+
+// GPULabel returns the label added to nodes with GPU resource.
+func (vcp *vsphereCloudProvider) GPULabel() string {
+	return GPULabel
+}
+
+// GetAvailableGPUTypes return all available GPU types cloud provider supports.
+func (vcp *vsphereCloudProvider) GetAvailableGPUTypes() map[string]struct{} {
+	return availableGPUTypes
+}
+
+// NodeGroups returns all node groups managed by this cloud provider.
+func (vcp *vsphereCloudProvider) NodeGroups() []cloudprovider.NodeGroup {
+	groups := make([]cloudprovider.NodeGroup, len(vcp.nodeGroups))
+	for i := range vcp.nodeGroups {
+		groups[i] = &vcp.nodeGroups[i]
+	}
+	return groups
+}
+
+// AddNodeGroup appends a node group to the list of node groups managed by this cloud provider.
+func (vcp *vsphereCloudProvider) AddNodeGroup(group vsphereNodeGroup) {
+	vcp.nodeGroups = append(vcp.nodeGroups, group)
+	vcp.sortNodeGroups()
+}
+
+// sortNodeGroups orders the node groups by descending priority, then name,
+// so NodeGroups() is deterministic and matches the priority expander
+// configuration instead of insertion chance.
+func (vcp *vsphereCloudProvider) sortNodeGroups() {
+	sort.SliceStable(vcp.nodeGroups, func(i, j int) bool {
+		pi := vcp.vsphereManager.nodeGroupPriority(vcp.nodeGroups[i].id)
+		pj := vcp.vsphereManager.nodeGroupPriority(vcp.nodeGroups[j].id)
+		if pi != pj {
+			return pi > pj
+		}
+		return vcp.nodeGroups[i].id < vcp.nodeGroups[j].id
+	})
+}
+
+// NodeGroupForNode returns the node group that a given node belongs to, by
+// looking the node up in each group's member list. Nodes the autoscaler
+// does not manage — control-plane nodes, manually created VMs, nodes of
+// other clusters — return (nil, nil) so the core treats them as unmanaged
+// instead of attributing them to an arbitrary group.
+func (vcp *vsphereCloudProvider) NodeGroupForNode(node *apiv1.Node) (cloudprovider.NodeGroup, error) {
+	if _, found := node.ObjectMeta.Labels["node-role.kubernetes.io/master"]; found {
+		return nil, nil
+	}
+	for i := range vcp.nodeGroups {
+		nodegroup := &vcp.nodeGroups[i]
+		names, err := vcp.vsphereManager.getNodeNames(nodegroup.id)
+		if err != nil {
+			return nil, fmt.Errorf("could not list members of node group %s: %v", nodegroup.id, err)
+		}
+		for _, name := range names {
+			if name == node.Name {
+				return nodegroup, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Pricing is not implemented.
+func (vcp *vsphereCloudProvider) Pricing() (cloudprovider.PricingModel, errors.AutoscalerError) {
+	return nil, cloudprovider.ErrNotImplemented
+}
+
+// GetAvailableMachineTypes returns the machine type catalog scanned from
+// the configured template folder, or nothing when no folder is configured.
+func (vcp *vsphereCloudProvider) GetAvailableMachineTypes() ([]string, error) {
+	if source, ok := vcp.vsphereManager.(machineTypeSource); ok {
+		return source.availableMachineTypes()
+	}
+	return []string{}, nil
+}
+
+// NewNodeGroup is not implemented.
+func (vcp *vsphereCloudProvider) NewNodeGroup(machineType string, labels map[string]string, systemLabels map[string]string,
+	taints []apiv1.Taint, extraResources map[string]resource.Quantity) (cloudprovider.NodeGroup, error) {
+	return nil, cloudprovider.ErrNotImplemented
+}
+
+// GetResourceLimiter returns resource constraints for the cloud provider.
+func (vcp *vsphereCloudProvider) GetResourceLimiter() (*cloudprovider.ResourceLimiter, error) {
+	return vcp.resourceLimiter, nil
+}
+
+// Refresh is called before every autoscaler main loop.
+//
+// Syncs the node group set with the VsphereNodeGroup resources when that
+// source is enabled, sweeps the tag inventory in one pass so the loop's
+// size and node lookups are served from the snapshot, prints debug
+// information and publishes the health summary when the manager supports it.
+func (vcp *vsphereCloudProvider) Refresh() error {
+	tr, traced := vcp.vsphereManager.(operationTracer)
+	var span *traceSpan
+	if traced {
+		span = tr.startOperationSpan("Refresh")
+	}
+	err := vcp.refresh()
+	span.end(err)
+	if traced {
+		// Exporting once per loop also picks up the scale operation spans
+		// finished since the last one.
+		tr.flushSpans()
+	}
+	return err
+}
+
+func (vcp *vsphereCloudProvider) refresh() error {
+	if reloader, ok := vcp.vsphereManager.(configReloader); ok {
+		reloader.reloadConfigIfChanged()
+	}
+	if err := vcp.syncNodeGroups(); err != nil {
+		return err
+	}
+	if source, ok := vcp.vsphereManager.(externalRemovalSource); ok {
+		for nodegroup, count := range source.externallyRemovedNodes() {
+			for i := range vcp.nodeGroups {
+				ng := &vcp.nodeGroups[i]
+				if ng.id != nodegroup {
+					continue
+				}
+				newTarget := *ng.targetSize - count
+				if newTarget < 0 {
+					newTarget = 0
+				}
+				klog.V(0).Infof("Node group %s lost %d VMs outside the autoscaler, target size %d->%d", nodegroup, count, *ng.targetSize, newTarget)
+				*ng.targetSize = newTarget
+				vcp.vsphereManager.targetSizeChanged(nodegroup, newTarget)
+			}
+		}
+	}
+	if refresher, ok := vcp.vsphereManager.(inventoryRefresher); ok {
+		groups := make([]string, 0, len(vcp.nodeGroups))
+		for _, nodegroup := range vcp.nodeGroups {
+			groups = append(groups, nodegroup.id)
+		}
+		if err := refresher.refreshInventory(groups); err != nil {
+			return err
+		}
+	}
+	if recycler, ok := vcp.vsphereManager.(nodeRecycler); ok {
+		groups := make([]string, 0, len(vcp.nodeGroups))
+		for _, nodegroup := range vcp.nodeGroups {
+			groups = append(groups, nodegroup.id)
+		}
+		recycler.flagOveragedNodes(groups)
+	}
+	if relocator, ok := vcp.vsphereManager.(nodeRelocator); ok {
+		groups := make([]string, 0, len(vcp.nodeGroups))
+		for _, nodegroup := range vcp.nodeGroups {
+			groups = append(groups, nodegroup.id)
+		}
+		relocator.relocateMovedNodes(groups)
+	}
+	for _, nodegroup := range vcp.nodeGroups {
+		klog.V(3).Info(nodegroup.Debug())
+	}
+	if sink, ok := vcp.vsphereManager.(healthSink); ok {
+		sink.publishHealth(vcp.collectHealth())
+	}
+	if reporter, ok := vcp.vsphereManager.(apiUsageReporter); ok {
+		klog.V(2).Infof("vCenter API usage this loop: %s", reporter.apiUsageSummary())
+	}
+	return nil
+}
+
+// syncNodeGroups reconciles the in-memory node group set with the
+// VsphereNodeGroup custom resources: new resources add groups, edited ones
+// update the size bounds and deleted ones remove the group. Does nothing
+// when the CRD source is disabled.
+func (vcp *vsphereCloudProvider) syncNodeGroups() error {
+	defs, enabled, err := vcp.vsphereManager.nodeGroupDefs()
+	if !enabled {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]*vsphereNodeGroup)
+	for i := range vcp.nodeGroups {
+		existing[vcp.nodeGroups[i].id] = &vcp.nodeGroups[i]
+	}
+
+	var updated []vsphereNodeGroup
+	for _, def := range defs {
+		if ng, ok := existing[def.name]; ok {
+			ng.minSize = def.minSize
+			ng.maxSize = def.maxSize
+			updated = append(updated, *ng)
+			delete(existing, def.name)
+			continue
+		}
+
+		ng := vsphereNodeGroup{
+			vsphereManager:     vcp.vsphereManager,
+			id:                 def.name,
+			clusterUpdateMutex: vcp.clusterUpdateMutex,
+			minSize:            def.minSize,
+			maxSize:            def.maxSize,
+			targetSize:         new(int),
+		}
+		*ng.targetSize, err = vcp.vsphereManager.nodeGroupSize(def.name)
+		if err != nil {
+			klog.Errorf("Could not get size of new node group %s, retrying next sync: %v", def.name, err)
+			continue
+		}
+		if restored, ok := vcp.vsphereManager.restoredTargetSize(def.name); ok && restored > *ng.targetSize {
+			*ng.targetSize = restored
+		}
+		klog.V(1).Infof("Adding node group %s from its VsphereNodeGroup resource", def.name)
+		updated = append(updated, ng)
+	}
+	for name := range existing {
+		klog.V(1).Infof("Removing node group %s, its VsphereNodeGroup resource is gone", name)
+	}
+	vcp.nodeGroups = updated
+	vcp.sortNodeGroups()
+	return nil
+}
+
+// Cleanup is called when the autoscaler shuts down, e.g. on SIGTERM. It
+// waits (bounded) for in-flight VM creations so clones started by this
+// process get tagged and tracked before the pod exits; anything that does
+// not finish in time is left to the persisted state, which the next run
+// adopts on startup.
+func (vcp *vsphereCloudProvider) Cleanup() error {
+	if drainer, ok := vcp.vsphereManager.(shutdownDrainer); ok {
+		drainer.drainInFlight()
+	}
+	return nil
+}
+
+// BuildVsphere is called by the autoscaler to build a vsphere cloud provider.
+//
+// Construction failures — a bad config file, an unreachable vCenter at
+// startup — do not kill the process: the error is logged and a deferred
+// provider is returned that keeps retrying the build on every Refresh
+// until it succeeds (see vsphere_deferred_build.go). In mixed builds
+// this keeps the rest of the autoscaler running while vCenter is down.
+func BuildVsphere(opts config.AutoscalingOptions, do cloudprovider.NodeGroupDiscoveryOptions, rl *cloudprovider.ResourceLimiter) cloudprovider.CloudProvider {
+	provider, err := buildVsphereProvider(opts, do, rl)
+	if err != nil {
+		klog.Errorf("Could not build vsphere cloud provider, starting degraded and retrying: %v", err)
+		return newDeferredVsphereProvider(opts, do, rl, err)
+	}
+	return provider
+}
+
+// buildVsphereProvider does the actual work of BuildVsphere: the
+// vsphereManager is created here, and the node groups are created based
+// on the specs provided via the command line parameters. Errors are
+// returned rather than fatal so a failed build can be retried.
+func buildVsphereProvider(opts config.AutoscalingOptions, do cloudprovider.NodeGroupDiscoveryOptions, rl *cloudprovider.ResourceLimiter) (cloudprovider.CloudProvider, error) {
+	var config io.ReadCloser
+
+	if opts.CloudConfig != "" {
+		var err error
+		config, err = os.Open(opts.CloudConfig)
+		if err != nil {
+			return nil, fmt.Errorf("could not open cloud provider configuration %s: %v", opts.CloudConfig, err)
+		}
+		defer config.Close()
+	}
+
+	manager, err := createVsphereManager(config, do, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not create vsphere manager: %v", err)
+	}
+
+	provider, err := buildVsphereCloudProvider(manager, rl)
+	if err != nil {
+		return nil, fmt.Errorf("could not create vsphere cloud provider: %v", err)
+	}
+
+	_, crdEnabled, _ := manager.nodeGroupDefs()
+	if len(do.NodeGroupSpecs) == 0 && !crdEnabled {
+		return nil, fmt.Errorf("must specify at least one node group with --nodes=<min>:<max>:<name>,...")
+	}
+
+	clusterUpdateLock := sync.Mutex{}
+	provider.(*vsphereCloudProvider).clusterUpdateMutex = &clusterUpdateLock
+
+	for _, nodegroupSpec := range do.NodeGroupSpecs {
+		spec, err := dynamic.SpecFromString(nodegroupSpec, scaleToZeroSupported)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse node group spec %s: %v", nodegroupSpec, err)
+		}
+
+		ng := vsphereNodeGroup{
+			vsphereManager:     manager,
+			id:                 spec.Name,
+			clusterUpdateMutex: &clusterUpdateLock,
+			minSize:            spec.MinSize,
+			maxSize:            spec.MaxSize,
+			targetSize:         new(int),
+		}
+		*ng.targetSize, err = ng.vsphereManager.nodeGroupSize(ng.id)
+		if err != nil {
+			return nil, fmt.Errorf("could not get size of node group %s: %v", ng.id, err)
+		}
+		// A previous run may have persisted a larger target size whose
+		// scale-up never finished; restore it so the scale-up resumes
+		// instead of being forgotten.
+		if restored, ok := manager.restoredTargetSize(ng.id); ok && restored > *ng.targetSize {
+			klog.V(1).Infof("Restoring persisted target size %d for node group %s (inventory has %d)", restored, ng.id, *ng.targetSize)
+			*ng.targetSize = restored
+		}
+		manager.targetSizeChanged(ng.id, *ng.targetSize)
+		provider.(*vsphereCloudProvider).AddNodeGroup(ng)
+	}
+
+	if crdEnabled {
+		if err := provider.(*vsphereCloudProvider).syncNodeGroups(); err != nil {
+			return nil, fmt.Errorf("could not sync node groups from VsphereNodeGroup resources: %v", err)
+		}
+	}
+
+	for _, ng := range provider.(*vsphereCloudProvider).nodeGroups {
+		klog.V(1).Infof("Node group %s has priority %d", ng.id, manager.nodeGroupPriority(ng.id))
+	}
+
+	if reporter, ok := manager.(debugStateReporter); ok {
+		if path := reporter.debugEndpointPath(); path != "" {
+			provider.(*vsphereCloudProvider).registerDebugEndpoint(path)
+		}
+	}
+
+	return provider, nil
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeGroupForNode(t *testing.T) {
+	manager := &fakeVsphereManager{nodes: []string{"pool-a-1", "pool-a-2"}}
+	provider, err := buildVsphereCloudProvider(manager, nil)
+	assert.NoError(t, err)
+	vcp := provider.(*vsphereCloudProvider)
+
+	// No node groups configured: nothing to panic on, just unmanaged.
+	group, err := vcp.NodeGroupForNode(node("pool-a-1", nil))
+	assert.NoError(t, err)
+	assert.Nil(t, group)
+
+	vcp.AddNodeGroup(*testNodeGroup(manager, 1, 10, 2))
+
+	group, err = vcp.NodeGroupForNode(node("pool-a-2", nil))
+	assert.NoError(t, err)
+	if assert.NotNil(t, group) {
+		assert.Equal(t, "pool-a", group.Id())
+	}
+
+	// Manually created VMs and other clusters' nodes are unmanaged.
+	group, err = vcp.NodeGroupForNode(node("stray-vm", nil))
+	assert.NoError(t, err)
+	assert.Nil(t, group)
+
+	group, err = vcp.NodeGroupForNode(node("master-1",
+		map[string]string{"node-role.kubernetes.io/master": ""}))
+	assert.NoError(t, err)
+	assert.Nil(t, group)
+}
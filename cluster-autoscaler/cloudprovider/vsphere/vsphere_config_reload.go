@@ -0,0 +1,140 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// configReloader is implemented by managers that can re-read their cloud
+// config while running; the provider checks once per loop.
+type configReloader interface {
+	reloadConfigIfChanged()
+}
+
+// watchConfigFile records the cloud config file to re-read when its
+// modification time changes.
+func (mgr *vsphereManagerGovmomi) watchConfigFile(path string) {
+	if path == "" {
+		klog.Warningf("reload-config is set but the autoscaler runs without --cloud-config, nothing to watch")
+		return
+	}
+	mgr.configPath = path
+	if info, err := os.Stat(path); err == nil {
+		mgr.configModTime = info.ModTime()
+	}
+	klog.V(1).Infof("Watching cloud config %s for changes", path)
+}
+
+// reloadConfigIfChanged re-reads the cloud config when the file changed
+// and applies it without a restart, so template, placement and option
+// edits do not cost the in-flight state a pod restart would lose. A
+// config that does not parse or validate is ignored with a warning — the
+// running config stays in effect. Settings materialised at startup
+// (connection, enabled subsystems) cannot be applied live; changes to
+// them are ignored with a warning too.
+func (mgr *vsphereManagerGovmomi) reloadConfigIfChanged() {
+	if mgr.configPath == "" {
+		return
+	}
+	info, err := os.Stat(mgr.configPath)
+	if err != nil {
+		klog.Warningf("Could not stat cloud config %s, keeping the running config: %v", mgr.configPath, err)
+		return
+	}
+	if !info.ModTime().After(mgr.configModTime) {
+		return
+	}
+	mgr.configModTime = info.ModTime()
+
+	content, err := ioutil.ReadFile(mgr.configPath)
+	if err != nil {
+		klog.Warningf("Could not re-read cloud config %s, keeping the running config: %v", mgr.configPath, err)
+		return
+	}
+	cfg, err := parseCloudConfig(content)
+	if err != nil {
+		klog.Warningf("Ignoring changed cloud config %s, keeping the running config: %v", mgr.configPath, err)
+		return
+	}
+	if err := validateNodeGroupConfig(cfg); err != nil {
+		klog.Warningf("Ignoring changed cloud config %s, keeping the running config: %v", mgr.configPath, err)
+		return
+	}
+
+	mgr.keepFrozenConfig(&cfg)
+	mgr.config = cfg
+	mgr.invalidateInventory()
+	klog.V(0).Infof("Reloaded cloud config %s", mgr.configPath)
+}
+
+// keepFrozenConfig carries the settings a reload cannot change over from
+// the running config into the freshly parsed one, warning when the file
+// tried to change them. These are the settings the constructor turned
+// into live objects or goroutines: the vCenter session, the subsystem
+// clients and the watcher loops.
+func (mgr *vsphereManagerGovmomi) keepFrozenConfig(cfg *ConfigVsphere) {
+	old := &mgr.config.Global
+	g := &cfg.Global
+
+	var changed []string
+	note := func(option string, differs bool) {
+		if differs {
+			changed = append(changed, option)
+		}
+	}
+	note("vcenter connection", g.VCenter != old.VCenter || g.User != old.User || g.Password != old.Password || g.InsecureFlag != old.InsecureFlag)
+	note("tls settings", g.MinTLSVersion != old.MinTLSVersion || g.TLSCipherSuites != old.TLSCipherSuites || g.HardenedTLS != old.HardenedTLS)
+	note("cluster-name", g.ClusterName != old.ClusterName)
+	note("bootstrap-token", g.BootstrapToken != old.BootstrapToken || g.BootstrapTokenTTL != old.BootstrapTokenTTL)
+	note("clone-timeout", g.CloneTimeout != old.CloneTimeout)
+	note("persist-state", g.PersistState != old.PersistState)
+	note("audit-log", g.AuditLog != old.AuditLog)
+	note("ownership-lock", g.OwnershipLock != old.OwnershipLock || g.OwnershipLockLease != old.OwnershipLockLease)
+	note("ipam-mode", g.IPAMMode != old.IPAMMode)
+	note("dns-mode", g.DNSMode != old.DNSMode)
+	note("node-group-crd", g.NodeGroupCRD != old.NodeGroupCRD || g.NodeGroupCRDNamespace != old.NodeGroupCRDNamespace)
+	note("vm-namespace", g.VMNamespace != old.VMNamespace)
+	note("inventory-watcher", g.InventoryWatcher != old.InventoryWatcher)
+	note("watch-events", g.WatchEvents != old.WatchEvents)
+	note("guest-probe", g.GuestProbe != old.GuestProbe || g.GuestUser != old.GuestUser || g.GuestPassword != old.GuestPassword)
+	note("reload-config", g.ReloadConfig != old.ReloadConfig)
+	if len(changed) > 0 {
+		klog.Warningf("Ignoring changes to %s in %s: these settings need a restart", strings.Join(changed, ", "), mgr.configPath)
+	}
+
+	g.VCenter, g.User, g.Password, g.InsecureFlag = old.VCenter, old.User, old.Password, old.InsecureFlag
+	g.MinTLSVersion, g.TLSCipherSuites, g.HardenedTLS = old.MinTLSVersion, old.TLSCipherSuites, old.HardenedTLS
+	g.ClusterName = old.ClusterName
+	g.BootstrapToken, g.BootstrapTokenTTL = old.BootstrapToken, old.BootstrapTokenTTL
+	g.CloneTimeout = old.CloneTimeout
+	g.PersistState = old.PersistState
+	g.AuditLog = old.AuditLog
+	g.OwnershipLock, g.OwnershipLockLease = old.OwnershipLock, old.OwnershipLockLease
+	g.IPAMMode = old.IPAMMode
+	g.DNSMode = old.DNSMode
+	g.NodeGroupCRD, g.NodeGroupCRDNamespace = old.NodeGroupCRD, old.NodeGroupCRDNamespace
+	g.VMNamespace = old.VMNamespace
+	g.InventoryWatcher = old.InventoryWatcher
+	g.WatchEvents = old.WatchEvents
+	g.GuestProbe, g.GuestUser, g.GuestPassword = old.GuestProbe, old.GuestUser, old.GuestPassword
+	g.ReloadConfig = old.ReloadConfig
+}
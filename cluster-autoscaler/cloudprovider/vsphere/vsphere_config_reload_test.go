@@ -0,0 +1,62 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadConfigIfChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsphere-reload")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cloud.conf")
+
+	write := func(content string, mtime time.Time) {
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		assert.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+
+	base := time.Now()
+	write("[global]\nvcenter = vc1\n\n[nodegroup \"ng1\"]\ndatastore = ds1\n", base)
+
+	mgr := testManager(newFakeTagService())
+	mgr.config.Global.VCenter = "vc1"
+	mgr.config.NodeGroup["ng1"] = &ConfigNodeGroup{Datastore: "ds1"}
+	mgr.watchConfigFile(path)
+
+	// Unchanged file: nothing happens.
+	mgr.reloadConfigIfChanged()
+	assert.Equal(t, "ds1", mgr.config.datastoreFor("ng1"))
+
+	// A changed datastore is applied; the changed vCenter URL is not.
+	write("[global]\nvcenter = vc2\n\n[nodegroup \"ng1\"]\ndatastore = ds2\n", base.Add(time.Minute))
+	mgr.reloadConfigIfChanged()
+	assert.Equal(t, "ds2", mgr.config.datastoreFor("ng1"))
+	assert.Equal(t, "vc1", mgr.config.Global.VCenter)
+
+	// An invalid config is ignored, the running one stays in effect.
+	write("[global]\nvcenter = vc1\n\n[nodegroup \"ng1\"]\ndatastore = ds3\nscale-down-mode = explode\n", base.Add(2*time.Minute))
+	mgr.reloadConfigIfChanged()
+	assert.Equal(t, "ds2", mgr.config.datastoreFor("ng1"))
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// configDuration is a duration option parsed from the Go duration syntax
+// ("30m", "1h30m") in both the INI and the YAML/JSON config formats.
+// Malformed values fail at parse time with the option's name attached,
+// instead of at first use. The type remembers whether the option was
+// present at all, so an explicit "0" can mean "disabled" where that is
+// meaningful while an absent option still gets its default.
+type configDuration struct {
+	value time.Duration
+	isSet bool
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler for the gcfg path.
+func (d *configDuration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.value = parsed
+	d.isSet = true
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler for the YAML/JSON path.
+func (d *configDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("durations must be Go duration strings: %v", err)
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// String renders the option for logs; unset options render empty.
+func (d configDuration) String() string {
+	if !d.isSet {
+		return ""
+	}
+	return d.value.String()
+}
+
+// applyConfigDefaults fills in the defaults for options that were absent
+// from the cloud config, so the rest of the code reads effective values
+// instead of re-deriving them at every use site. It runs after every
+// parse, including config reloads.
+func applyConfigDefaults(cfg *ConfigVsphere) {
+	if !cfg.Global.CloneTimeout.isSet {
+		cfg.Global.CloneTimeout = configDuration{value: defaultCloneTimeout, isSet: true}
+	}
+	if !cfg.Global.BootstrapTokenTTL.isSet {
+		cfg.Global.BootstrapTokenTTL = configDuration{value: defaultBootstrapTokenTTL, isSet: true}
+	}
+	if !cfg.Global.OwnershipLockLease.isSet {
+		cfg.Global.OwnershipLockLease = configDuration{value: defaultOwnershipLockLease, isSet: true}
+	}
+	if cfg.Global.MaxConcurrentClones <= 0 {
+		cfg.Global.MaxConcurrentClones = defaultGlobalCloneConcurrency
+	}
+	if cfg.Global.MaxConcurrentDeletes <= 0 {
+		cfg.Global.MaxConcurrentDeletes = defaultGlobalDeleteConcurrency
+	}
+	if cfg.Global.MaxDeletesPerHost <= 0 {
+		cfg.Global.MaxDeletesPerHost = defaultHostDeleteConcurrency
+	}
+}
+
+// validateRequiredConfig checks the options without which the govmomi
+// manager cannot even log in, so a truncated config fails with a clear
+// message instead of a connection error.
+func validateRequiredConfig(cfg ConfigVsphere) error {
+	var missing []string
+	if cfg.Global.VCenter == "" {
+		missing = append(missing, "vcenter")
+	}
+	if cfg.Global.User == "" {
+		missing = append(missing, "user")
+	}
+	if cfg.Global.Password == "" {
+		missing = append(missing, "password")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the global config options %s are required", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// knownConfigKeys collects the option names of a config section from its
+// json struct tags, which carry the same names as the gcfg ones.
+func knownConfigKeys(t reflect.Type) map[string]bool {
+	keys := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("json")
+		if tag == "" {
+			continue
+		}
+		keys[strings.Split(tag, ",")[0]] = true
+	}
+	return keys
+}
+
+// unknownConfigKeys returns the options of a YAML/JSON cloud config that
+// no known field matches, sorted. The gcfg parser rejects unknown INI
+// options itself, but yaml.Unmarshal silently drops them — typically a
+// typo that would otherwise surface as a mysteriously ignored setting.
+func unknownConfigKeys(content []byte) []string {
+	var doc struct {
+		Global     map[string]interface{}            `json:"global"`
+		NodeGroups map[string]map[string]interface{} `json:"nodegroups"`
+	}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil
+	}
+	var unknown []string
+	global := knownConfigKeys(reflect.TypeOf(ConfigGlobal{}))
+	for key := range doc.Global {
+		if !global[key] {
+			unknown = append(unknown, "global."+key)
+		}
+	}
+	group := knownConfigKeys(reflect.TypeOf(ConfigNodeGroup{}))
+	for name, options := range doc.NodeGroups {
+		for key := range options {
+			if !group[key] {
+				unknown = append(unknown, fmt.Sprintf("nodegroups.%s.%s", name, key))
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// summary returns a one-line description of the effective config for the
+// startup log: the identifying settings and the node group names, never
+// credentials.
+func (cfg ConfigVsphere) summary() string {
+	groups := make([]string, 0, len(cfg.NodeGroup))
+	for name := range cfg.NodeGroup {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+	return fmt.Sprintf("vcenter=%s datacenter=%s cluster-name=%s clone-timeout=%s max-concurrent-clones=%d nodegroups=[%s]",
+		cfg.Global.VCenter, cfg.Global.Datacenter, cfg.Global.ClusterName,
+		cfg.Global.CloneTimeout, cfg.Global.MaxConcurrentClones, strings.Join(groups, " "))
+}
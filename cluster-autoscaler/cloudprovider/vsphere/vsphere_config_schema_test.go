@@ -0,0 +1,124 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigDuration(t *testing.T) {
+	cfg, err := parseCloudConfig([]byte("[global]\nclone-timeout = 45m\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 45*time.Minute, cfg.Global.CloneTimeout.value)
+	assert.True(t, cfg.Global.CloneTimeout.isSet)
+
+	cfg, err = parseCloudConfig([]byte("global:\n  clone-timeout: 45m\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 45*time.Minute, cfg.Global.CloneTimeout.value)
+
+	// An explicit zero is remembered as set, it means "disabled".
+	cfg, err = parseCloudConfig([]byte("[global]\nclone-timeout = 0\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), cfg.Global.CloneTimeout.value)
+	assert.True(t, cfg.Global.CloneTimeout.isSet)
+
+	// Malformed durations fail at parse time, in both formats.
+	_, err = parseCloudConfig([]byte("[global]\nclone-timeout = soon\n"))
+	assert.Error(t, err)
+	_, err = parseCloudConfig([]byte("global:\n  clone-timeout: soon\n"))
+	assert.Error(t, err)
+}
+
+func TestApplyConfigDefaults(t *testing.T) {
+	var cfg ConfigVsphere
+	applyConfigDefaults(&cfg)
+	assert.Equal(t, defaultCloneTimeout, cfg.Global.CloneTimeout.value)
+	assert.Equal(t, defaultBootstrapTokenTTL, cfg.Global.BootstrapTokenTTL.value)
+	assert.Equal(t, defaultOwnershipLockLease, cfg.Global.OwnershipLockLease.value)
+	assert.Equal(t, defaultGlobalCloneConcurrency, cfg.Global.MaxConcurrentClones)
+	assert.Equal(t, defaultGlobalDeleteConcurrency, cfg.Global.MaxConcurrentDeletes)
+	assert.Equal(t, defaultHostDeleteConcurrency, cfg.Global.MaxDeletesPerHost)
+
+	// Explicit values survive.
+	cfg = ConfigVsphere{}
+	cfg.Global.CloneTimeout = configDuration{value: time.Hour, isSet: true}
+	cfg.Global.MaxConcurrentClones = 2
+	applyConfigDefaults(&cfg)
+	assert.Equal(t, time.Hour, cfg.Global.CloneTimeout.value)
+	assert.Equal(t, 2, cfg.Global.MaxConcurrentClones)
+}
+
+func TestValidateRequiredConfig(t *testing.T) {
+	var cfg ConfigVsphere
+	err := validateRequiredConfig(cfg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "vcenter, user, password")
+	}
+
+	cfg.Global.VCenter = "vcenter.example.com"
+	cfg.Global.User = "admin"
+	err = validateRequiredConfig(cfg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "password")
+		assert.NotContains(t, err.Error(), "vcenter,")
+	}
+
+	cfg.Global.Password = "secret"
+	assert.NoError(t, validateRequiredConfig(cfg))
+}
+
+func TestUnknownConfigKeys(t *testing.T) {
+	content := []byte(`
+global:
+  vcenter: vcenter.example.com
+  data-center: dc-1
+nodegroups:
+  pool-a:
+    template: ubuntu-template
+    warm-pool-size: 2
+    warm-pool-tll: 10m
+`)
+	assert.Equal(t, []string{
+		"global.data-center",
+		"nodegroups.pool-a.warm-pool-tll",
+	}, unknownConfigKeys(content))
+
+	assert.Empty(t, unknownConfigKeys([]byte("global:\n  vcenter: vcenter.example.com\n")))
+}
+
+func TestConfigSummary(t *testing.T) {
+	cfg, err := parseCloudConfig([]byte(`
+[global]
+vcenter = vcenter.example.com
+datacenter = dc-1
+user = admin
+password = hunter2
+cluster-name = prod
+
+[nodegroup "pool-b"]
+[nodegroup "pool-a"]
+`))
+	assert.NoError(t, err)
+	summary := cfg.summary()
+	assert.Contains(t, summary, "vcenter=vcenter.example.com")
+	assert.Contains(t, summary, "cluster-name=prod")
+	assert.Contains(t, summary, "nodegroups=[pool-a pool-b]")
+	assert.NotContains(t, summary, "hunter2")
+}
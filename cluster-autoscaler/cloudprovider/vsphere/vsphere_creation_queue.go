@@ -0,0 +1,193 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// defaultGlobalCloneConcurrency caps simultaneous clone tasks across all
+	// node groups unless max-concurrent-clones is set in [global].
+	defaultGlobalCloneConcurrency = 8
+	// defaultGroupCloneConcurrency caps simultaneous clone tasks of a single
+	// node group unless it sets its own max-concurrent-clones.
+	defaultGroupCloneConcurrency = 4
+
+	// creationQueuePollInterval is how often a queued clone re-checks for a
+	// free slot.
+	creationQueuePollInterval = 100 * time.Millisecond
+
+	// minCreationBackoff and maxCreationBackoff bound the adaptive delay
+	// inserted between clone starts when vCenter shows signs of overload.
+	minCreationBackoff = 10 * time.Second
+	maxCreationBackoff = 5 * time.Minute
+	// slowCloneThreshold is the clone task latency above which a success
+	// still counts as a sign of vCenter overload.
+	slowCloneThreshold = 15 * time.Minute
+)
+
+// creationQueue gates clone starts behind per node group and global
+// concurrency caps, so a large scale-up does not fire every clone task at
+// once, and spaces starts out with an adaptive backoff when recent tasks
+// failed or ran slowly — both typical symptoms of an overloaded vCenter.
+// Queued clones are visible through queuedNodes so they can be reported as
+// creating instances while they wait.
+type creationQueue struct {
+	globalLimit int
+	groupLimit  func(nodegroup string) int
+
+	sync.Mutex
+	running        int
+	runningByGroup map[string]int
+	// queued holds the node names waiting for a slot or being cloned,
+	// per node group.
+	queued map[string]map[string]bool
+	// backoff is the current delay between clone starts; zero while vCenter
+	// keeps up. It doubles on failed or slow clones and halves on fast
+	// successes.
+	backoff time.Duration
+	// nextStart is the earliest time the next clone may start.
+	nextStart time.Time
+}
+
+// newCreationQueue builds the creation queue from the configured limits.
+func newCreationQueue(cfg ConfigVsphere) *creationQueue {
+	globalLimit := cfg.Global.MaxConcurrentClones
+	if globalLimit <= 0 {
+		globalLimit = defaultGlobalCloneConcurrency
+	}
+	return &creationQueue{
+		globalLimit:    globalLimit,
+		groupLimit:     cfg.maxConcurrentClonesFor,
+		runningByGroup: make(map[string]int),
+		queued:         make(map[string]map[string]bool),
+	}
+}
+
+// run clones the named nodes through the queue and blocks until all of them
+// finished, returning the first error. The clone function is called once per
+// name, at most groupLimit/globalLimit at a time.
+func (q *creationQueue) run(ctx context.Context, nodegroup string, names []string, clone func(ctx context.Context, name string) error) error {
+	q.Lock()
+	if q.queued[nodegroup] == nil {
+		q.queued[nodegroup] = make(map[string]bool)
+	}
+	for _, name := range names {
+		q.queued[nodegroup][name] = true
+	}
+	q.Unlock()
+
+	var wg sync.WaitGroup
+	var errMutex sync.Mutex
+	var firstErr error
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			q.acquire(nodegroup)
+			start := time.Now()
+			err := clone(ctx, name)
+			q.release(nodegroup, name, time.Since(start), err)
+			if err != nil {
+				errMutex.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMutex.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// acquire blocks until the node group and global concurrency caps allow
+// another clone and the backoff spacing has passed.
+func (q *creationQueue) acquire(nodegroup string) {
+	for {
+		q.Lock()
+		if q.running < q.globalLimit && q.runningByGroup[nodegroup] < q.groupLimit(nodegroup) && !time.Now().Before(q.nextStart) {
+			q.running++
+			q.runningByGroup[nodegroup]++
+			if q.backoff > 0 {
+				q.nextStart = time.Now().Add(q.backoff)
+			}
+			q.Unlock()
+			return
+		}
+		q.Unlock()
+		time.Sleep(creationQueuePollInterval)
+	}
+}
+
+// release returns a clone's slot and adapts the backoff to its outcome:
+// failed or slow clones double the delay before the next start, fast
+// successes halve it.
+func (q *creationQueue) release(nodegroup string, name string, duration time.Duration, err error) {
+	q.Lock()
+	defer q.Unlock()
+	q.running--
+	q.runningByGroup[nodegroup]--
+	delete(q.queued[nodegroup], name)
+
+	if err != nil || duration > slowCloneThreshold {
+		if q.backoff == 0 {
+			q.backoff = minCreationBackoff
+		} else if q.backoff < maxCreationBackoff {
+			q.backoff *= 2
+			if q.backoff > maxCreationBackoff {
+				q.backoff = maxCreationBackoff
+			}
+		}
+		q.nextStart = time.Now().Add(q.backoff)
+		klog.V(2).Infof("Backing off clone starts by %s after a failed or slow clone in %s", q.backoff, nodegroup)
+	} else {
+		q.backoff /= 2
+		if q.backoff < minCreationBackoff {
+			q.backoff = 0
+		}
+	}
+}
+
+// inFlight returns how many creations are waiting or being cloned across
+// all node groups.
+func (q *creationQueue) inFlight() int {
+	q.Lock()
+	defer q.Unlock()
+	total := 0
+	for _, names := range q.queued {
+		total += len(names)
+	}
+	return total
+}
+
+// queuedNodes returns the names of nodes of a node group still waiting in
+// the queue or being cloned.
+func (q *creationQueue) queuedNodes(nodegroup string) []string {
+	q.Lock()
+	defer q.Unlock()
+	var names []string
+	for name := range q.queued[nodegroup] {
+		names = append(names, name)
+	}
+	return names
+}
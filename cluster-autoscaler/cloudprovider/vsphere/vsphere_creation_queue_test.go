@@ -0,0 +1,101 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreationQueueConcurrency(t *testing.T) {
+	queue := newCreationQueue(ConfigVsphere{
+		Global:    ConfigGlobal{MaxConcurrentClones: 3},
+		NodeGroup: map[string]*ConfigNodeGroup{"pool-a": {MaxConcurrentClones: 2}},
+	})
+
+	var mutex sync.Mutex
+	running, peak := 0, 0
+	names := []string{"pool-a-1", "pool-a-2", "pool-a-3", "pool-a-4", "pool-a-5"}
+
+	err := queue.run(context.TODO(), "pool-a", names, func(ctx context.Context, name string) error {
+		mutex.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, peak <= 2, "per-group cap exceeded: %d clones ran at once", peak)
+	assert.Empty(t, queue.queuedNodes("pool-a"))
+}
+
+func TestCreationQueueReportsQueued(t *testing.T) {
+	queue := newCreationQueue(ConfigVsphere{
+		NodeGroup: map[string]*ConfigNodeGroup{"pool-a": {MaxConcurrentClones: 1}},
+	})
+
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- queue.run(context.TODO(), "pool-a", []string{"pool-a-1", "pool-a-2"}, func(ctx context.Context, name string) error {
+			<-release
+			return nil
+		})
+	}()
+
+	// Both names stay visible as queued while the first clone blocks.
+	assert.Eventually(t, func() bool {
+		return len(queue.queuedNodes("pool-a")) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, queue.inFlight())
+
+	close(release)
+	assert.NoError(t, <-done)
+	assert.Empty(t, queue.queuedNodes("pool-a"))
+	assert.Equal(t, 0, queue.inFlight())
+}
+
+func TestCreationQueueBackoff(t *testing.T) {
+	queue := newCreationQueue(ConfigVsphere{})
+
+	queue.release("pool-a", "pool-a-1", time.Second, fmt.Errorf("clone failed"))
+	assert.Equal(t, minCreationBackoff, queue.backoff)
+	queue.release("pool-a", "pool-a-2", time.Second, fmt.Errorf("clone failed"))
+	assert.Equal(t, 2*minCreationBackoff, queue.backoff)
+
+	// A slow success is treated like a failure.
+	queue.release("pool-a", "pool-a-3", slowCloneThreshold+time.Second, nil)
+	assert.Equal(t, 4*minCreationBackoff, queue.backoff)
+
+	// Fast successes wind the backoff down again.
+	queue.release("pool-a", "pool-a-4", time.Second, nil)
+	assert.Equal(t, 2*minCreationBackoff, queue.backoff)
+	queue.release("pool-a", "pool-a-5", time.Second, nil)
+	queue.release("pool-a", "pool-a-6", time.Second, nil)
+	assert.Equal(t, time.Duration(0), queue.backoff)
+}
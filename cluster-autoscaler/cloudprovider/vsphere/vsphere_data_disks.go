@@ -0,0 +1,84 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// firstClassDisks filters a VM's device list down to its first-class disks
+// (FCDs). CNS volumes attached by the vSphere CSI driver show up as FCDs:
+// virtual disks carrying a vDiskId. The VM's own disks, cloned from the
+// template, have no vDiskId and are destroyed with the VM as usual.
+func firstClassDisks(devices []types.BaseVirtualDevice) []*types.VirtualDisk {
+	var disks []*types.VirtualDisk
+	for _, device := range devices {
+		if disk, ok := device.(*types.VirtualDisk); ok && disk.VDiskId != nil {
+			disks = append(disks, disk)
+		}
+	}
+	return disks
+}
+
+// detachDataDisks detaches any first-class disks still attached to a VM so
+// a following destroy cannot take the data with it. The disks are removed
+// from the VM without a file operation, which keeps their backing files —
+// data disks are never deleted, only detached. Normally the CSI driver has
+// long detached its volumes by the time the autoscaler deletes a node, but
+// a wedged kubelet or a failed unpublish can leave one behind and a destroy
+// of the VM would then delete the volume too.
+func (mgr *vsphereManagerGovmomi) detachDataDisks(ctx context.Context, vm *object.VirtualMachine, nodegroup, name string) error {
+	vms, err := mgr.retrieveVMProperties(ctx, []types.ManagedObjectReference{vm.Reference()}, []string{"config.hardware.device"})
+	if err != nil {
+		return fmt.Errorf("could not list devices of %s: %v", name, err)
+	}
+	if len(vms) != 1 || vms[0].Config == nil {
+		return nil
+	}
+	disks := firstClassDisks(vms[0].Config.Hardware.Device)
+	if len(disks) == 0 {
+		return nil
+	}
+
+	spec := types.VirtualMachineConfigSpec{}
+	for _, disk := range disks {
+		klog.V(1).Infof("Detaching first-class disk %s from %s before destroying it", disk.VDiskId.Id, name)
+		spec.DeviceChange = append(spec.DeviceChange, &types.VirtualDeviceConfigSpec{
+			// Remove without a FileOperation detaches the disk from the VM
+			// but leaves its backing untouched.
+			Operation: types.VirtualDeviceConfigSpecOperationRemove,
+			Device:    disk,
+		})
+	}
+	task, err := vm.Reconfigure(ctx, spec)
+	if err != nil {
+		err = fmt.Errorf("could not start detach of %d first-class disks from %s: %v", len(disks), name, err)
+		mgr.auditRecord("detach-disks", nodegroup, name, "scale-down", "", err)
+		return err
+	}
+	err = task.Wait(ctx)
+	mgr.auditRecord("detach-disks", nodegroup, name, "scale-down", task.Reference().Value, err)
+	if err != nil {
+		return fmt.Errorf("could not detach %d first-class disks from %s: %v", len(disks), name, err)
+	}
+	return nil
+}
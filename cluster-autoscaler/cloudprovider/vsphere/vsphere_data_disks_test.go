@@ -0,0 +1,41 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestFirstClassDisks(t *testing.T) {
+	templateDisk := &types.VirtualDisk{}
+	cnsDisk := &types.VirtualDisk{VDiskId: &types.ID{Id: "fcd-1"}}
+	devices := []types.BaseVirtualDevice{
+		&types.VirtualE1000{},
+		templateDisk,
+		cnsDisk,
+	}
+
+	disks := firstClassDisks(devices)
+	assert.Len(t, disks, 1)
+	assert.Equal(t, "fcd-1", disks[0].VDiskId.Id)
+
+	// A VM without FCDs yields nothing to detach.
+	assert.Empty(t, firstClassDisks([]types.BaseVirtualDevice{templateDisk}))
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// debugTask is one task record of the debug view.
+type debugTask struct {
+	Node      string `json:"node"`
+	NodeGroup string `json:"nodeGroup"`
+	Kind      string `json:"kind"`
+	State     string `json:"state"`
+	Message   string `json:"message,omitempty"`
+	Updated   string `json:"updated"`
+}
+
+// managerDebugState is the manager half of the debug view: session status,
+// cached inventory and vCenter task state that the health summary does not
+// carry.
+type managerDebugState struct {
+	// VCenter is the vCenter the manager currently talks to; during a
+	// failover this is the secondary.
+	VCenter        string `json:"vCenter"`
+	FailoverActive bool   `json:"failoverActive,omitempty"`
+	FailoverSince  string `json:"failoverSince,omitempty"`
+	Degraded       string `json:"degraded,omitempty"`
+	// InventoryTaken is when the served inventory snapshot was swept;
+	// InventoryVMs is its VM count per node group.
+	InventoryTaken string         `json:"inventoryTaken,omitempty"`
+	InventoryVMs   map[string]int `json:"inventoryVMs,omitempty"`
+	Tasks          []debugTask    `json:"tasks,omitempty"`
+	// RecentErrors are the failed tasks still within their retention.
+	RecentErrors []string `json:"recentErrors,omitempty"`
+}
+
+// debugView is the JSON document the debug endpoint serves.
+type debugView struct {
+	Health  healthSummary     `json:"health"`
+	Manager managerDebugState `json:"manager"`
+}
+
+// debugStateReporter is implemented by managers that expose their internal
+// state on an HTTP debug endpoint.
+type debugStateReporter interface {
+	debugEndpointPath() string
+	debugState() managerDebugState
+}
+
+// debugEndpointPath returns the configured debug endpoint path, or empty
+// when the endpoint is disabled.
+func (mgr *vsphereManagerGovmomi) debugEndpointPath() string {
+	return mgr.config.Global.DebugEndpoint
+}
+
+// debugState assembles the manager half of the debug view from the live
+// structures, taking each lock only briefly.
+func (mgr *vsphereManagerGovmomi) debugState() managerDebugState {
+	state := managerDebugState{VCenter: mgr.config.Global.VCenter}
+
+	mgr.failoverMutex.Lock()
+	if mgr.failoverActive {
+		state.VCenter = mgr.config.Global.SecondaryVCenter
+		state.FailoverActive = true
+		state.FailoverSince = mgr.failoverSince.Format(time.RFC3339)
+	}
+	mgr.failoverMutex.Unlock()
+
+	state.Degraded = mgr.degradedStatus()
+
+	mgr.inventoryMutex.RLock()
+	if mgr.inventory != nil {
+		state.InventoryTaken = mgr.inventory.taken.UTC().Format(time.RFC3339)
+		state.InventoryVMs = make(map[string]int, len(mgr.inventory.vmsByGroup))
+		for nodegroup, refs := range mgr.inventory.vmsByGroup {
+			state.InventoryVMs[nodegroup] = len(refs)
+		}
+	}
+	mgr.inventoryMutex.RUnlock()
+
+	if mgr.tasks != nil {
+		for _, task := range mgr.tasks.snapshot() {
+			state.Tasks = append(state.Tasks, debugTask{
+				Node:      task.nodeName,
+				NodeGroup: task.nodegroup,
+				Kind:      task.kind,
+				State:     string(task.state),
+				Message:   task.message,
+				Updated:   task.updated.UTC().Format(time.RFC3339),
+			})
+			if task.state == types.TaskInfoStateError {
+				state.RecentErrors = append(state.RecentErrors,
+					fmt.Sprintf("%s %s: %s", task.kind, task.nodeName, task.message))
+			}
+		}
+		sort.Slice(state.Tasks, func(i, j int) bool {
+			return state.Tasks[i].Node < state.Tasks[j].Node
+		})
+		sort.Strings(state.RecentErrors)
+	}
+	return state
+}
+
+// registerDebugEndpoint registers the debug handler on the default mux,
+// which the autoscaler's metrics listener serves as its fallback.
+func (vcp *vsphereCloudProvider) registerDebugEndpoint(path string) {
+	http.HandleFunc(path, vcp.serveDebug)
+	klog.V(1).Infof("Serving vsphere provider state at %s", path)
+}
+
+// serveDebug writes the debug view as indented JSON. It takes the cluster
+// update lock so the node group set cannot change underneath it; requests
+// therefore queue behind an in-flight scaling operation rather than racing
+// it.
+func (vcp *vsphereCloudProvider) serveDebug(w http.ResponseWriter, r *http.Request) {
+	vcp.clusterUpdateMutex.Lock()
+	view := debugView{Health: vcp.collectHealth()}
+	if reporter, ok := vcp.vsphereManager.(debugStateReporter); ok {
+		view.Manager = reporter.debugState()
+	}
+	vcp.clusterUpdateMutex.Unlock()
+
+	data, err := json.MarshalIndent(&view, "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not marshal debug view: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestDebugState(t *testing.T) {
+	mgr := testManager(newFakeTagService())
+	mgr.config.Global.VCenter = "vc1.example.com"
+	mgr.config.Global.SecondaryVCenter = "vc2.example.com"
+	mgr.inventory = &inventorySnapshot{
+		vmsByGroup: map[string][]types.ManagedObjectReference{
+			"ng1": {vmRef("vm-1"), vmRef("vm-2")},
+			"ng2": nil,
+		},
+		taken: time.Now(),
+	}
+	mgr.tasks = &taskManager{tasks: map[string]*nodeTask{}}
+	mgr.tasks.track("ng1", "node-1", taskKindClone, vmRef("task-1"))
+	mgr.tasks.track("ng1", "node-2", taskKindDelete, vmRef("task-2"))
+	mgr.tasks.complete("node-2", assert.AnError)
+
+	state := mgr.debugState()
+	assert.Equal(t, "vc1.example.com", state.VCenter)
+	assert.False(t, state.FailoverActive)
+	assert.Equal(t, map[string]int{"ng1": 2, "ng2": 0}, state.InventoryVMs)
+	assert.NotEmpty(t, state.InventoryTaken)
+
+	assert.Len(t, state.Tasks, 2)
+	assert.Equal(t, "node-1", state.Tasks[0].Node)
+	assert.Equal(t, string(types.TaskInfoStateRunning), state.Tasks[0].State)
+	assert.Equal(t, string(types.TaskInfoStateError), state.Tasks[1].State)
+	assert.Len(t, state.RecentErrors, 1)
+	assert.Contains(t, state.RecentErrors[0], "node-2")
+
+	// During a failover the session status points at the secondary.
+	mgr.failoverActive = true
+	mgr.failoverSince = time.Now()
+	state = mgr.debugState()
+	assert.Equal(t, "vc2.example.com", state.VCenter)
+	assert.True(t, state.FailoverActive)
+	assert.NotEmpty(t, state.FailoverSince)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"sync"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+	"k8s.io/klog"
+)
+
+const (
+	// deferredBuildInitialBackoff is the wait before the first rebuild
+	// attempt after a failed BuildVsphere.
+	deferredBuildInitialBackoff = 30 * time.Second
+	// deferredBuildMaxBackoff caps the doubling between rebuild attempts.
+	deferredBuildMaxBackoff = 5 * time.Minute
+)
+
+// deferredVsphereProvider is the degraded provider BuildVsphere returns
+// when construction fails, typically because vCenter was unreachable at
+// startup. It presents an empty cluster — no node groups, nothing to
+// scale — and retries the full build with backoff on every Refresh,
+// handing off to the real provider once one comes up. This keeps a
+// transient vCenter outage from crash-looping the whole autoscaler.
+type deferredVsphereProvider struct {
+	opts config.AutoscalingOptions
+	do   cloudprovider.NodeGroupDiscoveryOptions
+	rl   *cloudprovider.ResourceLimiter
+
+	mutex       sync.Mutex
+	delegate    cloudprovider.CloudProvider
+	lastErr     error
+	nextAttempt time.Time
+	backoff     time.Duration
+}
+
+// newDeferredVsphereProvider wraps a failed build so it can be retried.
+func newDeferredVsphereProvider(opts config.AutoscalingOptions, do cloudprovider.NodeGroupDiscoveryOptions, rl *cloudprovider.ResourceLimiter, err error) *deferredVsphereProvider {
+	return &deferredVsphereProvider{
+		opts:        opts,
+		do:          do,
+		rl:          rl,
+		lastErr:     err,
+		nextAttempt: time.Now().Add(deferredBuildInitialBackoff),
+		backoff:     deferredBuildInitialBackoff,
+	}
+}
+
+// current returns the real provider once a rebuild has succeeded.
+func (dvp *deferredVsphereProvider) current() cloudprovider.CloudProvider {
+	dvp.mutex.Lock()
+	defer dvp.mutex.Unlock()
+	return dvp.delegate
+}
+
+// Refresh retries the build while no real provider exists, and delegates
+// once one does.
+func (dvp *deferredVsphereProvider) Refresh() error {
+	if delegate := dvp.current(); delegate != nil {
+		return delegate.Refresh()
+	}
+
+	dvp.mutex.Lock()
+	defer dvp.mutex.Unlock()
+	if time.Now().Before(dvp.nextAttempt) {
+		return dvp.lastErr
+	}
+
+	provider, err := buildVsphereProvider(dvp.opts, dvp.do, dvp.rl)
+	if err != nil {
+		dvp.lastErr = err
+		dvp.backoff *= 2
+		if dvp.backoff > deferredBuildMaxBackoff {
+			dvp.backoff = deferredBuildMaxBackoff
+		}
+		dvp.nextAttempt = time.Now().Add(dvp.backoff)
+		klog.Errorf("Could not build vsphere cloud provider, retrying in %v: %v", dvp.backoff, err)
+		return err
+	}
+
+	klog.V(1).Infof("Vsphere cloud provider built successfully after earlier failures")
+	dvp.delegate = provider
+	dvp.lastErr = nil
+	return provider.Refresh()
+}
+
+// Name returns the name of the cloud provider.
+func (dvp *deferredVsphereProvider) Name() string {
+	return ProviderName
+}
+
+// GPULabel returns the label added to nodes with GPU resource.
+func (dvp *deferredVsphereProvider) GPULabel() string {
+	return GPULabel
+}
+
+// GetAvailableGPUTypes return all available GPU types cloud provider supports.
+func (dvp *deferredVsphereProvider) GetAvailableGPUTypes() map[string]struct{} {
+	return availableGPUTypes
+}
+
+// NodeGroups returns no node groups until the build has succeeded.
+func (dvp *deferredVsphereProvider) NodeGroups() []cloudprovider.NodeGroup {
+	if delegate := dvp.current(); delegate != nil {
+		return delegate.NodeGroups()
+	}
+	return []cloudprovider.NodeGroup{}
+}
+
+// NodeGroupForNode treats every node as unmanaged until the build has
+// succeeded, so the core neither scales down nor blames a group.
+func (dvp *deferredVsphereProvider) NodeGroupForNode(node *apiv1.Node) (cloudprovider.NodeGroup, error) {
+	if delegate := dvp.current(); delegate != nil {
+		return delegate.NodeGroupForNode(node)
+	}
+	return nil, nil
+}
+
+// Pricing is not implemented.
+func (dvp *deferredVsphereProvider) Pricing() (cloudprovider.PricingModel, errors.AutoscalerError) {
+	if delegate := dvp.current(); delegate != nil {
+		return delegate.Pricing()
+	}
+	return nil, cloudprovider.ErrNotImplemented
+}
+
+// GetAvailableMachineTypes returns nothing until the build has succeeded.
+func (dvp *deferredVsphereProvider) GetAvailableMachineTypes() ([]string, error) {
+	if delegate := dvp.current(); delegate != nil {
+		return delegate.GetAvailableMachineTypes()
+	}
+	return []string{}, nil
+}
+
+// NewNodeGroup is not implemented.
+func (dvp *deferredVsphereProvider) NewNodeGroup(machineType string, labels map[string]string, systemLabels map[string]string,
+	taints []apiv1.Taint, extraResources map[string]resource.Quantity) (cloudprovider.NodeGroup, error) {
+	return nil, cloudprovider.ErrNotImplemented
+}
+
+// GetResourceLimiter returns resource constraints for the cloud provider.
+func (dvp *deferredVsphereProvider) GetResourceLimiter() (*cloudprovider.ResourceLimiter, error) {
+	if delegate := dvp.current(); delegate != nil {
+		return delegate.GetResourceLimiter()
+	}
+	return dvp.rl, nil
+}
+
+// Cleanup is called when the autoscaler shuts down.
+func (dvp *deferredVsphereProvider) Cleanup() error {
+	if delegate := dvp.current(); delegate != nil {
+		return delegate.Cleanup()
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	k8smetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog"
+)
+
+// degradedProbeInterval is how often a degraded manager re-checks vCenter
+// access. Between probes all mutating operations fail fast, so a revoked
+// role does not turn into an endless stream of NoPermission faults.
+const degradedProbeInterval = 2 * time.Minute
+
+// degradedReasonPermission marks degradation caused by NoPermission or
+// InvalidLogin faults, i.e. the service account's role was edited or its
+// session can no longer authenticate.
+const degradedReasonPermission = "permission"
+
+var (
+	degradedMetric = k8smetrics.NewGaugeVec(
+		&k8smetrics.GaugeOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "vsphere_degraded",
+			Help:      "Whether the vsphere provider is degraded, by reason (1 degraded, 0 healthy).",
+		}, []string{"reason"},
+	)
+
+	registerDegradedMetricOnce sync.Once
+)
+
+// degradedState tracks whether the manager has stopped talking to vCenter
+// and why. It enters the degraded state on the first permission fault and
+// leaves it once a probe succeeds again.
+type degradedState struct {
+	mutex     sync.Mutex
+	reason    string
+	since     time.Time
+	lastErr   error
+	lastProbe time.Time
+}
+
+// enter records the start of a degraded period. Repeated faults while
+// already degraded only update the last error.
+func (d *degradedState) enter(reason string, err error) {
+	registerDegradedMetricOnce.Do(func() {
+		legacyregistry.MustRegister(degradedMetric)
+	})
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.lastErr = err
+	if d.reason == reason {
+		return
+	}
+	d.reason = reason
+	d.since = time.Now()
+	degradedMetric.WithLabelValues(reason).Set(1)
+	klog.Errorf("Entering degraded mode (%s), suspending vCenter operations: %v", reason, err)
+}
+
+// clear leaves the degraded state.
+func (d *degradedState) clear() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.reason == "" {
+		return
+	}
+	degradedMetric.WithLabelValues(d.reason).Set(0)
+	klog.Warningf("Leaving degraded mode (%s) after %s, vCenter access restored", d.reason, time.Since(d.since).Round(time.Second))
+	d.reason = ""
+	d.lastErr = nil
+}
+
+// check returns the error operations fail fast with while degraded; nil
+// when healthy.
+func (d *degradedState) check() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.reason == "" {
+		return nil
+	}
+	return fmt.Errorf("vCenter access degraded (%s) since %s: %v",
+		d.reason, d.since.UTC().Format(time.RFC3339), d.lastErr)
+}
+
+// shouldProbe reports whether a degraded state is due for a recovery
+// probe, at most once per degradedProbeInterval.
+func (d *degradedState) shouldProbe() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.reason == "" {
+		return false
+	}
+	if time.Since(d.lastProbe) < degradedProbeInterval {
+		return false
+	}
+	d.lastProbe = time.Now()
+	return true
+}
+
+// status returns a one-line description of the degraded state for the
+// health summary; empty when healthy.
+func (d *degradedState) status() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.reason == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s since %s: %v", d.reason, d.since.UTC().Format(time.RFC3339), d.lastErr)
+}
+
+// noteDegraded inspects an operation error and enters degraded mode when
+// it is a permission fault, the kind retrying cannot get past until the
+// role or the config changes.
+func (mgr *vsphereManagerGovmomi) noteDegraded(err error) {
+	if err == nil || !isPermissionError(err) {
+		return
+	}
+	mgr.degraded.enter(degradedReasonPermission, err)
+}
+
+// degradedError fails fast while vCenter access is degraded, giving a
+// recovery probe a chance first: when a probe interval has passed, a cheap
+// inventory read checks whether access is back. On recovery the privilege
+// preflight runs again, so a partially restored role is reported.
+func (mgr *vsphereManagerGovmomi) degradedError() error {
+	if !mgr.degraded.shouldProbe() {
+		return mgr.degraded.check()
+	}
+
+	ctx := context.TODO()
+	if _, err := mgr.finder(ctx); err != nil {
+		klog.V(2).Infof("Degraded mode probe failed, staying degraded: %v", err)
+		return mgr.degraded.check()
+	}
+	mgr.degraded.clear()
+	mgr.preflightPrivileges(ctx)
+	return nil
+}
+
+// degradedStatus implements degradedReporter for the health summary.
+func (mgr *vsphereManagerGovmomi) degradedStatus() string {
+	return mgr.degraded.status()
+}
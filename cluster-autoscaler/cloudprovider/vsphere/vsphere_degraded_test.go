@@ -0,0 +1,56 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDegradedState(t *testing.T) {
+	state := &degradedState{}
+
+	// Healthy: nothing to report, nothing to probe.
+	assert.NoError(t, state.check())
+	assert.Empty(t, state.status())
+	assert.False(t, state.shouldProbe())
+
+	state.enter(degradedReasonPermission, fmt.Errorf("NoPermission"))
+	err := state.check()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "permission")
+		assert.Contains(t, err.Error(), "NoPermission")
+	}
+	assert.Contains(t, state.status(), "permission")
+
+	// The first probe is due immediately, the next one only after the
+	// probe interval.
+	assert.True(t, state.shouldProbe())
+	assert.False(t, state.shouldProbe())
+
+	// Repeated faults update the error without resetting the start time.
+	since := state.since
+	state.enter(degradedReasonPermission, fmt.Errorf("InvalidLogin"))
+	assert.Equal(t, since, state.since)
+	assert.Contains(t, state.check().Error(), "InvalidLogin")
+
+	state.clear()
+	assert.NoError(t, state.check())
+	assert.Empty(t, state.status())
+}
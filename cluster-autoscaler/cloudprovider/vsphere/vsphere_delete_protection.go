@@ -0,0 +1,116 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+const (
+	// createdByKey is the ExtraConfig key marking a VM as created (or
+	// adopted) by the autoscaler. Scale-down refuses VMs without it.
+	createdByKey = "cluster-autoscaler.created-by"
+
+	// createdByValue is the marker value.
+	createdByValue = "cluster-autoscaler"
+)
+
+// hasCreatedByMarker reports whether a VM's ExtraConfig carries the
+// created-by marker.
+func hasCreatedByMarker(extraConfig []types.BaseOptionValue) bool {
+	for _, opt := range extraConfig {
+		value := opt.GetOptionValue()
+		if value != nil && value.Key == createdByKey {
+			marker, _ := value.Value.(string)
+			return marker == createdByValue
+		}
+	}
+	return false
+}
+
+// checkCreatedByMarker refuses to scale down a VM that does not carry the
+// created-by marker every clone and adoption stamps, so mis-applied tags
+// cannot make the autoscaler destroy pet VMs. disable-created-by-check in
+// the global section turns the check off, e.g. while nodes created before
+// the marker existed are still around.
+func (mgr *vsphereManagerGovmomi) checkCreatedByMarker(ctx context.Context, vmRef types.ManagedObjectReference, name string) error {
+	if mgr.config.Global.DisableCreatedByCheck {
+		return nil
+	}
+	vms, err := mgr.retrieveVMProperties(ctx, []types.ManagedObjectReference{vmRef}, []string{"config.extraConfig"})
+	if err != nil {
+		return fmt.Errorf("could not read created-by marker of VM %s: %v", name, err)
+	}
+	if len(vms) == 1 && vms[0].Config != nil && hasCreatedByMarker(vms[0].Config.ExtraConfig) {
+		return nil
+	}
+	return fmt.Errorf("VM %s does not carry the %s marker, refusing to destroy it (disable-created-by-check overrides)", name, createdByKey)
+}
+
+// checkIgnoreTag refuses to scale down a VM carrying the per-VM ignore
+// tag, the opt-out for one-off nodes that live among a group's members.
+// The tag not existing at all means nothing carries it; tag service
+// trouble fails the check, erring on the side of not deleting.
+func (mgr *vsphereManagerGovmomi) checkIgnoreTag(ctx context.Context, vmRef types.ManagedObjectReference, name string) error {
+	tagID, err := mgr.activeTags().GetTagID(ctx, ignoreTag)
+	if isTagNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not look up tag %s: %v", ignoreTag, err)
+	}
+	refs, err := mgr.activeTags().ListAttachedObjects(ctx, tagID)
+	if err != nil {
+		return fmt.Errorf("could not list objects with tag %s: %v", ignoreTag, err)
+	}
+	for _, ref := range refs {
+		if ref.Reference() == vmRef {
+			return fmt.Errorf("VM %s carries the %s tag, refusing to scale it down", name, ignoreTag)
+		}
+	}
+	return nil
+}
+
+// stampCreatedBy marks a VM the autoscaler did not clone itself — an
+// adopted one — as managed, so the created-by check lets it through at
+// scale-down.
+func (mgr *vsphereManagerGovmomi) stampCreatedBy(ctx context.Context, ref types.ManagedObjectReference) error {
+	if mgr.activeClient() == nil {
+		// Tag-service-only setups (the in-memory test fake) have no SOAP
+		// client to reconfigure through.
+		return nil
+	}
+	vm := object.NewVirtualMachine(mgr.activeClient().Vim, ref)
+	task, err := vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{
+		ExtraConfig: []types.BaseOptionValue{
+			&types.OptionValue{Key: createdByKey, Value: createdByValue},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not stamp created-by marker: %v", err)
+	}
+	if err := task.Wait(ctx); err != nil {
+		return fmt.Errorf("could not stamp created-by marker: %v", err)
+	}
+	klog.V(4).Infof("Stamped created-by marker on %s", ref.Value)
+	return nil
+}
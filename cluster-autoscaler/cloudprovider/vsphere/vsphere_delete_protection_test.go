@@ -0,0 +1,54 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestHasCreatedByMarker(t *testing.T) {
+	assert.False(t, hasCreatedByMarker(nil))
+	assert.False(t, hasCreatedByMarker([]types.BaseOptionValue{
+		&types.OptionValue{Key: "guestinfo.something", Value: "else"},
+	}))
+	// A foreign value under our key is not our marker.
+	assert.False(t, hasCreatedByMarker([]types.BaseOptionValue{
+		&types.OptionValue{Key: createdByKey, Value: "terraform"},
+	}))
+	assert.True(t, hasCreatedByMarker([]types.BaseOptionValue{
+		&types.OptionValue{Key: "guestinfo.something", Value: "else"},
+		&types.OptionValue{Key: createdByKey, Value: createdByValue},
+	}))
+}
+
+func TestCheckIgnoreTag(t *testing.T) {
+	ctx := context.TODO()
+	tags := newFakeTagService(ignoreTag)
+	mgr := testManager(tags)
+	assert.NoError(t, tags.AttachTag(ctx, tags.tags[ignoreTag], vmRef("vm-1")))
+
+	assert.Error(t, mgr.checkIgnoreTag(ctx, vmRef("vm-1"), "vm-1"))
+	assert.NoError(t, mgr.checkIgnoreTag(ctx, vmRef("vm-2"), "vm-2"))
+
+	// The tag not existing at all means nothing is ignored.
+	mgr = testManager(newFakeTagService())
+	assert.NoError(t, mgr.checkIgnoreTag(ctx, vmRef("vm-1"), "vm-1"))
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultGlobalDeleteConcurrency caps simultaneous VM deletions across
+	// all node groups unless max-concurrent-deletes is set in [global].
+	defaultGlobalDeleteConcurrency = 4
+	// defaultHostDeleteConcurrency caps simultaneous deletions of VMs on
+	// the same ESXi host unless max-deletes-per-host is set.
+	defaultHostDeleteConcurrency = 2
+
+	// deletionQueuePollInterval is how often a queued deletion re-checks
+	// for a free slot.
+	deletionQueuePollInterval = 100 * time.Millisecond
+)
+
+// deletionQueue gates VM deletions behind a global and a per ESXi host
+// concurrency cap, so shedding many nodes at once neither saturates a
+// datastore with destroy operations nor evicts the pods of too many VMs
+// of one host simultaneously. Deletions beyond the caps wait their turn;
+// the counterpart of the creation queue, without the adaptive backoff —
+// scale-down is never urgent enough to need pacing beyond the caps.
+type deletionQueue struct {
+	globalLimit int
+	hostLimit   int
+
+	sync.Mutex
+	running       int
+	runningByHost map[string]int
+}
+
+// newDeletionQueue builds the deletion queue from the configured limits.
+func newDeletionQueue(cfg ConfigVsphere) *deletionQueue {
+	globalLimit := cfg.Global.MaxConcurrentDeletes
+	if globalLimit <= 0 {
+		globalLimit = defaultGlobalDeleteConcurrency
+	}
+	hostLimit := cfg.Global.MaxDeletesPerHost
+	if hostLimit <= 0 {
+		hostLimit = defaultHostDeleteConcurrency
+	}
+	return &deletionQueue{
+		globalLimit:   globalLimit,
+		hostLimit:     hostLimit,
+		runningByHost: make(map[string]int),
+	}
+}
+
+// acquire blocks until the global and per-host caps allow another
+// deletion. VMs whose host could not be determined pass an empty host and
+// are only subject to the global cap.
+func (q *deletionQueue) acquire(host string) {
+	for {
+		q.Lock()
+		if q.running < q.globalLimit && (host == "" || q.runningByHost[host] < q.hostLimit) {
+			q.running++
+			if host != "" {
+				q.runningByHost[host]++
+			}
+			q.Unlock()
+			return
+		}
+		q.Unlock()
+		time.Sleep(deletionQueuePollInterval)
+	}
+}
+
+// release returns a deletion's slot.
+func (q *deletionQueue) release(host string) {
+	q.Lock()
+	defer q.Unlock()
+	q.running--
+	if host != "" {
+		q.runningByHost[host]--
+		if q.runningByHost[host] == 0 {
+			delete(q.runningByHost, host)
+		}
+	}
+}
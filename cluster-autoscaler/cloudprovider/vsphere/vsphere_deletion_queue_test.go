@@ -0,0 +1,88 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeletionQueuePerHostCap(t *testing.T) {
+	queue := newDeletionQueue(ConfigVsphere{
+		Global: ConfigGlobal{MaxConcurrentDeletes: 4, MaxDeletesPerHost: 1},
+	})
+
+	var mutex sync.Mutex
+	runningOnHost, peakOnHost := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			queue.acquire("host-1")
+			defer queue.release("host-1")
+			mutex.Lock()
+			runningOnHost++
+			if runningOnHost > peakOnHost {
+				peakOnHost = runningOnHost
+			}
+			mutex.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mutex.Lock()
+			runningOnHost--
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, peakOnHost, "per-host cap exceeded")
+	assert.Equal(t, 0, queue.running)
+	assert.Empty(t, queue.runningByHost)
+}
+
+func TestDeletionQueueGlobalCap(t *testing.T) {
+	queue := newDeletionQueue(ConfigVsphere{
+		Global: ConfigGlobal{MaxConcurrentDeletes: 2},
+	})
+
+	var mutex sync.Mutex
+	running, peak := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Unknown host: only the global cap applies.
+			queue.acquire("")
+			defer queue.release("")
+			mutex.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mutex.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.True(t, peak <= 2, "global cap exceeded: %d deletions ran at once", peak)
+}
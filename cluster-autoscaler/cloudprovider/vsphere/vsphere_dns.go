@@ -0,0 +1,191 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog"
+)
+
+const defaultDNSTTL = 300
+
+// dnsRegistrar maintains DNS records for nodes in environments without
+// cloud DNS integration. Implementations: RFC2136 dynamic updates, or an
+// external webhook.
+type dnsRegistrar interface {
+	register(nodeName, address string) error
+	deregister(nodeName string) error
+}
+
+// rfc2136Registrar sends dynamic updates (RFC2136) to a DNS server,
+// optionally signed with TSIG.
+type rfc2136Registrar struct {
+	server     string
+	zone       string
+	ttl        int
+	tsigKey    string
+	tsigSecret string
+	client     *dns.Client
+}
+
+// newRFC2136Registrar builds a registrar for the configured server and
+// zone.
+func newRFC2136Registrar(cfg ConfigGlobal) (*rfc2136Registrar, error) {
+	if cfg.DNSServer == "" || cfg.DNSZone == "" {
+		return nil, fmt.Errorf("dns-mode rfc2136 requires dns-server and dns-zone to be set")
+	}
+	r := &rfc2136Registrar{
+		server: cfg.DNSServer,
+		zone:   dns.Fqdn(cfg.DNSZone),
+		ttl:    cfg.DNSTTL,
+		client: &dns.Client{Timeout: 10 * time.Second},
+	}
+	if r.ttl == 0 {
+		r.ttl = defaultDNSTTL
+	}
+	if cfg.DNSTsigKeyName != "" {
+		r.tsigKey = dns.Fqdn(cfg.DNSTsigKeyName)
+		r.tsigSecret = cfg.DNSTsigSecret
+		r.client.TsigSecret = map[string]string{r.tsigKey: r.tsigSecret}
+	}
+	return r, nil
+}
+
+// fqdn returns the record name of a node in the configured zone.
+func (r *rfc2136Registrar) fqdn(nodeName string) string {
+	return dns.Fqdn(fmt.Sprintf("%s.%s", nodeName, r.zone))
+}
+
+// send signs (when TSIG is configured) and exchanges an update message,
+// checking the response code.
+func (r *rfc2136Registrar) send(msg *dns.Msg) error {
+	if r.tsigKey != "" {
+		msg.SetTsig(r.tsigKey, dns.HmacSHA256, 300, time.Now().Unix())
+	}
+	response, _, err := r.client.Exchange(msg, r.server)
+	if err != nil {
+		return fmt.Errorf("dns update against %s failed: %v", r.server, err)
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("dns update against %s refused: %s", r.server, dns.RcodeToString[response.Rcode])
+	}
+	return nil
+}
+
+// register inserts an A record for the node.
+func (r *rfc2136Registrar) register(nodeName, address string) error {
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d A %s", r.fqdn(nodeName), r.ttl, address))
+	if err != nil {
+		return fmt.Errorf("could not build A record for %s: %v", nodeName, err)
+	}
+	msg := new(dns.Msg)
+	msg.SetUpdate(r.zone)
+	msg.Insert([]dns.RR{rr})
+	return r.send(msg)
+}
+
+// deregister removes all records of the node's name.
+func (r *rfc2136Registrar) deregister(nodeName string) error {
+	rr, err := dns.NewRR(fmt.Sprintf("%s 0 ANY", r.fqdn(nodeName)))
+	if err != nil {
+		return fmt.Errorf("could not build record for %s: %v", nodeName, err)
+	}
+	msg := new(dns.Msg)
+	msg.SetUpdate(r.zone)
+	msg.RemoveName([]dns.RR{rr})
+	return r.send(msg)
+}
+
+// webhookDNSRegistrar delegates record management to an external service
+// via JSON over HTTP, mirroring the IPAM webhook.
+type webhookDNSRegistrar struct {
+	url     string
+	cluster string
+	client  *http.Client
+}
+
+func newWebhookDNSRegistrar(url, cluster string) *webhookDNSRegistrar {
+	return &webhookDNSRegistrar{
+		url:     url,
+		cluster: cluster,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// dnsRequest is the payload sent to the DNS webhook.
+type dnsRequest struct {
+	Action  string `json:"action"`
+	Cluster string `json:"cluster"`
+	Node    string `json:"node"`
+	Address string `json:"address,omitempty"`
+}
+
+func (w *webhookDNSRegistrar) call(request dnsRequest) error {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	response, err := w.client.Post(w.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("dns webhook call failed: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("dns webhook returned status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// register asks the webhook to create the node's record.
+func (w *webhookDNSRegistrar) register(nodeName, address string) error {
+	return w.call(dnsRequest{Action: "register", Cluster: w.cluster, Node: nodeName, Address: address})
+}
+
+// deregister asks the webhook to remove the node's record.
+func (w *webhookDNSRegistrar) deregister(nodeName string) error {
+	return w.call(dnsRequest{Action: "deregister", Cluster: w.cluster, Node: nodeName})
+}
+
+// registerDNS creates the node's DNS record, logging failures: a node
+// that joins without a record is still usable.
+func (mgr *vsphereManagerGovmomi) registerDNS(nodeName, address string) {
+	if mgr.dns == nil {
+		return
+	}
+	if err := mgr.dns.register(nodeName, address); err != nil {
+		klog.Errorf("Could not register DNS record for %s: %v", nodeName, err)
+		return
+	}
+	klog.V(2).Infof("Registered DNS record for %s -> %s", nodeName, address)
+}
+
+// deregisterDNS removes the node's DNS record, logging failures: a stale
+// record must not block a scale-down.
+func (mgr *vsphereManagerGovmomi) deregisterDNS(nodeName string) {
+	if mgr.dns == nil {
+		return
+	}
+	if err := mgr.dns.deregister(nodeName); err != nil {
+		klog.Errorf("Could not deregister DNS record of %s: %v", nodeName, err)
+	}
+}
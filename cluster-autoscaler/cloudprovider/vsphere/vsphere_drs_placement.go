@@ -0,0 +1,95 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	// scaleDownPlacementConsolidate deletes from the most lightly loaded
+	// hosts first, tending to empty hosts so DPM can power them off.
+	scaleDownPlacementConsolidate = "consolidate"
+	// scaleDownPlacementRelieve deletes from the most loaded hosts first,
+	// using the same CPU and memory demand DRS balances on, to relieve
+	// contention for the VMs staying behind.
+	scaleDownPlacementRelieve = "relieve-contention"
+)
+
+// scaleDownPlacement returns the effective scale-down placement policy.
+// The legacy host-consolidation flag is the older spelling of
+// "consolidate"; an explicit scale-down-placement wins over it.
+func (cfg *ConfigVsphere) scaleDownPlacement() string {
+	if policy := cfg.Global.ScaleDownPlacement; policy != "" {
+		return policy
+	}
+	if cfg.Global.HostConsolidation {
+		return scaleDownPlacementConsolidate
+	}
+	return ""
+}
+
+// validateScaleDownPlacement rejects unknown placement policies at startup.
+func validateScaleDownPlacement(cfg ConfigVsphere) error {
+	switch cfg.Global.ScaleDownPlacement {
+	case "", scaleDownPlacementConsolidate, scaleDownPlacementRelieve:
+		return nil
+	default:
+		return fmt.Errorf("invalid scale-down-placement %q, must be %s or %s",
+			cfg.Global.ScaleDownPlacement, scaleDownPlacementConsolidate, scaleDownPlacementRelieve)
+	}
+}
+
+// hostLoadFractions returns the load of each given host as a fraction of
+// its capacity: the larger of CPU and memory demand, the signals DRS
+// balances on. Hosts whose stats cannot be read are absent from the map.
+func (mgr *vsphereManagerGovmomi) hostLoadFractions(ctx context.Context, refs []types.ManagedObjectReference) (map[string]float64, error) {
+	if len(refs) == 0 {
+		return map[string]float64{}, nil
+	}
+	var hosts []mo.HostSystem
+	collector := property.DefaultCollector(mgr.activeClient().Vim)
+	if err := collector.Retrieve(ctx, refs, []string{"summary.quickStats", "summary.hardware"}, &hosts); err != nil {
+		return nil, fmt.Errorf("could not get host load: %v", err)
+	}
+
+	fractions := make(map[string]float64, len(hosts))
+	for _, host := range hosts {
+		hardware := host.Summary.Hardware
+		if hardware == nil {
+			continue
+		}
+		var cpu, memory float64
+		if capacity := int64(hardware.CpuMhz) * int64(hardware.NumCpuCores); capacity > 0 {
+			cpu = float64(host.Summary.QuickStats.OverallCpuUsage) / float64(capacity)
+		}
+		if hardware.MemorySize > 0 {
+			memory = float64(host.Summary.QuickStats.OverallMemoryUsage) * 1024 * 1024 / float64(hardware.MemorySize)
+		}
+		fraction := cpu
+		if memory > fraction {
+			fraction = memory
+		}
+		fractions[host.Self.Value] = fraction
+	}
+	return fractions, nil
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaleDownPlacement(t *testing.T) {
+	var cfg ConfigVsphere
+	assert.Equal(t, "", cfg.scaleDownPlacement())
+
+	// The legacy flag is the older spelling of consolidate.
+	cfg.Global.HostConsolidation = true
+	assert.Equal(t, scaleDownPlacementConsolidate, cfg.scaleDownPlacement())
+
+	// An explicit policy wins over the legacy flag.
+	cfg.Global.ScaleDownPlacement = scaleDownPlacementRelieve
+	assert.Equal(t, scaleDownPlacementRelieve, cfg.scaleDownPlacement())
+}
+
+func TestValidateScaleDownPlacement(t *testing.T) {
+	var cfg ConfigVsphere
+	assert.NoError(t, validateScaleDownPlacement(cfg))
+
+	cfg.Global.ScaleDownPlacement = scaleDownPlacementConsolidate
+	assert.NoError(t, validateScaleDownPlacement(cfg))
+	cfg.Global.ScaleDownPlacement = scaleDownPlacementRelieve
+	assert.NoError(t, validateScaleDownPlacement(cfg))
+
+	cfg.Global.ScaleDownPlacement = "drs"
+	err := validateScaleDownPlacement(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "scale-down-placement")
+}
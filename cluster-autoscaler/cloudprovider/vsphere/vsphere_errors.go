@@ -0,0 +1,123 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"net"
+
+	vctask "github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+)
+
+// vimFault digs the vSphere fault out of an error, whether it came back as
+// a task result or from a direct method call. Returns nil for errors that
+// carry no fault, e.g. ones already flattened with fmt.Errorf.
+func vimFault(err error) types.BaseMethodFault {
+	switch e := err.(type) {
+	case vctask.Error:
+		return e.Fault()
+	case *vctask.Error:
+		return e.Fault()
+	}
+	if soap.IsVimFault(err) {
+		return soap.ToVimFault(err)
+	}
+	return nil
+}
+
+// isTransientError reports whether an error looks like a network hiccup
+// that a retry can be expected to get past.
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if netErr, ok := err.(net.Error); ok {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+	return err == context.DeadlineExceeded
+}
+
+// classifyError maps a vCenter error onto the instance error class and
+// short code reported in instance statuses, so the core's backoff can tell
+// a full cluster from a broken role.
+func classifyError(err error) (cloudprovider.InstanceErrorClass, string) {
+	switch vimFault(err).(type) {
+	case types.BaseInsufficientResourcesFault:
+		return cloudprovider.OutOfResourcesErrorClass, "InsufficientResources"
+	case *types.NoDiskSpace:
+		return cloudprovider.OutOfResourcesErrorClass, "NoDiskSpace"
+	case *types.NotEnoughLicenses:
+		return cloudprovider.OutOfResourcesErrorClass, "NotEnoughLicenses"
+	case types.BaseNoPermission:
+		return cloudprovider.OtherErrorClass, "NoPermission"
+	case types.BaseInvalidArgument:
+		return cloudprovider.OtherErrorClass, "InvalidConfig"
+	}
+	if isTransientError(err) {
+		return cloudprovider.OtherErrorClass, "TransientNetwork"
+	}
+	return cloudprovider.OtherErrorClass, "Other"
+}
+
+// isPermissionError reports whether an error is vCenter refusing the
+// operation for lack of authorization — the service account's role was
+// edited or its session can no longer log in. Retrying cannot get past
+// these until the role or the config changes.
+func isPermissionError(err error) bool {
+	switch vimFault(err).(type) {
+	case types.BaseNoPermission, *types.InvalidLogin:
+		return true
+	}
+	return false
+}
+
+// isOutOfCapacity reports whether an error is vCenter refusing placement
+// for lack of resources, the case a fallback resource pool can get past.
+func isOutOfCapacity(err error) bool {
+	switch vimFault(err).(type) {
+	case types.BaseInsufficientResourcesFault, *types.NoDiskSpace:
+		return true
+	}
+	return false
+}
+
+// asAutoscalerError wraps a manager error in the AutoscalerError type
+// matching its fault, so backoff and status reporting distinguish
+// permission and config mistakes from capacity and network problems.
+// Errors that already are AutoscalerErrors pass through unchanged.
+func asAutoscalerError(err error) errors.AutoscalerError {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(errors.AutoscalerError); ok {
+		return e
+	}
+	switch vimFault(err).(type) {
+	case types.BaseNoPermission, types.BaseInvalidArgument:
+		return errors.NewAutoscalerError(errors.ConfigurationError, "%v", err)
+	case types.BaseInsufficientResourcesFault, *types.NoDiskSpace, *types.NotEnoughLicenses:
+		return errors.NewAutoscalerError(errors.CloudProviderError, "%v", err)
+	}
+	if isTransientError(err) {
+		return errors.NewAutoscalerError(errors.TransientError, "%v", err)
+	}
+	return errors.NewAutoscalerError(errors.CloudProviderError, "%v", err)
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+const (
+	// eventPollInterval is how long the event watcher sleeps when a read
+	// returns no new events.
+	eventPollInterval = 10 * time.Second
+
+	// eventPageSize is how many events one ReadNextEvents call may return.
+	eventPageSize = 100
+
+	// eventRetryDelay is how long the event watcher waits before
+	// re-creating a failed event collector.
+	eventRetryDelay = 30 * time.Second
+)
+
+// externalRemovals counts node VMs removed outside the autoscaler, per
+// node group, until the provider drains them into target size updates.
+type externalRemovals struct {
+	mutex   sync.Mutex
+	byGroup map[string]int
+}
+
+func (r *externalRemovals) add(nodegroup string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.byGroup == nil {
+		r.byGroup = make(map[string]int)
+	}
+	r.byGroup[nodegroup]++
+}
+
+func (r *externalRemovals) drain() map[string]int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	drained := r.byGroup
+	r.byGroup = nil
+	return drained
+}
+
+// externalRemovalSource is implemented by managers that learn about node
+// VMs deleted outside the autoscaler, e.g. through vCenter events. The
+// provider drains the counts each Refresh and lowers target sizes, so the
+// core does not wait out max-node-provision-time for VMs that will never
+// come back.
+type externalRemovalSource interface {
+	externallyRemovedNodes() map[string]int
+}
+
+// externallyRemovedNodes returns and resets the per-group counts of VMs
+// removed outside the autoscaler since the last call.
+func (mgr *vsphereManagerGovmomi) externallyRemovedNodes() map[string]int {
+	return mgr.removals.drain()
+}
+
+// startEventWatcher runs watchEvents forever, re-creating the collector
+// after errors. Run as a goroutine from the constructor when watch-events
+// is enabled.
+func (mgr *vsphereManagerGovmomi) startEventWatcher() {
+	for {
+		err := mgr.watchEvents(context.Background())
+		klog.Warningf("Event watcher stopped: %v, retrying in %s", err, eventRetryDelay)
+		time.Sleep(eventRetryDelay)
+	}
+}
+
+// watchEvents creates an event collector for VM removals, power-offs and
+// migrations below the cluster folder and feeds every event through
+// handleVMEvent.
+// Only returns on error.
+func (mgr *vsphereManagerGovmomi) watchEvents(ctx context.Context) error {
+	eventManager := mgr.activeClient().Vim.ServiceContent.EventManager
+	if eventManager == nil {
+		return fmt.Errorf("vCenter exposes no event manager")
+	}
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		return err
+	}
+	folder, err := finder.FolderOrDefault(ctx, mgr.config.Global.Folder)
+	if err != nil {
+		return fmt.Errorf("could not find folder to watch events on: %v", err)
+	}
+
+	begin := time.Now()
+	res, err := methods.CreateCollectorForEvents(ctx, mgr.activeClient().Vim, &types.CreateCollectorForEvents{
+		This: *eventManager,
+		Filter: types.EventFilterSpec{
+			Entity: &types.EventFilterSpecByEntity{
+				Entity:    folder.Reference(),
+				Recursion: types.EventFilterSpecRecursionOptionAll,
+			},
+			Time:        &types.EventFilterSpecByTime{BeginTime: &begin},
+			EventTypeId: []string{"VmRemovedEvent", "VmPoweredOffEvent", "VmMigratedEvent", "VmRelocatedEvent", "DrsVmMigratedEvent"},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not create event collector: %v", err)
+	}
+	collector := res.Returnval
+	defer methods.DestroyCollector(context.Background(), mgr.activeClient().Vim, &types.DestroyCollector{This: collector})
+	klog.V(2).Infof("Event watcher live on folder %s", folder.InventoryPath)
+
+	for {
+		page, err := methods.ReadNextEvents(ctx, mgr.activeClient().Vim, &types.ReadNextEvents{
+			This:     collector,
+			MaxCount: eventPageSize,
+		})
+		if err != nil {
+			return fmt.Errorf("could not read events: %v", err)
+		}
+		for _, event := range page.Returnval {
+			mgr.handleVMEvent(event)
+		}
+		if len(page.Returnval) == 0 {
+			time.Sleep(eventPollInterval)
+		}
+	}
+}
+
+// handleVMEvent reacts to a single VM event: removals of node VMs by
+// someone else are counted for the target size correction and power-offs
+// are logged, both invalidating the inventory snapshot so the next lookup
+// sees the change.
+func (mgr *vsphereManagerGovmomi) handleVMEvent(baseEvent types.BaseEvent) {
+	event := baseEvent.GetEvent()
+	if event.Vm == nil {
+		return
+	}
+	if event.UserName == mgr.config.Global.User {
+		// Our own scale-downs raise the same events.
+		return
+	}
+	name := event.Vm.Name
+	nodegroup := mgr.nodeGroupForNodeName(name)
+	if nodegroup == "" {
+		return
+	}
+
+	switch baseEvent.(type) {
+	case *types.VmRemovedEvent:
+		klog.V(0).Infof("VM %s of node group %s was removed outside the autoscaler by %s", name, nodegroup, event.UserName)
+		mgr.removals.add(nodegroup)
+		mgr.invalidateInventory()
+		mgr.auditRecord("removed-externally", nodegroup, name, "event by "+event.UserName, "", nil)
+	case *types.VmPoweredOffEvent:
+		klog.Warningf("VM %s of node group %s was powered off outside the autoscaler by %s", name, nodegroup, event.UserName)
+		mgr.invalidateInventory()
+	case *types.VmMigratedEvent, *types.VmRelocatedEvent, *types.DrsVmMigratedEvent:
+		// A migration may have assigned the VM a new MoRef; re-resolve the
+		// snapshot by instance UUID instead of waiting for tracking to
+		// misreport the node as gone.
+		klog.V(2).Infof("VM %s of node group %s migrated, reconciling MoRefs", name, nodegroup)
+		mgr.reconcileMoRefs(context.TODO())
+	}
+}
+
+// nodeGroupForNodeName maps a VM name back to its node group by the
+// <nodegroup>- name prefix the autoscaler uses for its clones. Adopted
+// VMs with foreign names cannot be attributed; their disappearance is
+// picked up by the regular refresh instead.
+func (mgr *vsphereManagerGovmomi) nodeGroupForNodeName(name string) string {
+	best := ""
+	for nodegroup := range mgr.config.NodeGroup {
+		if strings.HasPrefix(name, nodegroup+"-") && len(nodegroup) > len(best) {
+			best = nodegroup
+		}
+	}
+	return best
+}
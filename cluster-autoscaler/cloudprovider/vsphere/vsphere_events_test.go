@@ -0,0 +1,58 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func removedEvent(user, vmName string) types.BaseEvent {
+	return &types.VmRemovedEvent{
+		VmEvent: types.VmEvent{
+			Event: types.Event{
+				UserName: user,
+				Vm: &types.VmEventArgument{
+					EntityEventArgument: types.EntityEventArgument{Name: vmName},
+				},
+			},
+		},
+	}
+}
+
+func TestHandleVMEvent(t *testing.T) {
+	mgr := testManager(newFakeTagService())
+	mgr.config.Global.User = "autoscaler@vsphere.local"
+	mgr.config.NodeGroup["ng1"] = &ConfigNodeGroup{}
+	mgr.config.NodeGroup["ng1-large"] = &ConfigNodeGroup{}
+
+	// A removal by someone else is counted against the right group, with
+	// the longest matching name prefix winning.
+	mgr.handleVMEvent(removedEvent("admin@vsphere.local", "ng1-abc12345"))
+	mgr.handleVMEvent(removedEvent("admin@vsphere.local", "ng1-large-def67890"))
+	assert.Equal(t, map[string]int{"ng1": 1, "ng1-large": 1}, mgr.externallyRemovedNodes())
+
+	// Draining resets the counts.
+	assert.Empty(t, mgr.externallyRemovedNodes())
+
+	// Our own deletions and VMs of no known group are ignored.
+	mgr.handleVMEvent(removedEvent("autoscaler@vsphere.local", "ng1-abc12345"))
+	mgr.handleVMEvent(removedEvent("admin@vsphere.local", "somebody-else"))
+	assert.Empty(t, mgr.externallyRemovedNodes())
+}
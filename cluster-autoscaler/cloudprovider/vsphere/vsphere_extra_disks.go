@@ -0,0 +1,116 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// templateSCSIControllerKey is the device key of a template's first
+// SCSI controller; extra disks attach to it. vSphere assigns 1000 to
+// the first controller, and node templates have exactly one.
+const templateSCSIControllerKey = 1000
+
+// extraDiskSpec is one parsed `data-disk` entry of a node group: a virtual
+// disk created with the clone, for container images or emptyDir scratch
+// space that should not live on the root disk.
+type extraDiskSpec struct {
+	sizeGB       int64
+	datastore    string
+	provisioning string
+}
+
+// parseExtraDiskSpec parses a `data-disk` config entry of the form
+// `<size-gb>[,datastore=<name>][,type=thin|thick|eagerZeroedThick]`. The
+// datastore defaults to the one the clone lands on and the provisioning
+// to thin.
+func parseExtraDiskSpec(raw string) (extraDiskSpec, error) {
+	spec := extraDiskSpec{provisioning: diskProvisioningThin}
+
+	parts := strings.Split(raw, ",")
+	sizeGB, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil || sizeGB <= 0 {
+		return spec, fmt.Errorf("data-disk entry %q must start with a size in GB", raw)
+	}
+	spec.sizeGB = sizeGB
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return spec, fmt.Errorf("invalid data-disk option %q in %q", part, raw)
+		}
+		switch kv[0] {
+		case "datastore":
+			spec.datastore = kv[1]
+		case "type":
+			switch kv[1] {
+			case diskProvisioningThin, diskProvisioningThick, diskProvisioningEagerZeroedThick:
+				spec.provisioning = kv[1]
+			default:
+				return spec, fmt.Errorf("invalid data-disk type %q in %q", kv[1], raw)
+			}
+		default:
+			return spec, fmt.Errorf("unknown data-disk option %q in %q", kv[0], raw)
+		}
+	}
+	return spec, nil
+}
+
+// deviceChange builds the device change creating the disk with the clone.
+// index distinguishes multiple extra disks of one clone; the negative keys
+// are placeholders vCenter replaces on creation.
+func (spec extraDiskSpec) deviceChange(index int) *types.VirtualDeviceConfigSpec {
+	backing := &types.VirtualDiskFlatVer2BackingInfo{
+		DiskMode:        string(types.VirtualDiskModePersistent),
+		ThinProvisioned: types.NewBool(spec.provisioning == diskProvisioningThin),
+	}
+	if spec.provisioning == diskProvisioningEagerZeroedThick {
+		backing.EagerlyScrub = types.NewBool(true)
+	}
+	if spec.datastore != "" {
+		backing.FileName = fmt.Sprintf("[%s]", spec.datastore)
+	}
+	return &types.VirtualDeviceConfigSpec{
+		Operation:     types.VirtualDeviceConfigSpecOperationAdd,
+		FileOperation: types.VirtualDeviceConfigSpecFileOperationCreate,
+		Device: &types.VirtualDisk{
+			VirtualDevice: types.VirtualDevice{
+				Key:           int32(-1000 - index),
+				ControllerKey: templateSCSIControllerKey,
+				Backing:       backing,
+			},
+			CapacityInKB: spec.sizeGB * 1024 * 1024,
+		},
+	}
+}
+
+// extraDiskBytes sums the configured extra disk sizes of a node group, for
+// the ephemeral storage the template node advertises.
+func (cfg *ConfigVsphere) extraDiskBytes(nodegroup string) (int64, error) {
+	var total int64
+	for _, raw := range cfg.dataDisksFor(nodegroup) {
+		spec, err := parseExtraDiskSpec(raw)
+		if err != nil {
+			return 0, fmt.Errorf("node group %s: %v", nodegroup, err)
+		}
+		total += spec.sizeGB * 1024 * 1024 * 1024
+	}
+	return total, nil
+}
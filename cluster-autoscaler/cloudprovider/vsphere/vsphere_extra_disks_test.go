@@ -0,0 +1,83 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestParseExtraDiskSpec(t *testing.T) {
+	spec, err := parseExtraDiskSpec("200")
+	assert.NoError(t, err)
+	assert.Equal(t, extraDiskSpec{sizeGB: 200, provisioning: diskProvisioningThin}, spec)
+
+	spec, err = parseExtraDiskSpec("100,datastore=local-ssd,type=eagerZeroedThick")
+	assert.NoError(t, err)
+	assert.Equal(t, extraDiskSpec{sizeGB: 100, datastore: "local-ssd", provisioning: diskProvisioningEagerZeroedThick}, spec)
+
+	for _, raw := range []string{
+		"",
+		"fast",
+		"0",
+		"-10",
+		"100,type=sparse",
+		"100,datastore",
+		"100,color=red",
+	} {
+		_, err := parseExtraDiskSpec(raw)
+		assert.Error(t, err, "entry %q", raw)
+	}
+}
+
+func TestExtraDiskDeviceChange(t *testing.T) {
+	spec, err := parseExtraDiskSpec("200,datastore=local-ssd")
+	assert.NoError(t, err)
+
+	change := spec.deviceChange(1)
+	assert.Equal(t, types.VirtualDeviceConfigSpecOperationAdd, change.Operation)
+	assert.Equal(t, types.VirtualDeviceConfigSpecFileOperationCreate, change.FileOperation)
+
+	disk := change.Device.(*types.VirtualDisk)
+	assert.Equal(t, int64(200*1024*1024), disk.CapacityInKB)
+	assert.Equal(t, int32(-1001), disk.Key)
+	assert.Equal(t, int32(templateSCSIControllerKey), disk.ControllerKey)
+
+	backing := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+	assert.Equal(t, "[local-ssd]", backing.FileName)
+	assert.Equal(t, true, *backing.ThinProvisioned)
+}
+
+func TestExtraDiskBytes(t *testing.T) {
+	cfg := ConfigVsphere{NodeGroup: map[string]*ConfigNodeGroup{
+		"ng1": {DataDisks: []string{"100", "50,type=thick"}},
+		"bad": {DataDisks: []string{"fast"}},
+	}}
+
+	total, err := cfg.extraDiskBytes("ng1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(150*1024*1024*1024), total)
+
+	total, err = cfg.extraDiskBytes("other")
+	assert.NoError(t, err)
+	assert.Zero(t, total)
+
+	_, err = cfg.extraDiskBytes("bad")
+	assert.Error(t, err)
+}
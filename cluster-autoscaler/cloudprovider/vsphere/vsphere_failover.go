@@ -0,0 +1,161 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// primaryProbeInterval is how often the primary vCenter is probed for
+// recovery while failed over to the secondary.
+const primaryProbeInterval = 2 * time.Minute
+
+// isConnectionError reports whether an error looks like the vCenter
+// endpoint itself being unreachable, rather than the call being rejected.
+// Errors flattened with fmt.Errorf lose their type, so the message is
+// checked too.
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if isTransientError(err) {
+		return true
+	}
+	if _, ok := err.(*url.Error); ok {
+		return true
+	}
+	message := err.Error()
+	for _, symptom := range []string{"connection refused", "connection reset", "no such host", "no route to host", "EOF"} {
+		if strings.Contains(message, symptom) {
+			return true
+		}
+	}
+	return false
+}
+
+// activeClient returns the current vCenter client. The failover machinery
+// swaps the client under failoverMutex, so readers must go through here
+// rather than the field — an unsynchronized read would race the swap.
+func (mgr *vsphereManagerGovmomi) activeClient() *VsphereClient {
+	mgr.failoverMutex.Lock()
+	defer mgr.failoverMutex.Unlock()
+	return mgr.client
+}
+
+// activeTags returns the current tag service, which follows the client
+// across failover swaps; see activeClient.
+func (mgr *vsphereManagerGovmomi) activeTags() tagService {
+	mgr.failoverMutex.Lock()
+	defer mgr.failoverMutex.Unlock()
+	return mgr.tags
+}
+
+// maybeFailover switches the manager to the configured secondary vCenter
+// when an error indicates the primary is down. The secondary is expected
+// to be a linked mode partner or replica in the same SSO domain, so the
+// configured credentials and inventory paths stay valid. While failed
+// over, mutating operations are refused unless failover-mutations opts
+// in; a background probe switches back once the primary recovers.
+func (mgr *vsphereManagerGovmomi) maybeFailover(err error) {
+	if mgr.config.Global.SecondaryVCenter == "" || !isConnectionError(err) {
+		return
+	}
+	mgr.failoverMutex.Lock()
+	defer mgr.failoverMutex.Unlock()
+	if mgr.failoverActive {
+		return
+	}
+
+	secondaryCfg := mgr.config.Global
+	secondaryCfg.VCenter = mgr.config.Global.SecondaryVCenter
+	client, cerr := NewVsphereClient(secondaryCfg)
+	if cerr != nil {
+		klog.Errorf("Primary vCenter %s is unreachable (%v) and connecting to secondary %s failed too: %v",
+			mgr.config.Global.VCenter, err, secondaryCfg.VCenter, cerr)
+		return
+	}
+	instrumentClient(client, &mgr.usage)
+	traceClient(client, mgr.tracer)
+
+	// Swap the session; loops that already fetched the old client through
+	// activeClient keep failing until their next call picks up the new one.
+	if mgr.tags == tagService(mgr.client) {
+		mgr.tags = client
+	}
+	mgr.client = client
+	mgr.failoverActive = true
+	mgr.failoverSince = time.Now()
+	mgr.invalidateInventory()
+	klog.Warningf("Primary vCenter %s is unreachable (%v), failed over to secondary %s; mutating operations are %s",
+		mgr.config.Global.VCenter, err, secondaryCfg.VCenter, mgr.failoverMutationState())
+	go mgr.probePrimary()
+}
+
+// failoverMutationState describes whether scaling runs during failover,
+// for log messages.
+func (mgr *vsphereManagerGovmomi) failoverMutationState() string {
+	if mgr.config.Global.FailoverMutations {
+		return "enabled (failover-mutations)"
+	}
+	return "paused until the primary recovers"
+}
+
+// failoverMutationError returns an error while the manager runs against
+// the secondary vCenter and mutations are not opted in; read operations
+// keep the cluster state visible either way.
+func (mgr *vsphereManagerGovmomi) failoverMutationError() error {
+	mgr.failoverMutex.Lock()
+	defer mgr.failoverMutex.Unlock()
+	if mgr.failoverActive && !mgr.config.Global.FailoverMutations {
+		return fmt.Errorf("scaling is paused during the failover to %s (since %s): set failover-mutations to allow it",
+			mgr.config.Global.SecondaryVCenter, mgr.failoverSince.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// probePrimary periodically tries to reconnect to the primary vCenter and
+// switches the manager back once it succeeds.
+func (mgr *vsphereManagerGovmomi) probePrimary() {
+	ticker := time.NewTicker(primaryProbeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		client, err := NewVsphereClient(mgr.config.Global)
+		if err != nil {
+			klog.V(2).Infof("Primary vCenter %s still unreachable: %v", mgr.config.Global.VCenter, err)
+			continue
+		}
+		instrumentClient(client, &mgr.usage)
+		traceClient(client, mgr.tracer)
+
+		mgr.failoverMutex.Lock()
+		if mgr.tags == tagService(mgr.client) {
+			mgr.tags = client
+		}
+		mgr.client = client
+		mgr.failoverActive = false
+		mgr.failoverMutex.Unlock()
+		mgr.invalidateInventory()
+		klog.V(0).Infof("Primary vCenter %s recovered, switched back from secondary %s",
+			mgr.config.Global.VCenter, mgr.config.Global.SecondaryVCenter)
+		return
+	}
+}
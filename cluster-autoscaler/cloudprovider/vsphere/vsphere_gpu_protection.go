@@ -0,0 +1,73 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/gpu"
+	"k8s.io/klog"
+)
+
+// requestedGPUs sums the nvidia.com/gpu requests of the given pods,
+// skipping pods that already ran to completion.
+func requestedGPUs(pods []apiv1.Pod) int64 {
+	var requested int64
+	for _, pod := range pods {
+		if pod.Status.Phase == apiv1.PodSucceeded || pod.Status.Phase == apiv1.PodFailed {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			if quantity, ok := container.Resources.Requests[gpu.ResourceNvidiaGPU]; ok {
+				requested += quantity.Value()
+			}
+		}
+	}
+	return requested
+}
+
+// checkGPUWorkloads refuses to scale down a node whose pods still request
+// GPUs. The core's utilization check treats a training pod like any other
+// workload it may evict; with protect-gpu-workloads a vGPU node is only
+// offered up once nothing on it holds a GPU, so a lagging metrics pipeline
+// cannot get a node removed mid-training. Reuses the state store's client;
+// without persist-state the check is skipped.
+func (mgr *vsphereManagerGovmomi) checkGPUWorkloads(ctx context.Context, nodegroup, name string) error {
+	if !mgr.config.protectGPUWorkloadsFor(nodegroup) {
+		return nil
+	}
+	if mgr.state == nil {
+		klog.V(4).Infof("Skipping GPU workload check for %s: requires persist-state", name)
+		return nil
+	}
+
+	pods, err := mgr.state.client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + name,
+	})
+	if err != nil {
+		// Fail closed: not being able to see the pods is no license to
+		// delete a GPU node under a training job.
+		return fmt.Errorf("could not list pods of node %s for the GPU workload check: %v", name, err)
+	}
+	if requested := requestedGPUs(pods.Items); requested > 0 {
+		return fmt.Errorf("node %s has %d GPU(s) requested by running pods, refusing to scale it down", name, requested)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/gpu"
+)
+
+func gpuPod(phase apiv1.PodPhase, gpus string) apiv1.Pod {
+	pod := apiv1.Pod{Status: apiv1.PodStatus{Phase: phase}}
+	container := apiv1.Container{}
+	if gpus != "" {
+		container.Resources.Requests = apiv1.ResourceList{
+			gpu.ResourceNvidiaGPU: resource.MustParse(gpus),
+		}
+	}
+	pod.Spec.Containers = []apiv1.Container{container}
+	return pod
+}
+
+func TestRequestedGPUs(t *testing.T) {
+	assert.Equal(t, int64(0), requestedGPUs(nil))
+	assert.Equal(t, int64(0), requestedGPUs([]apiv1.Pod{
+		gpuPod(apiv1.PodRunning, ""),
+	}))
+	// Finished pods no longer hold their GPU.
+	assert.Equal(t, int64(0), requestedGPUs([]apiv1.Pod{
+		gpuPod(apiv1.PodSucceeded, "2"),
+		gpuPod(apiv1.PodFailed, "1"),
+	}))
+	assert.Equal(t, int64(3), requestedGPUs([]apiv1.Pod{
+		gpuPod(apiv1.PodRunning, "2"),
+		gpuPod(apiv1.PodPending, "1"),
+		gpuPod(apiv1.PodRunning, ""),
+	}))
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"encoding/json"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+)
+
+// The gRPC manager splits this package in two processes: a standalone
+// server wrapping a real manager (ServeGrpc) and a thin client manager
+// inside the autoscaler (vsphere_manager_grpc.go). Both ends are built
+// from this file, so the wire format is private to the package: the
+// messages are plain Go structs carried by a JSON codec and the service
+// is described by a hand-written grpc.ServiceDesc, with no .proto and no
+// generated code to keep in sync.
+
+// grpcServiceName is the full gRPC service name of the manager service.
+const grpcServiceName = "clusterautoscaler.cloudprovider.vsphere.Manager"
+
+// grpcJSONCodec is the gRPC codec carrying the wire messages; JSON
+// because both ends share the struct definitions above anyway.
+type grpcJSONCodec struct{}
+
+// Marshal implements grpc.Codec.
+func (grpcJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements grpc.Codec.
+func (grpcJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// String implements grpc.Codec.
+func (grpcJSONCodec) String() string {
+	return "json"
+}
+
+// grpcEmpty is the response of calls that only carry an error.
+type grpcEmpty struct{}
+
+// grpcNodeGroupRequest addresses one node group.
+type grpcNodeGroupRequest struct {
+	NodeGroup string
+}
+
+// grpcSizeResponse carries a node group size.
+type grpcSizeResponse struct {
+	Size int
+}
+
+// grpcCreateNodesRequest is the wire form of createNodes.
+type grpcCreateNodesRequest struct {
+	NodeGroup string
+	Count     int
+}
+
+// grpcDeleteNodesRequest is the wire form of deleteNodes.
+type grpcDeleteNodesRequest struct {
+	NodeGroup        string
+	Nodes            []NodeRef
+	UpdatedNodeCount int
+}
+
+// grpcNodesRequest carries a node group and a list of its nodes.
+type grpcNodesRequest struct {
+	NodeGroup string
+	Nodes     []NodeRef
+}
+
+// grpcNodesResponse carries a list of nodes.
+type grpcNodesResponse struct {
+	Nodes []NodeRef
+}
+
+// grpcIdleCandidatesRequest is the wire form of idleDeletionCandidates.
+type grpcIdleCandidatesRequest struct {
+	NodeGroup string
+	Count     int
+}
+
+// grpcInstancesResponse is the wire form of the getNodes result.
+type grpcInstancesResponse struct {
+	Instances []cloudprovider.Instance
+}
+
+// grpcNamesResponse carries a list of node names.
+type grpcNamesResponse struct {
+	Names []string
+}
+
+// grpcStatusResponse is the wire form of groupStatus; the error travels
+// as its message.
+type grpcStatusResponse struct {
+	PendingClones  int
+	PendingDeletes int
+	LastError      string
+	Cooldown       time.Duration
+}
+
+// grpcNodeInfoResponse carries the template node of a node group; the
+// client rebuilds the scheduler NodeInfo around it.
+type grpcNodeInfoResponse struct {
+	Node *apiv1.Node
+}
+
+// grpcTargetSizeRequest is the wire form of targetSizeChanged.
+type grpcTargetSizeRequest struct {
+	NodeGroup string
+	Size      int
+}
+
+// grpcNodeGroupDef is the wire form of nodeGroupDef, whose fields are
+// unexported.
+type grpcNodeGroupDef struct {
+	Name    string
+	MinSize int
+	MaxSize int
+}
+
+// grpcDefsResponse is the wire form of the nodeGroupDefs result.
+type grpcDefsResponse struct {
+	Defs    []grpcNodeGroupDef
+	Enabled bool
+}
+
+// grpcPriorityResponse carries a node group priority.
+type grpcPriorityResponse struct {
+	Priority int
+}
+
+// grpcRestoredSizeResponse is the wire form of the restoredTargetSize
+// result.
+type grpcRestoredSizeResponse struct {
+	Size     int
+	Restored bool
+}
+
+// grpcOptionsResponse carries the autoscaling option overrides of a node
+// group.
+type grpcOptionsResponse struct {
+	Options nodeGroupAutoscalingOptions
+}
+
+// grpcRefreshRequest is the wire form of the per-loop refresh call that
+// tunnels the provider's refresh hooks to the server.
+type grpcRefreshRequest struct {
+	NodeGroups []string
+}
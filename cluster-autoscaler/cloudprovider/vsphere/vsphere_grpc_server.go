@@ -0,0 +1,278 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+
+	"google.golang.org/grpc"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/klog"
+)
+
+// ServeGrpc builds a vsphere manager from the given cloud config and
+// serves it on listenAddr until the server fails, for running the
+// vSphere logic as a standalone process the autoscaler's grpc manager
+// connects to. The reader carries the same INI cloud config the
+// autoscaler runs with; clusterName overrides the cluster-name option
+// when not empty. Which real manager backs the server is selected the
+// usual way, through VSPHERE_MANAGER.
+func ServeGrpc(configReader io.Reader, listenAddr, clusterName string) error {
+	mgr, err := createVsphereManager(configReader, cloudprovider.NodeGroupDiscoveryOptions{}, config.AutoscalingOptions{ClusterName: clusterName})
+	if err != nil {
+		return err
+	}
+	if _, ok := mgr.(*vsphereManagerGrpc); ok {
+		return fmt.Errorf("refusing to serve the grpc manager over gRPC, set VSPHERE_MANAGER to a real manager")
+	}
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %v", listenAddr, err)
+	}
+	server := grpc.NewServer(grpc.CustomCodec(grpcJSONCodec{}))
+	srv := &grpcManagerServer{mgr: mgr}
+	server.RegisterService(srv.serviceDesc(), srv)
+	klog.V(0).Infof("Serving the vsphere manager on %s", listenAddr)
+	return server.Serve(listener)
+}
+
+// grpcManagerService is the handler type of the manager service. The
+// handlers live on grpcManagerServer and are wired up by name in
+// serviceDesc rather than through generated stubs.
+type grpcManagerService interface{}
+
+// grpcManagerServer exposes a vsphereManager over gRPC.
+type grpcManagerServer struct {
+	mgr vsphereManager
+}
+
+// grpcUnary adapts a handler to the grpc.MethodDesc signature. The
+// server registers the handlers on itself, so the srv argument is
+// redundant, and it runs without interceptors.
+func grpcUnary(handle func(dec func(interface{}) error) (interface{}, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(_ interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+		return handle(dec)
+	}
+}
+
+// serviceDesc describes the manager service, the hand-written equivalent
+// of what protoc would generate.
+func (s *grpcManagerServer) serviceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: grpcServiceName,
+		HandlerType: (*grpcManagerService)(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "NodeGroupSize", Handler: grpcUnary(s.handleNodeGroupSize)},
+			{MethodName: "CreateNodes", Handler: grpcUnary(s.handleCreateNodes)},
+			{MethodName: "DeleteNodes", Handler: grpcUnary(s.handleDeleteNodes)},
+			{MethodName: "RankDeletionCandidates", Handler: grpcUnary(s.handleRankDeletionCandidates)},
+			{MethodName: "IdleDeletionCandidates", Handler: grpcUnary(s.handleIdleDeletionCandidates)},
+			{MethodName: "Nodes", Handler: grpcUnary(s.handleNodes)},
+			{MethodName: "NodeNames", Handler: grpcUnary(s.handleNodeNames)},
+			{MethodName: "NodeGroupStatus", Handler: grpcUnary(s.handleNodeGroupStatus)},
+			{MethodName: "TemplateNodeInfo", Handler: grpcUnary(s.handleTemplateNodeInfo)},
+			{MethodName: "TargetSizeChanged", Handler: grpcUnary(s.handleTargetSizeChanged)},
+			{MethodName: "NodeGroupDefs", Handler: grpcUnary(s.handleNodeGroupDefs)},
+			{MethodName: "NodeGroupPriority", Handler: grpcUnary(s.handleNodeGroupPriority)},
+			{MethodName: "RestoredTargetSize", Handler: grpcUnary(s.handleRestoredTargetSize)},
+			{MethodName: "AutoscalingOptions", Handler: grpcUnary(s.handleAutoscalingOptions)},
+			{MethodName: "Refresh", Handler: grpcUnary(s.handleRefresh)},
+		},
+	}
+}
+
+func (s *grpcManagerServer) handleNodeGroupSize(dec func(interface{}) error) (interface{}, error) {
+	var req grpcNodeGroupRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	size, err := s.mgr.nodeGroupSize(req.NodeGroup)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcSizeResponse{Size: size}, nil
+}
+
+func (s *grpcManagerServer) handleCreateNodes(dec func(interface{}) error) (interface{}, error) {
+	var req grpcCreateNodesRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	return &grpcEmpty{}, s.mgr.createNodes(req.NodeGroup, req.Count)
+}
+
+func (s *grpcManagerServer) handleDeleteNodes(dec func(interface{}) error) (interface{}, error) {
+	var req grpcDeleteNodesRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	return &grpcEmpty{}, s.mgr.deleteNodes(req.NodeGroup, req.Nodes, req.UpdatedNodeCount)
+}
+
+func (s *grpcManagerServer) handleRankDeletionCandidates(dec func(interface{}) error) (interface{}, error) {
+	var req grpcNodesRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	return &grpcNodesResponse{Nodes: s.mgr.rankDeletionCandidates(req.NodeGroup, req.Nodes)}, nil
+}
+
+func (s *grpcManagerServer) handleIdleDeletionCandidates(dec func(interface{}) error) (interface{}, error) {
+	var req grpcIdleCandidatesRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	nodes, err := s.mgr.idleDeletionCandidates(req.NodeGroup, req.Count)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcNodesResponse{Nodes: nodes}, nil
+}
+
+func (s *grpcManagerServer) handleNodes(dec func(interface{}) error) (interface{}, error) {
+	var req grpcNodeGroupRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	instances, err := s.mgr.getNodes(req.NodeGroup)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcInstancesResponse{Instances: instances}, nil
+}
+
+func (s *grpcManagerServer) handleNodeNames(dec func(interface{}) error) (interface{}, error) {
+	var req grpcNodeGroupRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	names, err := s.mgr.getNodeNames(req.NodeGroup)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcNamesResponse{Names: names}, nil
+}
+
+func (s *grpcManagerServer) handleNodeGroupStatus(dec func(interface{}) error) (interface{}, error) {
+	var req grpcNodeGroupRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	status := s.mgr.nodeGroupStatus(req.NodeGroup)
+	resp := &grpcStatusResponse{
+		PendingClones:  status.pendingClones,
+		PendingDeletes: status.pendingDeletes,
+		Cooldown:       status.cooldown,
+	}
+	if status.lastError != nil {
+		resp.LastError = status.lastError.Error()
+	}
+	return resp, nil
+}
+
+func (s *grpcManagerServer) handleTemplateNodeInfo(dec func(interface{}) error) (interface{}, error) {
+	var req grpcNodeGroupRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	info, err := s.mgr.templateNodeInfo(req.NodeGroup)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcNodeInfoResponse{Node: info.Node()}, nil
+}
+
+func (s *grpcManagerServer) handleTargetSizeChanged(dec func(interface{}) error) (interface{}, error) {
+	var req grpcTargetSizeRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	s.mgr.targetSizeChanged(req.NodeGroup, req.Size)
+	return &grpcEmpty{}, nil
+}
+
+func (s *grpcManagerServer) handleNodeGroupDefs(dec func(interface{}) error) (interface{}, error) {
+	var req grpcEmpty
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	defs, enabled, err := s.mgr.nodeGroupDefs()
+	if err != nil {
+		return nil, err
+	}
+	resp := &grpcDefsResponse{Enabled: enabled}
+	for _, def := range defs {
+		resp.Defs = append(resp.Defs, grpcNodeGroupDef{Name: def.name, MinSize: def.minSize, MaxSize: def.maxSize})
+	}
+	return resp, nil
+}
+
+func (s *grpcManagerServer) handleNodeGroupPriority(dec func(interface{}) error) (interface{}, error) {
+	var req grpcNodeGroupRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	return &grpcPriorityResponse{Priority: s.mgr.nodeGroupPriority(req.NodeGroup)}, nil
+}
+
+func (s *grpcManagerServer) handleRestoredTargetSize(dec func(interface{}) error) (interface{}, error) {
+	var req grpcNodeGroupRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	size, restored := s.mgr.restoredTargetSize(req.NodeGroup)
+	return &grpcRestoredSizeResponse{Size: size, Restored: restored}, nil
+}
+
+func (s *grpcManagerServer) handleAutoscalingOptions(dec func(interface{}) error) (interface{}, error) {
+	var req grpcNodeGroupRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	return &grpcOptionsResponse{Options: s.mgr.autoscalingOptions(req.NodeGroup)}, nil
+}
+
+// handleRefresh runs the per-loop refresh hooks of the wrapped manager,
+// the same ones the provider's Refresh drives when the manager runs
+// in-process: config reload, inventory refresh, node age flagging and
+// config-move relocation.
+func (s *grpcManagerServer) handleRefresh(dec func(interface{}) error) (interface{}, error) {
+	var req grpcRefreshRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	if reloader, ok := s.mgr.(configReloader); ok {
+		reloader.reloadConfigIfChanged()
+	}
+	if refresher, ok := s.mgr.(inventoryRefresher); ok {
+		if err := refresher.refreshInventory(req.NodeGroups); err != nil {
+			return nil, err
+		}
+	}
+	if recycler, ok := s.mgr.(nodeRecycler); ok {
+		recycler.flagOveragedNodes(req.NodeGroups)
+	}
+	if relocator, ok := s.mgr.(nodeRelocator); ok {
+		relocator.relocateMovedNodes(req.NodeGroups)
+	}
+	return &grpcEmpty{}, nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+)
+
+// startGrpcManager serves the given manager on a loopback listener and
+// returns a grpc client manager connected to it.
+func startGrpcManager(t *testing.T, mgr vsphereManager) (*vsphereManagerGrpc, *grpc.Server) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server := grpc.NewServer(grpc.CustomCodec(grpcJSONCodec{}))
+	srv := &grpcManagerServer{mgr: mgr}
+	server.RegisterService(srv.serviceDesc(), srv)
+	go server.Serve(listener)
+
+	cfg := ConfigVsphere{Global: ConfigGlobal{GrpcEndpoint: listener.Addr().String()}}
+	client, err := createVsphereManagerGrpc(cfg, cloudprovider.NodeGroupDiscoveryOptions{}, config.AutoscalingOptions{})
+	assert.NoError(t, err)
+	return client, server
+}
+
+func TestGrpcManagerRoundTrip(t *testing.T) {
+	fake := &fakeVsphereManager{
+		nodes:   []string{"ng1-1", "ng1-2"},
+		options: nodeGroupAutoscalingOptions{ScaleDownUnneededTime: 5 * time.Minute},
+	}
+	client, server := startGrpcManager(t, fake)
+	defer server.Stop()
+
+	size, err := client.nodeGroupSize("ng1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, size)
+
+	names, err := client.getNodeNames("ng1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ng1-1", "ng1-2"}, names)
+
+	assert.NoError(t, client.createNodes("ng1", 3))
+	assert.Equal(t, []int{3}, fake.createdNodes)
+
+	assert.NoError(t, client.deleteNodes("ng1", []NodeRef{{Name: "ng1-2"}}, 1))
+	assert.Equal(t, []string{"ng1-2"}, fake.deletedNodes)
+
+	// The fake ranks by keeping the order; the order must survive the wire.
+	ranked := client.rankDeletionCandidates("ng1", []NodeRef{{Name: "b"}, {Name: "a"}})
+	assert.Equal(t, []NodeRef{{Name: "b"}, {Name: "a"}}, ranked)
+
+	victims, err := client.idleDeletionCandidates("ng1", 1)
+	assert.NoError(t, err)
+	assert.Len(t, victims, 1)
+
+	status := client.nodeGroupStatus("ng1")
+	assert.NoError(t, status.lastError)
+	assert.Zero(t, status.cooldown)
+
+	options := client.autoscalingOptions("ng1")
+	assert.Equal(t, 5*time.Minute, options.ScaleDownUnneededTime)
+
+	_, restored := client.restoredTargetSize("ng1")
+	assert.False(t, restored)
+
+	_, enabled, err := client.nodeGroupDefs()
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+
+	// Errors of the wrapped manager travel back as errors.
+	_, err = client.templateNodeInfo("ng1")
+	assert.Error(t, err)
+
+	// The fake implements none of the refresh hooks; the call is a no-op.
+	assert.NoError(t, client.refreshInventory([]string{"ng1"}))
+}
+
+func TestGrpcManagerServerDown(t *testing.T) {
+	client, server := startGrpcManager(t, &fakeVsphereManager{})
+	server.Stop()
+
+	_, err := client.nodeGroupSize("ng1")
+	assert.Error(t, err)
+	// Advisory calls degrade instead of failing.
+	nodes := []NodeRef{{Name: "a"}}
+	assert.Equal(t, nodes, client.rankDeletionCandidates("ng1", nodes))
+	assert.False(t, client.nodeGroupStatus("ng1").lastError == nil)
+}
+
+func TestCreateVsphereManagerGrpcNeedsEndpoint(t *testing.T) {
+	_, err := createVsphereManagerGrpc(ConfigVsphere{}, cloudprovider.NodeGroupDiscoveryOptions{}, config.AutoscalingOptions{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "grpc-endpoint")
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+const (
+	// guestProbeInterval is the minimum time between probe attempts for
+	// the same node.
+	guestProbeInterval = 30 * time.Second
+	// guestProbePollInterval is how often a started probe process is
+	// checked for completion.
+	guestProbePollInterval = 2 * time.Second
+	// guestProbeTimeout bounds a single probe attempt, including waiting
+	// for the process to exit.
+	guestProbeTimeout = 45 * time.Second
+	// guestProbeRetention is how long a confirmed node is remembered; by
+	// then its clone task record is long pruned and the result unused.
+	guestProbeRetention = time.Hour
+
+	// defaultGuestProbeCommand checks that the kubelet unit is up.
+	defaultGuestProbeCommand = "/usr/bin/systemctl is-active --quiet kubelet"
+)
+
+// guestProber confirms through VMware Tools guest operations that the
+// kubelet of a freshly cloned node is actually running, gating the
+// instance's transition from Creating to Running. Without it a node whose
+// clone succeeded but whose boot hangs counts as Running and is only
+// noticed when max-node-provision-time expires.
+type guestProber struct {
+	sync.Mutex
+	mgr *vsphereManagerGovmomi
+
+	// processManager is the resolved guest process manager reference,
+	// cached after the first probe.
+	processManager *types.ManagedObjectReference
+
+	// passed records when each node's kubelet was confirmed active.
+	passed map[string]time.Time
+	// lastAttempt rate-limits probes per node; probing marks an attempt
+	// still in flight.
+	lastAttempt map[string]time.Time
+	probing     map[string]bool
+}
+
+// newGuestProber creates a guestProber for a manager.
+func newGuestProber(mgr *vsphereManagerGovmomi) *guestProber {
+	return &guestProber{
+		mgr:         mgr,
+		passed:      make(map[string]time.Time),
+		lastAttempt: make(map[string]time.Time),
+		probing:     make(map[string]bool),
+	}
+}
+
+// kubeletActive reports whether the kubelet of a node has been confirmed
+// active. It never blocks: an unconfirmed node starts an asynchronous
+// probe (rate limited per node) and reports false until one succeeds.
+func (p *guestProber) kubeletActive(name string, vmRef types.ManagedObjectReference) bool {
+	p.Lock()
+	defer p.Unlock()
+	p.prune()
+	if _, ok := p.passed[name]; ok {
+		return true
+	}
+	if p.probing[name] || time.Since(p.lastAttempt[name]) < guestProbeInterval {
+		return false
+	}
+	p.lastAttempt[name] = time.Now()
+	p.probing[name] = true
+	go p.probe(name, vmRef)
+	return false
+}
+
+// prune drops confirmations and attempt records past their retention.
+// Callers must hold the lock.
+func (p *guestProber) prune() {
+	for name, at := range p.passed {
+		if time.Since(at) > guestProbeRetention {
+			delete(p.passed, name)
+		}
+	}
+	for name, at := range p.lastAttempt {
+		if time.Since(at) > guestProbeRetention {
+			delete(p.lastAttempt, name)
+		}
+	}
+}
+
+// probe runs one probe attempt and records the outcome.
+func (p *guestProber) probe(name string, vmRef types.ManagedObjectReference) {
+	ctx, cancel := context.WithTimeout(context.TODO(), guestProbeTimeout)
+	defer cancel()
+	active, err := p.runProbe(ctx, vmRef)
+
+	p.Lock()
+	defer p.Unlock()
+	delete(p.probing, name)
+	if err != nil {
+		// Expected while the VM is still booting: tools are not up yet.
+		klog.V(3).Infof("Guest probe of %s did not complete: %v", name, err)
+		return
+	}
+	if active {
+		klog.V(2).Infof("Guest probe confirmed the kubelet of %s is active", name)
+		p.passed[name] = time.Now()
+	} else {
+		klog.V(2).Infof("Guest probe of %s: kubelet not active yet", name)
+	}
+}
+
+// runProbe starts the probe command in the guest and waits for its exit
+// code: zero means the kubelet is active.
+func (p *guestProber) runProbe(ctx context.Context, vmRef types.ManagedObjectReference) (bool, error) {
+	pmRef, err := p.processManagerRef(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	parts := strings.Fields(p.mgr.config.Global.GuestProbeCommand)
+	if len(parts) == 0 {
+		parts = strings.Fields(defaultGuestProbeCommand)
+	}
+	auth := &types.NamePasswordAuthentication{
+		Username: p.mgr.config.Global.GuestUser,
+		Password: p.mgr.config.Global.GuestPassword,
+	}
+
+	res, err := methods.StartProgramInGuest(ctx, p.mgr.activeClient().Vim, &types.StartProgramInGuest{
+		This: *pmRef,
+		Vm:   vmRef,
+		Auth: auth,
+		Spec: &types.GuestProgramSpec{
+			ProgramPath: parts[0],
+			Arguments:   strings.Join(parts[1:], " "),
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not start probe in guest: %v", err)
+	}
+	pid := res.Returnval
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(guestProbePollInterval):
+		}
+		listRes, err := methods.ListProcessesInGuest(ctx, p.mgr.activeClient().Vim, &types.ListProcessesInGuest{
+			This: *pmRef,
+			Vm:   vmRef,
+			Auth: auth,
+			Pids: []int64{pid},
+		})
+		if err != nil {
+			return false, fmt.Errorf("could not list probe process in guest: %v", err)
+		}
+		for _, process := range listRes.Returnval {
+			if process.Pid == pid && process.EndTime != nil {
+				return process.ExitCode == 0, nil
+			}
+		}
+	}
+}
+
+// processManagerRef resolves (and caches) the guest process manager of
+// the vCenter session.
+func (p *guestProber) processManagerRef(ctx context.Context) (*types.ManagedObjectReference, error) {
+	p.Lock()
+	cached := p.processManager
+	p.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	gomRef := p.mgr.activeClient().Vim.ServiceContent.GuestOperationsManager
+	if gomRef == nil {
+		return nil, fmt.Errorf("vCenter exposes no guest operations manager")
+	}
+	var gom mo.GuestOperationsManager
+	pc := property.DefaultCollector(p.mgr.activeClient().Vim)
+	if err := pc.RetrieveOne(ctx, *gomRef, []string{"processManager"}, &gom); err != nil {
+		return nil, fmt.Errorf("could not get guest process manager: %v", err)
+	}
+	if gom.ProcessManager == nil {
+		return nil, fmt.Errorf("vCenter exposes no guest process manager")
+	}
+
+	p.Lock()
+	p.processManager = gom.ProcessManager
+	p.Unlock()
+	return gom.ProcessManager, nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/klog"
+)
+
+const healthConfigMapKey = "health"
+
+// groupHealth is the per node group entry of the health summary, making
+// target/actual size divergence visible without grepping logs.
+type groupHealth struct {
+	NodeGroup string `json:"nodeGroup"`
+	// TargetSize is the size the autoscaler wants the group to be.
+	TargetSize int `json:"targetSize"`
+	// CurrentVMs is the number of VMs that actually exist in vCenter.
+	CurrentVMs int `json:"currentVMs"`
+	// RunningNodes is the number of VMs past creation, i.e. expected to be
+	// registered as Kubernetes nodes.
+	RunningNodes int `json:"runningNodes"`
+	// PendingClones and PendingDeletes are the in-flight vCenter operations.
+	PendingClones  int    `json:"pendingClones,omitempty"`
+	PendingDeletes int    `json:"pendingDeletes,omitempty"`
+	LastError      string `json:"lastError,omitempty"`
+}
+
+// healthSummary is the provider health report published on every Refresh.
+type healthSummary struct {
+	GeneratedAt string        `json:"generatedAt"`
+	NodeGroups  []groupHealth `json:"nodeGroups"`
+	// GoneNodes are registered nodes whose backing VM no longer exists in
+	// vCenter, e.g. after a manual delete.
+	GoneNodes []string `json:"goneNodes,omitempty"`
+	// Degraded is set while the provider has suspended vCenter operations,
+	// e.g. "permission since <when>: <fault>" after the service account's
+	// role was edited.
+	Degraded string `json:"degraded,omitempty"`
+}
+
+// healthSink is implemented by managers that can publish the health summary
+// somewhere operators can read it, such as a ConfigMap.
+type healthSink interface {
+	publishHealth(summary healthSummary)
+}
+
+// goneNodeChecker is implemented by managers that can cross-check the
+// cluster's registered nodes against their machine inventory.
+type goneNodeChecker interface {
+	goneNodes() []string
+}
+
+// degradedReporter is implemented by managers that track a degraded mode,
+// such as suspended operations after a permission loss.
+type degradedReporter interface {
+	degradedStatus() string
+}
+
+// collectHealth gathers the health summary for all node groups from the
+// manager. Errors while inspecting a group are recorded in its entry rather
+// than aborting the whole summary.
+func (vcp *vsphereCloudProvider) collectHealth() healthSummary {
+	summary := healthSummary{
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	for i := range vcp.nodeGroups {
+		ng := &vcp.nodeGroups[i]
+		health := groupHealth{
+			NodeGroup:  ng.id,
+			TargetSize: *ng.targetSize,
+		}
+
+		size, err := ng.vsphereManager.nodeGroupSize(ng.id)
+		if err != nil {
+			health.LastError = fmt.Sprintf("could not get VM count: %v", err)
+		}
+		health.CurrentVMs = size
+
+		instances, err := ng.vsphereManager.getNodes(ng.id)
+		if err == nil {
+			for _, instance := range instances {
+				if instance.Status == nil || instance.Status.State == cloudprovider.InstanceRunning {
+					health.RunningNodes++
+				}
+			}
+		}
+
+		status := ng.vsphereManager.nodeGroupStatus(ng.id)
+		health.PendingClones = status.pendingClones
+		health.PendingDeletes = status.pendingDeletes
+		if status.lastError != nil {
+			health.LastError = status.lastError.Error()
+		}
+
+		summary.NodeGroups = append(summary.NodeGroups, health)
+	}
+	if checker, ok := vcp.vsphereManager.(goneNodeChecker); ok {
+		summary.GoneNodes = checker.goneNodes()
+	}
+	if reporter, ok := vcp.vsphereManager.(degradedReporter); ok {
+		summary.Degraded = reporter.degradedStatus()
+	}
+	return summary
+}
+
+// publishHealth writes the health summary into a ConfigMap next to the state
+// ConfigMap. It reuses the state store's client, so publishing requires state
+// persistence to be enabled; without it the summary is only logged.
+func (mgr *vsphereManagerGovmomi) publishHealth(summary healthSummary) {
+	if mgr.state == nil {
+		return
+	}
+
+	data, err := json.Marshal(&summary)
+	if err != nil {
+		klog.Errorf("Could not marshal health summary: %v", err)
+		return
+	}
+	cm := &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("cluster-autoscaler-vsphere-health-%s", mgr.clusterName),
+			Namespace: mgr.state.namespace,
+		},
+		Data: map[string]string{healthConfigMapKey: string(data)},
+	}
+
+	_, err = mgr.state.client.CoreV1().ConfigMaps(cm.Namespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = mgr.state.client.CoreV1().ConfigMaps(cm.Namespace).Create(context.TODO(), cm, metav1.CreateOptions{})
+	}
+	if err != nil {
+		klog.Errorf("Could not publish health summary to ConfigMap %s/%s: %v", cm.Namespace, cm.Name, err)
+	}
+}
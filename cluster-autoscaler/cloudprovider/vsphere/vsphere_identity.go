@@ -0,0 +1,73 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// guestinfoIdentityKey is the ExtraConfig key carrying the identity the
+// autoscaler stamped on a VM at clone time.
+const guestinfoIdentityKey = "guestinfo.cluster-autoscaler.identity"
+
+// nodeIdentity is the identity stamped on a clone: cluster, node group
+// and node name, so no two VMs — not even in different clusters sharing a
+// vCenter — carry the same value.
+func (mgr *vsphereManagerGovmomi) nodeIdentity(nodegroup, name string) string {
+	return fmt.Sprintf("%s/%s/%s", mgr.clusterName, nodegroup, name)
+}
+
+// identityFromExtraConfig extracts the stamped identity from a VM's
+// ExtraConfig; empty when the VM carries none.
+func identityFromExtraConfig(extraConfig []types.BaseOptionValue) string {
+	for _, opt := range extraConfig {
+		value := opt.GetOptionValue()
+		if value != nil && value.Key == guestinfoIdentityKey {
+			identity, _ := value.Value.(string)
+			return identity
+		}
+	}
+	return ""
+}
+
+// verifyVMIdentity checks that a VM's stamped identity matches the node
+// the autoscaler is about to delete, guarding against destroying the
+// wrong VM when hostnames collide across clusters or folders. VMs without
+// an identity — adopted or created before stamping existed — are let
+// through with a warning, since refusing would strand them forever.
+func (mgr *vsphereManagerGovmomi) verifyVMIdentity(ctx context.Context, vmRef types.ManagedObjectReference, nodegroup, name string) error {
+	vms, err := mgr.retrieveVMProperties(ctx, []types.ManagedObjectReference{vmRef}, []string{"config.extraConfig"})
+	if err != nil {
+		return fmt.Errorf("could not read identity of VM %s: %v", name, err)
+	}
+	identity := ""
+	if len(vms) == 1 && vms[0].Config != nil {
+		identity = identityFromExtraConfig(vms[0].Config.ExtraConfig)
+	}
+	if identity == "" {
+		klog.Warningf("VM %s carries no identity, deleting it unverified", name)
+		return nil
+	}
+	if expected := mgr.nodeIdentity(nodegroup, name); identity != expected {
+		return fmt.Errorf("VM %s carries identity %q, not %q, refusing to delete it", name, identity, expected)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestIdentityFromExtraConfig(t *testing.T) {
+	mgr := testManager(newFakeTagService())
+	assert.Equal(t, "test/pool-a/pool-a-abc12345", mgr.nodeIdentity("pool-a", "pool-a-abc12345"))
+
+	extraConfig := []types.BaseOptionValue{
+		&types.OptionValue{Key: "guestinfo.userdata", Value: "irrelevant"},
+		&types.OptionValue{Key: guestinfoIdentityKey, Value: "test/pool-a/pool-a-abc12345"},
+	}
+	assert.Equal(t, "test/pool-a/pool-a-abc12345", identityFromExtraConfig(extraConfig))
+
+	// No stamp at all, e.g. an adopted VM.
+	assert.Empty(t, identityFromExtraConfig(extraConfig[:1]))
+	assert.Empty(t, identityFromExtraConfig(nil))
+}
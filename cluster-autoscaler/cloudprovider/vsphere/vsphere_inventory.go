@@ -0,0 +1,217 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// maxInventoryAge bounds how long a snapshot may be served; normally the
+// snapshot is replaced every Refresh, well before this expires.
+const maxInventoryAge = time.Minute
+
+// inventorySnapshot is the result of one consolidated tag sweep: the VM
+// references of every node group, bucketed by group. The durable identity
+// of each VM is its instance UUID, recorded in uuidByRef — the MoRefs are
+// a detail of the vCenter currently hosting the VM and change on
+// cross-vCenter vMotion, after which reconcileMoRefs re-resolves them.
+type inventorySnapshot struct {
+	vmsByGroup map[string][]types.ManagedObjectReference
+	uuidByRef  map[types.ManagedObjectReference]string
+	taken      time.Time
+}
+
+// applyMoRefChanges rewrites the snapshot's references after VMs moved,
+// keeping group membership keyed to the VM identity rather than the old
+// MoRef. Callers must hold the inventory lock.
+func (s *inventorySnapshot) applyMoRefChanges(moved map[types.ManagedObjectReference]types.ManagedObjectReference) {
+	for _, refs := range s.vmsByGroup {
+		for i, ref := range refs {
+			if newRef, ok := moved[ref]; ok {
+				refs[i] = newRef
+			}
+		}
+	}
+	for oldRef, newRef := range moved {
+		if uuid, ok := s.uuidByRef[oldRef]; ok {
+			s.uuidByRef[newRef] = uuid
+			delete(s.uuidByRef, oldRef)
+		}
+	}
+}
+
+// inventoryRefresher is implemented by managers that maintain an inventory
+// snapshot which the provider refreshes once per autoscaler loop.
+type inventoryRefresher interface {
+	refreshInventory(nodegroups []string) error
+}
+
+// refreshInventory sweeps the tag inventory once: it lists the
+// cluster-tagged objects a single time and buckets them by node group tag,
+// so the size, node and status lookups of the following loop are served
+// from the snapshot instead of repeating ListAttachedObjects calls.
+func (mgr *vsphereManagerGovmomi) refreshInventory(nodegroups []string) error {
+	ctx := context.TODO()
+	if err := mgr.degradedError(); err != nil {
+		// Skip the sweep while degraded: lookups fall back to whatever
+		// snapshot exists, and Refresh surfaces the degradation.
+		return err
+	}
+
+	// Claim adoptable VMs first, so they are part of the sweep below.
+	// Adoption trouble is logged, not fatal: the regular inventory must
+	// stay fresh regardless.
+	for _, nodegroup := range nodegroups {
+		if err := mgr.adoptVMs(ctx, nodegroup); err != nil {
+			klog.Warningf("Could not adopt VMs for node group %s: %v", nodegroup, err)
+		}
+	}
+
+	// Sweep superseded template replicas alongside the adoption pass; like
+	// adoption, GC trouble must not keep the inventory from refreshing.
+	if mgr.config.Global.ReplicaGC {
+		if err := mgr.gcReplicas(ctx); err != nil {
+			klog.Warningf("Could not garbage-collect template replicas: %v", err)
+		}
+	}
+
+	// With a live watcher a snapshot the watcher has not invalidated is
+	// still accurate; skip the sweep instead of re-listing every tag.
+	if mgr.watcher.isLive() {
+		mgr.inventoryMutex.RLock()
+		fresh := mgr.inventory != nil && time.Since(mgr.inventory.taken) <= watcherMaxInventoryAge
+		mgr.inventoryMutex.RUnlock()
+		if fresh {
+			klog.V(4).Infof("Skipping tag sweep, the inventory watcher kept the snapshot current")
+			return nil
+		}
+	}
+
+	clusterTagID, err := mgr.activeTags().GetTagID(ctx, mgr.clusterTagName())
+	if err != nil {
+		mgr.noteDegraded(err)
+		mgr.maybeFailover(err)
+		return err
+	}
+	clusterRefs, err := mgr.activeTags().ListAttachedObjects(ctx, clusterTagID)
+	if err != nil {
+		mgr.maybeFailover(err)
+		return fmt.Errorf("could not list objects with cluster tag: %v", err)
+	}
+	inCluster := make(map[types.ManagedObjectReference]bool, len(clusterRefs))
+	for _, ref := range clusterRefs {
+		inCluster[ref.Reference()] = true
+	}
+	excluded, err := mgr.excludedVMs(ctx)
+	if err != nil {
+		return err
+	}
+
+	snapshot := &inventorySnapshot{
+		vmsByGroup: make(map[string][]types.ManagedObjectReference, len(nodegroups)),
+		taken:      time.Now(),
+	}
+	for _, nodegroup := range nodegroups {
+		// Folder and resource pool membership is not part of the tag sweep;
+		// those groups keep using live listings.
+		if mgr.config.membershipFor(nodegroup) != membershipTags {
+			continue
+		}
+		groupTagID, err := mgr.activeTags().GetTagID(ctx, mgr.nodeGroupTagName(nodegroup))
+		if err != nil {
+			return err
+		}
+		groupRefs, err := mgr.activeTags().ListAttachedObjects(ctx, groupTagID)
+		if err != nil {
+			return fmt.Errorf("could not list objects with tag of node group %s: %v", nodegroup, err)
+		}
+		vms := []types.ManagedObjectReference{}
+		for _, ref := range groupRefs {
+			r := ref.Reference()
+			if r.Type == "VirtualMachine" && inCluster[r] && !excluded[r] {
+				vms = append(vms, r)
+			}
+		}
+		snapshot.vmsByGroup[nodegroup] = vms
+	}
+
+	// Record each VM's instance UUID, the identity that survives a
+	// cross-vCenter vMotion. Best effort: without it the snapshot still
+	// works, it just cannot be reconciled after a migration.
+	var allRefs []types.ManagedObjectReference
+	if mgr.activeClient() != nil {
+		// Tag-service-only setups (the in-memory test fake) have no SOAP
+		// client to read properties through.
+		for _, vms := range snapshot.vmsByGroup {
+			allRefs = append(allRefs, vms...)
+		}
+	}
+	if members, err := mgr.retrieveVMProperties(ctx, allRefs, []string{"config.instanceUuid"}); err != nil {
+		klog.Warningf("Could not read instance UUIDs of swept VMs: %v", err)
+	} else {
+		snapshot.uuidByRef = make(map[types.ManagedObjectReference]string, len(members))
+		for _, vm := range members {
+			if vm.Config != nil && vm.Config.InstanceUuid != "" {
+				snapshot.uuidByRef[vm.Self] = vm.Config.InstanceUuid
+			}
+		}
+	}
+
+	mgr.inventoryMutex.Lock()
+	mgr.inventory = snapshot
+	mgr.inventoryMutex.Unlock()
+	klog.V(3).Infof("Swept tag inventory: %d cluster objects across %d node groups", len(clusterRefs), len(nodegroups))
+	return nil
+}
+
+// cachedNodeGroupVMs returns a node group's VMs from the last sweep. ok is
+// false when there is no usable snapshot or the group was not part of it,
+// in which case the caller falls back to a live tag query.
+func (mgr *vsphereManagerGovmomi) cachedNodeGroupVMs(nodegroup string) ([]types.ManagedObjectReference, bool) {
+	mgr.inventoryMutex.RLock()
+	defer mgr.inventoryMutex.RUnlock()
+	maxAge := maxInventoryAge
+	if mgr.watcher.isLive() {
+		// The watcher invalidates the snapshot on VM changes, so it stays
+		// usable far longer than a blind sweep result.
+		maxAge = watcherMaxInventoryAge
+	}
+	if mgr.inventory == nil || time.Since(mgr.inventory.taken) > maxAge {
+		mgr.usage.recordCacheMiss()
+		return nil, false
+	}
+	vms, ok := mgr.inventory.vmsByGroup[nodegroup]
+	if ok {
+		mgr.usage.recordCacheHit()
+	} else {
+		mgr.usage.recordCacheMiss()
+	}
+	return vms, ok
+}
+
+// invalidateInventory drops the snapshot after a tag or VM mutation, so
+// subsequent lookups see the change instead of the stale sweep.
+func (mgr *vsphereManagerGovmomi) invalidateInventory() {
+	mgr.inventoryMutex.Lock()
+	mgr.inventory = nil
+	mgr.inventoryMutex.Unlock()
+}
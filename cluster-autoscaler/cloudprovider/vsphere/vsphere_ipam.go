@@ -0,0 +1,240 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// ipAllocation is an address handed to a new node, injected into its
+// cloud-init network config.
+type ipAllocation struct {
+	Address string `json:"address"`
+	Prefix  int    `json:"prefix"`
+	Gateway string `json:"gateway"`
+}
+
+// ipAllocator hands out node IPs in environments where DHCP is not
+// available in the node VLAN. Implementations: a static range from the
+// config, or an external IPAM webhook.
+type ipAllocator interface {
+	allocate(nodegroup, nodeName string) (ipAllocation, error)
+	release(nodeName string) error
+}
+
+// staticIPAllocator hands out addresses from a configured range. In-use
+// addresses are tracked in memory and, when state persistence is enabled,
+// in the state ConfigMap so restarts do not double-allocate.
+type staticIPAllocator struct {
+	sync.Mutex
+	first   net.IP
+	last    net.IP
+	prefix  int
+	gateway string
+	inUse   map[string]string // node name -> address
+	state   *stateStore
+}
+
+// newStaticIPAllocator parses an ipam-range of the form
+// 10.0.0.10-10.0.0.250 and seeds the in-use set from persisted state.
+func newStaticIPAllocator(cfg ConfigGlobal, state *stateStore) (*staticIPAllocator, error) {
+	parts := strings.SplitN(cfg.IPAMRange, "-", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid ipam-range %q, expected <first>-<last>", cfg.IPAMRange)
+	}
+	first := net.ParseIP(strings.TrimSpace(parts[0]))
+	last := net.ParseIP(strings.TrimSpace(parts[1]))
+	if first == nil || last == nil {
+		return nil, fmt.Errorf("invalid ipam-range %q", cfg.IPAMRange)
+	}
+	if cfg.IPAMPrefix <= 0 || cfg.IPAMPrefix > 128 {
+		return nil, fmt.Errorf("invalid ipam-prefix %d", cfg.IPAMPrefix)
+	}
+
+	a := &staticIPAllocator{
+		first:   first,
+		last:    last,
+		prefix:  cfg.IPAMPrefix,
+		gateway: cfg.IPAMGateway,
+		inUse:   make(map[string]string),
+		state:   state,
+	}
+	if state != nil {
+		for node, address := range state.ipAllocations() {
+			a.inUse[node] = address
+		}
+	}
+	return a, nil
+}
+
+// allocate hands out the first free address of the range.
+func (a *staticIPAllocator) allocate(nodegroup, nodeName string) (ipAllocation, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	used := make(map[string]bool, len(a.inUse))
+	for _, address := range a.inUse {
+		used[address] = true
+	}
+
+	for ip := a.first; !ipAfter(ip, a.last); ip = nextIP(ip) {
+		if used[ip.String()] {
+			continue
+		}
+		a.inUse[nodeName] = ip.String()
+		if a.state != nil {
+			a.state.setIPAllocation(nodeName, ip.String())
+		}
+		return ipAllocation{Address: ip.String(), Prefix: a.prefix, Gateway: a.gateway}, nil
+	}
+	return ipAllocation{}, fmt.Errorf("ipam range exhausted, all addresses between %s and %s are in use", a.first, a.last)
+}
+
+// release returns a node's address to the range.
+func (a *staticIPAllocator) release(nodeName string) error {
+	a.Lock()
+	defer a.Unlock()
+	delete(a.inUse, nodeName)
+	if a.state != nil {
+		a.state.removeIPAllocation(nodeName)
+	}
+	return nil
+}
+
+// nextIP returns the address following ip.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// ipAfter returns whether a sorts after b.
+func ipAfter(a, b net.IP) bool {
+	return bytes.Compare(a.To16(), b.To16()) > 0
+}
+
+// webhookIPAllocator delegates allocation to an external IPAM service via
+// JSON over HTTP.
+type webhookIPAllocator struct {
+	url     string
+	cluster string
+	client  *http.Client
+}
+
+func newWebhookIPAllocator(url, cluster string) *webhookIPAllocator {
+	return &webhookIPAllocator{
+		url:     url,
+		cluster: cluster,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// ipamRequest is the payload sent to the IPAM webhook.
+type ipamRequest struct {
+	Action    string `json:"action"`
+	Cluster   string `json:"cluster"`
+	NodeGroup string `json:"nodeGroup,omitempty"`
+	Node      string `json:"node"`
+}
+
+func (w *webhookIPAllocator) call(request ipamRequest) (*http.Response, error) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	response, err := w.client.Post(w.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("ipam webhook call failed: %v", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("ipam webhook returned status %d", response.StatusCode)
+	}
+	return response, nil
+}
+
+// allocate asks the webhook for an address for the node.
+func (w *webhookIPAllocator) allocate(nodegroup, nodeName string) (ipAllocation, error) {
+	response, err := w.call(ipamRequest{Action: "allocate", Cluster: w.cluster, NodeGroup: nodegroup, Node: nodeName})
+	if err != nil {
+		return ipAllocation{}, err
+	}
+	defer response.Body.Close()
+
+	var allocation ipAllocation
+	if err := json.NewDecoder(response.Body).Decode(&allocation); err != nil {
+		return ipAllocation{}, fmt.Errorf("invalid ipam webhook response: %v", err)
+	}
+	if allocation.Address == "" || allocation.Prefix == 0 {
+		return ipAllocation{}, fmt.Errorf("ipam webhook returned incomplete allocation %+v", allocation)
+	}
+	return allocation, nil
+}
+
+// release tells the webhook the node's address is free again.
+func (w *webhookIPAllocator) release(nodeName string) error {
+	response, err := w.call(ipamRequest{Action: "release", Cluster: w.cluster, Node: nodeName})
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// networkMetadata renders the cloud-init network config (version 2) for an
+// allocation, matching the VM's first ethernet device.
+func networkMetadata(allocation ipAllocation) string {
+	var b strings.Builder
+	b.WriteString("network:\n")
+	b.WriteString("  version: 2\n")
+	b.WriteString("  ethernets:\n")
+	b.WriteString("    nics:\n")
+	b.WriteString("      match:\n")
+	b.WriteString("        name: e*\n")
+	b.WriteString("      addresses:\n")
+	fmt.Fprintf(&b, "        - %s/%d\n", allocation.Address, allocation.Prefix)
+	if allocation.Gateway != "" {
+		fmt.Fprintf(&b, "      gateway4: %s\n", allocation.Gateway)
+	}
+	return b.String()
+}
+
+// releaseIP returns a node's address to the allocator, logging failures:
+// a leaked address must not block a scale-down.
+func (mgr *vsphereManagerGovmomi) releaseIP(nodeName string) {
+	if mgr.ipam == nil {
+		return
+	}
+	if err := mgr.ipam.release(nodeName); err != nil {
+		klog.Errorf("Could not release IP of %s: %v", nodeName, err)
+	}
+}
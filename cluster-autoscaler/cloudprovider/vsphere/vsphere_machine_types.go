@@ -0,0 +1,156 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// machineTypeCatalogTTL is how long a scanned machine type catalog is
+// served before the template folder is consulted again. Template folders
+// change rarely — typically with an image publishing pipeline — so a
+// daily rescan suffices; a restart picks changes up immediately.
+const machineTypeCatalogTTL = 24 * time.Hour
+
+// machineType is one entry of the catalog: a template of the configured
+// machine-type-folder, named after the template and shaped by its
+// hardware.
+type machineType struct {
+	name     string
+	numCPU   int64
+	memoryMB int64
+	diskGB   int64
+	gpus     int64
+}
+
+// String renders a machine type for logs, e.g.
+// "worker-large: 8 vCPU, 32768 MB, 100 GB disk, 1 GPU".
+func (mt machineType) String() string {
+	s := fmt.Sprintf("%s: %d vCPU, %d MB, %d GB disk", mt.name, mt.numCPU, mt.memoryMB, mt.diskGB)
+	if mt.gpus > 0 {
+		s += fmt.Sprintf(", %d GPU", mt.gpus)
+	}
+	return s
+}
+
+// machineTypeSource is implemented by managers that can enumerate the
+// machine types available to the cluster.
+type machineTypeSource interface {
+	availableMachineTypes() ([]string, error)
+}
+
+// buildMachineTypeCatalog turns the retrieved members of the machine type
+// folder into catalog entries, dropping anything that is not a template,
+// sorted by name.
+func buildMachineTypeCatalog(vms []mo.VirtualMachine) []machineType {
+	var catalog []machineType
+	for _, vm := range vms {
+		if vm.Config == nil || !vm.Config.Template {
+			continue
+		}
+		hardware := vm.Config.Hardware
+		var diskBytes int64
+		for _, device := range hardware.Device {
+			if disk, ok := device.(*types.VirtualDisk); ok {
+				diskBytes += disk.CapacityInKB * 1024
+			}
+		}
+		catalog = append(catalog, machineType{
+			name:     vm.Name,
+			numCPU:   int64(hardware.NumCPU),
+			memoryMB: int64(hardware.MemoryMB),
+			diskGB:   diskBytes / (1024 * 1024 * 1024),
+			gpus:     templateGPUCount(hardware.Device),
+		})
+	}
+	sort.Slice(catalog, func(i, j int) bool {
+		return catalog[i].name < catalog[j].name
+	})
+	return catalog
+}
+
+// scanMachineTypes lists the templates of the machine type folder and
+// reads their hardware profiles in one property collector call.
+func (mgr *vsphereManagerGovmomi) scanMachineTypes(ctx context.Context, folder string) ([]machineType, error) {
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vms, err := finder.VirtualMachineList(ctx, folder+"/*")
+	if err != nil {
+		if _, notFound := err.(*find.NotFoundError); notFound {
+			// An empty folder is a valid, if useless, catalog.
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not list templates in %s: %v", folder, err)
+	}
+	refs := make([]types.ManagedObjectReference, 0, len(vms))
+	for _, vm := range vms {
+		refs = append(refs, vm.Reference())
+	}
+	members, err := mgr.retrieveVMProperties(ctx, refs, []string{"name", "config.template", "config.hardware"})
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := buildMachineTypeCatalog(members)
+	for _, mt := range catalog {
+		klog.V(2).Infof("Machine type %s", mt)
+	}
+	return catalog, nil
+}
+
+// availableMachineTypes returns the names of the catalog's machine types,
+// rescanning the template folder once the catalog outlives its TTL. A
+// failed rescan keeps serving the previous catalog rather than hiding
+// every machine type over a transient vCenter problem.
+func (mgr *vsphereManagerGovmomi) availableMachineTypes() ([]string, error) {
+	folder := mgr.config.Global.MachineTypeFolder
+	if folder == "" {
+		return nil, nil
+	}
+
+	mgr.machineTypesMutex.Lock()
+	defer mgr.machineTypesMutex.Unlock()
+	if time.Since(mgr.machineTypesScanned) > machineTypeCatalogTTL {
+		catalog, err := mgr.scanMachineTypes(context.TODO(), folder)
+		if err != nil {
+			if mgr.machineTypesScanned.IsZero() {
+				return nil, fmt.Errorf("could not scan machine types: %v", err)
+			}
+			klog.Warningf("Could not rescan machine types, keeping the previous catalog: %v", err)
+		} else {
+			klog.V(1).Infof("Scanned %s: %d machine types", folder, len(catalog))
+			mgr.machineTypes = catalog
+			mgr.machineTypesScanned = time.Now()
+		}
+	}
+
+	names := make([]string, 0, len(mgr.machineTypes))
+	for _, mt := range mgr.machineTypes {
+		names = append(names, mt.name)
+	}
+	return names, nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func catalogMember(name string, template bool, cpu, memoryMB int32, devices ...types.BaseVirtualDevice) mo.VirtualMachine {
+	return mo.VirtualMachine{
+		ManagedEntity: mo.ManagedEntity{Name: name},
+		Config: &types.VirtualMachineConfigInfo{
+			Template: template,
+			Hardware: types.VirtualHardware{NumCPU: cpu, MemoryMB: memoryMB, Device: devices},
+		},
+	}
+}
+
+func TestBuildMachineTypeCatalog(t *testing.T) {
+	disk := &types.VirtualDisk{CapacityInKB: 40 * 1024 * 1024}
+	catalog := buildMachineTypeCatalog([]mo.VirtualMachine{
+		catalogMember("worker-small", true, 2, 4096, disk),
+		// Powered-on VMs parked in the folder are not machine types.
+		catalogMember("stray-vm", false, 4, 8192, disk),
+		catalogMember("worker-gpu", true, 8, 32768, disk, &types.VirtualPCIPassthrough{}),
+	})
+
+	assert.Len(t, catalog, 2)
+	// Sorted by name, stray VM dropped.
+	assert.Equal(t, "worker-gpu", catalog[0].name)
+	assert.Equal(t, int64(8), catalog[0].numCPU)
+	assert.Equal(t, int64(1), catalog[0].gpus)
+	assert.Equal(t, "worker-small", catalog[1].name)
+	assert.Equal(t, int64(40), catalog[1].diskGB)
+	assert.Equal(t, "worker-small: 2 vCPU, 4096 MB, 40 GB disk", catalog[1].String())
+	assert.Equal(t, "worker-gpu: 8 vCPU, 32768 MB, 40 GB disk, 1 GPU", catalog[0].String())
+}
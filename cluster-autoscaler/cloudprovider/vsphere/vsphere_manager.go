@@ -0,0 +1,1321 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"gopkg.in/gcfg.v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider/vsphere/vsphereapi"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/klog"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+const (
+	defaultManager = "govmomi"
+
+	// clusterTagPrefix is the prefix of the vSphere tag attached to all VMs
+	// belonging to a kubernetes cluster.
+	clusterTagPrefix = "k8s-cluster-"
+	// nodeGroupTagPrefix is the prefix of the vSphere tag attached to all VMs
+	// belonging to a node group.
+	nodeGroupTagPrefix = "k8s-nodegroup-"
+	// roleControlPlaneTag marks control-plane VMs of stacked clusters. VMs
+	// carrying it never count toward, nor get deleted from, worker node
+	// groups even when they carry the cluster tag.
+	roleControlPlaneTag = "k8s-role-control-plane"
+	// ignoreTag opts a single VM out of autoscaling: it is not counted as
+	// a node group member and scale-down refuses to touch it, so one-off
+	// pet VMs can live in a node group's folder or carry its tags without
+	// being churned.
+	ignoreTag = "k8s-autoscaler-ignore"
+)
+
+// NodeRef stores the name, machineID and providerID of a node. It lives
+// in the vsphereapi package so embedders share the type.
+type NodeRef = vsphereapi.NodeRef
+
+// groupStatus describes the in-flight operations and the most recent
+// failure recorded for a node group.
+type groupStatus struct {
+	pendingClones  int
+	pendingDeletes int
+	lastError      error
+	// cooldown is how long scale-ups of the group stay deferred after
+	// repeated clone failures; zero when the group may scale up.
+	cooldown time.Duration
+}
+
+// vsphereManager is an interface for the basic interactions with vCenter.
+type vsphereManager interface {
+	nodeGroupSize(nodegroup string) (int, error)
+	createNodes(nodegroup string, nodes int) error
+	deleteNodes(nodegroup string, nodes []NodeRef, updatedNodeCount int) error
+	rankDeletionCandidates(nodegroup string, nodes []NodeRef) []NodeRef
+	// idleDeletionCandidates picks the node group members to remove when the
+	// target size is decreased without naming specific nodes, ordered by the
+	// group's decrease policy.
+	idleDeletionCandidates(nodegroup string, count int) ([]NodeRef, error)
+	getNodes(nodegroup string) ([]cloudprovider.Instance, error)
+	getNodeNames(nodegroup string) ([]string, error)
+	nodeGroupStatus(nodegroup string) groupStatus
+	templateNodeInfo(nodegroup string) (*schedulernodeinfo.NodeInfo, error)
+	// targetSizeChanged tells the manager the intended size of a node group
+	// changed, so it can persist it if state persistence is enabled.
+	targetSizeChanged(nodegroup string, size int)
+	// nodeGroupDefs returns the node group definitions from the
+	// VsphereNodeGroup custom resources, and whether that source is enabled.
+	nodeGroupDefs() ([]nodeGroupDef, bool, error)
+	// nodeGroupPriority returns the configured priority of a node group.
+	nodeGroupPriority(nodegroup string) int
+	// restoredTargetSize returns the target size a previous run persisted
+	// for a node group, if any.
+	restoredTargetSize(nodegroup string) (int, bool)
+	// autoscalingOptions returns the per node group autoscaling option
+	// overrides from the cloud config.
+	autoscalingOptions(nodegroup string) nodeGroupAutoscalingOptions
+}
+
+// nodeGroupAutoscalingOptions are the autoscaling tunables the cloud config
+// can override per node group; zero values mean the cluster-wide defaults
+// apply.
+type nodeGroupAutoscalingOptions struct {
+	ScaleDownUtilizationThreshold float64
+	ScaleDownUnneededTime         time.Duration
+	MaxNodeProvisionTime          time.Duration
+}
+
+// ConfigNodeGroup holds the settings from a [nodegroup "<name>"] section of
+// the cloud config. Empty fields fall back to the global values.
+type ConfigNodeGroup struct {
+	Template     string `gcfg:"template" json:"template"`
+	ResourcePool string `gcfg:"resource-pool" json:"resource-pool"`
+	Datastore    string `gcfg:"datastore" json:"datastore"`
+	Folder       string `gcfg:"folder" json:"folder"`
+	UserData     string `gcfg:"user-data" json:"user-data"`
+
+	// TemplateSnapshot, when set, pins clones to the named snapshot of the
+	// template, so a template updated mid-rollout keeps producing the image
+	// the snapshot was taken from.
+	TemplateSnapshot string `gcfg:"template-snapshot" json:"template-snapshot"`
+	// VerifyTemplate, when true, refuses scale-ups once the template's
+	// identity or configuration has changed since the autoscaler first used
+	// it, so template updates do not silently produce mixed node images.
+	VerifyTemplate bool `gcfg:"verify-template" json:"verify-template"`
+	// RolloutTemplate names a second template for a gradual image rollout:
+	// clones are split between it and the group's template so its share of
+	// the group converges to RolloutFraction, and scale-down retires VMs
+	// of the outgoing template first. Both options go together.
+	RolloutTemplate string `gcfg:"rollout-template" json:"rollout-template"`
+	// RolloutFraction is the fraction of the group's VMs to run on
+	// RolloutTemplate, e.g. "0.1" for one VM in ten.
+	RolloutFraction string `gcfg:"rollout-fraction" json:"rollout-fraction"`
+	// CloneSpecBuilder names a registered clone spec builder that decorates
+	// the config spec of this group's clones. Empty uses the built-in spec
+	// unchanged; see RegisterCloneSpecBuilder.
+	CloneSpecBuilder string `gcfg:"clone-spec-builder" json:"clone-spec-builder"`
+	// VerifyIdentity, when true, makes scale-down check the identity the
+	// autoscaler stamped on the VM at clone time before destroying it, so
+	// a hostname collision cannot take out somebody else's VM.
+	VerifyIdentity bool `gcfg:"verify-identity" json:"verify-identity"`
+	// DetachDataDisks, when true, makes scale-down detach any first-class
+	// disks (CNS volumes) still attached to a VM before destroying it. The
+	// disks are only detached, never deleted.
+	DetachDataDisks bool `gcfg:"detach-data-disks" json:"detach-data-disks"`
+	// ProtectGPUWorkloads, when true, refuses to scale down a node whose
+	// pods still request GPUs, so a vGPU node is never removed mid-training
+	// on the strength of lagging utilization metrics.
+	ProtectGPUWorkloads bool `gcfg:"protect-gpu-workloads" json:"protect-gpu-workloads"`
+	// PrewarmTemplates, when true, keeps a replica of the template on the
+	// node group's datastore and clones from that replica, avoiding the
+	// slow first cross-datastore clone. Replicas are recreated when the
+	// source template changes.
+	PrewarmTemplates bool `gcfg:"prewarm-templates" json:"prewarm-templates"`
+
+	// DiskProvisioning selects how clone disks are provisioned: "thin",
+	// "thick" or "eagerZeroedThick". Empty keeps the template's format.
+	// Requires a datastore to be configured.
+	DiskProvisioning string `gcfg:"disk-provisioning" json:"disk-provisioning"`
+
+	// HardwareVersion, when set, upgrades clones to the given virtual
+	// hardware version (e.g. vmx-19), so templates on older hardware can
+	// produce nodes on newer hardware.
+	HardwareVersion string `gcfg:"hardware-version" json:"hardware-version"`
+	// Firmware, when set, overrides the firmware of clones: "bios" or "efi".
+	Firmware string `gcfg:"firmware" json:"firmware"`
+	// SecureBoot, when true, enables EFI secure boot on clones. Requires
+	// EFI firmware, either from the template or via the firmware option.
+	SecureBoot bool `gcfg:"secure-boot" json:"secure-boot"`
+	// VTPM, when true, attaches a virtual TPM device to clones. Requires a
+	// key provider configured in vCenter.
+	VTPM bool `gcfg:"vtpm" json:"vtpm"`
+
+	// CPUReservation/CPULimit (MHz) and MemoryReservation/MemoryLimit (MB)
+	// set the resource allocation of clones, so latency-sensitive pools can
+	// run fully reserved while burst pools are allowed overcommit. Zero
+	// keeps the template's allocation; a limit of -1 means unlimited.
+	CPUReservation    int64 `gcfg:"cpu-reservation" json:"cpu-reservation"`
+	CPULimit          int64 `gcfg:"cpu-limit" json:"cpu-limit"`
+	MemoryReservation int64 `gcfg:"memory-reservation" json:"memory-reservation"`
+	MemoryLimit       int64 `gcfg:"memory-limit" json:"memory-limit"`
+	// CPUShares and MemoryShares are "low", "normal", "high" or a custom
+	// numeric share value.
+	CPUShares    string `gcfg:"cpu-shares" json:"cpu-shares"`
+	MemoryShares string `gcfg:"memory-shares" json:"memory-shares"`
+	// CPUHotAdd and MemoryHotAdd enable hot-adding the resource to running
+	// nodes.
+	CPUHotAdd    bool `gcfg:"cpu-hot-add" json:"cpu-hot-add"`
+	MemoryHotAdd bool `gcfg:"memory-hot-add" json:"memory-hot-add"`
+
+	// LatencySensitivity sets the VM latency sensitivity level of clones:
+	// "low", "normal", "medium" or "high". vSphere demands full CPU and
+	// memory reservations for "high", so it requires cpu-reservation and
+	// memory-reservation.
+	LatencySensitivity string `gcfg:"latency-sensitivity" json:"latency-sensitivity"`
+	// NUMANodeAffinity pins clones to host NUMA nodes, as a comma separated
+	// list of node numbers, e.g. "0,1".
+	NUMANodeAffinity string `gcfg:"numa-node-affinity" json:"numa-node-affinity"`
+	// CoresPerSocket overrides the virtual cores-per-socket topology of
+	// clones; zero keeps the template's topology.
+	CoresPerSocket int `gcfg:"cores-per-socket" json:"cores-per-socket"`
+
+	// NICs are extra network adapters attached to clones on top of the
+	// template's own devices, one `nic` entry per adapter in the form
+	// `<portgroup>[,type=<adapter>][,mac=generated|prefix:<xx:xx:xx>]`,
+	// e.g. for SR-IOV virtual functions with pinned MACs on DPDK nodes.
+	NICs []string `gcfg:"nic" json:"nics"`
+
+	// DataDisks are extra virtual disks created with each clone on top of
+	// the template's own, one `data-disk` entry per disk in the form
+	// `<size-gb>[,datastore=<name>][,type=thin|thick|eagerZeroedThick]`,
+	// e.g. for a dedicated scratch disk for container images and emptyDir.
+	DataDisks []string `gcfg:"data-disk" json:"data-disks"`
+
+	// FallbackResourcePools are additional resource pools tried in order
+	// when a clone into the group's resource pool fails for lack of
+	// capacity, one `fallback-resource-pool` entry per pool. The pool a VM
+	// landed in is recorded in a custom attribute, and scale-down prefers
+	// VMs in fallback pools, so the group drains back into its primary
+	// pool when capacity frees up.
+	FallbackResourcePools []string `gcfg:"fallback-resource-pool" json:"fallback-resource-pools"`
+
+	// AdoptPattern is a glob matched against VM inventory paths, e.g.
+	// `emergency-*`. Matching VMs not yet belonging to the cluster are
+	// adopted into this node group: tagged, counted and managed like any
+	// cloned node. Complements the k8s-adopt-<group> tag, which adopts
+	// individual VMs without a config change.
+	AdoptPattern string `gcfg:"adopt-pattern" json:"adopt-pattern"`
+
+	// AvoidVMsTagged keeps the group's nodes off hosts running VMs that
+	// carry the named vSphere tag, e.g. a tag marking database VMs. Clones
+	// are steered to the least loaded host without such a VM; when every
+	// host runs one, placement falls back to DRS with a warning.
+	AvoidVMsTagged string `gcfg:"avoid-vms-tagged" json:"avoid-vms-tagged"`
+
+	// GuestOS declares the guest operating system of the group's template:
+	// "linux" (the default) or "windows". Windows groups are configured
+	// through a sysprep guest customization spec instead of cloud-init
+	// guestinfo, and their template nodes carry the matching
+	// kubernetes.io/os label.
+	GuestOS string `gcfg:"guest-os" json:"guest-os"`
+
+	// OVFProperties are vApp/OVF property values injected into each clone,
+	// one `ovf-property` entry per property in the form `<id>=<value>`,
+	// for templates deployed from OVAs that read hostname, SSH keys or a
+	// join URL from the OVF environment instead of guestinfo. Values may
+	// use the {{node_name}} placeholder and the user-data substitutions.
+	OVFProperties []string `gcfg:"ovf-property" json:"ovf-properties"`
+
+	// CanaryFraction marks this node group as a canary pool — typically
+	// one pointing at a newer template — and caps it at the given fraction
+	// of the cluster's nodes, e.g. "0.1" for at most a tenth. The cap
+	// applies on top of the group's max size, so a misbehaving canary
+	// image cannot spread past its blast radius.
+	CanaryFraction string `gcfg:"canary-fraction" json:"canary-fraction"`
+
+	// MachineDeployment is the name of the CAPV MachineDeployment backing
+	// this node group when the capv manager is used. Defaults to the node
+	// group name.
+	MachineDeployment string `gcfg:"machine-deployment" json:"machine-deployment"`
+
+	// VMClass, VMImage and StorageClass select the VirtualMachineClass,
+	// VirtualMachineImage and storage class of nodes created by the
+	// vmservice manager.
+	VMClass      string `gcfg:"vm-class" json:"vm-class"`
+	VMImage      string `gcfg:"vm-image" json:"vm-image"`
+	StorageClass string `gcfg:"storage-class" json:"storage-class"`
+	// VMMetadataConfigMap names an existing ConfigMap in the Supervisor
+	// namespace holding the cloud-init metadata handed to nodes created by
+	// the vmservice manager.
+	VMMetadataConfigMap string `gcfg:"vm-metadata-configmap" json:"vm-metadata-configmap"`
+
+	// MaxPods is the pod capacity advertised for the group's template
+	// nodes in the scheduler simulation, matching the kubelet's --max-pods.
+	// Zero falls back to the kubelet default of 110.
+	MaxPods int `gcfg:"max-pods" json:"max-pods"`
+	// EphemeralStorageReserve is the part of the template's disk capacity
+	// not advertised as ephemeral storage, covering the OS, images and the
+	// kubelet's own reserve, as a resource quantity, e.g. 20Gi.
+	EphemeralStorageReserve string `gcfg:"ephemeral-storage-reserve" json:"ephemeral-storage-reserve"`
+
+	// KubeReserved and SystemReserved are the kubelet's resource reserves
+	// for Kubernetes and OS system daemons, as kubelet-style resource
+	// lists, e.g. cpu=100m,memory=500Mi. They are passed to the kubelet
+	// through {{kubelet_extra_args}} and subtracted from the template
+	// node's allocatable, so simulation and reality agree.
+	KubeReserved   string `gcfg:"kube-reserved" json:"kube-reserved"`
+	SystemReserved string `gcfg:"system-reserved" json:"system-reserved"`
+	// EvictionHard replaces the kubelet's default hard eviction thresholds
+	// in both places, e.g. memory.available<200Mi,nodefs.available<5%.
+	// Only the memory.available and nodefs.available signals are
+	// understood.
+	EvictionHard string `gcfg:"eviction-hard" json:"eviction-hard"`
+
+	// UserDataVars is a comma separated list of key=value substitutions
+	// for the user-data, e.g. registry=mirror.corp,join_url=https://....
+	// Each key becomes a {{key}} placeholder, on top of the built-in
+	// {{node_name}}, {{nodegroup}}, {{cluster_name}} and {{ip}}. Merged
+	// over the global list, node group entries winning.
+	UserDataVars string `gcfg:"user-data-vars" json:"user-data-vars"`
+
+	// Labels is a comma separated list of key=value labels the kubelet
+	// registers the node with, e.g. pool=highmem,disk=ssd.
+	Labels string `gcfg:"labels" json:"labels"`
+	// Taints is a comma separated list of key=value:Effect taints the
+	// kubelet registers the node with, e.g. dedicated=gpu:NoSchedule.
+	Taints string `gcfg:"taints" json:"taints"`
+
+	// Priority orders node groups: higher values come first in NodeGroups()
+	// and line up with the priority expander configuration. Groups with
+	// equal priority are ordered by name.
+	Priority int `gcfg:"priority" json:"priority"`
+
+	// WarmPoolSize is the number of powered-off standby VMs kept pre-cloned
+	// for this node group. Scale-ups activate standby VMs first, which is
+	// much faster than cloning on demand; the pool is replenished in the
+	// background. Zero disables the warm pool.
+	WarmPoolSize int `gcfg:"warm-pool-size" json:"warm-pool-size"`
+	// WarmPoolTTL is how long an idle VM may sit in the warm pool before
+	// it is destroyed to reclaim datastore space, as a Go duration string.
+	// Empty or "0" keeps pooled VMs forever.
+	WarmPoolTTL configDuration `gcfg:"warm-pool-ttl" json:"warm-pool-ttl"`
+
+	// MaxConcurrentClones caps how many clone tasks of this node group may
+	// run at once. Zero falls back to the default of 4; clones beyond the
+	// cap wait in the creation queue.
+	MaxConcurrentClones int `gcfg:"max-concurrent-clones" json:"max-concurrent-clones"`
+
+	// Membership selects how the group's member VMs are identified: "tags"
+	// (the default), or "folder"/"resource-pool" where every VM in the
+	// group's folder or resource pool is a member — for vCenter roles that
+	// cannot use the tagging service. These modes need a folder or resource
+	// pool dedicated to the node group.
+	Membership string `gcfg:"membership" json:"membership"`
+
+	// ChildResourcePool, when true, clones the group's nodes into a child
+	// resource pool named after the group under the configured pool,
+	// creating it on first use and sizing its reservations to the node
+	// count times the per-node cpu-reservation/memory-reservation. Keeps
+	// groups visible and segregated in the vCenter pool hierarchy.
+	ChildResourcePool bool `gcfg:"child-resource-pool" json:"child-resource-pool"`
+
+	// RelocateOnChange, when true, migrates the group's existing VMs to the
+	// configured resource pool and datastore when the config points
+	// somewhere else, one Storage vMotion at a time in the background. Off,
+	// a pool or datastore change only applies to nodes cloned afterwards
+	// and the old nodes stay where they are until they are scaled down.
+	RelocateOnChange bool `gcfg:"relocate-on-change" json:"relocate-on-change"`
+
+	// PreferredSiteHostGroup and SecondarySiteHostGroup name the DRS host
+	// groups of the two sites of a vSAN stretched cluster. When both are
+	// set, new nodes alternate between the sites through per-site DRS VM
+	// groups tied to these host groups. Must be set together.
+	PreferredSiteHostGroup string `gcfg:"preferred-site-host-group" json:"preferred-site-host-group"`
+	SecondarySiteHostGroup string `gcfg:"secondary-site-host-group" json:"secondary-site-host-group"`
+
+	// ScaleDownMode selects what happens to a VM on scale-down: "destroy"
+	// (the default), or "power-off"/"suspend" which return the VM to the
+	// warm pool for fast re-activation instead of destroying it.
+	ScaleDownMode string `gcfg:"scale-down-mode" json:"scale-down-mode"`
+
+	// DecreasePolicy selects which VMs are removed when the target size is
+	// decreased without naming specific nodes: "newest-first" (the default)
+	// or "oldest-first", by VM creation time.
+	DecreasePolicy string `gcfg:"decrease-policy" json:"decrease-policy"`
+
+	// Paused freezes scaling of this node group: "up", "down" or "all".
+	// Empty keeps the group scaling normally. The k8s-paused-<name> tag
+	// pauses both directions without a config change.
+	Paused string `gcfg:"paused" json:"paused"`
+
+	// ScalingWindows are recurring weekly time windows during which the
+	// group's reported size bounds change, e.g.
+	// days=mon-fri,hours=08:00-18:00,min=10 to keep capacity up during
+	// business hours. The option repeats; the first matching window wins.
+	ScalingWindows []string `gcfg:"scaling-window" json:"scaling-windows"`
+
+	// MaxNodeAge is how old a node of this group may grow before the
+	// autoscaler prefers it as a deletion candidate, as a Go duration
+	// string, e.g. 720h to rotate the fleet onto fresh clones monthly.
+	// Empty or "0" disables age-based recycling.
+	MaxNodeAge configDuration `gcfg:"max-node-age" json:"max-node-age"`
+
+	// MaxNodeProvisionTime overrides the cluster-wide
+	// --max-node-provision-time for this node group, as a Go duration
+	// string, e.g. 20m for groups on datastores where clones legitimately
+	// take longer. Empty keeps the cluster-wide default.
+	MaxNodeProvisionTime configDuration `gcfg:"max-node-provision-time" json:"max-node-provision-time"`
+	// ScaleDownUtilizationThreshold overrides the cluster-wide utilization
+	// threshold below which this group's nodes count as unneeded, as a
+	// fraction, e.g. 0.65. Empty keeps the cluster-wide default.
+	ScaleDownUtilizationThreshold string `gcfg:"scale-down-utilization-threshold" json:"scale-down-utilization-threshold"`
+	// ScaleDownUnneededTime overrides how long a node of this group must
+	// be unneeded before it is removed, as a Go duration string. Empty
+	// keeps the cluster-wide default.
+	ScaleDownUnneededTime configDuration `gcfg:"scale-down-unneeded-time" json:"scale-down-unneeded-time"`
+
+	// Zone is the failure domain of this node group, typically the name of
+	// the vSphere cluster the group's resource pool lives in. It is set as
+	// the topology.kubernetes.io/zone label on both the template node and
+	// the real nodes.
+	Zone string `gcfg:"zone" json:"zone"`
+}
+
+// ConfigGlobal holds the [global] section of the cloud config: the vCenter
+// endpoint, credentials and the default placement for cloned VMs.
+type ConfigGlobal struct {
+	VCenter      string `gcfg:"vcenter" json:"vcenter"`
+	User         string `gcfg:"user" json:"user"`
+	Password     string `gcfg:"password" json:"password"`
+	InsecureFlag bool   `gcfg:"insecure-flag" json:"insecure-flag"`
+
+	// MinTLSVersion is the lowest TLS protocol version accepted when
+	// connecting to vCenter: "1.0", "1.1", "1.2" or "1.3". Empty keeps
+	// Go's default.
+	MinTLSVersion string `gcfg:"min-tls-version" json:"min-tls-version"`
+
+	// TLSCipherSuites restricts the cipher suites offered to vCenter to
+	// the given comma-separated IANA names, e.g.
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Empty keeps Go's default
+	// selection. TLS 1.3 suites are not configurable in Go and the list
+	// only applies to TLS 1.2 and below.
+	TLSCipherSuites string `gcfg:"tls-cipher-suites" json:"tls-cipher-suites"`
+
+	// HardenedTLS enforces a hardened TLS posture: insecure-flag and
+	// cipher suites Go considers insecure are rejected at startup, and
+	// the minimum TLS version defaults to 1.2 instead of Go's default.
+	HardenedTLS bool `gcfg:"hardened-tls" json:"hardened-tls"`
+
+	Datacenter   string `gcfg:"datacenter" json:"datacenter"`
+	ResourcePool string `gcfg:"resource-pool" json:"resource-pool"`
+	Datastore    string `gcfg:"datastore" json:"datastore"`
+	Folder       string `gcfg:"folder" json:"folder"`
+	Template     string `gcfg:"template" json:"template"`
+	UserData     string `gcfg:"user-data" json:"user-data"`
+
+	// UserDataVars is the default list of user-data substitutions; node
+	// groups merge their own list over it. See the option of the same name
+	// in the nodegroup sections.
+	UserDataVars string `gcfg:"user-data-vars" json:"user-data-vars"`
+
+	// EphemeralStorageReserve is the default storage reserve for node
+	// groups that do not set their own.
+	EphemeralStorageReserve string `gcfg:"ephemeral-storage-reserve" json:"ephemeral-storage-reserve"`
+
+	// KubeReserved, SystemReserved and EvictionHard are the defaults for
+	// node groups that do not set their own.
+	KubeReserved   string `gcfg:"kube-reserved" json:"kube-reserved"`
+	SystemReserved string `gcfg:"system-reserved" json:"system-reserved"`
+	EvictionHard   string `gcfg:"eviction-hard" json:"eviction-hard"`
+
+	// HardwareVersion, Firmware and DiskProvisioning are the defaults for
+	// node groups that do not set their own.
+	HardwareVersion  string `gcfg:"hardware-version" json:"hardware-version"`
+	Firmware         string `gcfg:"firmware" json:"firmware"`
+	DiskProvisioning string `gcfg:"disk-provisioning" json:"disk-provisioning"`
+
+	ClusterName string `gcfg:"cluster-name" json:"cluster-name"`
+
+	// Zone is the default failure domain for node groups that do not set
+	// their own zone.
+	Zone string `gcfg:"zone" json:"zone"`
+	// Region is the region label applied to all nodes, e.g. the name of
+	// the datacenter or site.
+	Region string `gcfg:"region" json:"region"`
+
+	// AvoidVMsTagged is the default avoid-vms-tagged tag for node groups
+	// that do not set their own.
+	AvoidVMsTagged string `gcfg:"avoid-vms-tagged" json:"avoid-vms-tagged"`
+
+	// AllowedPaths restricts every VM create, delete and tag operation to
+	// inventory objects under the listed path prefixes. Repeat the entry
+	// for multiple prefixes; an empty list allows everything.
+	AllowedPaths []string `gcfg:"allowed-path" json:"allowed-paths"`
+
+	// InventoryWatcher, when true, keeps a WaitForUpdatesEx session on the
+	// cluster folder so VM changes invalidate the inventory snapshot in
+	// near-real-time and the per-loop tag sweep can reuse a still-valid
+	// snapshot instead of re-listing.
+	InventoryWatcher bool `gcfg:"inventory-watcher" json:"inventory-watcher"`
+
+	// WatchEvents, when true, subscribes to vCenter VM events below the
+	// cluster folder, so node VMs deleted or powered off outside the
+	// autoscaler are noticed promptly and target sizes follow.
+	WatchEvents bool `gcfg:"watch-events" json:"watch-events"`
+
+	// ReloadConfig, when true, makes the autoscaler re-read the cloud
+	// config file when it changes and apply template, placement and
+	// option edits without a restart. Connection settings and enabled
+	// subsystems still need one.
+	ReloadConfig bool `gcfg:"reload-config" json:"reload-config"`
+
+	// DisableCreatedByCheck turns off the scale-down safety check that
+	// only VMs carrying the created-by marker stamped at clone or adoption
+	// time may be destroyed. Needed while VMs created before the marker
+	// existed are still part of the cluster.
+	DisableCreatedByCheck bool `gcfg:"disable-created-by-check" json:"disable-created-by-check"`
+
+	// ReplicaGC, when true, periodically deletes template replicas that no
+	// node group references any more, e.g. after a template pattern rotated
+	// to a newer image. Replicas get a grace period before deletion.
+	ReplicaGC bool `gcfg:"replica-gc" json:"replica-gc"`
+
+	// BootDiagnostics, when true, captures a console screenshot and the
+	// serial log tail of a VM whose provisioning timed out, referencing
+	// the files in the failure message. Serial output capture needs a
+	// file-backed serial port on the template.
+	BootDiagnostics bool `gcfg:"boot-diagnostics" json:"boot-diagnostics"`
+
+	// GuestProbe, when true, confirms through VMware Tools guest
+	// operations that a new node's kubelet is running before the instance
+	// counts as provisioned, so stuck boots are noticed before
+	// max-node-provision-time. Needs GuestUser/GuestPassword credentials
+	// valid inside the node's guest OS.
+	GuestProbe    bool   `gcfg:"guest-probe" json:"guest-probe"`
+	GuestUser     string `gcfg:"guest-user" json:"guest-user"`
+	GuestPassword string `gcfg:"guest-password" json:"guest-password"`
+	// GuestProbeCommand overrides the probe command; its exit code decides
+	// whether the kubelet is up. Empty runs the systemd default.
+	GuestProbeCommand string `gcfg:"guest-probe-command" json:"guest-probe-command"`
+
+	// SecondaryVCenter is a standby vCenter endpoint in the same SSO
+	// domain (linked mode partner or replica) the provider fails over to
+	// when the primary becomes unreachable, keeping the cluster state
+	// readable through an outage. Mutating operations stay paused during
+	// failover unless FailoverMutations opts in.
+	SecondaryVCenter  string `gcfg:"secondary-vcenter" json:"secondary-vcenter"`
+	FailoverMutations bool   `gcfg:"failover-mutations" json:"failover-mutations"`
+
+	// HostConsolidation, when true, orders scale-down deletions so that
+	// VMs on the most lightly loaded ESXi hosts go first. Scale-down then
+	// tends to empty hosts, letting DPM power them off. It is the older
+	// spelling of `scale-down-placement = consolidate`.
+	HostConsolidation bool `gcfg:"host-consolidation" json:"host-consolidation"`
+
+	// ScaleDownPlacement orders scale-down deletions by the load of the
+	// hosts the VMs run on: "consolidate" empties the most lightly loaded
+	// hosts first for DPM, "relieve-contention" deletes from the most
+	// loaded hosts first — by the CPU and memory demand DRS balances on —
+	// to ease contention for the VMs staying behind. Empty applies no host
+	// ordering.
+	ScaleDownPlacement string `gcfg:"scale-down-placement" json:"scale-down-placement"`
+
+	// CloneTimeout bounds how long a single clone task may run, as a Go
+	// duration string. On expiry the task is cancelled, the partial VM is
+	// destroyed and the node creation is reported as failed. Defaults to
+	// 30m; "0" disables the timeout.
+	CloneTimeout configDuration `gcfg:"clone-timeout" json:"clone-timeout"`
+
+	// MaxConcurrentClones caps how many clone tasks may run at once across
+	// all node groups, so a large scale-up does not flood vCenter with
+	// simultaneous tasks. Defaults to 8; clones beyond the cap wait in the
+	// creation queue. The option of the same name in the nodegroup sections
+	// additionally caps a single group.
+	MaxConcurrentClones int `gcfg:"max-concurrent-clones" json:"max-concurrent-clones"`
+
+	// MaxConcurrentDeletes caps how many VM deletions may run at once
+	// across all node groups, so shedding many nodes does not saturate a
+	// datastore with destroy operations. Defaults to 4; deletions beyond
+	// the cap are queued.
+	MaxConcurrentDeletes int `gcfg:"max-concurrent-deletes" json:"max-concurrent-deletes"`
+	// MaxDeletesPerHost additionally caps simultaneous deletions of VMs on
+	// the same ESXi host, bounding how many nodes drain their pods off one
+	// host at a time. Defaults to 2.
+	MaxDeletesPerHost int `gcfg:"max-deletes-per-host" json:"max-deletes-per-host"`
+
+	// BootstrapToken, when true, makes the manager mint a short-lived
+	// kubeadm bootstrap token before each clone and substitute it for the
+	// {{bootstrap_token}} placeholder in the user-data.
+	BootstrapToken bool `gcfg:"bootstrap-token" json:"bootstrap-token"`
+	// BootstrapTokenTTL is how long minted tokens stay valid, as a Go
+	// duration string. Defaults to 15m.
+	BootstrapTokenTTL configDuration `gcfg:"bootstrap-token-ttl" json:"bootstrap-token-ttl"`
+
+	// IPAMMode selects how node IPs are obtained in networks without DHCP:
+	// "" (DHCP/none), "static" for a range from this config, or "webhook"
+	// for an external IPAM service. Allocated addresses are injected into
+	// the clone's cloud-init network config and released on delete.
+	IPAMMode string `gcfg:"ipam-mode" json:"ipam-mode"`
+	// IPAMRange is the static address range, e.g. 10.0.0.10-10.0.0.250.
+	IPAMRange string `gcfg:"ipam-range" json:"ipam-range"`
+	// IPAMPrefix is the prefix length of the node network, e.g. 24.
+	IPAMPrefix int `gcfg:"ipam-prefix" json:"ipam-prefix"`
+	// IPAMGateway is the default gateway handed to nodes.
+	IPAMGateway string `gcfg:"ipam-gateway" json:"ipam-gateway"`
+	// IPAMWebhookURL is the endpoint of the external IPAM service.
+	IPAMWebhookURL string `gcfg:"ipam-webhook-url" json:"ipam-webhook-url"`
+
+	// ValidateConfig, when true, resolves everything the config names in
+	// the vCenter inventory at startup and refuses to start on the first
+	// problem, listing all of them, instead of failing mid-scale-up.
+	ValidateConfig bool `gcfg:"validate-config" json:"validate-config"`
+
+	// VerifyTemplate, when true, refuses scale-ups once a template's
+	// identity or configuration has changed since the autoscaler first used
+	// it. See the option of the same name in the nodegroup sections.
+	VerifyTemplate bool `gcfg:"verify-template" json:"verify-template"`
+
+	// VerifyIdentity, when true, makes scale-down verify the stamped VM
+	// identity before destroying. See the option of the same name in the
+	// nodegroup sections.
+	VerifyIdentity bool `gcfg:"verify-identity" json:"verify-identity"`
+
+	// DetachDataDisks, when true, makes scale-down detach leftover
+	// first-class disks before destroying a VM. See the option of the same
+	// name in the nodegroup sections.
+	DetachDataDisks bool `gcfg:"detach-data-disks" json:"detach-data-disks"`
+
+	// ProtectGPUWorkloads, when true, refuses to scale down nodes whose
+	// pods still request GPUs. See the option of the same name in the
+	// nodegroup sections.
+	ProtectGPUWorkloads bool `gcfg:"protect-gpu-workloads" json:"protect-gpu-workloads"`
+
+	// PrewarmTemplates, when true, keeps template replicas on the target
+	// datastores and clones from them. See the option of the same name in
+	// the nodegroup sections.
+	PrewarmTemplates bool `gcfg:"prewarm-templates" json:"prewarm-templates"`
+
+	// BootstrapTags, when true, makes the manager create the tag category
+	// and the cluster, node group and warm pool tags at startup if they do
+	// not exist yet, so day-0 setup needs no manual tag creation.
+	BootstrapTags bool `gcfg:"bootstrap-tags" json:"bootstrap-tags"`
+	// TagCategory is the category the autoscaler's tags are created in
+	// when bootstrap-tags is enabled. Defaults to k8s-autoscaler, or to
+	// k8s-autoscaler-<cluster-name> with isolate-tags.
+	TagCategory string `gcfg:"tag-category" json:"tag-category"`
+	// TagPrefix replaces the leading "k8s" of the cluster and node group
+	// tag names, for sites whose tagging conventions reserve that prefix.
+	TagPrefix string `gcfg:"tag-prefix" json:"tag-prefix"`
+	// IsolateTags, when true, namespaces the tag layer by cluster: node
+	// group tags carry the cluster name, the default tag category is
+	// per-cluster, startup refuses un-namespaced leftovers in the category
+	// and scale-down refuses VMs not tagged for this cluster. For vCenters
+	// shared by several Kubernetes clusters.
+	IsolateTags bool `gcfg:"isolate-tags" json:"isolate-tags"`
+
+	// ExcludeTags are additional tag names whose VMs never belong to any
+	// node group, on top of the built-in k8s-role-control-plane tag. One
+	// `exclude-tag` entry per tag.
+	ExcludeTags []string `gcfg:"exclude-tag" json:"exclude-tags"`
+
+	// AuditLog, when set, is the path of an append-only JSON-lines file
+	// recording every mutating vCenter operation (clone, power, tag,
+	// destroy) with timestamp, reason, task ID and outcome.
+	AuditLog string `gcfg:"audit-log" json:"audit-log"`
+
+	// TraceEndpoint, when set, is an OTLP/HTTP endpoint (e.g.
+	// http://otel-collector:4318) scale operations and vCenter calls are
+	// exported to as trace spans, with vCenter task IDs as attributes.
+	TraceEndpoint string `gcfg:"trace-endpoint" json:"trace-endpoint"`
+
+	// DebugEndpoint, when set, is the HTTP path (e.g. /debug/vsphere) on
+	// the autoscaler's metrics listener serving a JSON view of provider
+	// state: node groups, cached inventory, pending tasks, session status
+	// and recent errors.
+	DebugEndpoint string `gcfg:"debug-endpoint" json:"debug-endpoint"`
+
+	// ProviderIDFormat is how the cluster's cloud controller manager
+	// writes .spec.providerID, as a template over {{uuid}} (BIOS UUID)
+	// and {{moref}} (managed object reference). Defaults to
+	// vsphere://{{uuid}}; CCMs using the legacy UUID-only or MoRef
+	// formats need this set for node-to-VM matching to work.
+	ProviderIDFormat string `gcfg:"provider-id-format" json:"provider-id-format"`
+
+	// MachineTypeFolder, when set, is the inventory folder whose templates
+	// are exposed as the available machine types, named after the template
+	// and shaped by its hardware. The folder is rescanned daily.
+	MachineTypeFolder string `gcfg:"machine-type-folder" json:"machine-type-folder"`
+
+	// CloneSpecBuilder names a registered clone spec builder that decorates
+	// the config spec of every clone. The option of the same name in the
+	// nodegroup sections overrides it per group.
+	CloneSpecBuilder string `gcfg:"clone-spec-builder" json:"clone-spec-builder"`
+
+	// DNSMode selects how node DNS records are maintained in environments
+	// without cloud DNS integration: "" (none), "rfc2136" for dynamic
+	// updates against a DNS server, or "webhook" for an external service.
+	// Records are created after a node's IP is allocated and removed on
+	// delete; both modes require ipam-mode to be set.
+	DNSMode string `gcfg:"dns-mode" json:"dns-mode"`
+	// DNSServer is the host:port of the server accepting RFC2136 updates.
+	DNSServer string `gcfg:"dns-server" json:"dns-server"`
+	// DNSZone is the zone node records are created in, e.g. k8s.example.com.
+	DNSZone string `gcfg:"dns-zone" json:"dns-zone"`
+	// DNSTTL is the TTL of created records in seconds. Defaults to 300.
+	DNSTTL int `gcfg:"dns-ttl" json:"dns-ttl"`
+	// DNSTsigKeyName and DNSTsigSecret authenticate RFC2136 updates with
+	// TSIG (hmac-sha256); updates are unsigned when left empty.
+	DNSTsigKeyName string `gcfg:"dns-tsig-key-name" json:"dns-tsig-key-name"`
+	DNSTsigSecret  string `gcfg:"dns-tsig-secret" json:"dns-tsig-secret"`
+	// DNSWebhookURL is the endpoint of the external DNS service.
+	DNSWebhookURL string `gcfg:"dns-webhook-url" json:"dns-webhook-url"`
+
+	// OwnershipLock, when true, makes the manager take a lease recorded in
+	// a custom attribute on the cluster's VM folder before any mutating
+	// operation, so two accidentally running autoscaler replicas do not
+	// both clone VMs.
+	OwnershipLock bool `gcfg:"ownership-lock" json:"ownership-lock"`
+	// OwnershipLockLease is how long an acquired lease stays valid, as a
+	// Go duration string. Defaults to 3m.
+	OwnershipLockLease configDuration `gcfg:"ownership-lock-lease" json:"ownership-lock-lease"`
+
+	// NodeGroupCRD, when true, makes the provider read its node group
+	// definitions from VsphereNodeGroup custom resources instead of the
+	// --nodes flags, syncing them on every Refresh. The CRD is then the
+	// sole source of node groups.
+	NodeGroupCRD bool `gcfg:"node-group-crd" json:"node-group-crd"`
+	// NodeGroupCRDNamespace is the namespace holding the VsphereNodeGroup
+	// resources. Defaults to kube-system.
+	NodeGroupCRDNamespace string `gcfg:"node-group-crd-namespace" json:"node-group-crd-namespace"`
+
+	// PersistState, when true, makes the manager persist per node group
+	// target sizes and in-flight clones into a ConfigMap, so a restarted
+	// autoscaler does not repeat scale-ups that were already under way.
+	PersistState bool `gcfg:"persist-state" json:"persist-state"`
+
+	// VMNamespace is the Supervisor cluster namespace the vmservice manager
+	// creates VirtualMachine resources in. Required for that manager.
+	VMNamespace string `gcfg:"vm-namespace" json:"vm-namespace"`
+	// VMClass, VMImage, StorageClass and VMMetadataConfigMap are the global
+	// defaults for the options of the same name in the nodegroup sections.
+	VMClass             string `gcfg:"vm-class" json:"vm-class"`
+	VMImage             string `gcfg:"vm-image" json:"vm-image"`
+	StorageClass        string `gcfg:"storage-class" json:"storage-class"`
+	VMMetadataConfigMap string `gcfg:"vm-metadata-configmap" json:"vm-metadata-configmap"`
+
+	// MachineNamespace is the namespace holding the CAPV MachineDeployments
+	// when the capv manager is used. Defaults to "default".
+	MachineNamespace string `gcfg:"machine-namespace" json:"machine-namespace"`
+	// MachineAPIVersion is the Cluster API version used by the capv
+	// manager. Defaults to v1alpha3.
+	MachineAPIVersion string `gcfg:"machine-api-version" json:"machine-api-version"`
+
+	// GrpcEndpoint is the address of a standalone vsphere manager process
+	// serving this package's gRPC interface (see ServeGrpc). Required by
+	// the grpc manager, unused by the others.
+	GrpcEndpoint string `gcfg:"grpc-endpoint" json:"grpc-endpoint"`
+}
+
+// ConfigVsphere is used to read and store information from the cloud config file.
+type ConfigVsphere struct {
+	Global    ConfigGlobal                `json:"global"`
+	NodeGroup map[string]*ConfigNodeGroup `gcfg:"nodegroup" json:"nodegroups"`
+}
+
+// templateFor returns the template for a node group, falling back to the global one.
+func (cfg *ConfigVsphere) templateFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.Template != "" {
+		return ng.Template
+	}
+	return cfg.Global.Template
+}
+
+// resourcePoolFor returns the resource pool for a node group, falling back to the global one.
+func (cfg *ConfigVsphere) resourcePoolFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.ResourcePool != "" {
+		return ng.ResourcePool
+	}
+	return cfg.Global.ResourcePool
+}
+
+// datastoreFor returns the datastore for a node group, falling back to the global one.
+func (cfg *ConfigVsphere) datastoreFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.Datastore != "" {
+		return ng.Datastore
+	}
+	return cfg.Global.Datastore
+}
+
+// folderFor returns the VM folder for a node group, falling back to the global one.
+func (cfg *ConfigVsphere) folderFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.Folder != "" {
+		return ng.Folder
+	}
+	return cfg.Global.Folder
+}
+
+// userDataFor returns the user-data file for a node group, falling back to the global one.
+func (cfg *ConfigVsphere) userDataFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.UserData != "" {
+		return ng.UserData
+	}
+	return cfg.Global.UserData
+}
+
+// userDataVarsFor returns the custom user-data substitutions of a node
+// group: the global list with the group's own entries merged over it.
+func (cfg *ConfigVsphere) userDataVarsFor(nodegroup string) (map[string]string, error) {
+	vars, err := parseLabels(cfg.Global.UserDataVars)
+	if err != nil {
+		return nil, fmt.Errorf("invalid global user-data-vars: %v", err)
+	}
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.UserDataVars != "" {
+		ngVars, err := parseLabels(ng.UserDataVars)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user-data-vars for node group %s: %v", nodegroup, err)
+		}
+		for key, value := range ngVars {
+			vars[key] = value
+		}
+	}
+	return vars, nil
+}
+
+// hardwareVersionFor returns the clone hardware version for a node group,
+// falling back to the global one. Empty means keep the template's version.
+func (cfg *ConfigVsphere) hardwareVersionFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.HardwareVersion != "" {
+		return ng.HardwareVersion
+	}
+	return cfg.Global.HardwareVersion
+}
+
+// firmwareFor returns the clone firmware for a node group, falling back to
+// the global one. Empty means keep the template's firmware.
+func (cfg *ConfigVsphere) firmwareFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.Firmware != "" {
+		return ng.Firmware
+	}
+	return cfg.Global.Firmware
+}
+
+// Scale-down modes: destroy the VM, or return it to the warm pool powered
+// off or suspended.
+const (
+	scaleDownModeDestroy  = "destroy"
+	scaleDownModePowerOff = "power-off"
+	scaleDownModeSuspend  = "suspend"
+)
+
+const (
+	decreasePolicyNewestFirst = "newest-first"
+	decreasePolicyOldestFirst = "oldest-first"
+)
+
+// Membership modes: group membership recorded with tags, or implied by the
+// group's VM folder or resource pool.
+const (
+	membershipTags         = "tags"
+	membershipFolder       = "folder"
+	membershipResourcePool = "resource-pool"
+)
+
+// Guest operating systems a node group's template can run.
+const (
+	guestOSLinux   = "linux"
+	guestOSWindows = "windows"
+)
+
+// guestOSFor returns the guest operating system of a node group's template.
+func (cfg *ConfigVsphere) guestOSFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.GuestOS != "" {
+		return ng.GuestOS
+	}
+	return guestOSLinux
+}
+
+// membershipFor returns how the membership of a node group is tracked.
+func (cfg *ConfigVsphere) membershipFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.Membership != "" {
+		return ng.Membership
+	}
+	return membershipTags
+}
+
+// decreasePolicyFor returns the decrease policy of a node group.
+func (cfg *ConfigVsphere) decreasePolicyFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.DecreasePolicy != "" {
+		return ng.DecreasePolicy
+	}
+	return decreasePolicyNewestFirst
+}
+
+// scaleDownModeFor returns the scale-down mode of a node group.
+func (cfg *ConfigVsphere) scaleDownModeFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.ScaleDownMode != "" {
+		return ng.ScaleDownMode
+	}
+	return scaleDownModeDestroy
+}
+
+// nicsFor returns the extra NIC entries configured for a node group.
+func (cfg *ConfigVsphere) nicsFor(nodegroup string) []string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.NICs
+	}
+	return nil
+}
+
+// maxNodeAgeFor returns the age beyond which a node group's nodes become
+// preferred deletion candidates; zero disables age-based recycling.
+func (cfg *ConfigVsphere) maxNodeAgeFor(nodegroup string) time.Duration {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.MaxNodeAge.isSet {
+		return ng.MaxNodeAge.value
+	}
+	return 0
+}
+
+// dataDisksFor returns the extra disk entries configured for a node group.
+func (cfg *ConfigVsphere) dataDisksFor(nodegroup string) []string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.DataDisks
+	}
+	return nil
+}
+
+// adoptPatternFor returns the VM name pattern a node group adopts; empty
+// disables pattern adoption.
+func (cfg *ConfigVsphere) adoptPatternFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.AdoptPattern
+	}
+	return ""
+}
+
+// avoidVMsTaggedFor returns the tag whose VMs' hosts a node group avoids,
+// falling back to the global one. Empty disables host anti-affinity.
+func (cfg *ConfigVsphere) avoidVMsTaggedFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.AvoidVMsTagged != "" {
+		return ng.AvoidVMsTagged
+	}
+	return cfg.Global.AvoidVMsTagged
+}
+
+// fallbackResourcePoolsFor returns the fallback resource pools of a node
+// group, in the order they are tried.
+func (cfg *ConfigVsphere) fallbackResourcePoolsFor(nodegroup string) []string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.FallbackResourcePools
+	}
+	return nil
+}
+
+// ovfPropertiesFor returns the OVF property entries of a node group.
+func (cfg *ConfigVsphere) ovfPropertiesFor(nodegroup string) []string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.OVFProperties
+	}
+	return nil
+}
+
+// priorityFor returns the configured priority of a node group; zero when unset.
+func (cfg *ConfigVsphere) priorityFor(nodegroup string) int {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.Priority
+	}
+	return 0
+}
+
+// defaultMaxPods matches the kubelet's default pod capacity.
+const defaultMaxPods = 110
+
+// maxPodsFor returns the pod capacity advertised for template nodes of a
+// node group.
+func (cfg *ConfigVsphere) maxPodsFor(nodegroup string) int64 {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.MaxPods > 0 {
+		return int64(ng.MaxPods)
+	}
+	return defaultMaxPods
+}
+
+// ephemeralStorageReserveFor returns the storage reserve of a node group,
+// falling back to the global one. Empty means no reserve.
+func (cfg *ConfigVsphere) ephemeralStorageReserveFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.EphemeralStorageReserve != "" {
+		return ng.EphemeralStorageReserve
+	}
+	return cfg.Global.EphemeralStorageReserve
+}
+
+// kubeReservedFor returns the kubelet's reserve for Kubernetes system
+// daemons of a node group, falling back to the global one. Empty means no
+// reserve.
+func (cfg *ConfigVsphere) kubeReservedFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.KubeReserved != "" {
+		return ng.KubeReserved
+	}
+	return cfg.Global.KubeReserved
+}
+
+// systemReservedFor returns the kubelet's reserve for OS system daemons of
+// a node group, falling back to the global one. Empty means no reserve.
+func (cfg *ConfigVsphere) systemReservedFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.SystemReserved != "" {
+		return ng.SystemReserved
+	}
+	return cfg.Global.SystemReserved
+}
+
+// childResourcePoolFor reports whether a node group clones its nodes into
+// a dedicated child resource pool named after the group.
+func (cfg *ConfigVsphere) childResourcePoolFor(nodegroup string) bool {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.ChildResourcePool
+	}
+	return false
+}
+
+// relocateOnChangeFor reports whether a node group's existing VMs follow
+// the configured resource pool and datastore when the config changes.
+func (cfg *ConfigVsphere) relocateOnChangeFor(nodegroup string) bool {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.RelocateOnChange
+	}
+	return false
+}
+
+// siteHostGroupsFor returns the host group names of the preferred and
+// secondary site of a node group on a vSAN stretched cluster, or two
+// empty strings when the group is not site-aware.
+func (cfg *ConfigVsphere) siteHostGroupsFor(nodegroup string) (string, string) {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.PreferredSiteHostGroup, ng.SecondarySiteHostGroup
+	}
+	return "", ""
+}
+
+// pausedFor returns which scaling directions of a node group are frozen:
+// "up", "down", "all" or empty for none.
+func (cfg *ConfigVsphere) pausedFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.Paused
+	}
+	return ""
+}
+
+// evictionHardFor returns the kubelet's hard eviction thresholds of a node
+// group, falling back to the global ones. Empty means the kubelet
+// defaults.
+func (cfg *ConfigVsphere) evictionHardFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.EvictionHard != "" {
+		return ng.EvictionHard
+	}
+	return cfg.Global.EvictionHard
+}
+
+// maxConcurrentClonesFor returns how many clone tasks of a node group may
+// run at once.
+func (cfg *ConfigVsphere) maxConcurrentClonesFor(nodegroup string) int {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.MaxConcurrentClones > 0 {
+		return ng.MaxConcurrentClones
+	}
+	return defaultGroupCloneConcurrency
+}
+
+// warmPoolSizeFor returns the warm pool size of a node group.
+func (cfg *ConfigVsphere) warmPoolSizeFor(nodegroup string) int {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.WarmPoolSize
+	}
+	return 0
+}
+
+// Disk provisioning formats for clone disks.
+const (
+	diskProvisioningThin             = "thin"
+	diskProvisioningThick            = "thick"
+	diskProvisioningEagerZeroedThick = "eagerZeroedThick"
+)
+
+// diskProvisioningFor returns the clone disk format for a node group,
+// falling back to the global one. Empty keeps the template's format.
+func (cfg *ConfigVsphere) diskProvisioningFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.DiskProvisioning != "" {
+		return ng.DiskProvisioning
+	}
+	return cfg.Global.DiskProvisioning
+}
+
+// secureBootFor returns whether clones of a node group get EFI secure boot.
+func (cfg *ConfigVsphere) secureBootFor(nodegroup string) bool {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.SecureBoot
+	}
+	return false
+}
+
+// vtpmFor returns whether clones of a node group get a virtual TPM device.
+func (cfg *ConfigVsphere) vtpmFor(nodegroup string) bool {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.VTPM
+	}
+	return false
+}
+
+// templateSnapshotFor returns the template snapshot clones of a node group
+// are pinned to; empty means clone from the template's current state.
+func (cfg *ConfigVsphere) templateSnapshotFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return ng.TemplateSnapshot
+	}
+	return ""
+}
+
+// verifyTemplateFor returns whether scale-ups of a node group must fail
+// once its template has changed underneath the autoscaler.
+func (cfg *ConfigVsphere) verifyTemplateFor(nodegroup string) bool {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.VerifyTemplate {
+		return true
+	}
+	return cfg.Global.VerifyTemplate
+}
+
+// verifyIdentityFor returns whether scale-down of a node group must verify
+// the stamped VM identity before destroying.
+func (cfg *ConfigVsphere) verifyIdentityFor(nodegroup string) bool {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.VerifyIdentity {
+		return true
+	}
+	return cfg.Global.VerifyIdentity
+}
+
+// detachDataDisksFor returns whether scale-down of a node group detaches
+// leftover first-class disks before destroying a VM.
+func (cfg *ConfigVsphere) detachDataDisksFor(nodegroup string) bool {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.DetachDataDisks {
+		return true
+	}
+	return cfg.Global.DetachDataDisks
+}
+
+// protectGPUWorkloadsFor returns whether scale-down of a node group checks
+// for pods still requesting GPUs, falling back to the global option.
+func (cfg *ConfigVsphere) protectGPUWorkloadsFor(nodegroup string) bool {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.ProtectGPUWorkloads {
+		return true
+	}
+	return cfg.Global.ProtectGPUWorkloads
+}
+
+// prewarmTemplatesFor returns whether a node group clones from a template
+// replica kept on its datastore.
+func (cfg *ConfigVsphere) prewarmTemplatesFor(nodegroup string) bool {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.PrewarmTemplates {
+		return true
+	}
+	return cfg.Global.PrewarmTemplates
+}
+
+// vmClassFor returns the VirtualMachineClass of a node group for the
+// vmservice manager, falling back to the global one.
+func (cfg *ConfigVsphere) vmClassFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.VMClass != "" {
+		return ng.VMClass
+	}
+	return cfg.Global.VMClass
+}
+
+// vmImageFor returns the VirtualMachineImage of a node group for the
+// vmservice manager, falling back to the global one.
+func (cfg *ConfigVsphere) vmImageFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.VMImage != "" {
+		return ng.VMImage
+	}
+	return cfg.Global.VMImage
+}
+
+// storageClassFor returns the storage class of a node group for the
+// vmservice manager, falling back to the global one.
+func (cfg *ConfigVsphere) storageClassFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.StorageClass != "" {
+		return ng.StorageClass
+	}
+	return cfg.Global.StorageClass
+}
+
+// vmMetadataConfigMapFor returns the metadata ConfigMap of a node group for
+// the vmservice manager, falling back to the global one.
+func (cfg *ConfigVsphere) vmMetadataConfigMapFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.VMMetadataConfigMap != "" {
+		return ng.VMMetadataConfigMap
+	}
+	return cfg.Global.VMMetadataConfigMap
+}
+
+// zoneFor returns the failure domain of a node group, falling back to the global one.
+func (cfg *ConfigVsphere) zoneFor(nodegroup string) string {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok && ng.Zone != "" {
+		return ng.Zone
+	}
+	return cfg.Global.Zone
+}
+
+// labelsFor returns the parsed labels configured for a node group.
+func (cfg *ConfigVsphere) labelsFor(nodegroup string) (map[string]string, error) {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return parseLabels(ng.Labels)
+	}
+	return nil, nil
+}
+
+// taintsFor returns the parsed taints configured for a node group.
+func (cfg *ConfigVsphere) taintsFor(nodegroup string) ([]apiv1.Taint, error) {
+	if ng, ok := cfg.NodeGroup[nodegroup]; ok {
+		return parseTaints(ng.Taints)
+	}
+	return nil, nil
+}
+
+// autoscalingOptionsFor returns the autoscaling option overrides of a node
+// group. Invalid thresholds are ignored here; the govmomi manager rejects
+// them at startup.
+func (cfg *ConfigVsphere) autoscalingOptionsFor(nodegroup string) nodeGroupAutoscalingOptions {
+	var opts nodeGroupAutoscalingOptions
+	ng, ok := cfg.NodeGroup[nodegroup]
+	if !ok {
+		return opts
+	}
+	if ng.MaxNodeProvisionTime.isSet {
+		opts.MaxNodeProvisionTime = ng.MaxNodeProvisionTime.value
+	}
+	if ng.ScaleDownUtilizationThreshold != "" {
+		if threshold, err := strconv.ParseFloat(ng.ScaleDownUtilizationThreshold, 64); err == nil {
+			opts.ScaleDownUtilizationThreshold = threshold
+		}
+	}
+	if ng.ScaleDownUnneededTime.isSet {
+		opts.ScaleDownUnneededTime = ng.ScaleDownUnneededTime.value
+	}
+	return opts
+}
+
+// parseCloudConfig parses the cloud config in either the classic gcfg (INI)
+// format or as YAML/JSON. In the structured form the [global] section becomes
+// a "global" mapping and the [nodegroup "<name>"] sections become entries of
+// a "nodegroups" mapping, with the same option names as the INI format.
+func parseCloudConfig(content []byte) (ConfigVsphere, error) {
+	var cfg ConfigVsphere
+	if looksLikeGcfg(content) {
+		if err := gcfg.ReadStringInto(&cfg, string(content)); err != nil {
+			return cfg, fmt.Errorf("could not parse gcfg cloud config: %v", err)
+		}
+		applyConfigDefaults(&cfg)
+		return cfg, nil
+	}
+	// yaml.Unmarshal accepts JSON as well, as YAML is a superset of it.
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return cfg, fmt.Errorf("could not parse YAML cloud config: %v", err)
+	}
+	// Unlike gcfg, yaml.Unmarshal drops options no field matches, which
+	// hides typos; warn about them instead.
+	for _, key := range unknownConfigKeys(content) {
+		klog.Warningf("Unknown cloud config option %s, ignoring it", key)
+	}
+	applyConfigDefaults(&cfg)
+	return cfg, nil
+}
+
+// looksLikeGcfg reports whether the cloud config is in the gcfg INI format,
+// recognized by its first non-blank, non-comment line being a [section]
+// header. Top-level YAML and JSON documents never start with one.
+func looksLikeGcfg(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		return strings.HasPrefix(line, "[")
+	}
+	return false
+}
+
+// resolveClusterName picks the cluster name a manager runs against.
+// Precedence is the --cluster-name flag, then the VSPHERE_CLUSTER_NAME
+// environment variable, then the cluster-name option in the cloud config.
+func resolveClusterName(cfg ConfigVsphere, opts config.AutoscalingOptions) (string, error) {
+	if opts.ClusterName != "" {
+		return opts.ClusterName, nil
+	}
+	if name := os.Getenv("VSPHERE_CLUSTER_NAME"); name != "" {
+		return name, nil
+	}
+	if cfg.Global.ClusterName != "" {
+		return cfg.Global.ClusterName, nil
+	}
+	return "", fmt.Errorf("the cluster-name parameter must be set")
+}
+
+// createVsphereManager creates the desired implementation of vsphereManager.
+// Currently reads the environment variable VSPHERE_MANAGER to find which to create,
+// and falls back to a default if the variable is not found.
+func createVsphereManager(configReader io.Reader, discoverOpts cloudprovider.NodeGroupDiscoveryOptions, opts config.AutoscalingOptions) (vsphereManager, error) {
+	var cfg ConfigVsphere
+	if configReader != nil {
+		content, err := ioutil.ReadAll(configReader)
+		if err != nil {
+			klog.Errorf("Couldn't read cloud config: %v", err)
+			return nil, err
+		}
+		cfg, err = parseCloudConfig(content)
+		if err != nil {
+			klog.Errorf("Couldn't parse cloud config: %v", err)
+			return nil, err
+		}
+	}
+
+	klog.Infof("Starting vsphere manager: %s", cfg.summary())
+	klog.V(1).Infof("Starting vsphere manager with config: %v", cfg)
+
+	// For now get manager from env var, can consider adding a flag later
+	manager, ok := os.LookupEnv("VSPHERE_MANAGER")
+	if !ok {
+		manager = defaultManager
+	}
+
+	switch manager {
+	case "govmomi":
+		return createVsphereManagerGovmomi(cfg, discoverOpts, opts)
+	case "capv":
+		return createVsphereManagerCapv(cfg, discoverOpts, opts)
+	case "vmservice":
+		return createVsphereManagerVMService(cfg, discoverOpts, opts)
+	case "grpc":
+		return createVsphereManagerGrpc(cfg, discoverOpts, opts)
+	}
+
+	return nil, fmt.Errorf("vsphere manager does not exist: %s", manager)
+}
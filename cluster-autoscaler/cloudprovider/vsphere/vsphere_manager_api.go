@@ -0,0 +1,68 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"io"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider/vsphere/vsphereapi"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+)
+
+// NewManagerAPI builds a vsphereapi.Manager from a cloud config, for
+// tools that embed the vSphere node lifecycle logic without the
+// cloudprovider glue. The reader carries the same INI cloud config the
+// autoscaler runs with; clusterName overrides the cluster-name option
+// when not empty.
+func NewManagerAPI(configReader io.Reader, clusterName string) (vsphereapi.Manager, error) {
+	mgr, err := createVsphereManager(configReader, cloudprovider.NodeGroupDiscoveryOptions{}, config.AutoscalingOptions{ClusterName: clusterName})
+	if err != nil {
+		return nil, err
+	}
+	return &managerAPI{mgr: mgr}, nil
+}
+
+// managerAPI adapts the unexported vsphereManager interface to the
+// exported vsphereapi.Manager one.
+type managerAPI struct {
+	mgr vsphereManager
+}
+
+// NodeGroupSize returns the current number of VMs in a node group.
+func (a *managerAPI) NodeGroupSize(nodegroup string) (int, error) {
+	return a.mgr.nodeGroupSize(nodegroup)
+}
+
+// CreateNodes adds the given number of nodes to a node group.
+func (a *managerAPI) CreateNodes(nodegroup string, count int) error {
+	return a.mgr.createNodes(nodegroup, count)
+}
+
+// DeleteNodes deletes the given nodes from a node group.
+func (a *managerAPI) DeleteNodes(nodegroup string, nodes []vsphereapi.NodeRef) error {
+	size, err := a.mgr.nodeGroupSize(nodegroup)
+	if err != nil {
+		return err
+	}
+	return a.mgr.deleteNodes(nodegroup, nodes, size-len(nodes))
+}
+
+// NodeNames returns the names of a node group's current nodes.
+func (a *managerAPI) NodeNames(nodegroup string) ([]string, error) {
+	return a.mgr.getNodeNames(nodegroup)
+}
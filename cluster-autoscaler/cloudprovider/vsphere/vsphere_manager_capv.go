@@ -0,0 +1,358 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+const (
+	// capvAPIGroup is the API group of Cluster API resources.
+	capvAPIGroup = "cluster.x-k8s.io"
+	// defaultCapvAPIVersion is used unless machine-api-version is set in the config.
+	defaultCapvAPIVersion = "v1alpha3"
+	// capvMachineDeleteAnnotation marks a specific machine for deletion when
+	// a MachineDeployment is scaled down.
+	capvMachineDeleteAnnotation = "cluster.x-k8s.io/delete-machine"
+)
+
+// vsphereManagerCapv implements the vsphereManager interface on top of
+// Cluster API vSphere (CAPV) MachineDeployments: scaling adjusts replicas
+// and node deletion uses the machine delete annotation, leaving all VM
+// lifecycle to the Cluster API controllers. This avoids double ownership
+// of VMs in clusters already managed by Cluster API.
+type vsphereManagerCapv struct {
+	client      dynamic.Interface
+	config      ConfigVsphere
+	clusterName string
+	namespace   string
+
+	machineDeploymentResource schema.GroupVersionResource
+	machineResource           schema.GroupVersionResource
+
+	trackersMutex sync.Mutex
+	trackers      map[string]*groupTracker
+}
+
+// createVsphereManagerCapv builds a manager driving CAPV MachineDeployments
+// through the dynamic Kubernetes client.
+func createVsphereManagerCapv(cfg ConfigVsphere, discoverOpts cloudprovider.NodeGroupDiscoveryOptions, opts config.AutoscalingOptions) (*vsphereManagerCapv, error) {
+	clusterName, err := resolveClusterName(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	restConfig, err := clientcmd.BuildConfigFromFlags("", opts.KubeConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not build kubernetes client config: %v", err)
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not create dynamic client: %v", err)
+	}
+
+	version := cfg.Global.MachineAPIVersion
+	if version == "" {
+		version = defaultCapvAPIVersion
+	}
+	namespace := cfg.Global.MachineNamespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	return &vsphereManagerCapv{
+		client:      client,
+		config:      cfg,
+		clusterName: clusterName,
+		namespace:   namespace,
+		machineDeploymentResource: schema.GroupVersionResource{
+			Group:    capvAPIGroup,
+			Version:  version,
+			Resource: "machinedeployments",
+		},
+		machineResource: schema.GroupVersionResource{
+			Group:    capvAPIGroup,
+			Version:  version,
+			Resource: "machines",
+		},
+		trackers: make(map[string]*groupTracker),
+	}, nil
+}
+
+// tracker returns the operation tracker for a node group, creating it if needed.
+func (mgr *vsphereManagerCapv) tracker(nodegroup string) *groupTracker {
+	mgr.trackersMutex.Lock()
+	defer mgr.trackersMutex.Unlock()
+	t, ok := mgr.trackers[nodegroup]
+	if !ok {
+		t = &groupTracker{}
+		mgr.trackers[nodegroup] = t
+	}
+	return t
+}
+
+// machineDeploymentFor returns the MachineDeployment name backing a node
+// group, which defaults to the node group name.
+func (mgr *vsphereManagerCapv) machineDeploymentFor(nodegroup string) string {
+	if ng, ok := mgr.config.NodeGroup[nodegroup]; ok && ng.MachineDeployment != "" {
+		return ng.MachineDeployment
+	}
+	return nodegroup
+}
+
+// getMachineDeployment fetches the MachineDeployment backing a node group.
+func (mgr *vsphereManagerCapv) getMachineDeployment(ctx context.Context, nodegroup string) (*unstructured.Unstructured, error) {
+	name := mgr.machineDeploymentFor(nodegroup)
+	u, err := mgr.client.Resource(mgr.machineDeploymentResource).Namespace(mgr.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get MachineDeployment %s/%s: %v", mgr.namespace, name, err)
+	}
+	return u, nil
+}
+
+// setReplicas updates the replica count of a MachineDeployment.
+func (mgr *vsphereManagerCapv) setReplicas(ctx context.Context, u *unstructured.Unstructured, replicas int64) error {
+	if err := unstructured.SetNestedField(u.Object, replicas, "spec", "replicas"); err != nil {
+		return err
+	}
+	_, err := mgr.client.Resource(mgr.machineDeploymentResource).Namespace(u.GetNamespace()).Update(ctx, u, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("could not update MachineDeployment %s/%s: %v", u.GetNamespace(), u.GetName(), err)
+	}
+	return nil
+}
+
+// nodeGroupSize returns the replica count of the backing MachineDeployment.
+func (mgr *vsphereManagerCapv) nodeGroupSize(nodegroup string) (int, error) {
+	u, err := mgr.getMachineDeployment(context.TODO(), nodegroup)
+	if err != nil {
+		return 0, err
+	}
+	replicas, found, err := unstructured.NestedInt64(u.Object, "spec", "replicas")
+	if err != nil || !found {
+		return 0, fmt.Errorf("could not read replicas of MachineDeployment %s: %v", u.GetName(), err)
+	}
+	return int(replicas), nil
+}
+
+// createNodes increases the replica count of the backing MachineDeployment.
+func (mgr *vsphereManagerCapv) createNodes(nodegroup string, nodes int) error {
+	ctx := context.TODO()
+	tracker := mgr.tracker(nodegroup)
+	tracker.cloneStarted()
+
+	err := func() error {
+		u, err := mgr.getMachineDeployment(ctx, nodegroup)
+		if err != nil {
+			return err
+		}
+		replicas, _, err := unstructured.NestedInt64(u.Object, "spec", "replicas")
+		if err != nil {
+			return err
+		}
+		klog.V(2).Infof("Scaling MachineDeployment %s from %d to %d replicas", u.GetName(), replicas, replicas+int64(nodes))
+		return mgr.setReplicas(ctx, u, replicas+int64(nodes))
+	}()
+
+	tracker.cloneFinished(err)
+	return err
+}
+
+// listMachines returns the Machines belonging to the node group's
+// MachineDeployment, matched via its selector.
+func (mgr *vsphereManagerCapv) listMachines(ctx context.Context, nodegroup string) ([]unstructured.Unstructured, error) {
+	u, err := mgr.getMachineDeployment(ctx, nodegroup)
+	if err != nil {
+		return nil, err
+	}
+	matchLabels, _, err := unstructured.NestedStringMap(u.Object, "spec", "selector", "matchLabels")
+	if err != nil {
+		return nil, fmt.Errorf("could not read selector of MachineDeployment %s: %v", u.GetName(), err)
+	}
+	selector := labels.SelectorFromSet(labels.Set(matchLabels))
+	list, err := mgr.client.Resource(mgr.machineResource).Namespace(mgr.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("could not list Machines for MachineDeployment %s: %v", u.GetName(), err)
+	}
+	return list.Items, nil
+}
+
+// deleteNodes annotates the Machines backing the given nodes for deletion
+// and decreases the MachineDeployment replica count accordingly.
+func (mgr *vsphereManagerCapv) deleteNodes(nodegroup string, nodes []NodeRef, updatedNodeCount int) error {
+	ctx := context.TODO()
+	tracker := mgr.tracker(nodegroup)
+
+	machines, err := mgr.listMachines(ctx, nodegroup)
+	if err != nil {
+		return err
+	}
+
+	machineByNode := make(map[string]*unstructured.Unstructured)
+	for i := range machines {
+		machine := &machines[i]
+		nodeName, found, err := unstructured.NestedString(machine.Object, "status", "nodeRef", "name")
+		if err != nil || !found {
+			continue
+		}
+		machineByNode[nodeName] = machine
+	}
+
+	for _, node := range nodes {
+		machine, ok := machineByNode[node.Name]
+		if !ok {
+			return fmt.Errorf("could not find Machine for node %s", node.Name)
+		}
+
+		tracker.deleteStarted()
+		err := mgr.annotateMachineForDeletion(ctx, machine)
+		tracker.deleteFinished(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	u, err := mgr.getMachineDeployment(ctx, nodegroup)
+	if err != nil {
+		return err
+	}
+	return mgr.setReplicas(ctx, u, int64(updatedNodeCount))
+}
+
+// annotateMachineForDeletion marks a Machine so the MachineDeployment
+// controller removes it first on scale down.
+func (mgr *vsphereManagerCapv) annotateMachineForDeletion(ctx context.Context, machine *unstructured.Unstructured) error {
+	annotations := machine.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[capvMachineDeleteAnnotation] = time.Now().String()
+	machine.SetAnnotations(annotations)
+
+	_, err := mgr.client.Resource(mgr.machineResource).Namespace(machine.GetNamespace()).Update(ctx, machine, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("could not annotate Machine %s for deletion: %v", machine.GetName(), err)
+	}
+	return nil
+}
+
+// rankDeletionCandidates returns the candidates unchanged; host placement
+// is owned by the Cluster API controllers in this mode.
+func (mgr *vsphereManagerCapv) rankDeletionCandidates(nodegroup string, nodes []NodeRef) []NodeRef {
+	return nodes
+}
+
+// idleDeletionCandidates is not implemented; the Cluster API controllers
+// pick the Machines to remove when a MachineDeployment is scaled down.
+func (mgr *vsphereManagerCapv) idleDeletionCandidates(nodegroup string, count int) ([]NodeRef, error) {
+	return nil, cloudprovider.ErrNotImplemented
+}
+
+// getNodes returns the instances of the Machines in a node group, mapping
+// the machine phase to an instance state.
+func (mgr *vsphereManagerCapv) getNodes(nodegroup string) ([]cloudprovider.Instance, error) {
+	machines, err := mgr.listMachines(context.TODO(), nodegroup)
+	if err != nil {
+		return nil, err
+	}
+	var instances []cloudprovider.Instance
+	for _, machine := range machines {
+		providerID, found, err := unstructured.NestedString(machine.Object, "spec", "providerID")
+		if err != nil || !found {
+			continue
+		}
+		instance := cloudprovider.Instance{Id: providerID}
+		if phase, found, err := unstructured.NestedString(machine.Object, "status", "phase"); err == nil && found {
+			switch phase {
+			case "Provisioning", "Pending":
+				instance.Status = &cloudprovider.InstanceStatus{State: cloudprovider.InstanceCreating}
+			case "Deleting":
+				instance.Status = &cloudprovider.InstanceStatus{State: cloudprovider.InstanceDeleting}
+			case "Running":
+				instance.Status = &cloudprovider.InstanceStatus{State: cloudprovider.InstanceRunning}
+			}
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// getNodeNames returns the names of the Machines in a node group.
+func (mgr *vsphereManagerCapv) getNodeNames(nodegroup string) ([]string, error) {
+	machines, err := mgr.listMachines(context.TODO(), nodegroup)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, machine := range machines {
+		names = append(names, machine.GetName())
+	}
+	return names, nil
+}
+
+// nodeGroupStatus returns the in-flight operation counts and last error for a node group.
+func (mgr *vsphereManagerCapv) nodeGroupStatus(nodegroup string) groupStatus {
+	return mgr.tracker(nodegroup).status()
+}
+
+// templateNodeInfo is not implemented for the CAPV manager; the machine
+// template hardware is not exposed on the MachineDeployment.
+func (mgr *vsphereManagerCapv) templateNodeInfo(nodegroup string) (*schedulernodeinfo.NodeInfo, error) {
+	return nil, cloudprovider.ErrNotImplemented
+}
+
+// targetSizeChanged does nothing for the CAPV manager; the intended size
+// already persists as the MachineDeployment replica count.
+func (mgr *vsphereManagerCapv) targetSizeChanged(nodegroup string, size int) {
+}
+
+// restoredTargetSize never returns a size for the CAPV manager; the replica
+// count in the MachineDeployment is authoritative across restarts.
+func (mgr *vsphereManagerCapv) restoredTargetSize(nodegroup string) (int, bool) {
+	return 0, false
+}
+
+// nodeGroupDefs is disabled for the CAPV manager; node groups map to
+// MachineDeployments configured via the --nodes flags.
+func (mgr *vsphereManagerCapv) nodeGroupDefs() ([]nodeGroupDef, bool, error) {
+	return nil, false, nil
+}
+
+// nodeGroupPriority returns the configured priority of a node group.
+func (mgr *vsphereManagerCapv) nodeGroupPriority(nodegroup string) int {
+	return mgr.config.priorityFor(nodegroup)
+}
+
+// autoscalingOptions returns the per node group autoscaling option
+// overrides from the cloud config.
+func (mgr *vsphereManagerCapv) autoscalingOptions(nodegroup string) nodeGroupAutoscalingOptions {
+	return mgr.config.autoscalingOptionsFor(nodegroup)
+}
@@ -0,0 +1,2143 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/gpu"
+	"k8s.io/autoscaler/cluster-autoscaler/version"
+	"k8s.io/klog"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+// defaultCloneTimeout bounds how long a single clone task may run before
+// it is cancelled, unless clone-timeout is set in the config.
+const defaultCloneTimeout = 30 * time.Minute
+
+// groupTracker records in-flight clone/delete operations and the last
+// error seen for a single node group.
+type groupTracker struct {
+	sync.Mutex
+	pendingClones  int
+	pendingDeletes int
+	lastError      error
+}
+
+func (t *groupTracker) cloneStarted() {
+	t.Lock()
+	defer t.Unlock()
+	t.pendingClones++
+}
+
+func (t *groupTracker) cloneFinished(err error) {
+	t.Lock()
+	defer t.Unlock()
+	t.pendingClones--
+	if err != nil {
+		t.lastError = err
+	}
+}
+
+func (t *groupTracker) deleteStarted() {
+	t.Lock()
+	defer t.Unlock()
+	t.pendingDeletes++
+}
+
+func (t *groupTracker) deleteFinished(err error) {
+	t.Lock()
+	defer t.Unlock()
+	t.pendingDeletes--
+	if err != nil {
+		t.lastError = err
+	}
+}
+
+func (t *groupTracker) status() groupStatus {
+	t.Lock()
+	defer t.Unlock()
+	return groupStatus{
+		pendingClones:  t.pendingClones,
+		pendingDeletes: t.pendingDeletes,
+		lastError:      t.lastError,
+	}
+}
+
+// vsphereManagerGovmomi implements the vsphereManager interface by driving
+// vCenter directly through govmomi: nodes are created by cloning a template
+// VM and group membership is tracked with vSphere tags.
+type vsphereManagerGovmomi struct {
+	client      *VsphereClient
+	config      ConfigVsphere
+	clusterName string
+
+	// tags is the tagging API, normally backed by client; tests substitute
+	// an in-memory fake.
+	tags tagService
+
+	// tokenMinter is nil unless bootstrap-token is enabled in the config.
+	tokenMinter *bootstrapTokenMinter
+
+	// tasks tracks outstanding vCenter tasks per node.
+	tasks *taskManager
+
+	// state is nil unless persist-state is enabled in the config.
+	state *stateStore
+
+	// cloneTimeout bounds individual clone tasks; zero disables the bound.
+	cloneTimeout time.Duration
+
+	// lock is nil unless ownership-lock is enabled in the config.
+	lock *ownershipLock
+
+	// crdSource is nil unless node-group-crd is enabled in the config.
+	crdSource *crdNodeGroupSource
+
+	// templates resolves template glob patterns to the newest match.
+	templates *templateResolver
+
+	// machineTypes is the catalog built from machine-type-folder; empty
+	// until the first scan, refreshed once the catalog outlives its TTL.
+	machineTypesMutex   sync.Mutex
+	machineTypes        []machineType
+	machineTypesScanned time.Time
+
+	// ipam is nil unless ipam-mode is set in the config.
+	ipam ipAllocator
+
+	// dns is nil unless dns-mode is set in the config.
+	dns dnsRegistrar
+
+	// warmPoolMutex serialises warm pool activation and replenishment.
+	warmPoolMutex sync.Mutex
+
+	// prewarmMutex serialises template replica creation and replacement.
+	prewarmMutex sync.Mutex
+
+	// audit is nil unless audit-log is set in the config.
+	audit *auditLog
+
+	// tracer is nil unless trace-endpoint is set in the config.
+	tracer *tracer
+
+	// creations gates clone starts behind the configured concurrency caps
+	// and backs off when vCenter struggles.
+	creations *creationQueue
+
+	// deletions gates VM deletions behind the global and per-host caps.
+	deletions *deletionQueue
+
+	// provisioning defers scale-ups of groups whose clones keep failing,
+	// with an exponentially growing per-group cool-down.
+	provisioning *provisioningBackoff
+
+	// degraded tracks whether vCenter operations are suspended, e.g. after
+	// the service account lost a required permission mid-flight.
+	degraded degradedState
+
+	trackersMutex sync.Mutex
+	trackers      map[string]*groupTracker
+
+	// inventory is the snapshot of the last consolidated tag sweep; nil
+	// until the first Refresh or after an invalidating mutation.
+	inventoryMutex sync.RWMutex
+	inventory      *inventorySnapshot
+
+	// watcher tracks the optional WaitForUpdatesEx session that keeps the
+	// inventory snapshot current between sweeps.
+	watcher inventoryWatcher
+
+	// removals counts VMs deleted outside the autoscaler, reported by the
+	// optional event watcher and drained by the provider each Refresh.
+	removals externalRemovals
+
+	// usage accounts vCenter API calls and cache traffic; the provider
+	// logs and resets it once per loop.
+	usage apiUsage
+
+	// replicaUnreferenced tracks when the replica GC first saw each
+	// unreferenced template replica, to apply the grace period.
+	replicaGCMutex      sync.Mutex
+	lastReplicaGC       time.Time
+	replicaUnreferenced map[string]time.Time
+
+	// configPath is the cloud config file to re-read on change; empty
+	// unless reload-config is set.
+	configPath    string
+	configModTime time.Time
+
+	// prober is nil unless guest-probe is enabled in the config.
+	prober *guestProber
+
+	// overaged are the nodes past their group's max-node-age, per node
+	// group, refreshed once per loop; scale-down prefers them.
+	overagedMutex sync.Mutex
+	overaged      map[string]map[string]bool
+
+	// relocating are the VMs with a relocation in flight, for groups that
+	// follow config moves with relocate-on-change.
+	relocateMutex sync.Mutex
+	relocating    map[string]bool
+
+	// failoverActive is true while the manager runs against the secondary
+	// vCenter because the primary is unreachable.
+	failoverMutex  sync.Mutex
+	failoverActive bool
+	failoverSince  time.Time
+}
+
+// createVsphereManagerGovmomi logs in to vCenter and returns a vsphereManagerGovmomi.
+func createVsphereManagerGovmomi(cfg ConfigVsphere, discoverOpts cloudprovider.NodeGroupDiscoveryOptions, opts config.AutoscalingOptions) (*vsphereManagerGovmomi, error) {
+	clusterName, err := resolveClusterName(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateRequiredConfig(cfg); err != nil {
+		return nil, err
+	}
+	if err := validateTLSConfig(cfg.Global); err != nil {
+		return nil, err
+	}
+	if cfg.Global.IsolateTags && cfg.Global.TagCategory == "" {
+		cfg.Global.TagCategory = defaultTagCategory + "-" + clusterName
+	}
+
+	client, err := newVsphereClientWithRetry(cfg.Global)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokenMinter *bootstrapTokenMinter
+	if cfg.Global.BootstrapToken {
+		ttl := defaultBootstrapTokenTTL
+		if cfg.Global.BootstrapTokenTTL.isSet {
+			ttl = cfg.Global.BootstrapTokenTTL.value
+		}
+		tokenMinter, err = newBootstrapTokenMinter(opts.KubeConfigPath, ttl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cloneTimeout := defaultCloneTimeout
+	if cfg.Global.CloneTimeout.isSet {
+		cloneTimeout = cfg.Global.CloneTimeout.value
+	}
+
+	var state *stateStore
+	if cfg.Global.PersistState {
+		state, err = newStateStore(opts.KubeConfigPath, clusterName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var audit *auditLog
+	if cfg.Global.AuditLog != "" {
+		audit, err = newAuditLog(cfg.Global.AuditLog)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var spans *tracer
+	if cfg.Global.TraceEndpoint != "" {
+		spans = newTracer(cfg.Global.TraceEndpoint)
+	}
+
+	mgr := &vsphereManagerGovmomi{
+		client:       client,
+		tags:         client,
+		config:       cfg,
+		clusterName:  clusterName,
+		tokenMinter:  tokenMinter,
+		tasks:        newTaskManager(client.Vim, spans),
+		state:        state,
+		cloneTimeout: cloneTimeout,
+		templates:    newTemplateResolver(),
+		audit:        audit,
+		tracer:       spans,
+		creations:    newCreationQueue(cfg),
+		deletions:    newDeletionQueue(cfg),
+		provisioning: newProvisioningBackoff(),
+		trackers:     make(map[string]*groupTracker),
+	}
+	instrumentClient(client, &mgr.usage)
+	traceClient(client, mgr.tracer)
+	mgr.preflightPrivileges(context.TODO())
+	if cfg.Global.BootstrapTags {
+		if err := mgr.bootstrapTags(context.TODO()); err != nil {
+			return nil, err
+		}
+	}
+	if err := mgr.validateTagNamespace(context.TODO()); err != nil {
+		return nil, err
+	}
+	if cfg.Global.ValidateConfig {
+		if err := mgr.validateConfig(context.TODO()); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Global.OwnershipLock {
+		lease := defaultOwnershipLockLease
+		if cfg.Global.OwnershipLockLease.isSet {
+			lease = cfg.Global.OwnershipLockLease.value
+		}
+		ctx := context.TODO()
+		finder, err := mgr.finder(ctx)
+		if err != nil {
+			return nil, err
+		}
+		folder, err := finder.FolderOrDefault(ctx, cfg.Global.Folder)
+		if err != nil {
+			return nil, fmt.Errorf("could not find folder for ownership lock: %v", err)
+		}
+		mgr.lock, err = newOwnershipLock(ctx, client.Vim, folder.Reference(), lease)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch cfg.Global.IPAMMode {
+	case "":
+	case "static":
+		mgr.ipam, err = newStaticIPAllocator(cfg.Global, state)
+		if err != nil {
+			return nil, err
+		}
+	case "webhook":
+		if cfg.Global.IPAMWebhookURL == "" {
+			return nil, fmt.Errorf("ipam-mode webhook requires ipam-webhook-url")
+		}
+		mgr.ipam = newWebhookIPAllocator(cfg.Global.IPAMWebhookURL, clusterName)
+	default:
+		return nil, fmt.Errorf("invalid ipam-mode %q, must be static or webhook", cfg.Global.IPAMMode)
+	}
+
+	switch cfg.Global.DNSMode {
+	case "":
+	case "rfc2136":
+		mgr.dns, err = newRFC2136Registrar(cfg.Global)
+		if err != nil {
+			return nil, err
+		}
+	case "webhook":
+		if cfg.Global.DNSWebhookURL == "" {
+			return nil, fmt.Errorf("dns-mode webhook requires dns-webhook-url")
+		}
+		mgr.dns = newWebhookDNSRegistrar(cfg.Global.DNSWebhookURL, clusterName)
+	default:
+		return nil, fmt.Errorf("invalid dns-mode %q, must be rfc2136 or webhook", cfg.Global.DNSMode)
+	}
+	if mgr.dns != nil && mgr.ipam == nil {
+		return nil, fmt.Errorf("dns-mode requires ipam-mode, as records are created from allocated addresses")
+	}
+
+	if cfg.Global.NodeGroupCRD {
+		mgr.crdSource, err = newCRDNodeGroupSource(opts.KubeConfigPath, cfg.Global.NodeGroupCRDNamespace)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Global.GuestProbe {
+		if cfg.Global.GuestUser == "" || cfg.Global.GuestPassword == "" {
+			return nil, fmt.Errorf("guest-probe requires guest-user and guest-password")
+		}
+		mgr.prober = newGuestProber(mgr)
+	}
+
+	if err := validateProviderIDFormat(cfg.Global.ProviderIDFormat); err != nil {
+		return nil, err
+	}
+
+	if _, err := lookupCloneSpecBuilder(cfg.Global.CloneSpecBuilder); err != nil {
+		return nil, err
+	}
+
+	if err := validateScaleDownPlacement(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateNodeGroupConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	for name, ng := range cfg.NodeGroup {
+		if ng.VTPM {
+			if err := mgr.verifyKeyProvider(context.TODO()); err != nil {
+				return nil, fmt.Errorf("node group %s requires a vTPM but %v", name, err)
+			}
+			break
+		}
+	}
+
+	if err := mgr.reconcileState(context.TODO()); err != nil {
+		return nil, err
+	}
+
+	if cfg.Global.ReloadConfig {
+		mgr.watchConfigFile(opts.CloudConfig)
+	}
+
+	for name := range cfg.NodeGroup {
+		if cfg.warmPoolSizeFor(name) > 0 {
+			go mgr.replenishWarmPool(name)
+		}
+	}
+	if cfg.Global.InventoryWatcher {
+		go mgr.startInventoryWatcher()
+	}
+	if cfg.Global.WatchEvents {
+		go mgr.startEventWatcher()
+	}
+	return mgr, nil
+}
+
+// validateNodeGroupConfig checks every node group section for invalid
+// values, so mistakes surface at startup (and at config reload) instead
+// of failing the first scale-up. Only local checks belong here; anything
+// that needs vCenter goes through validate-config instead.
+func validateNodeGroupConfig(cfg ConfigVsphere) error {
+	for name, ng := range cfg.NodeGroup {
+		switch ng.ScaleDownMode {
+		case "", scaleDownModeDestroy, scaleDownModePowerOff, scaleDownModeSuspend:
+		default:
+			return fmt.Errorf("invalid scale-down-mode %q for node group %s", ng.ScaleDownMode, name)
+		}
+		switch ng.DecreasePolicy {
+		case "", decreasePolicyNewestFirst, decreasePolicyOldestFirst:
+		default:
+			return fmt.Errorf("invalid decrease-policy %q for node group %s", ng.DecreasePolicy, name)
+		}
+		switch ng.Paused {
+		case "", pauseUp, pauseDown, pauseAll:
+		default:
+			return fmt.Errorf("invalid paused %q for node group %s, must be up, down or all", ng.Paused, name)
+		}
+		for _, raw := range ng.NICs {
+			if _, err := parseNICSpec(raw); err != nil {
+				return fmt.Errorf("invalid nic for node group %s: %v", name, err)
+			}
+		}
+		for _, raw := range ng.DataDisks {
+			if _, err := parseExtraDiskSpec(raw); err != nil {
+				return fmt.Errorf("invalid data-disk for node group %s: %v", name, err)
+			}
+		}
+		if _, err := resourceAllocation(ng.CPUReservation, ng.CPULimit, ng.CPUShares); err != nil {
+			return fmt.Errorf("invalid cpu allocation for node group %s: %v", name, err)
+		}
+		if _, err := resourceAllocation(ng.MemoryReservation, ng.MemoryLimit, ng.MemoryShares); err != nil {
+			return fmt.Errorf("invalid memory allocation for node group %s: %v", name, err)
+		}
+		switch types.LatencySensitivitySensitivityLevel(ng.LatencySensitivity) {
+		case "", types.LatencySensitivitySensitivityLevelLow,
+			types.LatencySensitivitySensitivityLevelNormal,
+			types.LatencySensitivitySensitivityLevelMedium:
+		case types.LatencySensitivitySensitivityLevelHigh:
+			// vSphere refuses to power on a high latency sensitivity VM
+			// without full reservations; fail at startup instead.
+			if ng.CPUReservation == 0 || ng.MemoryReservation == 0 {
+				return fmt.Errorf("latency-sensitivity high for node group %s requires cpu-reservation and memory-reservation", name)
+			}
+		default:
+			return fmt.Errorf("invalid latency-sensitivity %q for node group %s", ng.LatencySensitivity, name)
+		}
+		if _, err := parseNUMAAffinity(ng.NUMANodeAffinity); err != nil {
+			return fmt.Errorf("node group %s: %v", name, err)
+		}
+		if ng.CoresPerSocket < 0 {
+			return fmt.Errorf("invalid cores-per-socket %d for node group %s", ng.CoresPerSocket, name)
+		}
+		// Replicas are cloned from the template's current state, so they
+		// cannot honour a snapshot pin.
+		if cfg.prewarmTemplatesFor(name) && cfg.templateSnapshotFor(name) != "" {
+			return fmt.Errorf("node group %s combines prewarm-templates with template-snapshot", name)
+		}
+		if (ng.RolloutTemplate == "") != (ng.RolloutFraction == "") {
+			return fmt.Errorf("node group %s must set rollout-template and rollout-fraction together", name)
+		}
+		if ng.CloneSpecBuilder != "" {
+			if _, err := lookupCloneSpecBuilder(ng.CloneSpecBuilder); err != nil {
+				return fmt.Errorf("node group %s: %v", name, err)
+			}
+		}
+		for _, raw := range ng.ScalingWindows {
+			if _, err := parseScalingWindow(raw); err != nil {
+				return fmt.Errorf("node group %s: %v", name, err)
+			}
+		}
+		if ng.RolloutFraction != "" {
+			fraction, err := strconv.ParseFloat(ng.RolloutFraction, 64)
+			if err != nil || fraction <= 0 || fraction > 1 {
+				return fmt.Errorf("invalid rollout-fraction %q for node group %s, must be in (0, 1]", ng.RolloutFraction, name)
+			}
+		}
+		if ng.MaxConcurrentClones < 0 {
+			return fmt.Errorf("invalid max-concurrent-clones %d for node group %s", ng.MaxConcurrentClones, name)
+		}
+		if ng.ScaleDownUtilizationThreshold != "" {
+			threshold, err := strconv.ParseFloat(ng.ScaleDownUtilizationThreshold, 64)
+			if err != nil || threshold <= 0 || threshold >= 1 {
+				return fmt.Errorf("invalid scale-down-utilization-threshold %s for node group %s, must be a fraction between 0 and 1", ng.ScaleDownUtilizationThreshold, name)
+			}
+		}
+		switch ng.GuestOS {
+		case "", guestOSLinux, guestOSWindows:
+		default:
+			return fmt.Errorf("invalid guest-os %s for node group %s, must be linux or windows", ng.GuestOS, name)
+		}
+		for _, raw := range ng.OVFProperties {
+			if _, _, err := parseOVFProperty(raw); err != nil {
+				return fmt.Errorf("node group %s: %v", name, err)
+			}
+		}
+		if ng.CanaryFraction != "" {
+			fraction, err := strconv.ParseFloat(ng.CanaryFraction, 64)
+			if err != nil || fraction <= 0 || fraction >= 1 {
+				return fmt.Errorf("invalid canary-fraction %s for node group %s, must be a fraction between 0 and 1", ng.CanaryFraction, name)
+			}
+		}
+		if ng.AdoptPattern != "" && cfg.membershipFor(name) != membershipTags {
+			return fmt.Errorf("node group %s sets adopt-pattern but uses %s membership, adoption needs tags", name, cfg.membershipFor(name))
+		}
+		if ng.MaxPods < 0 {
+			return fmt.Errorf("invalid max-pods %d for node group %s", ng.MaxPods, name)
+		}
+		if reserve := cfg.ephemeralStorageReserveFor(name); reserve != "" {
+			if _, err := resource.ParseQuantity(reserve); err != nil {
+				return fmt.Errorf("invalid ephemeral-storage-reserve %s for node group %s: %v", reserve, name, err)
+			}
+		}
+		for _, opt := range []struct{ option, spec string }{
+			{"kube-reserved", cfg.kubeReservedFor(name)},
+			{"system-reserved", cfg.systemReservedFor(name)},
+		} {
+			if opt.spec == "" {
+				continue
+			}
+			if _, err := parseReservedResources(opt.spec); err != nil {
+				return fmt.Errorf("invalid %s %s for node group %s: %v", opt.option, opt.spec, name, err)
+			}
+		}
+		if spec := cfg.evictionHardFor(name); spec != "" {
+			if _, err := parseEvictionHard(spec); err != nil {
+				return fmt.Errorf("invalid eviction-hard %s for node group %s: %v", spec, name, err)
+			}
+		}
+		switch ng.Membership {
+		case "", membershipTags:
+		case membershipFolder:
+			if cfg.folderFor(name) == "" {
+				return fmt.Errorf("membership %s for node group %s requires a folder", ng.Membership, name)
+			}
+		case membershipResourcePool:
+			if cfg.resourcePoolFor(name) == "" {
+				return fmt.Errorf("membership %s for node group %s requires a resource-pool", ng.Membership, name)
+			}
+		default:
+			return fmt.Errorf("invalid membership %q for node group %s", ng.Membership, name)
+		}
+		// The warm pool is tracked with tags, so it cannot be combined with
+		// the tag-less membership modes.
+		if cfg.membershipFor(name) != membershipTags &&
+			(cfg.warmPoolSizeFor(name) > 0 || cfg.scaleDownModeFor(name) != scaleDownModeDestroy) {
+			return fmt.Errorf("node group %s combines membership %s with the tag-based warm pool", name, ng.Membership)
+		}
+		if ng.ChildResourcePool {
+			if cfg.resourcePoolFor(name) == "" {
+				return fmt.Errorf("node group %s sets child-resource-pool but no resource-pool to create it under", name)
+			}
+			// Resource pool membership lists the configured pool directly;
+			// nodes placed in a child below it would not count as members.
+			if cfg.membershipFor(name) == membershipResourcePool {
+				return fmt.Errorf("node group %s combines child-resource-pool with resource-pool membership", name)
+			}
+		}
+		if (ng.PreferredSiteHostGroup == "") != (ng.SecondarySiteHostGroup == "") {
+			return fmt.Errorf("node group %s must set preferred-site-host-group and secondary-site-host-group together", name)
+		}
+		if err := cfg.checkNodeGroupPathsAllowed(name); err != nil {
+			return fmt.Errorf("node group %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// verifyKeyProvider checks that vCenter has a key provider configured.
+// Attaching a vTPM encrypts VM home files, which fails late and opaquely
+// without one, so this is checked up front.
+func (mgr *vsphereManagerGovmomi) verifyKeyProvider(ctx context.Context) error {
+	ref := mgr.activeClient().Vim.ServiceContent.CryptoManager
+	if ref == nil {
+		return fmt.Errorf("vCenter exposes no crypto manager")
+	}
+	var cm mo.CryptoManagerKmip
+	pc := property.DefaultCollector(mgr.activeClient().Vim)
+	if err := pc.RetrieveOne(ctx, *ref, []string{"kmipServers"}, &cm); err != nil {
+		return fmt.Errorf("could not check key providers: %v", err)
+	}
+	if len(cm.KmipServers) == 0 {
+		return fmt.Errorf("vCenter has no key provider configured")
+	}
+	return nil
+}
+
+// nodeGroupDefs returns the node group definitions from the VsphereNodeGroup
+// custom resources and merges their per-group config (template, placement,
+// user-data) into the manager's config, so later clones pick them up.
+func (mgr *vsphereManagerGovmomi) nodeGroupDefs() ([]nodeGroupDef, bool, error) {
+	if mgr.crdSource == nil {
+		return nil, false, nil
+	}
+	defs, configs, err := mgr.crdSource.list(context.TODO())
+	if err != nil {
+		return nil, true, err
+	}
+	if mgr.config.NodeGroup == nil {
+		mgr.config.NodeGroup = make(map[string]*ConfigNodeGroup)
+	}
+	for name, cfg := range configs {
+		mgr.config.NodeGroup[name] = cfg
+	}
+	return defs, true, nil
+}
+
+// nodeGroupPriority returns the configured priority of a node group.
+func (mgr *vsphereManagerGovmomi) nodeGroupPriority(nodegroup string) int {
+	return mgr.config.priorityFor(nodegroup)
+}
+
+// autoscalingOptions returns the per node group autoscaling option
+// overrides from the cloud config.
+func (mgr *vsphereManagerGovmomi) autoscalingOptions(nodegroup string) nodeGroupAutoscalingOptions {
+	return mgr.config.autoscalingOptionsFor(nodegroup)
+}
+
+// checkOwnership takes or renews the ownership lease if locking is enabled.
+// Mutating operations must call this first and bail out on error.
+func (mgr *vsphereManagerGovmomi) checkOwnership(ctx context.Context) error {
+	if mgr.lock == nil {
+		return nil
+	}
+	return mgr.lock.acquire(ctx)
+}
+
+// reconcileState settles the clones a previous run left in flight against
+// the vCenter inventory: clones that produced a VM are adopted by tagging
+// them, clones that left nothing behind are dropped from the state.
+func (mgr *vsphereManagerGovmomi) reconcileState(ctx context.Context) error {
+	if mgr.state == nil {
+		return nil
+	}
+
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		return err
+	}
+	for _, nodegroup := range mgr.state.nodeGroups() {
+		for name, taskRef := range mgr.state.pendingNodes(nodegroup) {
+			vm, err := finder.VirtualMachine(ctx, name)
+			if err != nil {
+				klog.V(1).Infof("Pending clone %s (task %s) from previous run left no VM, dropping it", name, taskRef)
+				mgr.state.removePendingNode(nodegroup, name)
+				continue
+			}
+			if err := mgr.tagNode(ctx, nodegroup, vm.Reference()); err != nil {
+				return fmt.Errorf("could not adopt VM %s from previous run: %v", name, err)
+			}
+			klog.V(1).Infof("Adopted VM %s cloned by a previous run into node group %s", name, nodegroup)
+			mgr.state.removePendingNode(nodegroup, name)
+		}
+	}
+	return nil
+}
+
+// targetSizeChanged persists the new target size of a node group if state
+// persistence is enabled.
+func (mgr *vsphereManagerGovmomi) targetSizeChanged(nodegroup string, size int) {
+	if mgr.state == nil {
+		return
+	}
+	mgr.state.setTargetSize(nodegroup, size)
+}
+
+// restoredTargetSize returns the target size persisted by a previous run.
+func (mgr *vsphereManagerGovmomi) restoredTargetSize(nodegroup string) (int, bool) {
+	if mgr.state == nil {
+		return 0, false
+	}
+	return mgr.state.targetSize(nodegroup)
+}
+
+// tracker returns the operation tracker for a node group, creating it if needed.
+func (mgr *vsphereManagerGovmomi) tracker(nodegroup string) *groupTracker {
+	mgr.trackersMutex.Lock()
+	defer mgr.trackersMutex.Unlock()
+	t, ok := mgr.trackers[nodegroup]
+	if !ok {
+		t = &groupTracker{}
+		mgr.trackers[nodegroup] = t
+	}
+	return t
+}
+
+// finder returns a Finder scoped to the configured datacenter.
+func (mgr *vsphereManagerGovmomi) finder(ctx context.Context) (*find.Finder, error) {
+	finder := find.NewFinder(mgr.activeClient().Vim, false)
+	dc, err := finder.DatacenterOrDefault(ctx, mgr.config.Global.Datacenter)
+	if err != nil {
+		return nil, fmt.Errorf("could not find datacenter %s: %v", mgr.config.Global.Datacenter, err)
+	}
+	finder.SetDatacenter(dc)
+	return finder, nil
+}
+
+// resolveTemplate returns the concrete template name for a node group,
+// resolving glob patterns to the newest matching template.
+func (mgr *vsphereManagerGovmomi) resolveTemplate(ctx context.Context, finder *find.Finder, nodegroup string) (string, error) {
+	return mgr.templates.resolve(ctx, mgr, finder, mgr.config.templateFor(nodegroup))
+}
+
+// verifyTemplate checks the node group's template against the identity
+// recorded on first use, for the verify-template option.
+func (mgr *vsphereManagerGovmomi) verifyTemplate(ctx context.Context, nodegroup string, templateRef types.ManagedObjectReference) error {
+	vms, err := mgr.retrieveVMProperties(ctx, []types.ManagedObjectReference{templateRef}, []string{"config.changeVersion"})
+	if err != nil {
+		return err
+	}
+	var changeVersion string
+	if len(vms) == 1 && vms[0].Config != nil {
+		changeVersion = vms[0].Config.ChangeVersion
+	}
+	return mgr.templates.verify(nodegroup, templateRef, changeVersion)
+}
+
+// taggedVMs returns the references of all VMs carrying both the cluster
+// tag and the given tag.
+func (mgr *vsphereManagerGovmomi) taggedVMs(ctx context.Context, tagName string) ([]types.ManagedObjectReference, error) {
+	clusterTagID, err := mgr.activeTags().GetTagID(ctx, mgr.clusterTagName())
+	if err != nil {
+		return nil, err
+	}
+	tagID, err := mgr.activeTags().GetTagID(ctx, tagName)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterRefs, err := mgr.activeTags().ListAttachedObjects(ctx, clusterTagID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list objects with cluster tag: %v", err)
+	}
+	tagRefs, err := mgr.activeTags().ListAttachedObjects(ctx, tagID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list objects with tag %s: %v", tagName, err)
+	}
+
+	tagged := make(map[types.ManagedObjectReference]bool)
+	for _, ref := range tagRefs {
+		tagged[ref.Reference()] = true
+	}
+	excluded, err := mgr.excludedVMs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var vms []types.ManagedObjectReference
+	for _, ref := range clusterRefs {
+		r := ref.Reference()
+		if r.Type == "VirtualMachine" && tagged[r] && !excluded[r] {
+			vms = append(vms, r)
+		}
+	}
+	return vms, nil
+}
+
+// excludedVMs returns the objects carrying the control-plane role tag,
+// the per-VM ignore tag or any configured exclude-tag, which never belong
+// to a worker node group. Tags that do not exist in vCenter are simply
+// skipped.
+func (mgr *vsphereManagerGovmomi) excludedVMs(ctx context.Context) (map[types.ManagedObjectReference]bool, error) {
+	excluded := make(map[types.ManagedObjectReference]bool)
+	for _, tagName := range append([]string{roleControlPlaneTag, ignoreTag}, mgr.config.Global.ExcludeTags...) {
+		tagID, err := mgr.activeTags().GetTagID(ctx, tagName)
+		if isTagNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		refs, err := mgr.activeTags().ListAttachedObjects(ctx, tagID)
+		if err != nil {
+			return nil, fmt.Errorf("could not list objects with tag %s: %v", tagName, err)
+		}
+		for _, ref := range refs {
+			excluded[ref.Reference()] = true
+		}
+	}
+	return excluded, nil
+}
+
+// nodeGroupVMs returns the references of all member VMs of a node group:
+// by default the VMs carrying both the cluster tag and the group's tag, or
+// the children of the group's folder or resource pool for groups using one
+// of those membership modes.
+func (mgr *vsphereManagerGovmomi) nodeGroupVMs(ctx context.Context, nodegroup string) ([]types.ManagedObjectReference, error) {
+	if vms, ok := mgr.cachedNodeGroupVMs(nodegroup); ok {
+		return vms, nil
+	}
+	switch mgr.config.membershipFor(nodegroup) {
+	case membershipFolder:
+		return mgr.folderVMs(ctx, nodegroup)
+	case membershipResourcePool:
+		return mgr.resourcePoolVMs(ctx, nodegroup)
+	}
+	return mgr.taggedVMs(ctx, mgr.nodeGroupTagName(nodegroup))
+}
+
+// nodeGroupSize returns the number of VMs tagged as belonging to the node group.
+func (mgr *vsphereManagerGovmomi) nodeGroupSize(nodegroup string) (int, error) {
+	ctx := context.TODO()
+	vms, err := mgr.nodeGroupVMs(ctx, nodegroup)
+	if err != nil {
+		return 0, err
+	}
+	klog.V(3).Infof("Node group %s has size %d", nodegroup, len(vms))
+	return len(vms), nil
+}
+
+// createNodes clones the configured template once per requested node,
+// tags the clones and powers them on. Clones go through the creation
+// queue, which caps how many run at once and spaces them out when vCenter
+// shows signs of overload; a group whose scale-ups keep failing is
+// refused outright until its provisioning cool-down expires.
+func (mgr *vsphereManagerGovmomi) createNodes(nodegroup string, nodes int) error {
+	ctx := context.TODO()
+	if err := mgr.degradedError(); err != nil {
+		return err
+	}
+	if err := mgr.failoverMutationError(); err != nil {
+		return err
+	}
+	if err := mgr.scaleUpPausedError(ctx, nodegroup); err != nil {
+		return err
+	}
+	if err := mgr.provisioning.cooldownError(nodegroup); err != nil {
+		return err
+	}
+	if err := mgr.checkOwnership(ctx); err != nil {
+		return err
+	}
+	tracker := mgr.tracker(nodegroup)
+
+	var names []string
+	for i := 0; i < nodes; i++ {
+		// A warm standby VM, when available, beats cloning on demand by
+		// several minutes.
+		activated, err := mgr.activateStandby(ctx, nodegroup)
+		if err != nil {
+			klog.Warningf("Could not activate standby VM for %s, cloning instead: %v", nodegroup, err)
+		}
+		if activated {
+			continue
+		}
+		names = append(names, fmt.Sprintf("%s-%s", nodegroup, uuid.NewV4().String()[:8]))
+	}
+
+	err := mgr.creations.run(ctx, nodegroup, names, func(ctx context.Context, name string) error {
+		tracker.cloneStarted()
+		err := mgr.cloneNode(ctx, nodegroup, name)
+		tracker.cloneFinished(err)
+		if err != nil {
+			mgr.noteDegraded(err)
+			return asAutoscalerError(err).AddPrefix("could not create node %s: ", name)
+		}
+		return nil
+	})
+	if err != nil {
+		mgr.provisioning.recordFailure(nodegroup, err)
+		return err
+	}
+	mgr.provisioning.recordSuccess(nodegroup)
+
+	if mgr.config.childResourcePoolFor(nodegroup) {
+		mgr.resizeChildPool(ctx, nodegroup)
+	}
+	if mgr.config.warmPoolSizeFor(nodegroup) > 0 {
+		go mgr.replenishWarmPool(nodegroup)
+	}
+	return nil
+}
+
+// cloneNode clones the node group's template into a new VM, attaches the
+// cluster and node group tags, and leaves the VM powered on.
+func (mgr *vsphereManagerGovmomi) cloneNode(ctx context.Context, nodegroup, name string) error {
+	vmRef, err := mgr.cloneVM(ctx, nodegroup, name, true)
+	if err != nil {
+		return err
+	}
+
+	if err := mgr.tagNode(ctx, nodegroup, vmRef); err != nil {
+		// Keep the pending entry: the untagged VM exists and a restart can
+		// still adopt it through reconcileState.
+		return err
+	}
+	if mgr.state != nil {
+		mgr.state.removePendingNode(nodegroup, name)
+	}
+	return nil
+}
+
+// cloneVM clones the node group's template into a new VM and waits for the
+// clone task. Tagging is left to the caller; the pending state entry is
+// kept on success so a crash between clone and tag can be reconciled.
+func (mgr *vsphereManagerGovmomi) cloneVM(ctx context.Context, nodegroup, name string, powerOn bool) (types.ManagedObjectReference, error) {
+	var none types.ManagedObjectReference
+
+	// Checked again here, not just at startup, because node groups can
+	// appear later through the CRD source.
+	if err := mgr.config.checkNodeGroupPathsAllowed(nodegroup); err != nil {
+		return none, err
+	}
+
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		return none, err
+	}
+
+	templateName, err := mgr.pickCloneTemplate(ctx, finder, nodegroup)
+	if err != nil {
+		return none, err
+	}
+	// The stamp records the logical template even when the clone itself
+	// runs from a pre-warmed replica of it.
+	stampTemplate := templateName
+	template, err := finder.VirtualMachine(ctx, templateName)
+	if err != nil {
+		return none, fmt.Errorf("could not find template %s: %v", templateName, err)
+	}
+	if mgr.config.verifyTemplateFor(nodegroup) {
+		if err := mgr.verifyTemplate(ctx, nodegroup, template.Reference()); err != nil {
+			return none, err
+		}
+	}
+	if mgr.config.prewarmTemplatesFor(nodegroup) {
+		template, templateName, err = mgr.prewarmedTemplate(ctx, finder, nodegroup, template, templateName)
+		if err != nil {
+			return none, err
+		}
+	}
+	poolPaths := mgr.placementPools(nodegroup)
+	poolRefs := make([]types.ManagedObjectReference, 0, len(poolPaths))
+	for _, poolPath := range poolPaths {
+		pool, err := finder.ResourcePool(ctx, poolPath)
+		if err != nil {
+			return none, fmt.Errorf("could not find resource pool %s: %v", poolPath, err)
+		}
+		poolRefs = append(poolRefs, pool.Reference())
+	}
+	if mgr.config.childResourcePoolFor(nodegroup) {
+		// Only the primary placement goes through the child pool; fallback
+		// pools are an emergency path on foreign clusters.
+		child, err := mgr.ensureChildPool(ctx, finder, nodegroup, poolPaths[0])
+		if err != nil {
+			return none, err
+		}
+		poolRefs[0] = child.Reference()
+	}
+	folder, err := finder.Folder(ctx, mgr.config.folderFor(nodegroup))
+	if err != nil {
+		return none, fmt.Errorf("could not find folder %s: %v", mgr.config.folderFor(nodegroup), err)
+	}
+
+	spec := types.VirtualMachineCloneSpec{
+		Location: types.VirtualMachineRelocateSpec{
+			Pool: &poolRefs[0],
+		},
+		PowerOn: powerOn,
+	}
+
+	if snapshot := mgr.config.templateSnapshotFor(nodegroup); snapshot != "" {
+		spec.Snapshot, err = template.FindSnapshot(ctx, snapshot)
+		if err != nil {
+			return none, fmt.Errorf("could not find snapshot %s of template %s: %v", snapshot, templateName, err)
+		}
+	}
+
+	if datastore := mgr.config.datastoreFor(nodegroup); datastore != "" {
+		ds, err := finder.Datastore(ctx, datastore)
+		if err != nil {
+			return none, fmt.Errorf("could not find datastore %s: %v", datastore, err)
+		}
+		dsRef := ds.Reference()
+		spec.Location.Datastore = &dsRef
+	}
+
+	if provisioning := mgr.config.diskProvisioningFor(nodegroup); provisioning != "" {
+		if spec.Location.Datastore == nil {
+			return none, fmt.Errorf("disk-provisioning for node group %s requires a datastore", nodegroup)
+		}
+		spec.Location.Disk, err = mgr.diskLocators(ctx, template.Reference(), *spec.Location.Datastore, provisioning)
+		if err != nil {
+			return none, err
+		}
+	}
+
+	configSpec, err := mgr.buildConfigSpec(nodegroup, name)
+	if err != nil {
+		return none, err
+	}
+	configSpec.ExtraConfig = append(configSpec.ExtraConfig,
+		&types.OptionValue{Key: templateStampKey, Value: stampTemplate})
+	spec.Config = configSpec
+
+	if len(mgr.config.ovfPropertiesFor(nodegroup)) > 0 {
+		spec.Config.VAppConfig, err = mgr.vappConfigSpec(ctx, template.Reference(), nodegroup, name)
+		if err != nil {
+			return none, err
+		}
+	}
+
+	if mgr.config.guestOSFor(nodegroup) == guestOSWindows {
+		spec.Customization, err = mgr.windowsCustomization(nodegroup, name)
+		if err != nil {
+			return none, err
+		}
+	}
+
+	// Clones powered on immediately are scale-ups for pending pods; clones
+	// left off are warm pool stock.
+	reason := "scale-up"
+	if !powerOn {
+		reason = "warm-pool"
+	}
+
+	for attempt := range poolRefs {
+		spec.Location.Pool = &poolRefs[attempt]
+		if mgr.config.avoidVMsTaggedFor(nodegroup) != "" {
+			// Best effort: a placement hint is not worth failing the
+			// scale-up over.
+			host, err := mgr.antiAffinityHost(ctx, poolRefs[attempt], nodegroup)
+			if err != nil {
+				klog.Warningf("Could not pick an anti-affinity host for %s: %v", name, err)
+			} else {
+				spec.Location.Host = host
+			}
+		}
+		klog.V(2).Infof("Cloning VM %s from template %s into pool %s", name, templateName, poolPaths[attempt])
+
+		task, err := template.Clone(ctx, folder, name, spec)
+		if err != nil {
+			mgr.releaseIP(name)
+			mgr.deregisterDNS(name)
+			err = fmt.Errorf("could not start clone task: %v", err)
+			mgr.auditRecord("clone", nodegroup, name, reason, "", err)
+			return none, err
+		}
+		mgr.tasks.track(nodegroup, name, taskKindClone, task.Reference())
+		if mgr.state != nil {
+			mgr.state.addPendingNode(nodegroup, name, task.Reference().Value)
+		}
+
+		waitCtx := ctx
+		if mgr.cloneTimeout > 0 {
+			var cancel context.CancelFunc
+			waitCtx, cancel = context.WithTimeout(ctx, mgr.cloneTimeout)
+			defer cancel()
+		}
+
+		info, err := task.WaitForResult(waitCtx, nil)
+		if err != nil && waitCtx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("clone of %s did not finish within %s", name, mgr.cloneTimeout)
+			if mgr.config.Global.BootDiagnostics {
+				// Capture before the stuck clone is aborted and the
+				// half-provisioned VM destroyed.
+				if diagnostics := mgr.captureBootDiagnostics(ctx, finder, name); diagnostics != "" {
+					err = fmt.Errorf("%v (%s)", err, diagnostics)
+				}
+			}
+			mgr.tasks.complete(name, err)
+			mgr.abortStuckClone(ctx, finder, name, task)
+			if mgr.state != nil {
+				mgr.state.removePendingNode(nodegroup, name)
+			}
+			mgr.releaseIP(name)
+			mgr.deregisterDNS(name)
+			mgr.auditRecord("clone", nodegroup, name, reason, task.Reference().Value, err)
+			return none, err
+		}
+		mgr.tasks.complete(name, err)
+		if err != nil {
+			if isOutOfCapacity(err) && attempt+1 < len(poolRefs) {
+				// The pool is full, not broken: move on to the next pool.
+				mgr.auditRecord("clone", nodegroup, name, reason, task.Reference().Value, err)
+				klog.Warningf("Pool %s is out of capacity for %s, trying fallback pool %s: %v",
+					poolPaths[attempt], name, poolPaths[attempt+1], err)
+				if mgr.state != nil {
+					mgr.state.removePendingNode(nodegroup, name)
+				}
+				continue
+			}
+			if mgr.state != nil {
+				mgr.state.removePendingNode(nodegroup, name)
+			}
+			mgr.releaseIP(name)
+			mgr.deregisterDNS(name)
+			mgr.auditRecord("clone", nodegroup, name, reason, task.Reference().Value, err)
+			return none, asAutoscalerError(err).AddPrefix("clone task failed: ")
+		}
+		mgr.auditRecord("clone", nodegroup, name, reason, task.Reference().Value, nil)
+
+		vmRef, ok := info.Result.(types.ManagedObjectReference)
+		if !ok {
+			return none, fmt.Errorf("clone task returned unexpected result %T", info.Result)
+		}
+
+		if len(poolRefs) > 1 {
+			mgr.recordPlacementPool(ctx, vmRef, poolPaths[attempt])
+		}
+		if preferred, _ := mgr.config.siteHostGroupsFor(nodegroup); preferred != "" {
+			mgr.assignSiteVMGroup(ctx, poolRefs[attempt], nodegroup, vmRef, name)
+		}
+		return vmRef, nil
+	}
+	// Unreachable: the loop returns on its last attempt either way.
+	return none, fmt.Errorf("could not place %s in any resource pool", name)
+}
+
+// diskLocators builds a relocate disk locator per template disk, forcing
+// the requested provisioning format on the clone's disks.
+func (mgr *vsphereManagerGovmomi) diskLocators(ctx context.Context, templateRef, datastore types.ManagedObjectReference, provisioning string) ([]types.VirtualMachineRelocateSpecDiskLocator, error) {
+	backing := &types.VirtualDiskFlatVer2BackingInfo{}
+	switch provisioning {
+	case diskProvisioningThin:
+		backing.ThinProvisioned = types.NewBool(true)
+	case diskProvisioningThick:
+		backing.ThinProvisioned = types.NewBool(false)
+	case diskProvisioningEagerZeroedThick:
+		backing.ThinProvisioned = types.NewBool(false)
+		backing.EagerlyScrub = types.NewBool(true)
+	default:
+		return nil, fmt.Errorf("invalid disk-provisioning %q, must be thin, thick or eagerZeroedThick", provisioning)
+	}
+
+	vms, err := mgr.retrieveVMProperties(ctx, []types.ManagedObjectReference{templateRef}, []string{"config.hardware.device"})
+	if err != nil {
+		return nil, err
+	}
+	if len(vms) != 1 || vms[0].Config == nil {
+		return nil, fmt.Errorf("could not get devices of template %s", templateRef.Value)
+	}
+
+	var locators []types.VirtualMachineRelocateSpecDiskLocator
+	for _, device := range vms[0].Config.Hardware.Device {
+		if disk, ok := device.(*types.VirtualDisk); ok {
+			locators = append(locators, types.VirtualMachineRelocateSpecDiskLocator{
+				DiskId:          disk.Key,
+				Datastore:       datastore,
+				DiskBackingInfo: backing,
+			})
+		}
+	}
+	return locators, nil
+}
+
+// abortStuckClone cancels a clone task that exceeded the timeout and
+// destroys whatever partial VM it left behind, so a hung vCenter does not
+// leak half-created nodes.
+func (mgr *vsphereManagerGovmomi) abortStuckClone(ctx context.Context, finder *find.Finder, name string, task *object.Task) {
+	klog.Warningf("Cancelling stuck clone task for %s", name)
+	if err := task.Cancel(ctx); err != nil {
+		klog.Errorf("Could not cancel clone task for %s: %v", name, err)
+	}
+
+	vm, err := finder.VirtualMachine(ctx, name)
+	if err != nil {
+		// The clone never got far enough to leave a VM behind.
+		return
+	}
+	destroyTask, err := vm.Destroy(ctx)
+	if err != nil {
+		klog.Errorf("Could not destroy partial VM %s: %v", name, err)
+		return
+	}
+	if err := destroyTask.Wait(ctx); err != nil {
+		klog.Errorf("Destroying partial VM %s failed: %v", name, err)
+	}
+}
+
+// buildConfigSpec builds the config spec for a new clone and runs it
+// through the node group's clone spec builder, the extension point for
+// spec tweaks the config options do not cover.
+func (mgr *vsphereManagerGovmomi) buildConfigSpec(nodegroup, name string) (*types.VirtualMachineConfigSpec, error) {
+	spec, err := mgr.buildBaseConfigSpec(nodegroup, name)
+	if err != nil {
+		return nil, err
+	}
+	builder, err := lookupCloneSpecBuilder(mgr.config.cloneSpecBuilderFor(nodegroup))
+	if err != nil {
+		return nil, err
+	}
+	return builder.BuildConfigSpec(nodegroup, name, spec)
+}
+
+// buildBaseConfigSpec builds the extra configuration injected into a clone:
+// the audit stamp identifying who created the VM and why, plus the
+// guestinfo user-data read from the configured file.
+func (mgr *vsphereManagerGovmomi) buildBaseConfigSpec(nodegroup, name string) (*types.VirtualMachineConfigSpec, error) {
+	spec := &types.VirtualMachineConfigSpec{
+		ExtraConfig: mgr.auditStamp(nodegroup),
+	}
+
+	// Hardware overrides: clones can be upgraded to a newer virtual
+	// hardware version and switched to EFI without touching the template.
+	spec.Version = mgr.config.hardwareVersionFor(nodegroup)
+	switch firmware := mgr.config.firmwareFor(nodegroup); firmware {
+	case "":
+	case string(types.GuestOsDescriptorFirmwareTypeBios), string(types.GuestOsDescriptorFirmwareTypeEfi):
+		spec.Firmware = firmware
+	default:
+		return nil, fmt.Errorf("invalid firmware %q for node group %s, must be bios or efi", firmware, nodegroup)
+	}
+
+	if mgr.config.secureBootFor(nodegroup) {
+		if mgr.config.firmwareFor(nodegroup) == string(types.GuestOsDescriptorFirmwareTypeBios) {
+			return nil, fmt.Errorf("node group %s enables secure-boot but sets firmware to bios", nodegroup)
+		}
+		spec.BootOptions = &types.VirtualMachineBootOptions{
+			EfiSecureBootEnabled: types.NewBool(true),
+		}
+	}
+
+	if mgr.config.vtpmFor(nodegroup) {
+		spec.DeviceChange = append(spec.DeviceChange, &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationAdd,
+			Device:    &types.VirtualTPM{VirtualDevice: types.VirtualDevice{Key: -1}},
+		})
+	}
+
+	if ng, ok := mgr.config.NodeGroup[nodegroup]; ok {
+		cpuAllocation, err := resourceAllocation(ng.CPUReservation, ng.CPULimit, ng.CPUShares)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu allocation for node group %s: %v", nodegroup, err)
+		}
+		spec.CpuAllocation = cpuAllocation
+		memoryAllocation, err := resourceAllocation(ng.MemoryReservation, ng.MemoryLimit, ng.MemoryShares)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory allocation for node group %s: %v", nodegroup, err)
+		}
+		spec.MemoryAllocation = memoryAllocation
+		if ng.CPUHotAdd {
+			spec.CpuHotAddEnabled = types.NewBool(true)
+		}
+		if ng.MemoryHotAdd {
+			spec.MemoryHotAddEnabled = types.NewBool(true)
+		}
+		if ng.LatencySensitivity != "" {
+			spec.LatencySensitivity = &types.LatencySensitivity{
+				Level: types.LatencySensitivitySensitivityLevel(ng.LatencySensitivity),
+			}
+		}
+		if ng.NUMANodeAffinity != "" {
+			spec.ExtraConfig = append(spec.ExtraConfig,
+				&types.OptionValue{Key: "numa.nodeAffinity", Value: ng.NUMANodeAffinity})
+		}
+		spec.NumCoresPerSocket = int32(ng.CoresPerSocket)
+	}
+
+	for _, raw := range mgr.config.nicsFor(nodegroup) {
+		nic, err := parseNICSpec(raw)
+		if err != nil {
+			return nil, fmt.Errorf("node group %s: %v", nodegroup, err)
+		}
+		device, err := nic.device(name)
+		if err != nil {
+			return nil, fmt.Errorf("node group %s: %v", nodegroup, err)
+		}
+		spec.DeviceChange = append(spec.DeviceChange, &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationAdd,
+			Device:    device,
+		})
+		if nic.adapter == "sriov" {
+			// SR-IOV passthrough requires the VM's memory to be fully
+			// reserved.
+			spec.MemoryReservationLockedToMax = types.NewBool(true)
+		}
+	}
+
+	for i, raw := range mgr.config.dataDisksFor(nodegroup) {
+		disk, err := parseExtraDiskSpec(raw)
+		if err != nil {
+			return nil, fmt.Errorf("node group %s: %v", nodegroup, err)
+		}
+		spec.DeviceChange = append(spec.DeviceChange, disk.deviceChange(i))
+	}
+
+	// Stamp the node's identity so scale-down can verify it destroys the
+	// VM it thinks it does, even when hostnames collide.
+	spec.ExtraConfig = append(spec.ExtraConfig, &types.OptionValue{
+		Key:   guestinfoIdentityKey,
+		Value: mgr.nodeIdentity(nodegroup, name),
+	})
+
+	if mgr.config.guestOSFor(nodegroup) == guestOSWindows {
+		// Windows templates have no cloud-init to read guestinfo; the
+		// hostname, network settings and first-logon commands go through
+		// the sysprep customization spec built in cloneVM instead.
+		return spec, nil
+	}
+
+	userDataFile := mgr.config.userDataFor(nodegroup)
+	if userDataFile == "" {
+		return spec, nil
+	}
+
+	rawUserData, err := ioutil.ReadFile(userDataFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read user-data file %s: %v", userDataFile, err)
+	}
+
+	// The IP is allocated before the user-data is rendered so the {{ip}}
+	// placeholder can refer to it.
+	ip := ""
+	metadata := fmt.Sprintf("local-hostname: %s\n", name)
+	if mgr.ipam != nil {
+		allocation, err := mgr.ipam.allocate(nodegroup, name)
+		if err != nil {
+			return nil, fmt.Errorf("could not allocate IP for %s: %v", name, err)
+		}
+		klog.V(2).Infof("Allocated IP %s/%d for %s", allocation.Address, allocation.Prefix, name)
+		ip = allocation.Address
+		metadata += networkMetadata(allocation)
+		mgr.registerDNS(name, allocation.Address)
+	}
+
+	userData, err := mgr.renderUserData(nodegroup, name, ip, string(rawUserData))
+	if err != nil {
+		mgr.releaseIP(name)
+		mgr.deregisterDNS(name)
+		return nil, err
+	}
+
+	spec.ExtraConfig = append(spec.ExtraConfig,
+		&types.OptionValue{Key: "guestinfo.userdata", Value: base64.StdEncoding.EncodeToString([]byte(userData))},
+		&types.OptionValue{Key: "guestinfo.userdata.encoding", Value: "base64"},
+		&types.OptionValue{Key: "guestinfo.metadata", Value: base64.StdEncoding.EncodeToString([]byte(metadata))},
+		&types.OptionValue{Key: "guestinfo.metadata.encoding", Value: "base64"},
+	)
+	return spec, nil
+}
+
+// resourceAllocation builds the allocation for the cpu-/memory-reservation,
+// -limit and -shares options of a node group; nil when none of them is set,
+// keeping the template's allocation.
+func resourceAllocation(reservation, limit int64, shares string) (*types.ResourceAllocationInfo, error) {
+	if reservation == 0 && limit == 0 && shares == "" {
+		return nil, nil
+	}
+	alloc := &types.ResourceAllocationInfo{}
+	if reservation != 0 {
+		alloc.Reservation = types.NewInt64(reservation)
+	}
+	if limit != 0 {
+		alloc.Limit = types.NewInt64(limit)
+	}
+	switch shares {
+	case "":
+	case string(types.SharesLevelLow), string(types.SharesLevelNormal), string(types.SharesLevelHigh):
+		alloc.Shares = &types.SharesInfo{Level: types.SharesLevel(shares)}
+	default:
+		value, err := strconv.Atoi(shares)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid shares %q, must be low, normal, high or a positive number", shares)
+		}
+		alloc.Shares = &types.SharesInfo{Level: types.SharesLevelCustom, Shares: int32(value)}
+	}
+	return alloc, nil
+}
+
+// parseNUMAAffinity parses the numa-node-affinity option into the host NUMA
+// node numbers it names.
+func parseNUMAAffinity(affinity string) ([]int, error) {
+	if affinity == "" {
+		return nil, nil
+	}
+	var nodes []int
+	for _, part := range strings.Split(affinity, ",") {
+		node, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || node < 0 {
+			return nil, fmt.Errorf("invalid numa-node-affinity %q, must be a comma separated list of node numbers", affinity)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// auditStamp returns extraConfig entries identifying the VM as created by
+// the autoscaler, so vCenter admins can see where a VM came from and when.
+func (mgr *vsphereManagerGovmomi) auditStamp(nodegroup string) []types.BaseOptionValue {
+	return []types.BaseOptionValue{
+		&types.OptionValue{Key: createdByKey, Value: createdByValue},
+		&types.OptionValue{Key: "cluster-autoscaler.version", Value: version.ClusterAutoscalerVersion},
+		&types.OptionValue{Key: "cluster-autoscaler.cluster", Value: mgr.clusterName},
+		&types.OptionValue{Key: "cluster-autoscaler.nodegroup", Value: nodegroup},
+		&types.OptionValue{Key: "cluster-autoscaler.created-at", Value: time.Now().UTC().Format(time.RFC3339)},
+	}
+}
+
+// renderUserData substitutes the per-instance placeholders in the
+// user-data: {{kubelet_extra_args}} expands to the kubelet flags
+// registering the node group's labels, taints, reserves and eviction
+// thresholds, so the booted node matches the scheduling simulation;
+// {{node_name}}, {{nodegroup}}, {{cluster_name}} and {{ip}} identify the
+// instance; and any custom user-data-vars from the config are replaced by
+// their values — so one user-data template can serve all groups.
+func (mgr *vsphereManagerGovmomi) renderUserData(nodegroup, name, ip, userData string) (string, error) {
+	labels, err := mgr.config.labelsFor(nodegroup)
+	if err != nil {
+		return "", fmt.Errorf("invalid labels for node group %s: %v", nodegroup, err)
+	}
+	taints, err := mgr.config.taintsFor(nodegroup)
+	if err != nil {
+		return "", fmt.Errorf("invalid taints for node group %s: %v", nodegroup, err)
+	}
+	labels = cloudprovider.JoinStringMaps(labels, zoneLabels(mgr.config.zoneFor(nodegroup), mgr.config.Global.Region))
+	userData = strings.Replace(userData, "{{kubelet_extra_args}}",
+		kubeletExtraArgs(labels, taints, mgr.config.kubeReservedFor(nodegroup), mgr.config.systemReservedFor(nodegroup), mgr.config.evictionHardFor(nodegroup)), -1)
+
+	userData = strings.Replace(userData, "{{node_name}}", name, -1)
+	userData = strings.Replace(userData, "{{nodegroup}}", nodegroup, -1)
+	userData = strings.Replace(userData, "{{cluster_name}}", mgr.clusterName, -1)
+	if strings.Contains(userData, "{{ip}}") {
+		if ip == "" {
+			return "", fmt.Errorf("user-data for node group %s references {{ip}} but no IP was allocated, which requires ipam", nodegroup)
+		}
+		userData = strings.Replace(userData, "{{ip}}", ip, -1)
+	}
+
+	vars, err := mgr.config.userDataVarsFor(nodegroup)
+	if err != nil {
+		return "", err
+	}
+	for key, value := range vars {
+		userData = strings.Replace(userData, "{{"+key+"}}", value, -1)
+	}
+
+	if strings.Contains(userData, "{{bootstrap_token}}") {
+		if mgr.tokenMinter == nil {
+			return "", fmt.Errorf("user-data for node group %s references {{bootstrap_token}} but bootstrap-token is not enabled", nodegroup)
+		}
+		token, err := mgr.tokenMinter.mintToken()
+		if err != nil {
+			return "", fmt.Errorf("could not mint bootstrap token for node group %s: %v", nodegroup, err)
+		}
+		userData = strings.Replace(userData, "{{bootstrap_token}}", token, -1)
+	}
+
+	return userData, nil
+}
+
+// tagNode attaches the cluster and node group tags to a VM. For node
+// groups using folder or resource pool membership there is nothing to tag:
+// the clone's placement already makes it a member.
+func (mgr *vsphereManagerGovmomi) tagNode(ctx context.Context, nodegroup string, vmRef types.ManagedObjectReference) error {
+	if mgr.config.membershipFor(nodegroup) != membershipTags {
+		mgr.invalidateInventory()
+		return nil
+	}
+	if err := mgr.checkVMPathAllowed(ctx, vmRef); err != nil {
+		mgr.auditRecord("tag", nodegroup, vmRef.Value, "scale-up", "", err)
+		return err
+	}
+	clusterTagID, err := mgr.activeTags().GetTagID(ctx, mgr.clusterTagName())
+	if err != nil {
+		return err
+	}
+	groupTagID, err := mgr.activeTags().GetTagID(ctx, mgr.nodeGroupTagName(nodegroup))
+	if err != nil {
+		return err
+	}
+	if err := mgr.activeTags().AttachTag(ctx, clusterTagID, vmRef); err != nil {
+		err = fmt.Errorf("could not attach cluster tag to %s: %v", vmRef.Value, err)
+		mgr.auditRecord("tag", nodegroup, vmRef.Value, "scale-up", "", err)
+		return err
+	}
+	if err := mgr.activeTags().AttachTag(ctx, groupTagID, vmRef); err != nil {
+		err = fmt.Errorf("could not attach node group tag to %s: %v", vmRef.Value, err)
+		mgr.auditRecord("tag", nodegroup, vmRef.Value, "scale-up", "", err)
+		return err
+	}
+	mgr.auditRecord("tag", nodegroup, vmRef.Value, "scale-up", "", nil)
+	mgr.invalidateInventory()
+	return nil
+}
+
+// deleteNodes powers off and destroys the VMs backing the given nodes.
+func (mgr *vsphereManagerGovmomi) deleteNodes(nodegroup string, nodes []NodeRef, updatedNodeCount int) error {
+	ctx := context.TODO()
+	if err := mgr.degradedError(); err != nil {
+		return err
+	}
+	if err := mgr.failoverMutationError(); err != nil {
+		return err
+	}
+	if err := mgr.scaleDownPausedError(ctx, nodegroup); err != nil {
+		return err
+	}
+	if err := mgr.checkOwnership(ctx); err != nil {
+		return err
+	}
+	tracker := mgr.tracker(nodegroup)
+
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Deletions run concurrently up to the global and per-host caps of the
+	// deletion queue, so shedding many nodes finishes in parallel without
+	// overwhelming a datastore or draining too many VMs of one host at
+	// once.
+	hibernate := mgr.config.scaleDownModeFor(nodegroup) != scaleDownModeDestroy
+	var wg sync.WaitGroup
+	var errMutex sync.Mutex
+	var firstErr error
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node NodeRef) {
+			defer wg.Done()
+			host := mgr.vmHost(ctx, finder, node.Name)
+			mgr.deletions.acquire(host)
+			defer mgr.deletions.release(host)
+			tracker.deleteStarted()
+			var err error
+			if hibernate {
+				err = mgr.hibernateVM(ctx, finder, nodegroup, node.Name)
+			} else {
+				err = mgr.destroyVM(ctx, finder, nodegroup, node.Name)
+			}
+			tracker.deleteFinished(err)
+			if err != nil {
+				mgr.noteDegraded(err)
+				errMutex.Lock()
+				if firstErr == nil {
+					firstErr = asAutoscalerError(err).AddPrefix("could not delete node %s: ", node.Name)
+				}
+				errMutex.Unlock()
+			}
+		}(node)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	if mgr.config.childResourcePoolFor(nodegroup) {
+		mgr.resizeChildPool(ctx, nodegroup)
+	}
+	if hibernate {
+		// Hibernated VMs joined the warm pool; enforce the pool TTL.
+		go mgr.replenishWarmPool(nodegroup)
+	}
+	return nil
+}
+
+// vmHost returns the MoRef value of the ESXi host currently running the
+// named VM, or empty when it cannot be determined — the deletion then
+// only counts against the global cap.
+func (mgr *vsphereManagerGovmomi) vmHost(ctx context.Context, finder *find.Finder, name string) string {
+	vm, err := finder.VirtualMachine(ctx, name)
+	if err != nil {
+		return ""
+	}
+	vms, err := mgr.retrieveVMProperties(ctx, []types.ManagedObjectReference{vm.Reference()}, []string{"runtime.host"})
+	if err != nil || len(vms) != 1 || vms[0].Runtime.Host == nil {
+		return ""
+	}
+	return vms[0].Runtime.Host.Value
+}
+
+// destroyVM powers off and destroys a single VM by name.
+func (mgr *vsphereManagerGovmomi) destroyVM(ctx context.Context, finder *find.Finder, nodegroup, name string) error {
+	vm, err := finder.VirtualMachine(ctx, name)
+	if err != nil {
+		return fmt.Errorf("could not find VM %s: %v", name, err)
+	}
+	if err := mgr.config.checkPathAllowed("VM", vm.InventoryPath); err != nil {
+		return err
+	}
+	if mgr.config.verifyIdentityFor(nodegroup) {
+		if err := mgr.verifyVMIdentity(ctx, vm.Reference(), nodegroup, name); err != nil {
+			return err
+		}
+	}
+	if err := mgr.checkCreatedByMarker(ctx, vm.Reference(), name); err != nil {
+		return err
+	}
+	if err := mgr.checkIgnoreTag(ctx, vm.Reference(), name); err != nil {
+		return err
+	}
+	if err := mgr.checkClusterMembership(ctx, vm.Reference(), name); err != nil {
+		return err
+	}
+	if err := mgr.checkGPUWorkloads(ctx, nodegroup, name); err != nil {
+		return err
+	}
+
+	powerState, err := vm.PowerState(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get power state of %s: %v", name, err)
+	}
+	if powerState == types.VirtualMachinePowerStatePoweredOn {
+		task, err := vm.PowerOff(ctx)
+		if err != nil {
+			err = fmt.Errorf("could not start power off task for %s: %v", name, err)
+			mgr.auditRecord("power-off", nodegroup, name, "scale-down", "", err)
+			return err
+		}
+		if err := task.Wait(ctx); err != nil {
+			err = fmt.Errorf("power off task for %s failed: %v", name, err)
+			mgr.auditRecord("power-off", nodegroup, name, "scale-down", task.Reference().Value, err)
+			return err
+		}
+		mgr.auditRecord("power-off", nodegroup, name, "scale-down", task.Reference().Value, nil)
+	}
+
+	if mgr.config.detachDataDisksFor(nodegroup) {
+		if err := mgr.detachDataDisks(ctx, vm, nodegroup, name); err != nil {
+			return err
+		}
+	}
+
+	klog.V(2).Infof("Destroying VM %s", name)
+
+	task, err := vm.Destroy(ctx)
+	if err != nil {
+		err = fmt.Errorf("could not start destroy task for %s: %v", name, err)
+		mgr.auditRecord("destroy", nodegroup, name, "scale-down", "", err)
+		return err
+	}
+	mgr.tasks.track(nodegroup, name, taskKindDelete, task.Reference())
+
+	err = task.Wait(ctx)
+	mgr.tasks.complete(name, err)
+	mgr.auditRecord("destroy", nodegroup, name, "scale-down", task.Reference().Value, err)
+	if err != nil {
+		return asAutoscalerError(err).AddPrefix("destroy task for %s failed: ", name)
+	}
+	mgr.tasks.forget(name)
+	mgr.releaseIP(name)
+	mgr.deregisterDNS(name)
+	mgr.invalidateInventory()
+	return nil
+}
+
+// rankDeletionCandidates orders deletion candidates by the load of the ESXi
+// host their VM runs on, per the configured scale-down placement policy:
+// consolidation empties the most lightly loaded hosts first so DPM can
+// power them off, relieve-contention deletes from the most loaded hosts
+// first to ease contention for the VMs staying behind. Nodes whose host
+// cannot be determined keep their position at the end of the list. For
+// groups with fallback resource pools, VMs that landed in a fallback pool
+// come first regardless, so scale-down drains the overflow before touching
+// primary capacity; nodes past the group's max-node-age come before
+// everything else.
+func (mgr *vsphereManagerGovmomi) rankDeletionCandidates(nodegroup string, nodes []NodeRef) []NodeRef {
+	if len(nodes) >= 2 && len(mgr.config.fallbackResourcePoolsFor(nodegroup)) > 0 {
+		nodes = mgr.preferFallbackPlaced(nodegroup, nodes)
+	}
+
+	policy := mgr.config.scaleDownPlacement()
+	if policy == "" || len(nodes) < 2 {
+		return mgr.preferOveraged(nodegroup, nodes)
+	}
+
+	ctx := context.TODO()
+	refs, err := mgr.nodeGroupVMs(ctx, nodegroup)
+	if err != nil {
+		klog.Warningf("Could not rank deletion candidates for %s: %v", nodegroup, err)
+		return mgr.preferOveraged(nodegroup, nodes)
+	}
+	vms, err := mgr.retrieveVMProperties(ctx, refs, []string{"name", "runtime.host"})
+	if err != nil {
+		klog.Warningf("Could not rank deletion candidates for %s: %v", nodegroup, err)
+		return mgr.preferOveraged(nodegroup, nodes)
+	}
+
+	hostByVM := make(map[string]string)
+	var hostRefs []types.ManagedObjectReference
+	loadByHost := make(map[string]int)
+	for _, vm := range vms {
+		if vm.Runtime.Host == nil {
+			continue
+		}
+		hostByVM[vm.Name] = vm.Runtime.Host.Value
+		if loadByHost[vm.Runtime.Host.Value] == 0 {
+			hostRefs = append(hostRefs, *vm.Runtime.Host)
+		}
+		loadByHost[vm.Runtime.Host.Value]++
+	}
+
+	// Consolidation orders by how many group members a host runs, cheaply
+	// approximating "emptiest host"; relieving contention orders by the
+	// hosts' actual CPU and memory demand, the signals DRS balances on.
+	less := func(hostI, hostJ string) bool {
+		return loadByHost[hostI] < loadByHost[hostJ]
+	}
+	if policy == scaleDownPlacementRelieve {
+		fractions, err := mgr.hostLoadFractions(ctx, hostRefs)
+		if err != nil {
+			klog.Warningf("Could not rank deletion candidates for %s: %v", nodegroup, err)
+			return mgr.preferOveraged(nodegroup, nodes)
+		}
+		less = func(hostI, hostJ string) bool {
+			return fractions[hostI] > fractions[hostJ]
+		}
+	}
+
+	ranked := make([]NodeRef, len(nodes))
+	copy(ranked, nodes)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		hostI, okI := hostByVM[ranked[i].Name]
+		hostJ, okJ := hostByVM[ranked[j].Name]
+		if !okI || !okJ {
+			return okI
+		}
+		return less(hostI, hostJ)
+	})
+
+	klog.V(3).Infof("Ranked deletion candidates for %s by host load (%s): %v", nodegroup, policy, ranked)
+	// Age-based recycling trumps the placement preferences: an over-age
+	// node should go first even from a busy host.
+	return mgr.preferOveraged(nodegroup, ranked)
+}
+
+// idleDeletionCandidates picks the VMs to remove when the target size of a
+// node group is decreased without naming specific nodes. Candidates are
+// ordered by the cluster-autoscaler.created-at stamp set at clone time, per
+// the group's decrease policy; VMs without the stamp (adopted ones) count
+// as the oldest.
+func (mgr *vsphereManagerGovmomi) idleDeletionCandidates(nodegroup string, count int) ([]NodeRef, error) {
+	ctx := context.TODO()
+	refs, err := mgr.nodeGroupVMs(ctx, nodegroup)
+	if err != nil {
+		return nil, fmt.Errorf("could not list node group VMs: %v", err)
+	}
+	vms, err := mgr.retrieveVMProperties(ctx, refs, []string{"name", "config.extraConfig"})
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		name      string
+		createdAt string
+		template  string
+	}
+	candidates := make([]candidate, 0, len(vms))
+	for _, vm := range vms {
+		created, template := "", ""
+		if vm.Config != nil {
+			for _, option := range vm.Config.ExtraConfig {
+				value := option.GetOptionValue()
+				switch value.Key {
+				case "cluster-autoscaler.created-at":
+					created, _ = value.Value.(string)
+				case templateStampKey:
+					template, _ = value.Value.(string)
+				}
+			}
+		}
+		candidates = append(candidates, candidate{name: vm.Name, createdAt: created, template: template})
+	}
+	// RFC3339 timestamps sort chronologically as strings; ties fall back to
+	// the VM name to keep the order stable.
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].createdAt != candidates[j].createdAt {
+			return candidates[i].createdAt < candidates[j].createdAt
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	if mgr.config.decreasePolicyFor(nodegroup) == decreasePolicyNewestFirst {
+		for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		}
+	}
+
+	// During a weighted rollout, retire VMs of the outgoing template first
+	// so scale-downs advance the rollout instead of eating the new image.
+	// The decrease policy still orders within each version.
+	if rolloutPattern, fraction := mgr.config.rolloutFor(nodegroup); rolloutPattern != "" && fraction > 0 {
+		rollout := rolloutPattern
+		if isTemplatePattern(rolloutPattern) && mgr.activeClient() != nil {
+			if finder, err := mgr.finder(ctx); err == nil {
+				if resolved, err := mgr.templates.resolve(ctx, mgr, finder, rolloutPattern); err == nil {
+					rollout = resolved
+				}
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].template != rollout && candidates[j].template == rollout
+		})
+	}
+
+	if count > len(candidates) {
+		count = len(candidates)
+	}
+	victims := make([]NodeRef, 0, count)
+	for _, c := range candidates[:count] {
+		victims = append(victims, NodeRef{Name: c.name})
+	}
+	return victims, nil
+}
+
+// propertyRetrievalChunk bounds how many VMs a single property collector
+// call retrieves.
+const propertyRetrievalChunk = 500
+
+// retrieveVMProperties fetches the requested properties of the given VMs,
+// in batches of propertyRetrievalChunk.
+func (mgr *vsphereManagerGovmomi) retrieveVMProperties(ctx context.Context, refs []types.ManagedObjectReference, props []string) ([]mo.VirtualMachine, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	var vms []mo.VirtualMachine
+	pc := property.DefaultCollector(mgr.activeClient().Vim)
+	// Retrieved in chunks: one call for tens of thousands of VMs can blow
+	// past vCenter's response limits and time out on large inventories.
+	for start := 0; start < len(refs); start += propertyRetrievalChunk {
+		end := start + propertyRetrievalChunk
+		if end > len(refs) {
+			end = len(refs)
+		}
+		var chunk []mo.VirtualMachine
+		if err := pc.Retrieve(ctx, refs[start:end], props, &chunk); err != nil {
+			return nil, fmt.Errorf("could not retrieve VM properties: %v", err)
+		}
+		vms = append(vms, chunk...)
+	}
+	return vms, nil
+}
+
+// goneNodes cross-checks the cluster's registered nodes against the vCenter
+// inventory and returns the names of nodes whose providerID points at a VM
+// that no longer exists, e.g. after a manual delete in vCenter. Requires
+// state persistence for the Kubernetes client; without it (or on lookup
+// errors) the check is skipped.
+func (mgr *vsphereManagerGovmomi) goneNodes() []string {
+	if mgr.state == nil {
+		return nil
+	}
+	ctx := context.TODO()
+
+	clusterTagID, err := mgr.activeTags().GetTagID(ctx, mgr.clusterTagName())
+	if err != nil {
+		klog.Warningf("Gone node check skipped, could not get cluster tag: %v", err)
+		return nil
+	}
+	refs, err := mgr.activeTags().ListAttachedObjects(ctx, clusterTagID)
+	if err != nil {
+		klog.Warningf("Gone node check skipped, could not list cluster VMs: %v", err)
+		return nil
+	}
+	var vmRefs []types.ManagedObjectReference
+	for _, ref := range refs {
+		if ref.Reference().Type == "VirtualMachine" {
+			vmRefs = append(vmRefs, ref.Reference())
+		}
+	}
+	// VMs of folder and resource pool membership groups carry no cluster
+	// tag; list them separately so they are not falsely reported gone.
+	for name := range mgr.config.NodeGroup {
+		if mgr.config.membershipFor(name) == membershipTags {
+			continue
+		}
+		groupRefs, err := mgr.nodeGroupVMs(ctx, name)
+		if err != nil {
+			klog.Warningf("Gone node check skipped, could not list node group %s: %v", name, err)
+			return nil
+		}
+		vmRefs = append(vmRefs, groupRefs...)
+	}
+	vms, err := mgr.retrieveVMProperties(ctx, vmRefs, []string{"config.uuid"})
+	if err != nil {
+		klog.Warningf("Gone node check skipped: %v", err)
+		return nil
+	}
+	existing := make(map[string]bool)
+	for _, vm := range vms {
+		if vm.Config != nil {
+			existing[mgr.config.providerID(vm.Config.Uuid, vm.Self)] = true
+		}
+	}
+
+	nodes, err := mgr.state.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("Gone node check skipped, could not list nodes: %v", err)
+		return nil
+	}
+
+	var gone []string
+	prefix := providerIDPrefix(mgr.config.providerIDFormat())
+	for _, node := range nodes.Items {
+		providerID := node.Spec.ProviderID
+		if !strings.HasPrefix(providerID, prefix) || strings.HasPrefix(providerID, "vsphere://pending-") {
+			continue
+		}
+		if existing[providerID] {
+			continue
+		}
+		klog.Warningf("Node %s (%s) has no backing VM in vCenter, reporting it as gone", node.Name, providerID)
+		// Drop any stale task record so no placeholder instance keeps the
+		// node alive from the core's point of view.
+		mgr.tasks.forget(node.Name)
+		gone = append(gone, node.Name)
+	}
+	return gone
+}
+
+// getNodes returns the instances of a node group: one per tagged VM, with
+// status derived from any outstanding vCenter task, plus placeholders for
+// clones that have not produced a usable VM yet.
+func (mgr *vsphereManagerGovmomi) getNodes(nodegroup string) ([]cloudprovider.Instance, error) {
+	ctx := context.TODO()
+	refs, err := mgr.nodeGroupVMs(ctx, nodegroup)
+	if err != nil {
+		return nil, err
+	}
+	vms, err := mgr.retrieveVMProperties(ctx, refs, []string{"name", "config.uuid"})
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []cloudprovider.Instance
+	seen := make(map[string]bool)
+	for _, vm := range vms {
+		if vm.Config == nil {
+			continue
+		}
+		seen[vm.Name] = true
+		status := mgr.tasks.instanceStatus(vm.Name)
+		if mgr.prober != nil && status != nil && status.ErrorInfo == nil && status.State == cloudprovider.InstanceRunning {
+			// The clone succeeded, but the node only counts as provisioned
+			// once the guest probe has seen its kubelet running.
+			if !mgr.prober.kubeletActive(vm.Name, vm.Self) {
+				status.State = cloudprovider.InstanceCreating
+			}
+		}
+		instances = append(instances, cloudprovider.Instance{
+			Id:     mgr.config.providerID(vm.Config.Uuid, vm.Self),
+			Status: status,
+		})
+	}
+
+	// Clones still in flight (or failed) have no tagged VM yet; report them
+	// as placeholder instances so the core sees the creation and any error.
+	for _, pending := range mgr.tasks.pendingClones(nodegroup) {
+		if seen[pending.nodeName] {
+			continue
+		}
+		seen[pending.nodeName] = true
+		instances = append(instances, cloudprovider.Instance{
+			Id:     fmt.Sprintf("vsphere://pending-%s", pending.nodeName),
+			Status: mgr.tasks.instanceStatus(pending.nodeName),
+		})
+	}
+
+	// Clones waiting in the creation queue have no vCenter task yet either;
+	// report them as creating so the core does not retry the scale-up.
+	for _, name := range mgr.creations.queuedNodes(nodegroup) {
+		if seen[name] {
+			continue
+		}
+		instances = append(instances, cloudprovider.Instance{
+			Id:     fmt.Sprintf("vsphere://pending-%s", name),
+			Status: &cloudprovider.InstanceStatus{State: cloudprovider.InstanceCreating},
+		})
+	}
+	return instances, nil
+}
+
+// getNodeNames returns the names of all VMs in a node group.
+func (mgr *vsphereManagerGovmomi) getNodeNames(nodegroup string) ([]string, error) {
+	ctx := context.TODO()
+	refs, err := mgr.nodeGroupVMs(ctx, nodegroup)
+	if err != nil {
+		return nil, err
+	}
+	vms, err := mgr.retrieveVMProperties(ctx, refs, []string{"name"})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, vm := range vms {
+		names = append(names, vm.Name)
+	}
+	return names, nil
+}
+
+// nodeGroupStatus returns the in-flight operation counts and last error for a node group.
+func (mgr *vsphereManagerGovmomi) nodeGroupStatus(nodegroup string) groupStatus {
+	status := mgr.tracker(nodegroup).status()
+	status.cooldown = mgr.provisioning.remaining(nodegroup)
+	return status
+}
+
+// templateNodeInfo returns a NodeInfo with a node template based on the
+// hardware of the node group's VM template and the configured labels and taints.
+func (mgr *vsphereManagerGovmomi) templateNodeInfo(nodegroup string) (*schedulernodeinfo.NodeInfo, error) {
+	ctx := context.TODO()
+
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		return nil, err
+	}
+	templateName, err := mgr.resolveTemplate(ctx, finder, nodegroup)
+	if err != nil {
+		return nil, err
+	}
+	template, err := finder.VirtualMachine(ctx, templateName)
+	if err != nil {
+		return nil, fmt.Errorf("could not find template %s: %v", templateName, err)
+	}
+	vms, err := mgr.retrieveVMProperties(ctx, []types.ManagedObjectReference{template.Reference()}, []string{"config.hardware"})
+	if err != nil {
+		return nil, err
+	}
+	if len(vms) != 1 || vms[0].Config == nil {
+		return nil, fmt.Errorf("could not get hardware of template %s", templateName)
+	}
+	hardware := vms[0].Config.Hardware
+
+	// The template's disk capacity is what a clone's ephemeral storage
+	// comes from, minus the configured system reserve.
+	var diskBytes int64
+	for _, device := range hardware.Device {
+		if disk, ok := device.(*types.VirtualDisk); ok {
+			diskBytes += disk.CapacityInKB * 1024
+		}
+	}
+	// Extra data disks are created with the clone, so they are not part of
+	// the template's hardware but do count towards ephemeral storage.
+	extraBytes, err := mgr.config.extraDiskBytes(nodegroup)
+	if err != nil {
+		return nil, err
+	}
+	diskBytes += extraBytes
+	gpus := templateGPUCount(hardware.Device)
+
+	node, err := mgr.buildNodeFromTemplate(nodegroup, int64(hardware.NumCPU), int64(hardware.MemoryMB), diskBytes, gpus)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeInfo := schedulernodeinfo.NewNodeInfo(cloudprovider.BuildKubeProxy(nodegroup))
+	nodeInfo.SetNode(node)
+	return nodeInfo, nil
+}
+
+// buildNodeFromTemplate builds the template node the scheduler simulation
+// uses to decide whether pending pods would fit a new node of this group.
+func (mgr *vsphereManagerGovmomi) buildNodeFromTemplate(nodegroup string, numCPU, memoryMB, diskBytes, gpus int64) (*apiv1.Node, error) {
+	node := apiv1.Node{}
+	nodeName := fmt.Sprintf("%s-template-%d", nodegroup, rand.Int63())
+
+	node.ObjectMeta = metav1.ObjectMeta{
+		Name:     nodeName,
+		SelfLink: fmt.Sprintf("/api/v1/nodes/%s", nodeName),
+		Labels:   map[string]string{},
+	}
+
+	node.Status = apiv1.NodeStatus{
+		Capacity: apiv1.ResourceList{},
+	}
+	node.Status.Capacity[apiv1.ResourcePods] = *resource.NewQuantity(mgr.config.maxPodsFor(nodegroup), resource.DecimalSI)
+	node.Status.Capacity[apiv1.ResourceCPU] = *resource.NewQuantity(numCPU, resource.DecimalSI)
+	node.Status.Capacity[apiv1.ResourceMemory] = *resource.NewQuantity(memoryMB*1024*1024, resource.DecimalSI)
+	if diskBytes > 0 {
+		storageBytes, err := mgr.ephemeralStorageBytes(nodegroup, diskBytes)
+		if err != nil {
+			return nil, err
+		}
+		node.Status.Capacity[apiv1.ResourceEphemeralStorage] = *resource.NewQuantity(storageBytes, resource.DecimalSI)
+	}
+	if gpus > 0 {
+		node.Status.Capacity[gpu.ResourceNvidiaGPU] = *resource.NewQuantity(gpus, resource.DecimalSI)
+		node.Labels[GPULabel] = "true"
+	}
+
+	allocatable, err := mgr.nodeAllocatable(nodegroup, node.Status.Capacity)
+	if err != nil {
+		return nil, err
+	}
+	node.Status.Allocatable = allocatable
+
+	labels, err := mgr.config.labelsFor(nodegroup)
+	if err != nil {
+		return nil, fmt.Errorf("invalid labels for node group %s: %v", nodegroup, err)
+	}
+	taints, err := mgr.config.taintsFor(nodegroup)
+	if err != nil {
+		return nil, fmt.Errorf("invalid taints for node group %s: %v", nodegroup, err)
+	}
+	node.Labels = cloudprovider.JoinStringMaps(node.Labels, labels, buildGenericLabels(nodegroup, mgr.config.templateFor(nodegroup), nodeName, mgr.config.guestOSFor(nodegroup)),
+		zoneLabels(mgr.config.zoneFor(nodegroup), mgr.config.Global.Region))
+	node.Spec.Taints = taints
+
+	node.Status.Conditions = cloudprovider.BuildReadyConditions()
+	return &node, nil
+}
+
+// ephemeralStorageBytes derives the ephemeral storage capacity advertised
+// for a node group from its template's disk capacity, minus the configured
+// system reserve.
+func (mgr *vsphereManagerGovmomi) ephemeralStorageBytes(nodegroup string, diskBytes int64) (int64, error) {
+	reserve := mgr.config.ephemeralStorageReserveFor(nodegroup)
+	if reserve == "" {
+		return diskBytes, nil
+	}
+	quantity, err := resource.ParseQuantity(reserve)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ephemeral-storage-reserve %s for node group %s: %v", reserve, nodegroup, err)
+	}
+	storageBytes := diskBytes - quantity.Value()
+	if storageBytes < 0 {
+		storageBytes = 0
+	}
+	return storageBytes, nil
+}
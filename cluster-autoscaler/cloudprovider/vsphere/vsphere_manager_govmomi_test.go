@@ -0,0 +1,362 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// fakeTagService is an in-memory tagService, standing in for vCenter's
+// tagging API until the govmomi simulator is vendored.
+type fakeTagService struct {
+	// tags maps tag names to IDs.
+	tags map[string]string
+	// categories maps category names to IDs.
+	categories map[string]string
+	// attachments maps tag IDs to attached objects.
+	attachments map[string][]mo.Reference
+}
+
+func newFakeTagService(tagNames ...string) *fakeTagService {
+	f := &fakeTagService{
+		tags:        make(map[string]string),
+		categories:  make(map[string]string),
+		attachments: make(map[string][]mo.Reference),
+	}
+	for i, name := range tagNames {
+		f.tags[name] = fmt.Sprintf("tag-%d", i)
+	}
+	return f
+}
+
+func (f *fakeTagService) GetTagID(ctx context.Context, tagName string) (string, error) {
+	if id, ok := f.tags[tagName]; ok {
+		return id, nil
+	}
+	return "", &TagNotFoundError{Name: tagName}
+}
+
+func (f *fakeTagService) AttachTag(ctx context.Context, tagID string, ref mo.Reference) error {
+	if tagID == "" {
+		return fmt.Errorf("no such tag")
+	}
+	f.attachments[tagID] = append(f.attachments[tagID], ref)
+	return nil
+}
+
+func (f *fakeTagService) DetachTag(ctx context.Context, tagID string, ref mo.Reference) error {
+	var kept []mo.Reference
+	for _, attached := range f.attachments[tagID] {
+		if attached.Reference() != ref.Reference() {
+			kept = append(kept, attached)
+		}
+	}
+	f.attachments[tagID] = kept
+	return nil
+}
+
+func (f *fakeTagService) ListAttachedObjects(ctx context.Context, tagID string) ([]mo.Reference, error) {
+	return f.attachments[tagID], nil
+}
+
+func (f *fakeTagService) GetCategoryID(ctx context.Context, categoryName string) (string, error) {
+	return f.categories[categoryName], nil
+}
+
+func (f *fakeTagService) CreateCategory(ctx context.Context, categoryName, cardinality string, associableTypes []string) (string, error) {
+	id := fmt.Sprintf("category-%d", len(f.categories))
+	f.categories[categoryName] = id
+	return id, nil
+}
+
+func (f *fakeTagService) CreateTag(ctx context.Context, tagName, categoryID string) (string, error) {
+	id := fmt.Sprintf("tag-%d", len(f.tags))
+	f.tags[tagName] = id
+	return id, nil
+}
+
+func vmRef(id string) types.ManagedObjectReference {
+	return types.ManagedObjectReference{Type: "VirtualMachine", Value: id}
+}
+
+func testManager(tags tagService) *vsphereManagerGovmomi {
+	return &vsphereManagerGovmomi{
+		tags:        tags,
+		clusterName: "test",
+		config: ConfigVsphere{
+			NodeGroup: map[string]*ConfigNodeGroup{},
+		},
+	}
+}
+
+func TestNodeGroupSize(t *testing.T) {
+	ctx := context.TODO()
+
+	for _, tc := range []struct {
+		name      string
+		nodegroup string
+		cluster   []types.ManagedObjectReference
+		group     map[string][]types.ManagedObjectReference
+		expected  int
+	}{
+		{
+			name:      "counts VMs with both tags",
+			nodegroup: "pool-a",
+			cluster:   []types.ManagedObjectReference{vmRef("vm-1"), vmRef("vm-2"), vmRef("vm-3")},
+			group: map[string][]types.ManagedObjectReference{
+				"pool-a": {vmRef("vm-1"), vmRef("vm-3")},
+				"pool-b": {vmRef("vm-2")},
+			},
+			expected: 2,
+		},
+		{
+			name:      "group tag alone does not count",
+			nodegroup: "pool-a",
+			cluster:   []types.ManagedObjectReference{vmRef("vm-1")},
+			group: map[string][]types.ManagedObjectReference{
+				"pool-a": {vmRef("vm-1"), vmRef("vm-9")},
+			},
+			expected: 1,
+		},
+		{
+			name:      "empty group",
+			nodegroup: "pool-empty",
+			cluster:   []types.ManagedObjectReference{vmRef("vm-1")},
+			group: map[string][]types.ManagedObjectReference{
+				// The tag exists but nothing carries it; a missing tag
+				// would be a TagNotFound error instead.
+				"pool-empty": {},
+			},
+			expected: 0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tagNames := []string{clusterTagPrefix + "test"}
+			for group := range tc.group {
+				tagNames = append(tagNames, nodeGroupTagPrefix+group)
+			}
+			tags := newFakeTagService(tagNames...)
+			mgr := testManager(tags)
+
+			clusterTagID, _ := tags.GetTagID(ctx, clusterTagPrefix+"test")
+			for _, ref := range tc.cluster {
+				assert.NoError(t, tags.AttachTag(ctx, clusterTagID, ref))
+			}
+			for group, refs := range tc.group {
+				groupTagID, _ := tags.GetTagID(ctx, nodeGroupTagPrefix+group)
+				for _, ref := range refs {
+					assert.NoError(t, tags.AttachTag(ctx, groupTagID, ref))
+				}
+			}
+
+			size, err := mgr.nodeGroupSize(tc.nodegroup)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, size)
+		})
+	}
+}
+
+func TestControlPlaneExclusion(t *testing.T) {
+	ctx := context.TODO()
+	tags := newFakeTagService(clusterTagPrefix+"test", nodeGroupTagPrefix+"pool-a", roleControlPlaneTag)
+	mgr := testManager(tags)
+
+	clusterTagID, _ := tags.GetTagID(ctx, clusterTagPrefix+"test")
+	groupTagID, _ := tags.GetTagID(ctx, nodeGroupTagPrefix+"pool-a")
+	roleTagID, _ := tags.GetTagID(ctx, roleControlPlaneTag)
+
+	// A worker and a control-plane VM, both carrying the cluster and the
+	// node group tag; the control-plane VM must not count.
+	for _, id := range []string{clusterTagID, groupTagID} {
+		assert.NoError(t, tags.AttachTag(ctx, id, vmRef("worker-1")))
+		assert.NoError(t, tags.AttachTag(ctx, id, vmRef("cp-1")))
+	}
+	assert.NoError(t, tags.AttachTag(ctx, roleTagID, vmRef("cp-1")))
+
+	size, err := mgr.nodeGroupSize("pool-a")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+}
+
+func TestRefreshInventory(t *testing.T) {
+	ctx := context.TODO()
+	tags := newFakeTagService(clusterTagPrefix+"test", nodeGroupTagPrefix+"pool-a")
+	mgr := testManager(tags)
+
+	clusterTagID, _ := tags.GetTagID(ctx, clusterTagPrefix+"test")
+	groupTagID, _ := tags.GetTagID(ctx, nodeGroupTagPrefix+"pool-a")
+	assert.NoError(t, tags.AttachTag(ctx, clusterTagID, vmRef("vm-1")))
+	assert.NoError(t, tags.AttachTag(ctx, groupTagID, vmRef("vm-1")))
+
+	assert.NoError(t, mgr.refreshInventory([]string{"pool-a"}))
+	size, err := mgr.nodeGroupSize("pool-a")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+
+	// Lookups are served from the snapshot until it is invalidated.
+	assert.NoError(t, tags.AttachTag(ctx, clusterTagID, vmRef("vm-2")))
+	assert.NoError(t, tags.AttachTag(ctx, groupTagID, vmRef("vm-2")))
+	size, _ = mgr.nodeGroupSize("pool-a")
+	assert.Equal(t, 1, size)
+
+	mgr.invalidateInventory()
+	size, _ = mgr.nodeGroupSize("pool-a")
+	assert.Equal(t, 2, size)
+}
+
+func TestTagNode(t *testing.T) {
+	ctx := context.TODO()
+	tags := newFakeTagService(clusterTagPrefix+"test", nodeGroupTagPrefix+"pool-a")
+	mgr := testManager(tags)
+
+	ref := vmRef("vm-new")
+	assert.NoError(t, mgr.tagNode(ctx, "pool-a", ref))
+
+	// The clone must carry both the cluster and the node group tag to be
+	// counted as a member.
+	size, err := mgr.nodeGroupSize("pool-a")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+
+	// Tagging against a cluster with no tags created must fail rather than
+	// leave the VM half-tagged.
+	mgr = testManager(newFakeTagService())
+	assert.Error(t, mgr.tagNode(ctx, "pool-a", ref))
+}
+
+func TestBootstrapTags(t *testing.T) {
+	ctx := context.TODO()
+	tags := newFakeTagService()
+	mgr := testManager(tags)
+	mgr.config.NodeGroup["pool-a"] = &ConfigNodeGroup{WarmPoolSize: 1}
+	mgr.config.NodeGroup["pool-b"] = &ConfigNodeGroup{}
+
+	assert.NoError(t, mgr.bootstrapTags(ctx))
+	assert.NotEmpty(t, tags.categories[defaultTagCategory])
+	for _, name := range []string{
+		clusterTagPrefix + "test",
+		nodeGroupTagPrefix + "pool-a",
+		nodeGroupTagPrefix + "pool-b",
+		warmPoolTagPrefix + "pool-a",
+	} {
+		id, err := tags.GetTagID(ctx, name)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, id, name)
+	}
+	// pool-b has no warm pool, so it needs no warm pool tag.
+	_, ok := tags.tags[warmPoolTagPrefix+"pool-b"]
+	assert.False(t, ok)
+
+	// Idempotent: a second run creates nothing new.
+	created := len(tags.tags)
+	assert.NoError(t, mgr.bootstrapTags(ctx))
+	assert.Equal(t, created, len(tags.tags))
+}
+
+func TestWindowsCustomization(t *testing.T) {
+	userData, err := ioutil.TempFile("", "user-data")
+	assert.NoError(t, err)
+	defer os.Remove(userData.Name())
+	_, err = userData.WriteString("# bootstrap the kubelet\npowershell C:\\k\\start.ps1 {{kubelet_extra_args}}\n\n")
+	assert.NoError(t, err)
+	assert.NoError(t, userData.Close())
+
+	mgr := testManager(newFakeTagService())
+	mgr.config.NodeGroup["pool-win"] = &ConfigNodeGroup{
+		GuestOS:  guestOSWindows,
+		UserData: userData.Name(),
+		Labels:   "pool=win",
+	}
+
+	spec, err := mgr.windowsCustomization("pool-win", "pool-win-1")
+	assert.NoError(t, err)
+
+	sysprep, ok := spec.Identity.(*types.CustomizationSysprep)
+	if assert.True(t, ok) {
+		assert.Equal(t, &types.CustomizationFixedName{Name: "pool-win-1"}, sysprep.UserData.ComputerName)
+		// Comments and blank lines are dropped, placeholders rendered.
+		if assert.NotNil(t, sysprep.GuiRunOnce) {
+			assert.Equal(t, []string{`powershell C:\k\start.ps1 --node-labels=pool=win`}, sysprep.GuiRunOnce.CommandList)
+		}
+		// First-logon commands need one automatic logon.
+		assert.True(t, sysprep.GuiUnattended.AutoLogon)
+	}
+
+	// Without IPAM the adapter stays on DHCP.
+	if assert.Len(t, spec.NicSettingMap, 1) {
+		assert.IsType(t, &types.CustomizationDhcpIpGenerator{}, spec.NicSettingMap[0].Adapter.Ip)
+	}
+}
+
+func TestRenderUserData(t *testing.T) {
+	mgr := testManager(newFakeTagService())
+	mgr.config.Global.UserDataVars = "registry=mirror.corp,join_url=https://old:6443"
+	mgr.config.NodeGroup["pool-a"] = &ConfigNodeGroup{UserDataVars: "join_url=https://cp:6443"}
+
+	rendered, err := mgr.renderUserData("pool-a", "pool-a-1", "10.0.0.5",
+		"node={{node_name}} group={{nodegroup}} cluster={{cluster_name}} ip={{ip}} registry={{registry}} join={{join_url}}")
+	assert.NoError(t, err)
+	// Node group vars win over the global list.
+	assert.Equal(t, "node=pool-a-1 group=pool-a cluster=test ip=10.0.0.5 registry=mirror.corp join=https://cp:6443", rendered)
+
+	// {{ip}} needs an allocated address.
+	_, err = mgr.renderUserData("pool-a", "pool-a-1", "", "ip={{ip}}")
+	assert.Error(t, err)
+
+	_, err = mgr.renderUserData("pool-a", "pool-a-1", "", "bad={{broken}")
+	assert.NoError(t, err)
+}
+
+func TestAdoptVMs(t *testing.T) {
+	ctx := context.TODO()
+	tags := newFakeTagService(
+		clusterTagPrefix+"test",
+		nodeGroupTagPrefix+"pool-a",
+		adoptTagPrefix+"pool-a",
+	)
+	mgr := testManager(tags)
+	mgr.config.NodeGroup["pool-a"] = &ConfigNodeGroup{}
+
+	// vm-1 already belongs to the cluster, vm-2 and vm-3 are marked for
+	// adoption.
+	assert.NoError(t, tags.AttachTag(ctx, tags.tags[clusterTagPrefix+"test"], vmRef("vm-1")))
+	adoptTagID := tags.tags[adoptTagPrefix+"pool-a"]
+	assert.NoError(t, tags.AttachTag(ctx, adoptTagID, vmRef("vm-1")))
+	assert.NoError(t, tags.AttachTag(ctx, adoptTagID, vmRef("vm-2")))
+	assert.NoError(t, tags.AttachTag(ctx, adoptTagID, vmRef("vm-3")))
+
+	assert.NoError(t, mgr.adoptVMs(ctx, "pool-a"))
+
+	// The new VMs carry both membership tags and lost the adopt tag;
+	// vm-1 was left alone, keeping its adopt tag for the operator to see.
+	groupAttached := tags.attachments[tags.tags[nodeGroupTagPrefix+"pool-a"]]
+	assert.Len(t, groupAttached, 2)
+	assert.NotContains(t, groupAttached, mo.Reference(vmRef("vm-1")))
+	assert.Len(t, tags.attachments[adoptTagID], 1)
+
+	// A second run finds nothing left to adopt.
+	assert.NoError(t, mgr.adoptVMs(ctx, "pool-a"))
+	assert.Len(t, tags.attachments[tags.tags[nodeGroupTagPrefix+"pool-a"]], 2)
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/klog"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+const (
+	// grpcReadTimeout bounds the read-only manager calls; the server
+	// answers those from its caches.
+	grpcReadTimeout = time.Minute
+	// grpcMutateTimeout bounds scale-up, scale-down and refresh calls,
+	// which wait on vCenter tasks on the server side and come with their
+	// own timeouts there.
+	grpcMutateTimeout = time.Hour
+)
+
+// vsphereManagerGrpc is the vsphereManager half of the gRPC split: it
+// forwards every call to a standalone manager process (see ServeGrpc),
+// so the autoscaler image needs none of the vCenter logic or credentials
+// and the manager can be patched and redeployed independently of
+// autoscaler upgrades. It is selected with VSPHERE_MANAGER=grpc and
+// connects to the grpc-endpoint option of the [global] section.
+type vsphereManagerGrpc struct {
+	conn *grpc.ClientConn
+}
+
+// createVsphereManagerGrpc connects to the standalone manager process.
+// Dialing is lazy, so a manager that is down at startup only fails the
+// calls made while it stays down.
+func createVsphereManagerGrpc(cfg ConfigVsphere, _ cloudprovider.NodeGroupDiscoveryOptions, _ config.AutoscalingOptions) (*vsphereManagerGrpc, error) {
+	endpoint := cfg.Global.GrpcEndpoint
+	if endpoint == "" {
+		return nil, fmt.Errorf("the grpc manager needs the grpc-endpoint option in the [global] section")
+	}
+	conn, err := grpc.Dial(endpoint, grpc.WithInsecure(), grpc.WithDefaultCallOptions(grpc.CallCustomCodec(grpcJSONCodec{})))
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to the vsphere manager at %s: %v", endpoint, err)
+	}
+	klog.V(1).Infof("Using the standalone vsphere manager at %s", endpoint)
+	return &vsphereManagerGrpc{conn: conn}, nil
+}
+
+// call invokes one method of the manager service.
+func (mgr *vsphereManagerGrpc) call(method string, req, resp interface{}, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return mgr.conn.Invoke(ctx, "/"+grpcServiceName+"/"+method, req, resp)
+}
+
+func (mgr *vsphereManagerGrpc) nodeGroupSize(nodegroup string) (int, error) {
+	var resp grpcSizeResponse
+	if err := mgr.call("NodeGroupSize", &grpcNodeGroupRequest{NodeGroup: nodegroup}, &resp, grpcReadTimeout); err != nil {
+		return 0, err
+	}
+	return resp.Size, nil
+}
+
+func (mgr *vsphereManagerGrpc) createNodes(nodegroup string, nodes int) error {
+	return mgr.call("CreateNodes", &grpcCreateNodesRequest{NodeGroup: nodegroup, Count: nodes}, &grpcEmpty{}, grpcMutateTimeout)
+}
+
+func (mgr *vsphereManagerGrpc) deleteNodes(nodegroup string, nodes []NodeRef, updatedNodeCount int) error {
+	req := &grpcDeleteNodesRequest{NodeGroup: nodegroup, Nodes: nodes, UpdatedNodeCount: updatedNodeCount}
+	return mgr.call("DeleteNodes", req, &grpcEmpty{}, grpcMutateTimeout)
+}
+
+// rankDeletionCandidates keeps the core's order when the server is
+// unreachable; the ranking is advisory everywhere else too.
+func (mgr *vsphereManagerGrpc) rankDeletionCandidates(nodegroup string, nodes []NodeRef) []NodeRef {
+	var resp grpcNodesResponse
+	if err := mgr.call("RankDeletionCandidates", &grpcNodesRequest{NodeGroup: nodegroup, Nodes: nodes}, &resp, grpcReadTimeout); err != nil {
+		klog.Warningf("Could not rank deletion candidates for %s remotely, keeping the given order: %v", nodegroup, err)
+		return nodes
+	}
+	return resp.Nodes
+}
+
+func (mgr *vsphereManagerGrpc) idleDeletionCandidates(nodegroup string, count int) ([]NodeRef, error) {
+	var resp grpcNodesResponse
+	if err := mgr.call("IdleDeletionCandidates", &grpcIdleCandidatesRequest{NodeGroup: nodegroup, Count: count}, &resp, grpcReadTimeout); err != nil {
+		return nil, err
+	}
+	return resp.Nodes, nil
+}
+
+func (mgr *vsphereManagerGrpc) getNodes(nodegroup string) ([]cloudprovider.Instance, error) {
+	var resp grpcInstancesResponse
+	if err := mgr.call("Nodes", &grpcNodeGroupRequest{NodeGroup: nodegroup}, &resp, grpcReadTimeout); err != nil {
+		return nil, err
+	}
+	return resp.Instances, nil
+}
+
+func (mgr *vsphereManagerGrpc) getNodeNames(nodegroup string) ([]string, error) {
+	var resp grpcNamesResponse
+	if err := mgr.call("NodeNames", &grpcNodeGroupRequest{NodeGroup: nodegroup}, &resp, grpcReadTimeout); err != nil {
+		return nil, err
+	}
+	return resp.Names, nil
+}
+
+func (mgr *vsphereManagerGrpc) nodeGroupStatus(nodegroup string) groupStatus {
+	var resp grpcStatusResponse
+	if err := mgr.call("NodeGroupStatus", &grpcNodeGroupRequest{NodeGroup: nodegroup}, &resp, grpcReadTimeout); err != nil {
+		return groupStatus{lastError: err}
+	}
+	status := groupStatus{
+		pendingClones:  resp.PendingClones,
+		pendingDeletes: resp.PendingDeletes,
+		cooldown:       resp.Cooldown,
+	}
+	if resp.LastError != "" {
+		status.lastError = errors.New(resp.LastError)
+	}
+	return status
+}
+
+func (mgr *vsphereManagerGrpc) templateNodeInfo(nodegroup string) (*schedulernodeinfo.NodeInfo, error) {
+	var resp grpcNodeInfoResponse
+	if err := mgr.call("TemplateNodeInfo", &grpcNodeGroupRequest{NodeGroup: nodegroup}, &resp, grpcReadTimeout); err != nil {
+		return nil, err
+	}
+	if resp.Node == nil {
+		return nil, fmt.Errorf("the manager returned no template node for %s", nodegroup)
+	}
+	nodeInfo := schedulernodeinfo.NewNodeInfo(cloudprovider.BuildKubeProxy(nodegroup))
+	nodeInfo.SetNode(resp.Node)
+	return nodeInfo, nil
+}
+
+func (mgr *vsphereManagerGrpc) targetSizeChanged(nodegroup string, size int) {
+	if err := mgr.call("TargetSizeChanged", &grpcTargetSizeRequest{NodeGroup: nodegroup, Size: size}, &grpcEmpty{}, grpcReadTimeout); err != nil {
+		klog.Warningf("Could not report the new target size of %s to the manager: %v", nodegroup, err)
+	}
+}
+
+func (mgr *vsphereManagerGrpc) nodeGroupDefs() ([]nodeGroupDef, bool, error) {
+	var resp grpcDefsResponse
+	if err := mgr.call("NodeGroupDefs", &grpcEmpty{}, &resp, grpcReadTimeout); err != nil {
+		return nil, false, err
+	}
+	var defs []nodeGroupDef
+	for _, def := range resp.Defs {
+		defs = append(defs, nodeGroupDef{name: def.Name, minSize: def.MinSize, maxSize: def.MaxSize})
+	}
+	return defs, resp.Enabled, nil
+}
+
+func (mgr *vsphereManagerGrpc) nodeGroupPriority(nodegroup string) int {
+	var resp grpcPriorityResponse
+	if err := mgr.call("NodeGroupPriority", &grpcNodeGroupRequest{NodeGroup: nodegroup}, &resp, grpcReadTimeout); err != nil {
+		klog.Warningf("Could not read the priority of %s from the manager: %v", nodegroup, err)
+		return 0
+	}
+	return resp.Priority
+}
+
+func (mgr *vsphereManagerGrpc) restoredTargetSize(nodegroup string) (int, bool) {
+	var resp grpcRestoredSizeResponse
+	if err := mgr.call("RestoredTargetSize", &grpcNodeGroupRequest{NodeGroup: nodegroup}, &resp, grpcReadTimeout); err != nil {
+		klog.Warningf("Could not read the restored target size of %s from the manager: %v", nodegroup, err)
+		return 0, false
+	}
+	return resp.Size, resp.Restored
+}
+
+func (mgr *vsphereManagerGrpc) autoscalingOptions(nodegroup string) nodeGroupAutoscalingOptions {
+	var resp grpcOptionsResponse
+	if err := mgr.call("AutoscalingOptions", &grpcNodeGroupRequest{NodeGroup: nodegroup}, &resp, grpcReadTimeout); err != nil {
+		klog.Warningf("Could not read the autoscaling options of %s from the manager: %v", nodegroup, err)
+		return nodeGroupAutoscalingOptions{}
+	}
+	return resp.Options
+}
+
+// refreshInventory implements inventoryRefresher by forwarding the
+// provider's per-loop refresh to the server, which runs its own refresh
+// hooks there; see handleRefresh.
+func (mgr *vsphereManagerGrpc) refreshInventory(nodegroups []string) error {
+	return mgr.call("Refresh", &grpcRefreshRequest{NodeGroups: nodegroups}, &grpcEmpty{}, grpcMutateTimeout)
+}
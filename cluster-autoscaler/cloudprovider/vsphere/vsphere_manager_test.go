@@ -0,0 +1,117 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+)
+
+func TestParseCloudConfig(t *testing.T) {
+	gcfgConfig := `
+# classic INI config
+[global]
+vcenter = vcenter.example.com
+datacenter = dc-1
+
+[nodegroup "pool-a"]
+template = ubuntu-template
+priority = 5
+`
+	yamlConfig := `
+global:
+  vcenter: vcenter.example.com
+  datacenter: dc-1
+nodegroups:
+  pool-a:
+    template: ubuntu-template
+    priority: 5
+`
+	jsonConfig := `
+{
+  "global": {"vcenter": "vcenter.example.com", "datacenter": "dc-1"},
+  "nodegroups": {"pool-a": {"template": "ubuntu-template", "priority": 5}}
+}
+`
+
+	for _, tc := range []struct {
+		name    string
+		content string
+	}{
+		{name: "gcfg", content: gcfgConfig},
+		{name: "yaml", content: yamlConfig},
+		{name: "json", content: jsonConfig},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := parseCloudConfig([]byte(tc.content))
+			assert.NoError(t, err)
+			assert.Equal(t, "vcenter.example.com", cfg.Global.VCenter)
+			assert.Equal(t, "dc-1", cfg.Global.Datacenter)
+			if assert.Contains(t, cfg.NodeGroup, "pool-a") {
+				assert.Equal(t, "ubuntu-template", cfg.NodeGroup["pool-a"].Template)
+				assert.Equal(t, 5, cfg.NodeGroup["pool-a"].Priority)
+			}
+		})
+	}
+}
+
+func TestParseCloudConfigInvalid(t *testing.T) {
+	_, err := parseCloudConfig([]byte("[global]\nno-such-option = x\n"))
+	assert.Error(t, err)
+
+	_, err = parseCloudConfig([]byte("global: [unclosed\n"))
+	assert.Error(t, err)
+}
+
+func TestResolveClusterName(t *testing.T) {
+	withCfg := ConfigVsphere{}
+	withCfg.Global.ClusterName = "from-config"
+	flagOpts := config.AutoscalingOptions{ClusterName: "from-flag"}
+
+	tests := []struct {
+		name    string
+		cfg     ConfigVsphere
+		opts    config.AutoscalingOptions
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{name: "nothing set", wantErr: true},
+		{name: "config only", cfg: withCfg, want: "from-config"},
+		{name: "env only", env: "from-env", want: "from-env"},
+		{name: "flag only", opts: flagOpts, want: "from-flag"},
+		{name: "env beats config", cfg: withCfg, env: "from-env", want: "from-env"},
+		{name: "flag beats config", cfg: withCfg, opts: flagOpts, want: "from-flag"},
+		{name: "flag beats env", opts: flagOpts, env: "from-env", want: "from-flag"},
+		{name: "flag beats both", cfg: withCfg, opts: flagOpts, env: "from-env", want: "from-flag"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("VSPHERE_CLUSTER_NAME", tc.env)
+			got, err := resolveClusterName(tc.cfg, tc.opts)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,319 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/vmware/govmomi/vim25/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+const (
+	// vmServiceClusterLabel and vmServiceNodeGroupLabel track which cluster
+	// and node group a VirtualMachine resource belongs to, taking the role
+	// the vSphere tags play in the govmomi manager.
+	vmServiceClusterLabel   = "cluster-autoscaler.vsphere/cluster"
+	vmServiceNodeGroupLabel = "cluster-autoscaler.vsphere/nodegroup"
+)
+
+// vmServiceResource locates the VirtualMachine resources of the vSphere
+// with Tanzu VM Service in a Supervisor cluster.
+var vmServiceResource = schema.GroupVersionResource{
+	Group:    "vmoperator.vmware.com",
+	Version:  "v1alpha1",
+	Resource: "virtualmachines",
+}
+
+// vsphereManagerVMService implements the vsphereManager interface on top of
+// the vSphere with Tanzu VM Service: nodes are VirtualMachine resources in
+// a Supervisor cluster namespace and the VM Service controllers own the
+// actual vCenter operations. No vCenter credentials are required, which
+// suits workload clusters that are only granted a Supervisor namespace.
+type vsphereManagerVMService struct {
+	client      dynamic.Interface
+	config      ConfigVsphere
+	clusterName string
+	namespace   string
+
+	trackersMutex sync.Mutex
+	trackers      map[string]*groupTracker
+}
+
+// createVsphereManagerVMService builds a manager driving VM Service
+// VirtualMachine resources through the dynamic Kubernetes client.
+func createVsphereManagerVMService(cfg ConfigVsphere, discoverOpts cloudprovider.NodeGroupDiscoveryOptions, opts config.AutoscalingOptions) (*vsphereManagerVMService, error) {
+	clusterName, err := resolveClusterName(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Global.VMNamespace == "" {
+		return nil, fmt.Errorf("the vmservice manager requires vm-namespace to be set")
+	}
+
+	restConfig, err := clientcmd.BuildConfigFromFlags("", opts.KubeConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not build kubernetes client config: %v", err)
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not create dynamic client: %v", err)
+	}
+
+	return &vsphereManagerVMService{
+		client:      client,
+		config:      cfg,
+		clusterName: clusterName,
+		namespace:   cfg.Global.VMNamespace,
+		trackers:    make(map[string]*groupTracker),
+	}, nil
+}
+
+// tracker returns the operation tracker for a node group, creating it if needed.
+func (mgr *vsphereManagerVMService) tracker(nodegroup string) *groupTracker {
+	mgr.trackersMutex.Lock()
+	defer mgr.trackersMutex.Unlock()
+	t, ok := mgr.trackers[nodegroup]
+	if !ok {
+		t = &groupTracker{}
+		mgr.trackers[nodegroup] = t
+	}
+	return t
+}
+
+// listVMs returns the VirtualMachine resources of a node group, matched by
+// the cluster and node group labels.
+func (mgr *vsphereManagerVMService) listVMs(ctx context.Context, nodegroup string) ([]unstructured.Unstructured, error) {
+	selector := fmt.Sprintf("%s=%s,%s=%s", vmServiceClusterLabel, mgr.clusterName, vmServiceNodeGroupLabel, nodegroup)
+	list, err := mgr.client.Resource(vmServiceResource).Namespace(mgr.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("could not list VirtualMachines of node group %s: %v", nodegroup, err)
+	}
+	return list.Items, nil
+}
+
+// nodeGroupSize counts the VirtualMachine resources carrying the node
+// group's labels.
+func (mgr *vsphereManagerVMService) nodeGroupSize(nodegroup string) (int, error) {
+	vms, err := mgr.listVMs(context.TODO(), nodegroup)
+	if err != nil {
+		return 0, err
+	}
+	return len(vms), nil
+}
+
+// buildVM renders the VirtualMachine resource for a new node.
+func (mgr *vsphereManagerVMService) buildVM(nodegroup, name string) (*unstructured.Unstructured, error) {
+	class := mgr.config.vmClassFor(nodegroup)
+	image := mgr.config.vmImageFor(nodegroup)
+	if class == "" || image == "" {
+		return nil, fmt.Errorf("node group %s needs vm-class and vm-image for the vmservice manager", nodegroup)
+	}
+
+	spec := map[string]interface{}{
+		"className":  class,
+		"imageName":  image,
+		"powerState": "poweredOn",
+	}
+	if storageClass := mgr.config.storageClassFor(nodegroup); storageClass != "" {
+		spec["storageClass"] = storageClass
+	}
+	if configMap := mgr.config.vmMetadataConfigMapFor(nodegroup); configMap != "" {
+		spec["vmMetadata"] = map[string]interface{}{
+			"configMapName": configMap,
+			"transport":     "CloudInit",
+		}
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": vmServiceResource.Group + "/" + vmServiceResource.Version,
+		"kind":       "VirtualMachine",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": mgr.namespace,
+			"labels": map[string]interface{}{
+				vmServiceClusterLabel:   mgr.clusterName,
+				vmServiceNodeGroupLabel: nodegroup,
+			},
+		},
+		"spec": spec,
+	}}, nil
+}
+
+// createNodes creates one VirtualMachine resource per requested node; the
+// VM Service controllers take it from there.
+func (mgr *vsphereManagerVMService) createNodes(nodegroup string, nodes int) error {
+	ctx := context.TODO()
+	tracker := mgr.tracker(nodegroup)
+
+	for i := 0; i < nodes; i++ {
+		name := fmt.Sprintf("%s-%s", nodegroup, uuid.NewV4().String()[:8])
+		vm, err := mgr.buildVM(nodegroup, name)
+		if err != nil {
+			return err
+		}
+
+		tracker.cloneStarted()
+		klog.V(2).Infof("Creating VirtualMachine %s/%s for node group %s", mgr.namespace, name, nodegroup)
+		_, err = mgr.client.Resource(vmServiceResource).Namespace(mgr.namespace).Create(ctx, vm, metav1.CreateOptions{})
+		tracker.cloneFinished(err)
+		if err != nil {
+			return fmt.Errorf("could not create VirtualMachine %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// deleteNodes deletes the VirtualMachine resources backing the given nodes.
+func (mgr *vsphereManagerVMService) deleteNodes(nodegroup string, nodes []NodeRef, updatedNodeCount int) error {
+	ctx := context.TODO()
+	tracker := mgr.tracker(nodegroup)
+
+	for _, node := range nodes {
+		tracker.deleteStarted()
+		klog.V(2).Infof("Deleting VirtualMachine %s/%s", mgr.namespace, node.Name)
+		err := mgr.client.Resource(vmServiceResource).Namespace(mgr.namespace).Delete(ctx, node.Name, metav1.DeleteOptions{})
+		tracker.deleteFinished(err)
+		if err != nil {
+			return fmt.Errorf("could not delete VirtualMachine %s: %v", node.Name, err)
+		}
+	}
+	return nil
+}
+
+// rankDeletionCandidates returns the candidates unchanged; host placement
+// is owned by the VM Service controllers in this mode.
+func (mgr *vsphereManagerVMService) rankDeletionCandidates(nodegroup string, nodes []NodeRef) []NodeRef {
+	return nodes
+}
+
+// idleDeletionCandidates picks the newest VirtualMachines of the node group
+// by creation timestamp; the decrease policy options of the govmomi manager
+// do not apply here.
+func (mgr *vsphereManagerVMService) idleDeletionCandidates(nodegroup string, count int) ([]NodeRef, error) {
+	vms, err := mgr.listVMs(context.TODO(), nodegroup)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(vms, func(i, j int) bool {
+		created, otherCreated := vms[i].GetCreationTimestamp(), vms[j].GetCreationTimestamp()
+		return otherCreated.Before(&created)
+	})
+	if count > len(vms) {
+		count = len(vms)
+	}
+	victims := make([]NodeRef, 0, count)
+	for _, vm := range vms[:count] {
+		victims = append(victims, NodeRef{Name: vm.GetName()})
+	}
+	return victims, nil
+}
+
+// getNodes returns the instances of the VirtualMachines in a node group,
+// mapping the VM power state and BIOS UUID to an instance.
+func (mgr *vsphereManagerVMService) getNodes(nodegroup string) ([]cloudprovider.Instance, error) {
+	vms, err := mgr.listVMs(context.TODO(), nodegroup)
+	if err != nil {
+		return nil, err
+	}
+	var instances []cloudprovider.Instance
+	for _, vm := range vms {
+		biosUUID, found, err := unstructured.NestedString(vm.Object, "status", "biosUUID")
+		if err != nil || !found || biosUUID == "" {
+			// The VM Service has not created the backing VM yet.
+			instances = append(instances, cloudprovider.Instance{
+				Id:     fmt.Sprintf("vsphere://pending-%s", vm.GetName()),
+				Status: &cloudprovider.InstanceStatus{State: cloudprovider.InstanceCreating},
+			})
+			continue
+		}
+		// VM Service VMs have no managed object reference to offer, so a
+		// {{moref}} format renders it empty here.
+		instance := cloudprovider.Instance{Id: mgr.config.providerID(biosUUID, types.ManagedObjectReference{})}
+		if vm.GetDeletionTimestamp() != nil {
+			instance.Status = &cloudprovider.InstanceStatus{State: cloudprovider.InstanceDeleting}
+		} else {
+			instance.Status = &cloudprovider.InstanceStatus{State: cloudprovider.InstanceRunning}
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// getNodeNames returns the names of the VirtualMachines in a node group.
+func (mgr *vsphereManagerVMService) getNodeNames(nodegroup string) ([]string, error) {
+	vms, err := mgr.listVMs(context.TODO(), nodegroup)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, vm := range vms {
+		names = append(names, vm.GetName())
+	}
+	return names, nil
+}
+
+// nodeGroupStatus returns the in-flight operation counts and last error for a node group.
+func (mgr *vsphereManagerVMService) nodeGroupStatus(nodegroup string) groupStatus {
+	return mgr.tracker(nodegroup).status()
+}
+
+// templateNodeInfo is not implemented for the vmservice manager; the
+// hardware behind a VirtualMachineClass is not exposed to the autoscaler.
+func (mgr *vsphereManagerVMService) templateNodeInfo(nodegroup string) (*schedulernodeinfo.NodeInfo, error) {
+	return nil, cloudprovider.ErrNotImplemented
+}
+
+// targetSizeChanged does nothing for the vmservice manager; the set of
+// VirtualMachine resources is authoritative.
+func (mgr *vsphereManagerVMService) targetSizeChanged(nodegroup string, size int) {
+}
+
+// restoredTargetSize never returns a size for the vmservice manager; the
+// VirtualMachine resources survive restarts on their own.
+func (mgr *vsphereManagerVMService) restoredTargetSize(nodegroup string) (int, bool) {
+	return 0, false
+}
+
+// nodeGroupDefs is disabled for the vmservice manager; node groups are
+// configured via the --nodes flags.
+func (mgr *vsphereManagerVMService) nodeGroupDefs() ([]nodeGroupDef, bool, error) {
+	return nil, false, nil
+}
+
+// nodeGroupPriority returns the configured priority of a node group.
+func (mgr *vsphereManagerVMService) nodeGroupPriority(nodegroup string) int {
+	return mgr.config.priorityFor(nodegroup)
+}
+
+// autoscalingOptions returns the per node group autoscaling option
+// overrides from the cloud config.
+func (mgr *vsphereManagerVMService) autoscalingOptions(nodegroup string) nodeGroupAutoscalingOptions {
+	return mgr.config.autoscalingOptionsFor(nodegroup)
+}
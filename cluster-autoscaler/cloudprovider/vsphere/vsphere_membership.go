@@ -0,0 +1,127 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// folderVMs returns the VMs in the node group's folder, for groups whose
+// membership is implied by folder placement instead of tags.
+func (mgr *vsphereManagerGovmomi) folderVMs(ctx context.Context, nodegroup string) ([]types.ManagedObjectReference, error) {
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		return nil, err
+	}
+	folderPath := mgr.config.folderFor(nodegroup)
+	folder, err := finder.Folder(ctx, folderPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not find folder %s: %v", folderPath, err)
+	}
+
+	var f mo.Folder
+	pc := property.DefaultCollector(mgr.activeClient().Vim)
+	if err := pc.RetrieveOne(ctx, folder.Reference(), []string{"childEntity"}, &f); err != nil {
+		return nil, fmt.Errorf("could not list children of folder %s: %v", folderPath, err)
+	}
+	var refs []types.ManagedObjectReference
+	for _, ref := range f.ChildEntity {
+		if ref.Type == "VirtualMachine" {
+			refs = append(refs, ref)
+		}
+	}
+	refs, err = mgr.withoutTemplates(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+	return mgr.withoutExcluded(ctx, refs), nil
+}
+
+// resourcePoolVMs returns the VMs in the node group's resource pool, for
+// groups whose membership is implied by pool placement instead of tags.
+func (mgr *vsphereManagerGovmomi) resourcePoolVMs(ctx context.Context, nodegroup string) ([]types.ManagedObjectReference, error) {
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		return nil, err
+	}
+	poolPath := mgr.config.resourcePoolFor(nodegroup)
+	pool, err := finder.ResourcePool(ctx, poolPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not find resource pool %s: %v", poolPath, err)
+	}
+
+	var rp mo.ResourcePool
+	pc := property.DefaultCollector(mgr.activeClient().Vim)
+	if err := pc.RetrieveOne(ctx, pool.Reference(), []string{"vm"}, &rp); err != nil {
+		return nil, fmt.Errorf("could not list VMs of resource pool %s: %v", poolPath, err)
+	}
+	refs, err := mgr.withoutTemplates(ctx, rp.Vm)
+	if err != nil {
+		return nil, err
+	}
+	return mgr.withoutExcluded(ctx, refs), nil
+}
+
+// withoutExcluded drops VMs carrying the ignore tag or another exclusion
+// tag from a member candidate list. Best effort: folder and resource pool
+// membership exists for vCenter roles that may not be able to query the
+// tagging service at all, so tag trouble leaves the list unfiltered
+// instead of breaking the group.
+func (mgr *vsphereManagerGovmomi) withoutExcluded(ctx context.Context, refs []types.ManagedObjectReference) []types.ManagedObjectReference {
+	if len(refs) == 0 {
+		return refs
+	}
+	excluded, err := mgr.excludedVMs(ctx)
+	if err != nil {
+		klog.Warningf("Could not list excluded VMs, counting all members: %v", err)
+		return refs
+	}
+	var members []types.ManagedObjectReference
+	for _, ref := range refs {
+		if excluded[ref] {
+			continue
+		}
+		members = append(members, ref)
+	}
+	return members
+}
+
+// withoutTemplates filters template VMs out of a member candidate list, so
+// a template kept in the group's folder or pool does not count as a node.
+func (mgr *vsphereManagerGovmomi) withoutTemplates(ctx context.Context, refs []types.ManagedObjectReference) ([]types.ManagedObjectReference, error) {
+	if len(refs) == 0 {
+		return refs, nil
+	}
+	vms, err := mgr.retrieveVMProperties(ctx, refs, []string{"config.template"})
+	if err != nil {
+		return nil, err
+	}
+	var members []types.ManagedObjectReference
+	for _, vm := range vms {
+		if vm.Config != nil && vm.Config.Template {
+			continue
+		}
+		members = append(members, vm.Reference())
+	}
+	return members, nil
+}
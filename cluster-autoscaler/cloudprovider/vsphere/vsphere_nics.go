@@ -0,0 +1,102 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	macStrategyGenerated = "generated"
+	macStrategyPrefix    = "prefix"
+)
+
+// nicSpec is one parsed `nic` entry of a node group: the portgroup the
+// adapter connects to, the adapter type and the MAC assignment strategy.
+type nicSpec struct {
+	portgroup string
+	adapter   string
+	mac       string
+}
+
+// parseNICSpec parses a `nic` config entry of the form
+// `<portgroup>[,type=<adapter>][,mac=generated|prefix:<xx:xx:xx>]`.
+// The adapter defaults to vmxnet3; `sriov` attaches an SR-IOV virtual
+// function instead.
+func parseNICSpec(raw string) (nicSpec, error) {
+	spec := nicSpec{adapter: "vmxnet3", mac: macStrategyGenerated}
+
+	parts := strings.Split(raw, ",")
+	spec.portgroup = strings.TrimSpace(parts[0])
+	if spec.portgroup == "" {
+		return spec, fmt.Errorf("nic entry %q has no portgroup", raw)
+	}
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return spec, fmt.Errorf("invalid nic option %q in %q", part, raw)
+		}
+		switch kv[0] {
+		case "type":
+			spec.adapter = kv[1]
+		case "mac":
+			if kv[1] != macStrategyGenerated && !strings.HasPrefix(kv[1], macStrategyPrefix+":") {
+				return spec, fmt.Errorf("invalid mac strategy %q in %q", kv[1], raw)
+			}
+			spec.mac = kv[1]
+		default:
+			return spec, fmt.Errorf("unknown nic option %q in %q", kv[0], raw)
+		}
+	}
+	return spec, nil
+}
+
+// device builds the ethernet card for the clone named name. With the prefix
+// MAC strategy the address is the prefix followed by three bytes derived
+// from the node name, so a node keeps its MAC across rebuilds.
+func (spec nicSpec) device(name string) (types.BaseVirtualDevice, error) {
+	backing := &types.VirtualEthernetCardNetworkBackingInfo{
+		VirtualDeviceDeviceBackingInfo: types.VirtualDeviceDeviceBackingInfo{
+			DeviceName: spec.portgroup,
+		},
+	}
+	device, err := object.VirtualDeviceList{}.CreateEthernetCard(spec.adapter, backing)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nic adapter type %q: %v", spec.adapter, err)
+	}
+
+	if strings.HasPrefix(spec.mac, macStrategyPrefix+":") {
+		card := device.(types.BaseVirtualEthernetCard).GetVirtualEthernetCard()
+		card.AddressType = string(types.VirtualEthernetCardMacTypeManual)
+		card.MacAddress = deriveMAC(strings.TrimPrefix(spec.mac, macStrategyPrefix+":"), name)
+	}
+	return device, nil
+}
+
+// deriveMAC builds a stable MAC address from the configured prefix and a
+// hash of the node name.
+func deriveMAC(prefix, name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	sum := h.Sum32()
+	return fmt.Sprintf("%s:%02x:%02x:%02x", prefix, byte(sum>>16), byte(sum>>8), byte(sum))
+}
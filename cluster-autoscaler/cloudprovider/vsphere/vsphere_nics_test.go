@@ -0,0 +1,73 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestParseNICSpec(t *testing.T) {
+	for _, tc := range []struct {
+		raw       string
+		expected  nicSpec
+		expectErr bool
+	}{
+		{
+			raw:      "vm-network",
+			expected: nicSpec{portgroup: "vm-network", adapter: "vmxnet3", mac: "generated"},
+		},
+		{
+			raw:      "dpdk-pg, type=sriov, mac=prefix:02:00:5e",
+			expected: nicSpec{portgroup: "dpdk-pg", adapter: "sriov", mac: "prefix:02:00:5e"},
+		},
+		{raw: "", expectErr: true},
+		{raw: "pg,speed=fast", expectErr: true},
+		{raw: "pg,mac=random", expectErr: true},
+	} {
+		spec, err := parseNICSpec(tc.raw)
+		if tc.expectErr {
+			assert.Error(t, err, tc.raw)
+			continue
+		}
+		assert.NoError(t, err, tc.raw)
+		assert.Equal(t, tc.expected, spec, tc.raw)
+	}
+}
+
+func TestNICSpecDevice(t *testing.T) {
+	spec, err := parseNICSpec("dpdk-pg,type=sriov,mac=prefix:02:00:5e")
+	assert.NoError(t, err)
+
+	device, err := spec.device("pool-a-1234")
+	assert.NoError(t, err)
+	card := device.(types.BaseVirtualEthernetCard).GetVirtualEthernetCard()
+	assert.IsType(t, &types.VirtualSriovEthernetCard{}, device)
+	assert.Equal(t, string(types.VirtualEthernetCardMacTypeManual), card.AddressType)
+	// The derived MAC is stable for a given node name.
+	assert.Equal(t, card.MacAddress, deriveMAC("02:00:5e", "pool-a-1234"))
+	assert.NotEqual(t, card.MacAddress, deriveMAC("02:00:5e", "pool-a-5678"))
+
+	_, err = spec.device("")
+	assert.NoError(t, err)
+
+	spec.adapter = "floppy"
+	_, err = spec.device("pool-a-1234")
+	assert.Error(t, err)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// nodeRecycler is implemented by managers that track node age; the
+// provider refreshes the over-age set once per loop.
+type nodeRecycler interface {
+	flagOveragedNodes(nodegroups []string)
+}
+
+// flagOveragedNodes refreshes the set of nodes older than their group's
+// max-node-age. The autoscaler never deletes a node just for its age —
+// that would fight the workload — but over-age nodes become the preferred
+// deletion candidates of every scale-down, so the fleet gradually rotates
+// onto fresh clones without a separate tool.
+func (mgr *vsphereManagerGovmomi) flagOveragedNodes(nodegroups []string) {
+	ctx := context.TODO()
+	overaged := make(map[string]map[string]bool)
+
+	for _, nodegroup := range nodegroups {
+		maxAge := mgr.config.maxNodeAgeFor(nodegroup)
+		if maxAge <= 0 {
+			continue
+		}
+		refs, err := mgr.nodeGroupVMs(ctx, nodegroup)
+		if err != nil {
+			klog.Warningf("Could not check node ages for %s: %v", nodegroup, err)
+			continue
+		}
+		vms, err := mgr.retrieveVMProperties(ctx, refs, []string{"name", "config.extraConfig"})
+		if err != nil {
+			klog.Warningf("Could not check node ages for %s: %v", nodegroup, err)
+			continue
+		}
+		for _, vm := range vms {
+			if vm.Config == nil {
+				continue
+			}
+			for _, option := range vm.Config.ExtraConfig {
+				value := option.GetOptionValue()
+				if value.Key != "cluster-autoscaler.created-at" {
+					continue
+				}
+				stamp, _ := value.Value.(string)
+				created, err := time.Parse(time.RFC3339, stamp)
+				if err != nil {
+					// Adopted VMs have no stamp and hand-edited ones an
+					// unreadable one; their age is unknown, so they are
+					// not recycled.
+					continue
+				}
+				if time.Since(created) <= maxAge {
+					continue
+				}
+				if overaged[nodegroup] == nil {
+					overaged[nodegroup] = make(map[string]bool)
+				}
+				overaged[nodegroup][vm.Name] = true
+				if !mgr.isOveraged(nodegroup, vm.Name) {
+					klog.Infof("Node %s of group %s exceeded max-node-age %s (created %s), preferring it for scale-down", vm.Name, nodegroup, maxAge, stamp)
+				}
+			}
+		}
+	}
+
+	mgr.overagedMutex.Lock()
+	mgr.overaged = overaged
+	mgr.overagedMutex.Unlock()
+}
+
+// isOveraged reports whether a node was flagged over-age in the last sweep.
+func (mgr *vsphereManagerGovmomi) isOveraged(nodegroup, name string) bool {
+	mgr.overagedMutex.Lock()
+	defer mgr.overagedMutex.Unlock()
+	return mgr.overaged[nodegroup][name]
+}
+
+// preferOveraged stably moves the nodes flagged over-age to the front of
+// the deletion candidates, so scale-down retires them before fresh nodes.
+func (mgr *vsphereManagerGovmomi) preferOveraged(nodegroup string, nodes []NodeRef) []NodeRef {
+	mgr.overagedMutex.Lock()
+	flagged := mgr.overaged[nodegroup]
+	mgr.overagedMutex.Unlock()
+	if len(flagged) == 0 || len(nodes) < 2 {
+		return nodes
+	}
+
+	ranked := make([]NodeRef, 0, len(nodes))
+	for _, node := range nodes {
+		if flagged[node.Name] {
+			ranked = append(ranked, node)
+		}
+	}
+	if len(ranked) == 0 {
+		return nodes
+	}
+	for _, node := range nodes {
+		if !flagged[node.Name] {
+			ranked = append(ranked, node)
+		}
+	}
+	klog.V(3).Infof("Preferring %d over-age deletion candidates for %s", len(flagged), nodegroup)
+	return ranked
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreferOveraged(t *testing.T) {
+	mgr := testManager(newFakeTagService())
+	nodes := []NodeRef{{Name: "node-1"}, {Name: "node-2"}, {Name: "node-3"}}
+
+	// Nothing flagged: the order is untouched.
+	assert.Equal(t, nodes, mgr.preferOveraged("ng1", nodes))
+
+	mgr.overaged = map[string]map[string]bool{
+		"ng1": {"node-3": true},
+		"ng2": {"node-1": true},
+	}
+
+	ranked := mgr.preferOveraged("ng1", nodes)
+	assert.Equal(t, []NodeRef{{Name: "node-3"}, {Name: "node-1"}, {Name: "node-2"}}, ranked)
+
+	// Flags of another group do not leak.
+	assert.Equal(t, nodes, mgr.preferOveraged("ng3", nodes))
+
+	// A single candidate is left alone either way.
+	single := []NodeRef{{Name: "node-3"}}
+	assert.Equal(t, single, mgr.preferOveraged("ng1", single))
+}
+
+func TestMaxNodeAgeFor(t *testing.T) {
+	cfg := ConfigVsphere{NodeGroup: map[string]*ConfigNodeGroup{
+		"rotating": {MaxNodeAge: configDuration{value: 720 * time.Hour, isSet: true}},
+		"disabled": {MaxNodeAge: configDuration{isSet: true}},
+		"plain":    {},
+	}}
+
+	assert.Equal(t, 720*time.Hour, cfg.maxNodeAgeFor("rotating"))
+	assert.Zero(t, cfg.maxNodeAgeFor("disabled"))
+	assert.Zero(t, cfg.maxNodeAgeFor("plain"))
+	assert.Zero(t, cfg.maxNodeAgeFor("unknown"))
+}
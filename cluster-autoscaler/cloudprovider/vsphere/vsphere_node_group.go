@@ -0,0 +1,344 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/klog"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+const (
+	scaleToZeroSupported = false
+
+	// scaleDownDisabledAnnotation marks a node the autoscaler must never
+	// delete, whoever asks.
+	scaleDownDisabledAnnotation = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
+)
+
+// vsphereNodeGroup implements NodeGroup interface from cluster-autoscaler/cloudprovider.
+//
+// Represents a homogeneous collection of VMs cloned from the same template,
+// which can be dynamically resized between a minimum and maximum
+// number of nodes.
+type vsphereNodeGroup struct {
+	vsphereManager vsphereManager
+	id             string
+
+	clusterUpdateMutex *sync.Mutex
+
+	minSize int
+	maxSize int
+	// Stored as a pointer so that when autoscaler copies the nodegroup it can still update the target size
+	targetSize *int
+}
+
+// IncreaseSize increases the number of nodes by cloning new VMs from the
+// node group's template.
+func (ng *vsphereNodeGroup) IncreaseSize(delta int) error {
+	var span *traceSpan
+	if tr, ok := ng.vsphereManager.(operationTracer); ok {
+		span = tr.startOperationSpan("IncreaseSize")
+		span.setAttribute("nodegroup", ng.id)
+		span.setAttribute("delta", fmt.Sprintf("%d", delta))
+	}
+	err := ng.increaseSize(delta)
+	span.end(err)
+	return err
+}
+
+func (ng *vsphereNodeGroup) increaseSize(delta int) error {
+	ng.clusterUpdateMutex.Lock()
+	defer ng.clusterUpdateMutex.Unlock()
+
+	if delta <= 0 {
+		return fmt.Errorf("size increase must be positive")
+	}
+
+	size, err := ng.vsphereManager.nodeGroupSize(ng.id)
+	if err != nil {
+		return fmt.Errorf("could not check current nodegroup size: %v", err)
+	}
+	if size+delta > ng.MaxSize() {
+		return fmt.Errorf("size increase too large, desired:%d max:%d", size+delta, ng.MaxSize())
+	}
+	if checker, ok := ng.vsphereManager.(canaryChecker); ok {
+		if err := checker.checkCanaryFraction(ng.id, size+delta); err != nil {
+			return err
+		}
+	}
+
+	klog.V(0).Infof("Increasing size by %d, %d->%d", delta, *ng.targetSize, *ng.targetSize+delta)
+	*ng.targetSize += delta
+	ng.vsphereManager.targetSizeChanged(ng.id, *ng.targetSize)
+
+	err = ng.vsphereManager.createNodes(ng.id, delta)
+	if err != nil {
+		// Keep the error classification so the core's backoff treats
+		// config mistakes differently from transient vCenter errors.
+		return asAutoscalerError(err).AddPrefix("could not increase node group size: ")
+	}
+
+	return nil
+}
+
+// DeleteNodes deletes a set of nodes chosen by the autoscaler.
+func (ng *vsphereNodeGroup) DeleteNodes(nodes []*apiv1.Node) error {
+	var span *traceSpan
+	if tr, ok := ng.vsphereManager.(operationTracer); ok {
+		span = tr.startOperationSpan("DeleteNodes")
+		span.setAttribute("nodegroup", ng.id)
+		span.setAttribute("nodes", fmt.Sprintf("%d", len(nodes)))
+	}
+	err := ng.deleteNodes(nodes)
+	span.end(err)
+	return err
+}
+
+func (ng *vsphereNodeGroup) deleteNodes(nodes []*apiv1.Node) error {
+	ng.clusterUpdateMutex.Lock()
+	defer ng.clusterUpdateMutex.Unlock()
+
+	size, err := ng.vsphereManager.nodeGroupSize(ng.id)
+	if err != nil {
+		return fmt.Errorf("could not check current nodegroup size: %v", err)
+	}
+	if size-len(nodes) < ng.MinSize() {
+		return fmt.Errorf("size decrease too large, desired:%d min:%d", size-len(nodes), ng.MinSize())
+	}
+
+	// Only delete nodes that are actually members of this group: the VMs
+	// are looked up by node name, so deleting on behalf of another group
+	// (or an unmanaged node) would destroy the wrong VM.
+	memberNames, err := ng.vsphereManager.getNodeNames(ng.id)
+	if err != nil {
+		return fmt.Errorf("could not list node group members: %v", err)
+	}
+	members := make(map[string]bool, len(memberNames))
+	for _, name := range memberNames {
+		members[name] = true
+	}
+
+	var nodeNames []string
+	var nodeRefs []NodeRef
+	for _, node := range nodes {
+		if !members[node.Name] {
+			return fmt.Errorf("node %s does not belong to node group %s", node.Name, ng.id)
+		}
+		if node.Annotations[scaleDownDisabledAnnotation] == "true" {
+			return fmt.Errorf("node %s has scale-down disabled by annotation", node.Name)
+		}
+		nodeNames = append(nodeNames, node.Name)
+
+		// Find node IPs, can be multiple (IPv4 and IPv6)
+		var IPs []string
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == apiv1.NodeInternalIP {
+				IPs = append(IPs, addr.Address)
+			}
+		}
+		nodeRefs = append(nodeRefs, NodeRef{
+			Name:       node.Name,
+			MachineID:  node.Status.NodeInfo.MachineID,
+			ProviderID: node.Spec.ProviderID,
+			IPs:        IPs,
+		})
+	}
+
+	klog.V(0).Infof("Deleting nodes: %v", nodeNames)
+
+	nodeRefs = ng.vsphereManager.rankDeletionCandidates(ng.id, nodeRefs)
+
+	err = ng.vsphereManager.deleteNodes(ng.id, nodeRefs, size-len(nodes))
+	if err != nil {
+		return asAutoscalerError(err).AddPrefix("manager error deleting nodes: ")
+	}
+
+	// Check the new node group size and store that as the new target
+	newSize, err := ng.vsphereManager.nodeGroupSize(ng.id)
+	if err != nil {
+		// Set to the expected size as a fallback
+		*ng.targetSize = size - len(nodes)
+		ng.vsphereManager.targetSizeChanged(ng.id, *ng.targetSize)
+		return fmt.Errorf("could not check new node group size after scale down: %v", err)
+	}
+	*ng.targetSize = newSize
+	ng.vsphereManager.targetSizeChanged(ng.id, *ng.targetSize)
+
+	return nil
+}
+
+// DecreaseTargetSize decreases the target size of the node group. When more
+// VMs exist than the new target allows, the excess ones are removed through
+// the same delete path DeleteNodes uses, chosen by the group's decrease
+// policy; managers without candidate selection only adjust the target.
+func (ng *vsphereNodeGroup) DecreaseTargetSize(delta int) error {
+	if delta >= 0 {
+		return fmt.Errorf("size decrease must be negative")
+	}
+
+	ng.clusterUpdateMutex.Lock()
+	defer ng.clusterUpdateMutex.Unlock()
+
+	newTarget := *ng.targetSize + delta
+	if newTarget < ng.minSize {
+		return fmt.Errorf("size decrease too large, desired:%d min:%d", newTarget, ng.minSize)
+	}
+	klog.V(0).Infof("Decreasing target size by %d, %d->%d", delta, *ng.targetSize, newTarget)
+
+	size, err := ng.vsphereManager.nodeGroupSize(ng.id)
+	if err != nil {
+		return fmt.Errorf("could not check current nodegroup size: %v", err)
+	}
+	if excess := size - newTarget; excess > 0 {
+		victims, err := ng.vsphereManager.idleDeletionCandidates(ng.id, excess)
+		if err != nil {
+			klog.V(2).Infof("Not removing excess VMs of %s, only adjusting target: %v", ng.id, err)
+		} else if len(victims) > 0 {
+			klog.V(0).Infof("Removing excess VMs of %s: %v", ng.id, victims)
+			if err := ng.vsphereManager.deleteNodes(ng.id, victims, newTarget); err != nil {
+				return asAutoscalerError(err).AddPrefix("manager error deleting nodes: ")
+			}
+		}
+	}
+
+	*ng.targetSize = newTarget
+	ng.vsphereManager.targetSizeChanged(ng.id, newTarget)
+	return nil
+}
+
+// Id returns the node group ID
+func (ng *vsphereNodeGroup) Id() string {
+	return ng.id
+}
+
+// Debug returns a string summarising the node group state: configured and
+// current sizes, in-flight operations and the last error seen by the manager.
+func (ng *vsphereNodeGroup) Debug() string {
+	summary := fmt.Sprintf("%s min=%d max=%d target=%d", ng.id, ng.minSize, ng.maxSize, *ng.targetSize)
+
+	size, err := ng.vsphereManager.nodeGroupSize(ng.id)
+	if err != nil {
+		return fmt.Sprintf("%s (could not get VM count: %v)", summary, err)
+	}
+	summary = fmt.Sprintf("%s vms=%d", summary, size)
+
+	status := ng.vsphereManager.nodeGroupStatus(ng.id)
+	if status.pendingClones > 0 || status.pendingDeletes > 0 {
+		summary = fmt.Sprintf("%s cloning=%d deleting=%d", summary, status.pendingClones, status.pendingDeletes)
+	}
+	if status.cooldown > 0 {
+		summary = fmt.Sprintf("%s cooldown=%s", summary, status.cooldown.Round(time.Second))
+	}
+	if status.lastError != nil {
+		summary = fmt.Sprintf("%s lastError=%q", summary, status.lastError)
+	}
+	return summary
+}
+
+// Nodes returns a list of nodes that belong to this node group, including
+// their instance status derived from outstanding vCenter tasks.
+func (ng *vsphereNodeGroup) Nodes() ([]cloudprovider.Instance, error) {
+	instances, err := ng.vsphereManager.getNodes(ng.id)
+	if err != nil {
+		return nil, fmt.Errorf("could not get nodes: %v", err)
+	}
+	return instances, nil
+}
+
+// TemplateNodeInfo returns a node template for this node group.
+func (ng *vsphereNodeGroup) TemplateNodeInfo() (*schedulernodeinfo.NodeInfo, error) {
+	return ng.vsphereManager.templateNodeInfo(ng.id)
+}
+
+// GetOptions returns the autoscaling options for this node group: the
+// given cluster-wide defaults with the overrides from the cloud config
+// applied. Returns nil when the config overrides nothing, meaning the
+// defaults apply unchanged. This mirrors the NodeGroup options hook of
+// newer autoscaler cores, so per group overrides like a longer max
+// provision time for groups on slow datastores are picked up as soon as
+// the core consults it.
+func (ng *vsphereNodeGroup) GetOptions(defaults nodeGroupAutoscalingOptions) (*nodeGroupAutoscalingOptions, error) {
+	overrides := ng.vsphereManager.autoscalingOptions(ng.id)
+	if overrides == (nodeGroupAutoscalingOptions{}) {
+		return nil, nil
+	}
+	opts := defaults
+	if overrides.MaxNodeProvisionTime > 0 {
+		opts.MaxNodeProvisionTime = overrides.MaxNodeProvisionTime
+	}
+	if overrides.ScaleDownUtilizationThreshold > 0 {
+		opts.ScaleDownUtilizationThreshold = overrides.ScaleDownUtilizationThreshold
+	}
+	if overrides.ScaleDownUnneededTime > 0 {
+		opts.ScaleDownUnneededTime = overrides.ScaleDownUnneededTime
+	}
+	return &opts, nil
+}
+
+// Exist returns if this node group exists.
+// Currently always returns true.
+func (ng *vsphereNodeGroup) Exist() bool {
+	return true
+}
+
+// Create creates the node group on the cloud provider side.
+func (ng *vsphereNodeGroup) Create() (cloudprovider.NodeGroup, error) {
+	return nil, cloudprovider.ErrAlreadyExist
+}
+
+// Delete deletes the node group on the cloud provider side.
+func (ng *vsphereNodeGroup) Delete() error {
+	return cloudprovider.ErrNotImplemented
+}
+
+// Autoprovisioned returns if the nodegroup is autoprovisioned.
+func (ng *vsphereNodeGroup) Autoprovisioned() bool {
+	return false
+}
+
+// MaxSize returns the maximum allowed size of the node group. An active
+// scaling window overrides the static bound.
+func (ng *vsphereNodeGroup) MaxSize() int {
+	if source, ok := ng.vsphereManager.(scalingWindowSource); ok {
+		if _, max, active := source.scalingWindowLimits(ng.id, time.Now()); active && max >= 0 {
+			return max
+		}
+	}
+	return ng.maxSize
+}
+
+// MinSize returns the minimum allowed size of the node group. An active
+// scaling window overrides the static bound.
+func (ng *vsphereNodeGroup) MinSize() int {
+	if source, ok := ng.vsphereManager.(scalingWindowSource); ok {
+		if min, _, active := source.scalingWindowLimits(ng.id, time.Now()); active && min >= 0 {
+			return min
+		}
+	}
+	return ng.minSize
+}
+
+// TargetSize returns the target size of the node group.
+func (ng *vsphereNodeGroup) TargetSize() (int, error) {
+	return *ng.targetSize, nil
+}
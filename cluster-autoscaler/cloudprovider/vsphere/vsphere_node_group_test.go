@@ -0,0 +1,246 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+// fakeVsphereManager is a vsphereManager with a fixed node list, recording
+// the create and delete calls the node group layer makes.
+type fakeVsphereManager struct {
+	nodes   []string
+	options nodeGroupAutoscalingOptions
+
+	createdNodes []int
+	deletedNodes []string
+}
+
+func (f *fakeVsphereManager) nodeGroupSize(nodegroup string) (int, error) {
+	return len(f.nodes), nil
+}
+
+func (f *fakeVsphereManager) createNodes(nodegroup string, nodes int) error {
+	f.createdNodes = append(f.createdNodes, nodes)
+	return nil
+}
+
+func (f *fakeVsphereManager) deleteNodes(nodegroup string, nodes []NodeRef, updatedNodeCount int) error {
+	remaining := make(map[string]bool)
+	for _, name := range f.nodes {
+		remaining[name] = true
+	}
+	for _, node := range nodes {
+		f.deletedNodes = append(f.deletedNodes, node.Name)
+		delete(remaining, node.Name)
+	}
+	f.nodes = f.nodes[:0]
+	for name := range remaining {
+		f.nodes = append(f.nodes, name)
+	}
+	return nil
+}
+
+func (f *fakeVsphereManager) rankDeletionCandidates(nodegroup string, nodes []NodeRef) []NodeRef {
+	return nodes
+}
+
+func (f *fakeVsphereManager) idleDeletionCandidates(nodegroup string, count int) ([]NodeRef, error) {
+	// Newest-first over the fixed node list, i.e. from the end.
+	var victims []NodeRef
+	for i := len(f.nodes) - 1; i >= 0 && len(victims) < count; i-- {
+		victims = append(victims, NodeRef{Name: f.nodes[i]})
+	}
+	return victims, nil
+}
+
+func (f *fakeVsphereManager) getNodes(nodegroup string) ([]cloudprovider.Instance, error) {
+	return nil, nil
+}
+
+func (f *fakeVsphereManager) getNodeNames(nodegroup string) ([]string, error) {
+	return f.nodes, nil
+}
+
+func (f *fakeVsphereManager) nodeGroupStatus(nodegroup string) groupStatus {
+	return groupStatus{}
+}
+
+func (f *fakeVsphereManager) templateNodeInfo(nodegroup string) (*schedulernodeinfo.NodeInfo, error) {
+	return nil, cloudprovider.ErrNotImplemented
+}
+
+func (f *fakeVsphereManager) targetSizeChanged(nodegroup string, size int) {}
+
+func (f *fakeVsphereManager) restoredTargetSize(nodegroup string) (int, bool) {
+	return 0, false
+}
+
+func (f *fakeVsphereManager) autoscalingOptions(nodegroup string) nodeGroupAutoscalingOptions {
+	return f.options
+}
+
+func (f *fakeVsphereManager) nodeGroupDefs() ([]nodeGroupDef, bool, error) {
+	return nil, false, nil
+}
+
+func (f *fakeVsphereManager) nodeGroupPriority(nodegroup string) int {
+	return 0
+}
+
+func testNodeGroup(manager vsphereManager, minSize, maxSize, targetSize int) *vsphereNodeGroup {
+	return &vsphereNodeGroup{
+		vsphereManager:     manager,
+		id:                 "pool-a",
+		clusterUpdateMutex: &sync.Mutex{},
+		minSize:            minSize,
+		maxSize:            maxSize,
+		targetSize:         &targetSize,
+	}
+}
+
+func node(name string, annotations map[string]string) *apiv1.Node {
+	return &apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+	}
+}
+
+func TestIncreaseSize(t *testing.T) {
+	manager := &fakeVsphereManager{nodes: []string{"pool-a-1"}}
+	ng := testNodeGroup(manager, 1, 3, 1)
+
+	assert.Error(t, ng.IncreaseSize(0))
+	assert.Error(t, ng.IncreaseSize(5))
+	assert.Empty(t, manager.createdNodes)
+
+	assert.NoError(t, ng.IncreaseSize(2))
+	assert.Equal(t, []int{2}, manager.createdNodes)
+	assert.Equal(t, 3, *ng.targetSize)
+}
+
+func TestDecreaseTargetSize(t *testing.T) {
+	manager := &fakeVsphereManager{nodes: []string{"pool-a-1", "pool-a-2", "pool-a-3"}}
+	ng := testNodeGroup(manager, 1, 10, 3)
+
+	assert.Error(t, ng.DecreaseTargetSize(1))
+	assert.Error(t, ng.DecreaseTargetSize(-3))
+	assert.Empty(t, manager.deletedNodes)
+
+	// The newest excess VM goes through the delete path.
+	assert.NoError(t, ng.DecreaseTargetSize(-1))
+	assert.Equal(t, 2, *ng.targetSize)
+	assert.Equal(t, []string{"pool-a-3"}, manager.deletedNodes)
+
+	// No excess VMs: only the target moves.
+	manager.nodes = []string{"pool-a-1"}
+	manager.deletedNodes = nil
+	assert.NoError(t, ng.DecreaseTargetSize(-1))
+	assert.Equal(t, 1, *ng.targetSize)
+	assert.Empty(t, manager.deletedNodes)
+}
+
+func TestDeleteNodes(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		members    []string
+		minSize    int
+		deleted    []*apiv1.Node
+		expectErr  bool
+		expectGone []string
+	}{
+		{
+			name:       "deletes exactly the requested members",
+			members:    []string{"pool-a-1", "pool-a-2", "pool-a-3"},
+			deleted:    []*apiv1.Node{node("pool-a-2", nil)},
+			expectGone: []string{"pool-a-2"},
+		},
+		{
+			name:      "refuses nodes outside the group",
+			members:   []string{"pool-a-1"},
+			deleted:   []*apiv1.Node{node("pool-b-1", nil)},
+			expectErr: true,
+		},
+		{
+			name:    "refuses scale-down-disabled nodes",
+			members: []string{"pool-a-1", "pool-a-2"},
+			deleted: []*apiv1.Node{
+				node("pool-a-1", map[string]string{scaleDownDisabledAnnotation: "true"}),
+			},
+			expectErr: true,
+		},
+		{
+			name:      "refuses dropping below min size",
+			members:   []string{"pool-a-1", "pool-a-2"},
+			minSize:   2,
+			deleted:   []*apiv1.Node{node("pool-a-1", nil)},
+			expectErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			manager := &fakeVsphereManager{nodes: tc.members}
+			ng := testNodeGroup(manager, tc.minSize, 10, len(tc.members))
+
+			err := ng.DeleteNodes(tc.deleted)
+			if tc.expectErr {
+				assert.Error(t, err)
+				assert.Empty(t, manager.deletedNodes)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectGone, manager.deletedNodes)
+		})
+	}
+}
+
+func TestGetOptions(t *testing.T) {
+	defaults := nodeGroupAutoscalingOptions{MaxNodeProvisionTime: 15 * time.Minute}
+
+	// No overrides configured: nil keeps the defaults in force.
+	manager := &fakeVsphereManager{}
+	opts, err := testNodeGroup(manager, 1, 10, 2).GetOptions(defaults)
+	assert.NoError(t, err)
+	assert.Nil(t, opts)
+
+	manager = &fakeVsphereManager{options: nodeGroupAutoscalingOptions{MaxNodeProvisionTime: 25 * time.Minute}}
+	opts, err = testNodeGroup(manager, 1, 10, 2).GetOptions(defaults)
+	assert.NoError(t, err)
+	if assert.NotNil(t, opts) {
+		assert.Equal(t, 25*time.Minute, opts.MaxNodeProvisionTime)
+	}
+
+	// Partial overrides: unset fields keep the cluster-wide defaults.
+	defaults.ScaleDownUtilizationThreshold = 0.5
+	manager = &fakeVsphereManager{options: nodeGroupAutoscalingOptions{
+		ScaleDownUtilizationThreshold: 0.75,
+		ScaleDownUnneededTime:         30 * time.Minute,
+	}}
+	opts, err = testNodeGroup(manager, 1, 10, 2).GetOptions(defaults)
+	assert.NoError(t, err)
+	if assert.NotNil(t, opts) {
+		assert.Equal(t, 0.75, opts.ScaleDownUtilizationThreshold)
+		assert.Equal(t, 30*time.Minute, opts.ScaleDownUnneededTime)
+		assert.Equal(t, 15*time.Minute, opts.MaxNodeProvisionTime)
+	}
+}
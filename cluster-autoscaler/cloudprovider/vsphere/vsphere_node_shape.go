@@ -0,0 +1,208 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/types"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// defaultEvictionMemoryBytes mirrors the kubelet's default
+	// memory.available<100Mi eviction-hard threshold.
+	defaultEvictionMemoryBytes = 100 * 1024 * 1024
+
+	// defaultEvictionDiskFraction mirrors the kubelet's default
+	// nodefs.available<10% eviction-hard threshold.
+	defaultEvictionDiskFraction = 0.10
+)
+
+// templateGPUCount counts the accelerator devices of a template's
+// hardware. Node templates use PCI passthrough almost exclusively for
+// GPUs — vGPU profiles and direct passthrough both surface as
+// VirtualPCIPassthrough devices.
+func templateGPUCount(devices []types.BaseVirtualDevice) int64 {
+	var gpus int64
+	for _, device := range devices {
+		if _, ok := device.(*types.VirtualPCIPassthrough); ok {
+			gpus++
+		}
+	}
+	return gpus
+}
+
+// evictionThresholds are the kubelet hard eviction signals the template
+// node computation understands: an absolute memory threshold and a disk
+// threshold that is either absolute or a fraction of the disk capacity.
+type evictionThresholds struct {
+	memoryBytes  int64
+	diskBytes    int64
+	diskFraction float64
+}
+
+// defaultEvictionThresholds returns the kubelet's default eviction-hard
+// thresholds.
+func defaultEvictionThresholds() evictionThresholds {
+	return evictionThresholds{
+		memoryBytes:  defaultEvictionMemoryBytes,
+		diskFraction: defaultEvictionDiskFraction,
+	}
+}
+
+// parseReservedResources parses a kubelet-style resource list as passed to
+// --kube-reserved or --system-reserved, e.g. cpu=100m,memory=500Mi.
+func parseReservedResources(spec string) (apiv1.ResourceList, error) {
+	reserved := apiv1.ResourceList{}
+	for _, pair := range strings.Split(spec, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected resource=quantity, got %s", pair)
+		}
+		quantity, err := resource.ParseQuantity(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity %s for %s: %v", parts[1], parts[0], err)
+		}
+		reserved[apiv1.ResourceName(parts[0])] = quantity
+	}
+	return reserved, nil
+}
+
+// parseEvictionHard parses a kubelet-style eviction threshold list, e.g.
+// memory.available<200Mi,nodefs.available<5%. Like the kubelet flag, the
+// list replaces the defaults entirely: a signal not in the list has no
+// threshold.
+func parseEvictionHard(spec string) (evictionThresholds, error) {
+	var thresholds evictionThresholds
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(entry, "<", 2)
+		if len(parts) != 2 {
+			return thresholds, fmt.Errorf("expected signal<value, got %s", entry)
+		}
+		signal, value := parts[0], parts[1]
+		switch signal {
+		case "memory.available":
+			quantity, err := resource.ParseQuantity(value)
+			if err != nil {
+				return thresholds, fmt.Errorf("invalid threshold %s for %s: %v", value, signal, err)
+			}
+			thresholds.memoryBytes = quantity.Value()
+		case "nodefs.available":
+			if strings.HasSuffix(value, "%") {
+				percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+				if err != nil || percent < 0 || percent > 100 {
+					return thresholds, fmt.Errorf("invalid percentage %s for %s", value, signal)
+				}
+				thresholds.diskFraction = percent / 100
+			} else {
+				quantity, err := resource.ParseQuantity(value)
+				if err != nil {
+					return thresholds, fmt.Errorf("invalid threshold %s for %s: %v", value, signal, err)
+				}
+				thresholds.diskBytes = quantity.Value()
+			}
+		default:
+			return thresholds, fmt.Errorf("unsupported eviction signal %s", signal)
+		}
+	}
+	return thresholds, nil
+}
+
+// allocatableFromCapacity derives the allocatable resources a kubelet with
+// the given reserves and eviction thresholds reports on a node with the
+// given capacity: the summed reserves are subtracted per resource, then
+// the eviction thresholds from memory and ephemeral storage. Advertising
+// raw capacity as allocatable makes the least-waste expander overestimate
+// every shape equally, but makes "would it fit" decisions wrong for pods
+// close to the node size.
+func allocatableFromCapacity(capacity, reserved apiv1.ResourceList, eviction evictionThresholds) apiv1.ResourceList {
+	allocatable := apiv1.ResourceList{}
+	for name, quantity := range capacity {
+		allocatable[name] = quantity.DeepCopy()
+	}
+	for name, quantity := range reserved {
+		have, ok := allocatable[name]
+		if !ok {
+			continue
+		}
+		available := have.MilliValue() - quantity.MilliValue()
+		if available < 0 {
+			available = 0
+		}
+		allocatable[name] = *resource.NewMilliQuantity(available, resource.DecimalSI)
+	}
+	if memory, ok := allocatable[apiv1.ResourceMemory]; ok {
+		available := memory.Value() - eviction.memoryBytes
+		if available < 0 {
+			available = 0
+		}
+		allocatable[apiv1.ResourceMemory] = *resource.NewQuantity(available, resource.DecimalSI)
+	}
+	if disk, ok := allocatable[apiv1.ResourceEphemeralStorage]; ok {
+		// Percentage thresholds are relative to the capacity, not to
+		// what is left after the reserves.
+		threshold := eviction.diskBytes
+		if eviction.diskFraction > 0 {
+			capacityDisk := capacity[apiv1.ResourceEphemeralStorage]
+			threshold = int64(float64(capacityDisk.Value()) * eviction.diskFraction)
+		}
+		available := disk.Value() - threshold
+		if available < 0 {
+			available = 0
+		}
+		allocatable[apiv1.ResourceEphemeralStorage] = *resource.NewQuantity(available, resource.DecimalSI)
+	}
+	return allocatable
+}
+
+// nodeAllocatable computes the allocatable resources of a template node of
+// a node group from its capacity and the group's kube-reserved,
+// system-reserved and eviction-hard settings, mirroring what the kubelet
+// on a real node of the group reports.
+func (mgr *vsphereManagerGovmomi) nodeAllocatable(nodegroup string, capacity apiv1.ResourceList) (apiv1.ResourceList, error) {
+	reserved := apiv1.ResourceList{}
+	for _, opt := range []struct{ name, spec string }{
+		{"kube-reserved", mgr.config.kubeReservedFor(nodegroup)},
+		{"system-reserved", mgr.config.systemReservedFor(nodegroup)},
+	} {
+		if opt.spec == "" {
+			continue
+		}
+		parsed, err := parseReservedResources(opt.spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %s for node group %s: %v", opt.name, opt.spec, nodegroup, err)
+		}
+		for name, quantity := range parsed {
+			sum := reserved[name]
+			sum.Add(quantity)
+			reserved[name] = sum
+		}
+	}
+	eviction := defaultEvictionThresholds()
+	if spec := mgr.config.evictionHardFor(nodegroup); spec != "" {
+		var err error
+		eviction, err = parseEvictionHard(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid eviction-hard %s for node group %s: %v", spec, nodegroup, err)
+		}
+	}
+	return allocatableFromCapacity(capacity, reserved, eviction), nil
+}
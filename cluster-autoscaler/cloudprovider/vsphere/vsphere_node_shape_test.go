@@ -0,0 +1,125 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestTemplateGPUCount(t *testing.T) {
+	assert.Equal(t, int64(0), templateGPUCount(nil))
+	assert.Equal(t, int64(2), templateGPUCount([]types.BaseVirtualDevice{
+		&types.VirtualDisk{},
+		&types.VirtualPCIPassthrough{},
+		&types.VirtualPCIPassthrough{},
+	}))
+}
+
+func TestParseReservedResources(t *testing.T) {
+	reserved, err := parseReservedResources("cpu=100m,memory=500Mi")
+	assert.NoError(t, err)
+	cpu := reserved[apiv1.ResourceCPU]
+	assert.Equal(t, int64(100), cpu.MilliValue())
+	memory := reserved[apiv1.ResourceMemory]
+	assert.Equal(t, int64(500*1024*1024), memory.Value())
+
+	_, err = parseReservedResources("cpu")
+	assert.Error(t, err)
+	_, err = parseReservedResources("cpu=banana")
+	assert.Error(t, err)
+}
+
+func TestParseEvictionHard(t *testing.T) {
+	thresholds, err := parseEvictionHard("memory.available<200Mi,nodefs.available<5%")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(200*1024*1024), thresholds.memoryBytes)
+	assert.Equal(t, 0.05, thresholds.diskFraction)
+	assert.Equal(t, int64(0), thresholds.diskBytes)
+
+	thresholds, err = parseEvictionHard("nodefs.available<10Gi")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), thresholds.memoryBytes, "the list replaces the defaults")
+	assert.Equal(t, int64(10*1024*1024*1024), thresholds.diskBytes)
+
+	_, err = parseEvictionHard("memory.available=200Mi")
+	assert.Error(t, err)
+	_, err = parseEvictionHard("imagefs.available<10%")
+	assert.Error(t, err)
+	_, err = parseEvictionHard("nodefs.available<200%")
+	assert.Error(t, err)
+}
+
+func TestAllocatableFromCapacity(t *testing.T) {
+	capacity := apiv1.ResourceList{
+		apiv1.ResourceCPU:              *resource.NewQuantity(4, resource.DecimalSI),
+		apiv1.ResourceMemory:           *resource.NewQuantity(8*1024*1024*1024, resource.DecimalSI),
+		apiv1.ResourceEphemeralStorage: *resource.NewQuantity(100*1024*1024*1024, resource.DecimalSI),
+	}
+	allocatable := allocatableFromCapacity(capacity, nil, defaultEvictionThresholds())
+
+	cpus := allocatable[apiv1.ResourceCPU]
+	assert.Equal(t, int64(4), cpus.Value(), "cpu passes through")
+
+	memory := allocatable[apiv1.ResourceMemory]
+	assert.Equal(t, int64(8*1024*1024*1024-defaultEvictionMemoryBytes), memory.Value())
+
+	disk := allocatable[apiv1.ResourceEphemeralStorage]
+	assert.Equal(t, int64(90*1024*1024*1024), disk.Value(), "10%% of the disk is the eviction threshold")
+
+	// The input is not mutated.
+	original := capacity[apiv1.ResourceMemory]
+	assert.Equal(t, int64(8*1024*1024*1024), original.Value())
+}
+
+func TestNodeAllocatable(t *testing.T) {
+	mgr := testManager(newFakeTagService())
+	mgr.config.NodeGroup["pool"] = &ConfigNodeGroup{
+		KubeReserved:   "cpu=500m,memory=1Gi",
+		SystemReserved: "memory=1Gi",
+		EvictionHard:   "memory.available<200Mi,nodefs.available<10Gi",
+	}
+	capacity := apiv1.ResourceList{
+		apiv1.ResourceCPU:              *resource.NewQuantity(4, resource.DecimalSI),
+		apiv1.ResourceMemory:           *resource.NewQuantity(8*1024*1024*1024, resource.DecimalSI),
+		apiv1.ResourceEphemeralStorage: *resource.NewQuantity(100*1024*1024*1024, resource.DecimalSI),
+	}
+
+	allocatable, err := mgr.nodeAllocatable("pool", capacity)
+	assert.NoError(t, err)
+
+	cpus := allocatable[apiv1.ResourceCPU]
+	assert.Equal(t, int64(3500), cpus.MilliValue())
+	memory := allocatable[apiv1.ResourceMemory]
+	assert.Equal(t, int64((8-2)*1024*1024*1024-200*1024*1024), memory.Value(), "both reserves and the eviction threshold are subtracted")
+	disk := allocatable[apiv1.ResourceEphemeralStorage]
+	assert.Equal(t, int64(90*1024*1024*1024), disk.Value())
+
+	// A group without settings gets the kubelet defaults.
+	allocatable, err = mgr.nodeAllocatable("other", capacity)
+	assert.NoError(t, err)
+	memory = allocatable[apiv1.ResourceMemory]
+	assert.Equal(t, int64(8*1024*1024*1024-defaultEvictionMemoryBytes), memory.Value())
+
+	mgr.config.NodeGroup["pool"].KubeReserved = "cpu=banana"
+	_, err = mgr.nodeAllocatable("pool", capacity)
+	assert.Error(t, err)
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// vsphereNodeGroupResource is the VsphereNodeGroup custom resource holding
+// a node group definition: sizes, template and placement.
+var vsphereNodeGroupResource = schema.GroupVersionResource{
+	Group:    "vsphere.vmware.com",
+	Version:  "v1alpha1",
+	Resource: "vspherenodegroups",
+}
+
+// nodeGroupDef is a node group definition read from a VsphereNodeGroup
+// custom resource.
+type nodeGroupDef struct {
+	name    string
+	minSize int
+	maxSize int
+}
+
+// crdNodeGroupSource reads node group definitions from VsphereNodeGroup
+// custom resources, so groups can be added and edited at runtime with
+// kubectl instead of restarting the autoscaler with new --nodes flags.
+type crdNodeGroupSource struct {
+	client    dynamic.Interface
+	namespace string
+}
+
+// newCRDNodeGroupSource builds a source listing VsphereNodeGroup resources
+// in the given namespace.
+func newCRDNodeGroupSource(kubeConfigPath, namespace string) (*crdNodeGroupSource, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not build kubernetes client config: %v", err)
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not create dynamic client: %v", err)
+	}
+	if namespace == "" {
+		namespace = metav1.NamespaceSystem
+	}
+	return &crdNodeGroupSource{client: client, namespace: namespace}, nil
+}
+
+// list returns the current node group definitions and their per-group
+// config (template, placement, user-data), keyed by group name.
+func (s *crdNodeGroupSource) list(ctx context.Context) ([]nodeGroupDef, map[string]*ConfigNodeGroup, error) {
+	groups, err := s.client.Resource(vsphereNodeGroupResource).Namespace(s.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not list VsphereNodeGroups in %s: %v", s.namespace, err)
+	}
+
+	var defs []nodeGroupDef
+	configs := make(map[string]*ConfigNodeGroup)
+	for _, item := range groups.Items {
+		minSize, _, err := unstructured.NestedInt64(item.Object, "spec", "minSize")
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid minSize in VsphereNodeGroup %s: %v", item.GetName(), err)
+		}
+		maxSize, found, err := unstructured.NestedInt64(item.Object, "spec", "maxSize")
+		if err != nil || !found {
+			return nil, nil, fmt.Errorf("missing or invalid maxSize in VsphereNodeGroup %s: %v", item.GetName(), err)
+		}
+
+		defs = append(defs, nodeGroupDef{
+			name:    item.GetName(),
+			minSize: int(minSize),
+			maxSize: int(maxSize),
+		})
+		priority, _, _ := unstructured.NestedInt64(item.Object, "spec", "priority")
+		configs[item.GetName()] = &ConfigNodeGroup{
+			Priority:     int(priority),
+			Template:     nestedString(&item, "spec", "template"),
+			ResourcePool: nestedString(&item, "spec", "resourcePool"),
+			Datastore:    nestedString(&item, "spec", "datastore"),
+			Folder:       nestedString(&item, "spec", "folder"),
+			UserData:     nestedString(&item, "spec", "userData"),
+			Labels:       nestedString(&item, "spec", "labels"),
+			Taints:       nestedString(&item, "spec", "taints"),
+			Zone:         nestedString(&item, "spec", "zone"),
+		}
+	}
+	return defs, configs, nil
+}
+
+// nestedString reads a string field of an unstructured object, treating
+// missing or mistyped fields as empty.
+func nestedString(u *unstructured.Unstructured, fields ...string) string {
+	value, _, _ := unstructured.NestedString(u.Object, fields...)
+	return value
+}
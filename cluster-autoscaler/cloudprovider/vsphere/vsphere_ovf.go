@@ -0,0 +1,108 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// parseOVFProperty splits an ovf-property entry into its id and value.
+func parseOVFProperty(raw string) (string, string, error) {
+	parts := strings.SplitN(raw, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("invalid ovf-property %q, expected <id>=<value>", raw)
+	}
+	return parts[0], parts[1], nil
+}
+
+// vappConfigSpec builds the VAppConfigSpec injecting the node group's
+// ovf-property values into a clone of the given template, so OVAs that
+// read their bootstrap data from the OVF environment work without
+// modification.
+func (mgr *vsphereManagerGovmomi) vappConfigSpec(ctx context.Context, template types.ManagedObjectReference, nodegroup, name string) (*types.VmConfigSpec, error) {
+	values := make(map[string]string)
+	for _, raw := range mgr.config.ovfPropertiesFor(nodegroup) {
+		id, value, err := parseOVFProperty(raw)
+		if err != nil {
+			return nil, fmt.Errorf("node group %s: %v", nodegroup, err)
+		}
+		// OVF properties get the same substitutions as user-data, except
+		// {{ip}}: the clone's IP lives in its guestinfo metadata and is
+		// not threaded through to here.
+		value, err = mgr.renderUserData(nodegroup, name, "", value)
+		if err != nil {
+			return nil, err
+		}
+		values[id] = value
+	}
+
+	var vm mo.VirtualMachine
+	pc := property.DefaultCollector(mgr.activeClient().Vim)
+	if err := pc.RetrieveOne(ctx, template, []string{"config.vAppConfig"}, &vm); err != nil {
+		return nil, fmt.Errorf("could not read vApp config of template for node group %s: %v", nodegroup, err)
+	}
+	var vmConfig *types.VmConfigInfo
+	if vm.Config != nil && vm.Config.VAppConfig != nil {
+		vmConfig = vm.Config.VAppConfig.GetVmConfigInfo()
+	}
+	if vmConfig == nil {
+		return nil, fmt.Errorf("node group %s sets ovf-property but its template exposes no OVF properties", nodegroup)
+	}
+	return ovfPropertySpec(vmConfig, values, nodegroup)
+}
+
+// ovfPropertySpec matches the configured values against the template's
+// property infos — edits are keyed by the template-assigned property key,
+// so the infos are looked up rather than built — and rejects ids the
+// template does not expose.
+func ovfPropertySpec(vmConfig *types.VmConfigInfo, values map[string]string, nodegroup string) (*types.VmConfigSpec, error) {
+	remaining := make(map[string]string, len(values))
+	for id, value := range values {
+		remaining[id] = value
+	}
+
+	spec := &types.VmConfigSpec{}
+	for i := range vmConfig.Property {
+		info := vmConfig.Property[i]
+		value, ok := remaining[info.Id]
+		if !ok {
+			continue
+		}
+		info.Value = value
+		spec.Property = append(spec.Property, types.VAppPropertySpec{
+			ArrayUpdateSpec: types.ArrayUpdateSpec{Operation: types.ArrayUpdateOperationEdit},
+			Info:            &info,
+		})
+		delete(remaining, info.Id)
+	}
+	if len(remaining) > 0 {
+		missing := make([]string, 0, len(remaining))
+		for id := range remaining {
+			missing = append(missing, id)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("template of node group %s has no OVF property %s", nodegroup, strings.Join(missing, ", "))
+	}
+	return spec, nil
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestParseOVFProperty(t *testing.T) {
+	id, value, err := parseOVFProperty("hostname={{node_name}}")
+	assert.NoError(t, err)
+	assert.Equal(t, "hostname", id)
+	assert.Equal(t, "{{node_name}}", value)
+
+	_, value, err = parseOVFProperty("join_url=https://k8s.example.com:6443?a=b")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://k8s.example.com:6443?a=b", value)
+
+	_, _, err = parseOVFProperty("no-separator")
+	assert.Error(t, err)
+	_, _, err = parseOVFProperty("=value")
+	assert.Error(t, err)
+}
+
+func TestOVFPropertySpec(t *testing.T) {
+	vmConfig := &types.VmConfigInfo{
+		Property: []types.VAppPropertyInfo{
+			{Key: 0, Id: "hostname"},
+			{Key: 1, Id: "join_url"},
+			{Key: 2, Id: "untouched"},
+		},
+	}
+
+	spec, err := ovfPropertySpec(vmConfig, map[string]string{
+		"hostname": "ng1-abc12345",
+		"join_url": "https://k8s.example.com:6443",
+	}, "ng1")
+	assert.NoError(t, err)
+	if assert.Len(t, spec.Property, 2) {
+		for _, prop := range spec.Property {
+			assert.Equal(t, types.ArrayUpdateOperationEdit, prop.Operation)
+			// The edit must carry the template-assigned key.
+			assert.Equal(t, prop.Info.Key, vmConfig.Property[prop.Info.Key].Key)
+		}
+	}
+
+	// Ids the template does not expose are rejected, not silently dropped.
+	_, err = ovfPropertySpec(vmConfig, map[string]string{"bogus": "x"}, "ng1")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "bogus")
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+const (
+	// ownershipLockField is the name of the custom attribute on the cluster's
+	// VM folder holding the current lock owner and lease expiry.
+	ownershipLockField = "cluster-autoscaler.owner"
+	// defaultOwnershipLockLease is how long an acquired lease stays valid
+	// unless renewed, when ownership-lock-lease is not set in the config.
+	defaultOwnershipLockLease = 3 * time.Minute
+)
+
+// ownershipLock guards against two autoscaler replicas mutating the same
+// cluster: before any mutating operation the manager must hold a lease
+// recorded in a custom attribute on the cluster's VM folder. This is a
+// best-effort guard against misconfiguration, not a strict mutual
+// exclusion primitive — vCenter offers no compare-and-swap on attributes.
+type ownershipLock struct {
+	vim    *vim25.Client
+	fields *object.CustomFieldsManager
+	folder types.ManagedObjectReference
+	key    int32
+
+	// holderID identifies this autoscaler process in the lock value.
+	holderID string
+	lease    time.Duration
+}
+
+// newOwnershipLock creates the lock custom attribute if needed and returns
+// a lock handle identified by the local hostname and a random suffix.
+func newOwnershipLock(ctx context.Context, vim *vim25.Client, folder types.ManagedObjectReference, lease time.Duration) (*ownershipLock, error) {
+	fields, err := object.GetCustomFieldsManager(vim)
+	if err != nil {
+		return nil, fmt.Errorf("could not get custom fields manager: %v", err)
+	}
+
+	key, err := fields.FindKey(ctx, ownershipLockField)
+	if err != nil {
+		def, err := fields.Add(ctx, ownershipLockField, "Folder", nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not create lock attribute %s: %v", ownershipLockField, err)
+		}
+		key = def.Key
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return &ownershipLock{
+		vim:      vim,
+		fields:   fields,
+		folder:   folder,
+		key:      key,
+		holderID: fmt.Sprintf("%s-%s", hostname, uuid.NewV4().String()[:8]),
+		lease:    lease,
+	}, nil
+}
+
+// currentValue reads the lock attribute from the folder.
+func (l *ownershipLock) currentValue(ctx context.Context) (string, error) {
+	var folder mo.Folder
+	pc := property.DefaultCollector(l.vim)
+	if err := pc.RetrieveOne(ctx, l.folder, []string{"customValue"}, &folder); err != nil {
+		return "", fmt.Errorf("could not read lock attribute: %v", err)
+	}
+	for _, value := range folder.CustomValue {
+		if sv, ok := value.(*types.CustomFieldStringValue); ok && sv.Key == l.key {
+			return sv.Value, nil
+		}
+	}
+	return "", nil
+}
+
+// acquire takes or renews the lease. It fails if another live holder owns
+// the lock, in which case no mutating operation may proceed.
+func (l *ownershipLock) acquire(ctx context.Context) error {
+	value, err := l.currentValue(ctx)
+	if err != nil {
+		return err
+	}
+
+	if value != "" {
+		parts := strings.SplitN(value, " ", 2)
+		holder := parts[0]
+		if holder != l.holderID && len(parts) == 2 {
+			expiry, err := time.Parse(time.RFC3339, parts[1])
+			if err == nil && time.Now().Before(expiry) {
+				return fmt.Errorf("another autoscaler (%s) holds the ownership lock until %s", holder, parts[1])
+			}
+		}
+		if holder != l.holderID {
+			klog.V(1).Infof("Taking over expired ownership lock from %s", holder)
+		}
+	}
+
+	expiry := time.Now().Add(l.lease).UTC().Format(time.RFC3339)
+	if err := l.fields.Set(ctx, l.folder, l.key, fmt.Sprintf("%s %s", l.holderID, expiry)); err != nil {
+		return fmt.Errorf("could not write ownership lock: %v", err)
+	}
+	return nil
+}
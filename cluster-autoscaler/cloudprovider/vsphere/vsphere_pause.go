@@ -0,0 +1,75 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/klog"
+)
+
+const (
+	// Values of the paused node group option.
+	pauseUp   = "up"
+	pauseDown = "down"
+	pauseAll  = "all"
+
+	// pausedTagPrefix is the prefix of the vSphere tag whose mere
+	// existence pauses a node group in both directions, so operators can
+	// freeze a pool from the vCenter UI without touching the cloud
+	// config. Deleting the tag resumes scaling.
+	pausedTagPrefix = "k8s-paused-"
+)
+
+// pausedByTag reports whether the pause tag of a node group exists. Tag
+// service trouble counts as not paused: an unreachable tagging endpoint
+// must not freeze every group.
+func (mgr *vsphereManagerGovmomi) pausedByTag(ctx context.Context, nodegroup string) bool {
+	_, err := mgr.activeTags().GetTagID(ctx, pausedTagPrefix+nodegroup)
+	if err == nil {
+		return true
+	}
+	if !isTagNotFound(err) {
+		klog.Warningf("Could not check the pause tag of node group %s: %v", nodegroup, err)
+	}
+	return false
+}
+
+// scaleUpPausedError returns an error when scale-up of a node group is
+// paused, via the paused config option or the pause tag.
+func (mgr *vsphereManagerGovmomi) scaleUpPausedError(ctx context.Context, nodegroup string) error {
+	if mode := mgr.config.pausedFor(nodegroup); mode == pauseUp || mode == pauseAll {
+		return fmt.Errorf("scale-up of node group %s is paused (paused = %s)", nodegroup, mode)
+	}
+	if mgr.pausedByTag(ctx, nodegroup) {
+		return fmt.Errorf("scale-up of node group %s is paused by the %s%s tag", nodegroup, pausedTagPrefix, nodegroup)
+	}
+	return nil
+}
+
+// scaleDownPausedError returns an error when scale-down of a node group
+// is paused, via the paused config option or the pause tag.
+func (mgr *vsphereManagerGovmomi) scaleDownPausedError(ctx context.Context, nodegroup string) error {
+	if mode := mgr.config.pausedFor(nodegroup); mode == pauseDown || mode == pauseAll {
+		return fmt.Errorf("scale-down of node group %s is paused (paused = %s)", nodegroup, mode)
+	}
+	if mgr.pausedByTag(ctx, nodegroup) {
+		return fmt.Errorf("scale-down of node group %s is paused by the %s%s tag", nodegroup, pausedTagPrefix, nodegroup)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScalePausedByConfig(t *testing.T) {
+	ctx := context.TODO()
+	mgr := testManager(newFakeTagService())
+
+	assert.NoError(t, mgr.scaleUpPausedError(ctx, "pool"))
+	assert.NoError(t, mgr.scaleDownPausedError(ctx, "pool"))
+
+	mgr.config.NodeGroup["pool"] = &ConfigNodeGroup{Paused: pauseUp}
+	assert.Error(t, mgr.scaleUpPausedError(ctx, "pool"))
+	assert.NoError(t, mgr.scaleDownPausedError(ctx, "pool"))
+
+	mgr.config.NodeGroup["pool"].Paused = pauseDown
+	assert.NoError(t, mgr.scaleUpPausedError(ctx, "pool"))
+	assert.Error(t, mgr.scaleDownPausedError(ctx, "pool"))
+
+	mgr.config.NodeGroup["pool"].Paused = pauseAll
+	assert.Error(t, mgr.scaleUpPausedError(ctx, "pool"))
+	assert.Error(t, mgr.scaleDownPausedError(ctx, "pool"))
+}
+
+func TestScalePausedByTag(t *testing.T) {
+	ctx := context.TODO()
+	mgr := testManager(newFakeTagService(pausedTagPrefix + "maint"))
+
+	assert.Error(t, mgr.scaleUpPausedError(ctx, "maint"))
+	assert.Error(t, mgr.scaleDownPausedError(ctx, "maint"))
+
+	assert.NoError(t, mgr.scaleUpPausedError(ctx, "other"))
+	assert.NoError(t, mgr.scaleDownPausedError(ctx, "other"))
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"sort"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// placementPoolAttribute is the custom attribute recording which resource
+// pool a VM was cloned into, set when a node group has fallback pools.
+const placementPoolAttribute = "cluster-autoscaler.placement-pool"
+
+// placementPools returns the resource pools of a node group in placement
+// order: the primary pool first, then the configured fallbacks.
+func (mgr *vsphereManagerGovmomi) placementPools(nodegroup string) []string {
+	return append([]string{mgr.config.resourcePoolFor(nodegroup)},
+		mgr.config.fallbackResourcePoolsFor(nodegroup)...)
+}
+
+// recordPlacementPool stamps the placement-pool custom attribute on a VM.
+// Best effort: a scale-up that succeeded is not failed over bookkeeping,
+// the VM merely stays invisible to the fallback drain.
+func (mgr *vsphereManagerGovmomi) recordPlacementPool(ctx context.Context, vm types.ManagedObjectReference, pool string) {
+	fields, err := object.GetCustomFieldsManager(mgr.activeClient().Vim)
+	if err != nil {
+		klog.Warningf("Could not record placement of %s: %v", vm.Value, err)
+		return
+	}
+	key, err := fields.FindKey(ctx, placementPoolAttribute)
+	if err == object.ErrKeyNameNotFound {
+		def, addErr := fields.Add(ctx, placementPoolAttribute, "VirtualMachine", nil, nil)
+		if addErr != nil {
+			klog.Warningf("Could not create custom attribute %s: %v", placementPoolAttribute, addErr)
+			return
+		}
+		key = def.Key
+	} else if err != nil {
+		klog.Warningf("Could not record placement of %s: %v", vm.Value, err)
+		return
+	}
+	if err := fields.Set(ctx, vm, key, pool); err != nil {
+		klog.Warningf("Could not record placement of %s: %v", vm.Value, err)
+	}
+}
+
+// fallbackPlacedVMs returns the names of the node group's VMs whose
+// placement-pool attribute names a pool other than the group's primary
+// one, i.e. the ones a capacity shortage pushed into a fallback pool.
+func (mgr *vsphereManagerGovmomi) fallbackPlacedVMs(ctx context.Context, nodegroup string) (map[string]bool, error) {
+	fields, err := object.GetCustomFieldsManager(mgr.activeClient().Vim)
+	if err != nil {
+		return nil, err
+	}
+	key, err := fields.FindKey(ctx, placementPoolAttribute)
+	if err == object.ErrKeyNameNotFound {
+		// The attribute is only created on the first fallback placement.
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	refs, err := mgr.nodeGroupVMs(ctx, nodegroup)
+	if err != nil {
+		return nil, err
+	}
+	vms, err := mgr.retrieveVMProperties(ctx, refs, []string{"name", "customValue"})
+	if err != nil {
+		return nil, err
+	}
+
+	primary := mgr.config.resourcePoolFor(nodegroup)
+	placed := make(map[string]bool)
+	for _, vm := range vms {
+		for _, value := range vm.CustomValue {
+			field, ok := value.(*types.CustomFieldStringValue)
+			if !ok || field.Key != key {
+				continue
+			}
+			if field.Value != "" && field.Value != primary {
+				placed[vm.Name] = true
+			}
+		}
+	}
+	return placed, nil
+}
+
+// preferFallbackPlaced moves deletion candidates whose VM landed in a
+// fallback resource pool to the front of the list, so scale-down drains
+// overflow capacity first and the group settles back into its primary
+// pool. The order among fallback-placed (and among primary-placed)
+// candidates is preserved.
+func (mgr *vsphereManagerGovmomi) preferFallbackPlaced(nodegroup string, nodes []NodeRef) []NodeRef {
+	placed, err := mgr.fallbackPlacedVMs(context.TODO(), nodegroup)
+	if err != nil {
+		klog.Warningf("Could not check fallback placement of %s: %v", nodegroup, err)
+		return nodes
+	}
+	if len(placed) == 0 {
+		return nodes
+	}
+	ranked := make([]NodeRef, len(nodes))
+	copy(ranked, nodes)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return placed[ranked[i].Name] && !placed[ranked[j].Name]
+	})
+	return ranked
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// requiredPrivileges are the vCenter privileges the autoscaler needs on the
+// configured datacenter, resource pool, folder and datastore. A role
+// missing any of these surfaces later as cryptic clone or delete failures,
+// so they are checked once at startup. Tagging privileges live in the
+// Automation API and cannot be checked here.
+var requiredPrivileges = []string{
+	"Datastore.AllocateSpace",
+	"Resource.AssignVMToPool",
+	"VirtualMachine.Config.AdvancedConfig",
+	"VirtualMachine.Interact.PowerOff",
+	"VirtualMachine.Interact.PowerOn",
+	"VirtualMachine.Inventory.CreateFromExisting",
+	"VirtualMachine.Inventory.Delete",
+	"VirtualMachine.Provisioning.Clone",
+	"VirtualMachine.Provisioning.DeployTemplate",
+}
+
+// preflightPrivileges checks the session's privileges on the configured
+// inventory objects and logs a report of anything missing. The check is
+// advisory: a failure to run it (e.g. the session cannot even query the
+// authorization manager) is logged but does not stop the manager, and
+// missing privileges only fail later, when the operation needing them runs.
+func (mgr *vsphereManagerGovmomi) preflightPrivileges(ctx context.Context) {
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		klog.Warningf("Privilege preflight skipped, could not build finder: %v", err)
+		return
+	}
+
+	entities := make(map[string]types.ManagedObjectReference)
+	dc, err := finder.DatacenterOrDefault(ctx, mgr.config.Global.Datacenter)
+	if err == nil {
+		entities[dc.InventoryPath] = dc.Reference()
+	}
+	if pool := mgr.config.Global.ResourcePool; pool != "" {
+		if p, err := finder.ResourcePool(ctx, pool); err == nil {
+			entities[pool] = p.Reference()
+		}
+	}
+	if folder := mgr.config.Global.Folder; folder != "" {
+		if f, err := finder.Folder(ctx, folder); err == nil {
+			entities[folder] = f.Reference()
+		}
+	}
+	if datastore := mgr.config.Global.Datastore; datastore != "" {
+		if ds, err := finder.Datastore(ctx, datastore); err == nil {
+			entities[datastore] = ds.Reference()
+		}
+	}
+
+	userSession, err := session.NewManager(mgr.activeClient().Vim).UserSession(ctx)
+	if err != nil || userSession == nil {
+		klog.Warningf("Privilege preflight skipped, could not get current session: %v", err)
+		return
+	}
+
+	var refs []types.ManagedObjectReference
+	names := make(map[types.ManagedObjectReference]string)
+	for name, ref := range entities {
+		refs = append(refs, ref)
+		names[ref] = name
+	}
+
+	response, err := methods.HasPrivilegeOnEntities(ctx, mgr.activeClient().Vim, &types.HasPrivilegeOnEntities{
+		This:      *mgr.activeClient().Vim.ServiceContent.AuthorizationManager,
+		Entity:    refs,
+		SessionId: userSession.Key,
+		PrivId:    requiredPrivileges,
+	})
+	if err != nil {
+		klog.Warningf("Privilege preflight skipped, query failed: %v", err)
+		return
+	}
+
+	var missing []string
+	for _, entity := range response.Returnval {
+		for _, priv := range entity.PrivAvailability {
+			if !priv.IsGranted {
+				missing = append(missing, fmt.Sprintf("%s on %s", priv.PrivId, names[entity.Entity]))
+			}
+		}
+	}
+	if len(missing) == 0 {
+		klog.V(1).Infof("Privilege preflight passed, all %d required privileges granted on %d inventory objects", len(requiredPrivileges), len(refs))
+		return
+	}
+	sort.Strings(missing)
+	klog.Warningf("Privilege preflight found %d missing privileges; scale operations will fail until the role is fixed:\n  %s", len(missing), strings.Join(missing, "\n  "))
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// defaultProviderIDFormat matches what the upstream vSphere cloud
+// controller manager writes into .spec.providerID.
+const defaultProviderIDFormat = "vsphere://{{uuid}}"
+
+// providerIDFormat returns the configured providerID format, or the
+// default. The format must render to exactly what the cluster's CCM puts
+// on its nodes, since the core matches instances to nodes by this string.
+func (cfg *ConfigVsphere) providerIDFormat() string {
+	if cfg.Global.ProviderIDFormat != "" {
+		return cfg.Global.ProviderIDFormat
+	}
+	return defaultProviderIDFormat
+}
+
+// providerID renders the providerID of a VM: {{uuid}} is the BIOS UUID,
+// {{moref}} the managed object reference value (e.g. vm-123).
+func (cfg *ConfigVsphere) providerID(uuid string, ref types.ManagedObjectReference) string {
+	id := strings.Replace(cfg.providerIDFormat(), "{{uuid}}", uuid, -1)
+	return strings.Replace(id, "{{moref}}", ref.Value, -1)
+}
+
+// providerIDPrefix returns the literal prefix of the format before its
+// first placeholder, used to recognise which of a cluster's nodes carry
+// this provider's IDs. A format starting with a placeholder yields an
+// empty prefix, i.e. every node is assumed to be a vSphere node.
+func providerIDPrefix(format string) string {
+	if i := strings.Index(format, "{{"); i >= 0 {
+		return format[:i]
+	}
+	return format
+}
+
+// validateProviderIDFormat rejects formats that cannot identify a VM.
+func validateProviderIDFormat(format string) error {
+	if format == "" {
+		return nil
+	}
+	if !strings.Contains(format, "{{uuid}}") && !strings.Contains(format, "{{moref}}") {
+		return fmt.Errorf("provider-id-format %q must contain {{uuid}} or {{moref}}", format)
+	}
+	return nil
+}
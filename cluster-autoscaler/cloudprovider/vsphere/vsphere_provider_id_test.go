@@ -0,0 +1,47 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderID(t *testing.T) {
+	cfg := &ConfigVsphere{}
+	ref := vmRef("vm-42")
+
+	assert.Equal(t, "vsphere://421c9a7f", cfg.providerID("421c9a7f", ref))
+
+	cfg.Global.ProviderIDFormat = "{{uuid}}"
+	assert.Equal(t, "421c9a7f", cfg.providerID("421c9a7f", ref))
+
+	cfg.Global.ProviderIDFormat = "vsphere://{{moref}}"
+	assert.Equal(t, "vsphere://vm-42", cfg.providerID("421c9a7f", ref))
+}
+
+func TestProviderIDPrefix(t *testing.T) {
+	assert.Equal(t, "vsphere://", providerIDPrefix(defaultProviderIDFormat))
+	assert.Equal(t, "", providerIDPrefix("{{uuid}}"))
+}
+
+func TestValidateProviderIDFormat(t *testing.T) {
+	assert.NoError(t, validateProviderIDFormat(""))
+	assert.NoError(t, validateProviderIDFormat("vsphere://{{moref}}"))
+	assert.Error(t, validateProviderIDFormat("vsphere://"))
+}
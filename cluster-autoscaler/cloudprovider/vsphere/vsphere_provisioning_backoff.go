@@ -0,0 +1,144 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	k8smetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog"
+)
+
+const (
+	// provisioningBackoffInitial is the cool-down after the first failed
+	// scale-up of a node group; it doubles per consecutive failure up to
+	// provisioningBackoffMax.
+	provisioningBackoffInitial = time.Minute
+	provisioningBackoffMax     = 30 * time.Minute
+)
+
+var (
+	provisioningBackoffMetric = k8smetrics.NewGaugeVec(
+		&k8smetrics.GaugeOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "vsphere_provisioning_backoff_seconds",
+			Help:      "Cool-down imposed on a node group's scale-ups after repeated clone failures, in seconds; zero while the group is healthy.",
+		}, []string{"nodegroup"},
+	)
+
+	registerBackoffMetricOnce sync.Once
+)
+
+// groupCooldown is the failure history of one node group: how often its
+// scale-ups failed in a row and until when the next attempt is deferred.
+type groupCooldown struct {
+	failures int
+	delay    time.Duration
+	until    time.Time
+	lastErr  error
+}
+
+// provisioningBackoff remembers failed scale-ups per node group across
+// loops and defers retries with an exponentially growing cool-down. A
+// group whose clones keep failing — datastore full, template gone — would
+// otherwise be retried every loop, hammering vCenter and starving the
+// groups that still work.
+type provisioningBackoff struct {
+	sync.Mutex
+	groups map[string]*groupCooldown
+}
+
+// newProvisioningBackoff returns an empty backoff registry.
+func newProvisioningBackoff() *provisioningBackoff {
+	registerBackoffMetricOnce.Do(func() {
+		legacyregistry.MustRegister(provisioningBackoffMetric)
+	})
+	return &provisioningBackoff{groups: make(map[string]*groupCooldown)}
+}
+
+// recordFailure notes a failed scale-up and extends the group's cool-down.
+func (b *provisioningBackoff) recordFailure(nodegroup string, err error) {
+	b.Lock()
+	defer b.Unlock()
+	cooldown, ok := b.groups[nodegroup]
+	if !ok {
+		cooldown = &groupCooldown{}
+		b.groups[nodegroup] = cooldown
+	}
+	cooldown.failures++
+	if cooldown.delay == 0 {
+		cooldown.delay = provisioningBackoffInitial
+	} else if cooldown.delay < provisioningBackoffMax {
+		cooldown.delay *= 2
+		if cooldown.delay > provisioningBackoffMax {
+			cooldown.delay = provisioningBackoffMax
+		}
+	}
+	cooldown.until = time.Now().Add(cooldown.delay)
+	cooldown.lastErr = err
+	provisioningBackoffMetric.WithLabelValues(nodegroup).Set(cooldown.delay.Seconds())
+	klog.Warningf("Scale-up of node group %s failed %d times in a row, cooling down for %s: %v",
+		nodegroup, cooldown.failures, cooldown.delay, err)
+}
+
+// recordSuccess clears a group's failure history after a scale-up worked.
+func (b *provisioningBackoff) recordSuccess(nodegroup string) {
+	b.Lock()
+	defer b.Unlock()
+	if _, ok := b.groups[nodegroup]; !ok {
+		return
+	}
+	delete(b.groups, nodegroup)
+	provisioningBackoffMetric.WithLabelValues(nodegroup).Set(0)
+	klog.V(2).Infof("Node group %s scaled up successfully, clearing its provisioning backoff", nodegroup)
+}
+
+// remaining returns how much of the group's cool-down is left; zero when
+// the group may scale up. An expired cool-down keeps the failure history,
+// so the next failure doubles the delay instead of starting over.
+func (b *provisioningBackoff) remaining(nodegroup string) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.Lock()
+	defer b.Unlock()
+	cooldown, ok := b.groups[nodegroup]
+	if !ok {
+		return 0
+	}
+	left := time.Until(cooldown.until)
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// cooldownError returns the error a scale-up during the cool-down fails
+// with, or nil when the group may scale up.
+func (b *provisioningBackoff) cooldownError(nodegroup string) error {
+	b.Lock()
+	defer b.Unlock()
+	cooldown, ok := b.groups[nodegroup]
+	if !ok || !time.Now().Before(cooldown.until) {
+		return nil
+	}
+	return fmt.Errorf("node group %s is cooling down for another %s after %d failed scale-ups, last error: %v",
+		nodegroup, time.Until(cooldown.until).Round(time.Second), cooldown.failures, cooldown.lastErr)
+}
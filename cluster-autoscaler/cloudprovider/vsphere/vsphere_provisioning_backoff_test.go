@@ -0,0 +1,73 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProvisioningBackoff(t *testing.T) {
+	backoff := newProvisioningBackoff()
+
+	// A healthy group has no cool-down.
+	assert.NoError(t, backoff.cooldownError("ng1"))
+	assert.Zero(t, backoff.remaining("ng1"))
+
+	backoff.recordFailure("ng1", fmt.Errorf("datastore full"))
+	err := backoff.cooldownError("ng1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "datastore full")
+	assert.Contains(t, err.Error(), "1 failed scale-ups")
+	assert.True(t, backoff.remaining("ng1") > 0)
+
+	// Other groups are unaffected.
+	assert.NoError(t, backoff.cooldownError("ng2"))
+
+	// Consecutive failures double the delay up to the cap.
+	backoff.recordFailure("ng1", fmt.Errorf("datastore full"))
+	assert.Equal(t, 2*provisioningBackoffInitial, backoff.groups["ng1"].delay)
+	for i := 0; i < 20; i++ {
+		backoff.recordFailure("ng1", fmt.Errorf("datastore full"))
+	}
+	assert.Equal(t, provisioningBackoffMax, backoff.groups["ng1"].delay)
+
+	// A success clears the history entirely.
+	backoff.recordSuccess("ng1")
+	assert.NoError(t, backoff.cooldownError("ng1"))
+	assert.Zero(t, backoff.remaining("ng1"))
+	backoff.recordFailure("ng1", fmt.Errorf("datastore full"))
+	assert.Equal(t, provisioningBackoffInitial, backoff.groups["ng1"].delay)
+}
+
+func TestProvisioningBackoffExpiry(t *testing.T) {
+	backoff := newProvisioningBackoff()
+	backoff.recordFailure("ng1", fmt.Errorf("datastore full"))
+
+	// Once the cool-down expires scale-ups are allowed again, but the
+	// failure history stays so the next failure doubles the delay.
+	backoff.groups["ng1"].until = time.Now().Add(-time.Second)
+	assert.NoError(t, backoff.cooldownError("ng1"))
+	assert.Zero(t, backoff.remaining("ng1"))
+
+	backoff.recordFailure("ng1", fmt.Errorf("datastore full"))
+	assert.Equal(t, 2*provisioningBackoffInitial, backoff.groups["ng1"].delay)
+	assert.Equal(t, 2, backoff.groups["ng1"].failures)
+}
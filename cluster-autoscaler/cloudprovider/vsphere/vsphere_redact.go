@@ -0,0 +1,65 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+)
+
+// redactedPlaceholder is what credentials render as in logs and debug
+// output. Empty credentials stay empty, so it remains visible whether an
+// option was set at all.
+const redactedPlaceholder = "<redacted>"
+
+// redactSecret replaces a non-empty credential with the placeholder.
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redactedPlaceholder
+}
+
+// redacted returns a copy of the global section with every credential
+// replaced by the placeholder.
+func (g ConfigGlobal) redacted() ConfigGlobal {
+	g.Password = redactSecret(g.Password)
+	g.GuestPassword = redactSecret(g.GuestPassword)
+	g.DNSTsigSecret = redactSecret(g.DNSTsigSecret)
+	return g
+}
+
+// String renders the global section for logs with credentials redacted.
+// Formatting a config with %v or %s can therefore never leak a password;
+// code that needs the real values reads the fields directly.
+func (g ConfigGlobal) String() string {
+	// The local type drops the String method, otherwise Sprintf would
+	// recurse into it.
+	type plain ConfigGlobal
+	return fmt.Sprintf("%+v", plain(g.redacted()))
+}
+
+// String renders the whole config for logs with credentials redacted.
+// The node group sections are printed by value, not as map pointers.
+func (cfg ConfigVsphere) String() string {
+	groups := make(map[string]ConfigNodeGroup, len(cfg.NodeGroup))
+	for name, ng := range cfg.NodeGroup {
+		if ng != nil {
+			groups[name] = *ng
+		}
+	}
+	return fmt.Sprintf("{Global:%v NodeGroup:%+v}", cfg.Global, groups)
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactedConfigString(t *testing.T) {
+	var cfg ConfigVsphere
+	cfg.Global.VCenter = "vcenter.example.com"
+	cfg.Global.User = "admin"
+	cfg.Global.Password = "hunter2"
+	cfg.Global.GuestPassword = "guest-secret"
+	cfg.Global.DNSTsigSecret = "tsig-secret"
+	cfg.NodeGroup = map[string]*ConfigNodeGroup{
+		"pool-a": {Template: "ubuntu-template"},
+	}
+
+	for _, rendered := range []string{
+		cfg.String(),
+		fmt.Sprintf("%v", cfg),
+		fmt.Sprintf("%+v", &cfg),
+		fmt.Sprintf("%s", cfg.Global),
+	} {
+		assert.NotContains(t, rendered, "hunter2")
+		assert.NotContains(t, rendered, "guest-secret")
+		assert.NotContains(t, rendered, "tsig-secret")
+		assert.Contains(t, rendered, redactedPlaceholder)
+		assert.Contains(t, rendered, "vcenter.example.com")
+	}
+	// The non-secret parts of the config still print.
+	assert.Contains(t, cfg.String(), "ubuntu-template")
+}
+
+func TestRedactSecret(t *testing.T) {
+	// Empty credentials stay empty so "not configured" remains visible.
+	assert.Equal(t, "", redactSecret(""))
+	assert.Equal(t, redactedPlaceholder, redactSecret("hunter2"))
+
+	redacted := ConfigGlobal{User: "admin"}.redacted()
+	assert.Equal(t, "", redacted.Password)
+	assert.Equal(t, "admin", redacted.User)
+}
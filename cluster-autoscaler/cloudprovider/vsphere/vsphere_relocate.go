@@ -0,0 +1,191 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// relocateMaxInFlight caps concurrent relocations. A Storage vMotion
+// copies whole disks, so even one saturates a datastore link; moving a
+// fleet is deliberately slow rather than disruptive.
+const relocateMaxInFlight = 1
+
+// nodeRelocator is implemented by managers that migrate existing VMs
+// after a config move; the provider checks for drift once per loop.
+type nodeRelocator interface {
+	relocateMovedNodes(nodegroups []string)
+}
+
+// relocateMovedNodes migrates VMs whose placement no longer matches their
+// group's configured resource pool or datastore, for groups that opted in
+// with relocate-on-change. Without it, pointing a node group at a new
+// pool or datastore only affects nodes cloned afterwards and the old
+// nodes are stranded until scale-down happens to retire them. Relocations
+// run in the background, at most relocateMaxInFlight at a time, and a
+// failed one is simply retried on a later loop when the drift is still
+// there.
+func (mgr *vsphereManagerGovmomi) relocateMovedNodes(nodegroups []string) {
+	if mgr.relocationsInFlight() >= relocateMaxInFlight {
+		return
+	}
+	ctx := context.TODO()
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		klog.Warningf("Could not check node placement: %v", err)
+		return
+	}
+
+	for _, nodegroup := range nodegroups {
+		if !mgr.config.relocateOnChangeFor(nodegroup) {
+			continue
+		}
+		poolPath := mgr.config.resourcePoolFor(nodegroup)
+		if mgr.config.childResourcePoolFor(nodegroup) {
+			poolPath = poolPath + "/" + nodegroup
+		}
+		pool, err := finder.ResourcePool(ctx, poolPath)
+		if err != nil {
+			// A child pool only exists once a node was cloned into it; in
+			// that case there is nothing to move yet either.
+			klog.Warningf("Could not resolve resource pool %s for node group %s, skipping relocation: %v", poolPath, nodegroup, err)
+			continue
+		}
+		var dsRef *types.ManagedObjectReference
+		if datastore := mgr.config.datastoreFor(nodegroup); datastore != "" {
+			ds, err := finder.Datastore(ctx, datastore)
+			if err != nil {
+				klog.Warningf("Could not resolve datastore %s for node group %s, skipping relocation: %v", datastore, nodegroup, err)
+				continue
+			}
+			ref := ds.Reference()
+			dsRef = &ref
+		}
+
+		refs, err := mgr.nodeGroupVMs(ctx, nodegroup)
+		if err != nil {
+			klog.Warningf("Could not check node placement for %s: %v", nodegroup, err)
+			continue
+		}
+		vms, err := mgr.retrieveVMProperties(ctx, refs, []string{"name", "resourcePool", "datastore"})
+		if err != nil {
+			klog.Warningf("Could not check node placement for %s: %v", nodegroup, err)
+			continue
+		}
+		for _, vm := range vms {
+			spec, drift := relocationSpec(vm, pool.Reference(), dsRef)
+			if len(drift) == 0 {
+				continue
+			}
+			if !mgr.startRelocation(vm.Name) {
+				continue
+			}
+			klog.V(0).Infof("Relocating node %s of group %s, its %s moved in the config", vm.Name, nodegroup, strings.Join(drift, " and "))
+			go mgr.relocateVM(vm.Self, nodegroup, vm.Name, spec)
+			if mgr.relocationsInFlight() >= relocateMaxInFlight {
+				return
+			}
+		}
+	}
+}
+
+// relocationSpec compares a VM's placement with the configured one and
+// returns the relocate spec covering the difference, plus which parts
+// drifted. A nil dsRef means the group pins no datastore and any is fine.
+func relocationSpec(vm mo.VirtualMachine, poolRef types.ManagedObjectReference, dsRef *types.ManagedObjectReference) (types.VirtualMachineRelocateSpec, []string) {
+	var spec types.VirtualMachineRelocateSpec
+	var drift []string
+	if vm.ResourcePool != nil && *vm.ResourcePool != poolRef {
+		ref := poolRef
+		spec.Pool = &ref
+		drift = append(drift, "resource pool")
+	}
+	if dsRef != nil {
+		onTarget := false
+		for _, ds := range vm.Datastore {
+			if ds == *dsRef {
+				onTarget = true
+				break
+			}
+		}
+		if !onTarget {
+			spec.Datastore = dsRef
+			drift = append(drift, "datastore")
+		}
+	}
+	return spec, drift
+}
+
+// relocateVM runs one relocation to completion; callers start it on its
+// own goroutine after registering the VM with startRelocation.
+func (mgr *vsphereManagerGovmomi) relocateVM(ref types.ManagedObjectReference, nodegroup, name string, spec types.VirtualMachineRelocateSpec) {
+	defer mgr.finishRelocation(name)
+	ctx := context.TODO()
+
+	vm := object.NewVirtualMachine(mgr.activeClient().Vim, ref)
+	task, err := vm.Relocate(ctx, spec, types.VirtualMachineMovePriorityDefaultPriority)
+	if err != nil {
+		err = fmt.Errorf("could not start relocate task for %s: %v", name, err)
+		mgr.auditRecord("relocate", nodegroup, name, "config-change", "", err)
+		klog.Warningf("%v", err)
+		return
+	}
+	err = task.Wait(ctx)
+	mgr.auditRecord("relocate", nodegroup, name, "config-change", task.Reference().Value, err)
+	if err != nil {
+		klog.Warningf("Relocate task for %s failed: %v", name, err)
+		return
+	}
+	klog.V(1).Infof("Relocated node %s of group %s", name, nodegroup)
+	mgr.invalidateInventory()
+}
+
+// startRelocation registers a VM as being relocated, or reports false
+// when a relocation for it is already running.
+func (mgr *vsphereManagerGovmomi) startRelocation(name string) bool {
+	mgr.relocateMutex.Lock()
+	defer mgr.relocateMutex.Unlock()
+	if mgr.relocating[name] {
+		return false
+	}
+	if mgr.relocating == nil {
+		mgr.relocating = make(map[string]bool)
+	}
+	mgr.relocating[name] = true
+	return true
+}
+
+// finishRelocation drops a VM from the in-flight relocations.
+func (mgr *vsphereManagerGovmomi) finishRelocation(name string) {
+	mgr.relocateMutex.Lock()
+	defer mgr.relocateMutex.Unlock()
+	delete(mgr.relocating, name)
+}
+
+// relocationsInFlight returns how many relocations are running.
+func (mgr *vsphereManagerGovmomi) relocationsInFlight() int {
+	mgr.relocateMutex.Lock()
+	defer mgr.relocateMutex.Unlock()
+	return len(mgr.relocating)
+}
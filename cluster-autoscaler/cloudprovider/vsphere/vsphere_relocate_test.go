@@ -0,0 +1,96 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestRelocationSpec(t *testing.T) {
+	pool1 := types.ManagedObjectReference{Type: "ResourcePool", Value: "pool-1"}
+	pool2 := types.ManagedObjectReference{Type: "ResourcePool", Value: "pool-2"}
+	ds1 := types.ManagedObjectReference{Type: "Datastore", Value: "ds-1"}
+	ds2 := types.ManagedObjectReference{Type: "Datastore", Value: "ds-2"}
+
+	vm := mo.VirtualMachine{
+		ResourcePool: &pool1,
+		Datastore:    []types.ManagedObjectReference{ds1},
+	}
+
+	// Placement matches the config: nothing to do.
+	spec, drift := relocationSpec(vm, pool1, &ds1)
+	assert.Empty(t, drift)
+	assert.Nil(t, spec.Pool)
+	assert.Nil(t, spec.Datastore)
+
+	// The config moved to another pool.
+	spec, drift = relocationSpec(vm, pool2, &ds1)
+	assert.Equal(t, []string{"resource pool"}, drift)
+	assert.Equal(t, pool2, *spec.Pool)
+	assert.Nil(t, spec.Datastore)
+
+	// The config moved to another datastore.
+	spec, drift = relocationSpec(vm, pool1, &ds2)
+	assert.Equal(t, []string{"datastore"}, drift)
+	assert.Nil(t, spec.Pool)
+	assert.Equal(t, ds2, *spec.Datastore)
+
+	// Both moved at once.
+	spec, drift = relocationSpec(vm, pool2, &ds2)
+	assert.Equal(t, []string{"resource pool", "datastore"}, drift)
+	assert.Equal(t, pool2, *spec.Pool)
+	assert.Equal(t, ds2, *spec.Datastore)
+
+	// No datastore pinned in the config: any datastore is fine.
+	_, drift = relocationSpec(vm, pool1, nil)
+	assert.Empty(t, drift)
+
+	// VMs whose pool is not known yet are left alone.
+	_, drift = relocationSpec(mo.VirtualMachine{}, pool2, nil)
+	assert.Empty(t, drift)
+}
+
+func TestRelocationBookkeeping(t *testing.T) {
+	mgr := testManager(newFakeTagService())
+
+	assert.Equal(t, 0, mgr.relocationsInFlight())
+	assert.True(t, mgr.startRelocation("vm1"))
+	// A second relocation of the same VM must not start while one runs.
+	assert.False(t, mgr.startRelocation("vm1"))
+	assert.True(t, mgr.startRelocation("vm2"))
+	assert.Equal(t, 2, mgr.relocationsInFlight())
+
+	mgr.finishRelocation("vm1")
+	assert.Equal(t, 1, mgr.relocationsInFlight())
+	assert.True(t, mgr.startRelocation("vm1"))
+}
+
+func TestRelocateOnChangeFor(t *testing.T) {
+	cfg := ConfigVsphere{
+		NodeGroup: map[string]*ConfigNodeGroup{
+			"ng1": {RelocateOnChange: true},
+			"ng2": {},
+		},
+	}
+	assert.True(t, cfg.relocateOnChangeFor("ng1"))
+	assert.False(t, cfg.relocateOnChangeFor("ng2"))
+	assert.False(t, cfg.relocateOnChangeFor("missing"))
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/vmware/govmomi/find"
+	"k8s.io/klog"
+)
+
+const (
+	// replicaGCInterval is how often the replica sweep runs.
+	replicaGCInterval = 10 * time.Minute
+
+	// replicaGCGracePeriod is how long a replica must stay unreferenced
+	// before it is deleted, covering clones still in flight from it and
+	// template rotations that are rolled back.
+	replicaGCGracePeriod = 30 * time.Minute
+)
+
+// gcReplicas deletes template replicas that no node group references any
+// more, e.g. replicas of templates that were rotated away by a template
+// pattern. A replica counts as referenced while some node group's current
+// template and datastore resolve to its name; anything else the
+// autoscaler created is deleted once it has been unreferenced for the
+// grace period. Clones are full copies, so an unreferenced replica has no
+// dependents — the grace period only covers clones still in flight.
+func (mgr *vsphereManagerGovmomi) gcReplicas(ctx context.Context) error {
+	mgr.replicaGCMutex.Lock()
+	if time.Since(mgr.lastReplicaGC) < replicaGCInterval {
+		mgr.replicaGCMutex.Unlock()
+		return nil
+	}
+	mgr.lastReplicaGC = time.Now()
+	if mgr.replicaUnreferenced == nil {
+		mgr.replicaUnreferenced = make(map[string]time.Time)
+	}
+	mgr.replicaGCMutex.Unlock()
+
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		return err
+	}
+	replicas, err := finder.VirtualMachineList(ctx, "*-replica-*")
+	if err != nil {
+		if _, notFound := err.(*find.NotFoundError); notFound {
+			return nil
+		}
+		return fmt.Errorf("could not list template replicas: %v", err)
+	}
+
+	referenced := make(map[string]bool)
+	for name := range mgr.config.NodeGroup {
+		if !mgr.config.prewarmTemplatesFor(name) {
+			continue
+		}
+		datastore := mgr.config.datastoreFor(name)
+		if datastore == "" {
+			continue
+		}
+		templateName, err := mgr.resolveTemplate(ctx, finder, name)
+		if err != nil {
+			// Better to keep a possibly stale replica than to delete one
+			// whose group is only temporarily unresolvable.
+			klog.Warningf("Skipping replica GC for node group %s, could not resolve its template: %v", name, err)
+			continue
+		}
+		referenced[fmt.Sprintf("%s-replica-%s", path.Base(templateName), path.Base(datastore))] = true
+	}
+
+	mgr.replicaGCMutex.Lock()
+	defer mgr.replicaGCMutex.Unlock()
+	seen := make(map[string]bool, len(replicas))
+	for _, replica := range replicas {
+		name := path.Base(replica.InventoryPath)
+		seen[name] = true
+		if referenced[name] {
+			delete(mgr.replicaUnreferenced, name)
+			continue
+		}
+		// Only touch replicas carrying our source-version stamp; anything
+		// else merely matches the name pattern.
+		version, err := mgr.replicaSourceVersion(ctx, replica.Reference())
+		if err != nil || version == "" {
+			continue
+		}
+		first, tracked := mgr.replicaUnreferenced[name]
+		if !tracked {
+			mgr.replicaUnreferenced[name] = time.Now()
+			klog.V(2).Infof("Template replica %s is no longer referenced, deleting it in %s", name, replicaGCGracePeriod)
+			continue
+		}
+		if time.Since(first) < replicaGCGracePeriod {
+			continue
+		}
+		klog.V(1).Infof("Deleting superseded template replica %s", name)
+		task, err := replica.Destroy(ctx)
+		if err == nil {
+			err = task.Wait(ctx)
+		}
+		mgr.auditRecord("gc-replica", "", name, "superseded", "", err)
+		if err != nil {
+			klog.Warningf("Could not delete superseded replica %s: %v", name, err)
+			continue
+		}
+		delete(mgr.replicaUnreferenced, name)
+	}
+	// Forget replicas that disappeared on their own.
+	for name := range mgr.replicaUnreferenced {
+		if !seen[name] {
+			delete(mgr.replicaUnreferenced, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/vmware/govmomi/find"
+	"k8s.io/klog"
+)
+
+// templateStampKey is the ExtraConfig key recording which template a VM
+// was cloned from, set at clone time. The weighted rollout counts VMs by
+// this stamp, and scale-down uses it to retire the outgoing version
+// first.
+const templateStampKey = "cluster-autoscaler.template"
+
+// rolloutFor returns a node group's rollout template and the fraction of
+// the group it should converge to; empty/zero when no rollout is
+// configured. Validity is checked at startup.
+func (cfg *ConfigVsphere) rolloutFor(nodegroup string) (string, float64) {
+	ng, ok := cfg.NodeGroup[nodegroup]
+	if !ok || ng.RolloutTemplate == "" {
+		return "", 0
+	}
+	fraction, err := strconv.ParseFloat(ng.RolloutFraction, 64)
+	if err != nil {
+		return "", 0
+	}
+	return ng.RolloutTemplate, fraction
+}
+
+// wantRollout reports whether the next clone should come from the rollout
+// template: it does when the new VM can join the rollout without pushing
+// its share of the group above the configured fraction.
+func wantRollout(fraction float64, rolloutCount, total int) bool {
+	return float64(rolloutCount+1) <= fraction*float64(total+1)
+}
+
+// templateStamps returns the template stamp of each VM in a node group by
+// VM name; VMs without the stamp (adopted, or created before rollouts
+// existed) map to the empty string.
+func (mgr *vsphereManagerGovmomi) templateStamps(ctx context.Context, nodegroup string) (map[string]string, error) {
+	refs, err := mgr.nodeGroupVMs(ctx, nodegroup)
+	if err != nil {
+		return nil, fmt.Errorf("could not list node group VMs: %v", err)
+	}
+	vms, err := mgr.retrieveVMProperties(ctx, refs, []string{"name", "config.extraConfig"})
+	if err != nil {
+		return nil, err
+	}
+	stamps := make(map[string]string, len(vms))
+	for _, vm := range vms {
+		stamp := ""
+		if vm.Config != nil {
+			for _, option := range vm.Config.ExtraConfig {
+				if value := option.GetOptionValue(); value != nil && value.Key == templateStampKey {
+					stamp, _ = value.Value.(string)
+				}
+			}
+		}
+		stamps[vm.Name] = stamp
+	}
+	return stamps, nil
+}
+
+// pickCloneTemplate chooses the template for the next clone of a node
+// group. Without a rollout it is simply the group's template; with one,
+// clones alternate between the two so the rollout template's share of the
+// group converges to the configured fraction — e.g. rollout-fraction 0.1
+// keeps one VM in ten on the new image.
+func (mgr *vsphereManagerGovmomi) pickCloneTemplate(ctx context.Context, finder *find.Finder, nodegroup string) (string, error) {
+	base, err := mgr.resolveTemplate(ctx, finder, nodegroup)
+	if err != nil {
+		return "", err
+	}
+	rolloutPattern, fraction := mgr.config.rolloutFor(nodegroup)
+	if rolloutPattern == "" || fraction <= 0 {
+		return base, nil
+	}
+	rollout, err := mgr.templates.resolve(ctx, mgr, finder, rolloutPattern)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve rollout-template of node group %s: %v", nodegroup, err)
+	}
+
+	stamps, err := mgr.templateStamps(ctx, nodegroup)
+	if err != nil {
+		// Counting trouble must not fail the scale-up; the base template
+		// is always a safe choice.
+		klog.Warningf("Could not count template versions of node group %s, cloning from %s: %v", nodegroup, base, err)
+		return base, nil
+	}
+	rolloutCount := 0
+	for _, stamp := range stamps {
+		if stamp == rollout {
+			rolloutCount++
+		}
+	}
+	if wantRollout(fraction, rolloutCount, len(stamps)) {
+		klog.V(2).Infof("Node group %s has %d of %d VMs on rollout template %s (target %.0f%%), cloning from it", nodegroup, rolloutCount, len(stamps), rollout, fraction*100)
+		return rollout, nil
+	}
+	return base, nil
+}
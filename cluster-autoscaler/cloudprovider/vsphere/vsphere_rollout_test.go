@@ -0,0 +1,53 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWantRollout(t *testing.T) {
+	// One in ten: the first clone of an empty group stays on the base
+	// image, the rollout share only builds up as the group grows.
+	assert.False(t, wantRollout(0.1, 0, 0))
+	assert.False(t, wantRollout(0.1, 0, 8))
+	assert.True(t, wantRollout(0.1, 0, 9))
+	assert.False(t, wantRollout(0.1, 1, 9))
+	assert.True(t, wantRollout(0.1, 1, 19))
+
+	// 90/10 the other way round: most clones go to the rollout image.
+	assert.True(t, wantRollout(0.9, 0, 1))
+	assert.True(t, wantRollout(0.9, 8, 9))
+	assert.False(t, wantRollout(0.9, 9, 9))
+}
+
+func TestRolloutFor(t *testing.T) {
+	cfg := &ConfigVsphere{NodeGroup: map[string]*ConfigNodeGroup{
+		"ng1": {RolloutTemplate: "ubuntu-v2", RolloutFraction: "0.1"},
+		"ng2": {},
+	}}
+
+	template, fraction := cfg.rolloutFor("ng1")
+	assert.Equal(t, "ubuntu-v2", template)
+	assert.Equal(t, 0.1, fraction)
+
+	template, fraction = cfg.rolloutFor("ng2")
+	assert.Equal(t, "", template)
+	assert.Equal(t, 0.0, fraction)
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scalingWindow is one parsed scaling-window option: a recurring weekly
+// time range during which a node group's reported minimum and/or maximum
+// size differ from the static --nodes bounds. Sizes of -1 leave the
+// static bound in place.
+type scalingWindow struct {
+	days  [7]bool
+	start int // minute of day, inclusive
+	end   int // minute of day, exclusive
+	min   int
+	max   int
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseClockMinute parses a HH:MM clock time into a minute of day.
+func parseClockMinute(s string) (int, error) {
+	parsed, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid clock time %q, use HH:MM", s)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// parseScalingWindow parses one scaling-window option, a comma-separated
+// key=value list like
+//
+//	days=mon-fri,hours=08:00-18:00,min=10
+//
+// days may be a single weekday or a range (wrapping, so sat-sun works)
+// and defaults to every day; hours is a local-time range, wrapping past
+// midnight for night windows, and defaults to the whole day. At least
+// one of min and max must be set.
+func parseScalingWindow(raw string) (scalingWindow, error) {
+	window := scalingWindow{start: 0, end: 24 * 60, min: -1, max: -1}
+	for i := range window.days {
+		window.days[i] = true
+	}
+
+	for _, part := range strings.Split(raw, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return window, fmt.Errorf("invalid scaling-window part %q, use key=value", part)
+		}
+		key, value := kv[0], kv[1]
+		switch key {
+		case "days":
+			for i := range window.days {
+				window.days[i] = false
+			}
+			bounds := strings.SplitN(value, "-", 2)
+			first, ok := weekdayNames[strings.ToLower(bounds[0])]
+			if !ok {
+				return window, fmt.Errorf("invalid weekday %q in scaling-window", bounds[0])
+			}
+			last := first
+			if len(bounds) == 2 {
+				if last, ok = weekdayNames[strings.ToLower(bounds[1])]; !ok {
+					return window, fmt.Errorf("invalid weekday %q in scaling-window", bounds[1])
+				}
+			}
+			for day := first; ; day = (day + 1) % 7 {
+				window.days[day] = true
+				if day == last {
+					break
+				}
+			}
+		case "hours":
+			bounds := strings.SplitN(value, "-", 2)
+			if len(bounds) != 2 {
+				return window, fmt.Errorf("invalid hours %q in scaling-window, use HH:MM-HH:MM", value)
+			}
+			var err error
+			if window.start, err = parseClockMinute(bounds[0]); err != nil {
+				return window, err
+			}
+			if window.end, err = parseClockMinute(bounds[1]); err != nil {
+				return window, err
+			}
+		case "min", "max":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 0 {
+				return window, fmt.Errorf("invalid %s %q in scaling-window", key, value)
+			}
+			if key == "min" {
+				window.min = size
+			} else {
+				window.max = size
+			}
+		default:
+			return window, fmt.Errorf("unknown scaling-window key %q", key)
+		}
+	}
+
+	if window.min == -1 && window.max == -1 {
+		return window, fmt.Errorf("scaling-window %q sets neither min nor max", raw)
+	}
+	if window.min != -1 && window.max != -1 && window.min > window.max {
+		return window, fmt.Errorf("scaling-window %q has min above max", raw)
+	}
+	return window, nil
+}
+
+// active reports whether the window covers the given local time. Windows
+// wrapping past midnight (hours=22:00-06:00) match the late and the early
+// part on each of their days.
+func (w scalingWindow) active(now time.Time) bool {
+	if !w.days[now.Weekday()] {
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// scalingWindowSource is implemented by managers whose config can change
+// a node group's size bounds on a schedule; the node group consults it
+// when reporting MinSize and MaxSize.
+type scalingWindowSource interface {
+	// scalingWindowLimits returns the (min, max) overrides of the window
+	// covering now, -1 for a bound the window leaves alone, and whether
+	// any window is active.
+	scalingWindowLimits(nodegroup string, now time.Time) (int, int, bool)
+}
+
+// scalingWindowLimits returns the size bounds of the first configured
+// window of the node group covering now. Invalid windows were rejected
+// at startup and are skipped here.
+func (mgr *vsphereManagerGovmomi) scalingWindowLimits(nodegroup string, now time.Time) (int, int, bool) {
+	ng, ok := mgr.config.NodeGroup[nodegroup]
+	if !ok {
+		return -1, -1, false
+	}
+	for _, raw := range ng.ScalingWindows {
+		window, err := parseScalingWindow(raw)
+		if err != nil {
+			continue
+		}
+		if window.active(now) {
+			return window.min, window.max, true
+		}
+	}
+	return -1, -1, false
+}
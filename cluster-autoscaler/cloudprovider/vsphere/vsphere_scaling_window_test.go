@@ -0,0 +1,102 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScalingWindow(t *testing.T) {
+	window, err := parseScalingWindow("days=mon-fri,hours=08:00-18:00,min=10")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, window.min)
+	assert.Equal(t, -1, window.max)
+	assert.True(t, window.days[time.Monday])
+	assert.True(t, window.days[time.Friday])
+	assert.False(t, window.days[time.Saturday])
+	assert.Equal(t, 8*60, window.start)
+	assert.Equal(t, 18*60, window.end)
+
+	// Days default to every day, hours to the whole day.
+	window, err = parseScalingWindow("max=5")
+	assert.NoError(t, err)
+	assert.True(t, window.days[time.Sunday])
+	assert.Equal(t, 0, window.start)
+	assert.Equal(t, 24*60, window.end)
+
+	// Weekday ranges wrap.
+	window, err = parseScalingWindow("days=sat-sun,min=0")
+	assert.NoError(t, err)
+	assert.True(t, window.days[time.Saturday])
+	assert.True(t, window.days[time.Sunday])
+	assert.False(t, window.days[time.Monday])
+
+	for _, raw := range []string{
+		"days=mon-fri",                // no bound
+		"days=funday,min=1",           // bad weekday
+		"hours=8-18,min=1",            // bad clock time
+		"min=-3",                      // negative size
+		"min=10,max=2",                // min above max
+		"days=mon-fri hours=08,min=1", // not key=value
+	} {
+		_, err := parseScalingWindow(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestScalingWindowActive(t *testing.T) {
+	window, err := parseScalingWindow("days=mon-fri,hours=08:00-18:00,min=10")
+	assert.NoError(t, err)
+	// 2026-08-24 is a Monday.
+	assert.True(t, window.active(time.Date(2026, 8, 24, 9, 30, 0, 0, time.UTC)))
+	assert.False(t, window.active(time.Date(2026, 8, 24, 18, 0, 0, 0, time.UTC)))
+	assert.False(t, window.active(time.Date(2026, 8, 29, 9, 30, 0, 0, time.UTC))) // Saturday
+
+	// Night windows wrap past midnight.
+	night, err := parseScalingWindow("hours=22:00-06:00,min=2")
+	assert.NoError(t, err)
+	assert.True(t, night.active(time.Date(2026, 8, 24, 23, 0, 0, 0, time.UTC)))
+	assert.True(t, night.active(time.Date(2026, 8, 24, 5, 0, 0, 0, time.UTC)))
+	assert.False(t, night.active(time.Date(2026, 8, 24, 12, 0, 0, 0, time.UTC)))
+}
+
+func TestScalingWindowLimits(t *testing.T) {
+	mgr := testManager(newFakeTagService())
+	mgr.config.NodeGroup["pool-a"] = &ConfigNodeGroup{
+		ScalingWindows: []string{
+			"days=mon-fri,hours=08:00-18:00,min=10",
+			"max=20",
+		},
+	}
+
+	// The first matching window wins.
+	min, max, active := mgr.scalingWindowLimits("pool-a", time.Date(2026, 8, 24, 9, 0, 0, 0, time.Local))
+	assert.True(t, active)
+	assert.Equal(t, 10, min)
+	assert.Equal(t, -1, max)
+
+	min, max, active = mgr.scalingWindowLimits("pool-a", time.Date(2026, 8, 29, 9, 0, 0, 0, time.Local))
+	assert.True(t, active)
+	assert.Equal(t, -1, min)
+	assert.Equal(t, 20, max)
+
+	_, _, active = mgr.scalingWindowLimits("pool-b", time.Now())
+	assert.False(t, active)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	// shutdownDrainTimeout bounds how long Cleanup waits for in-flight VM
+	// creations before letting the process exit. Long enough for a clone
+	// that is nearly done to finish and get tagged, short enough to stay
+	// within a reasonable pod termination grace period.
+	shutdownDrainTimeout = 60 * time.Second
+
+	shutdownPollInterval = time.Second
+)
+
+// shutdownDrainer is implemented by managers that can wait for their
+// in-flight vCenter operations when the autoscaler shuts down.
+type shutdownDrainer interface {
+	drainInFlight()
+}
+
+// drainInFlight waits, bounded by shutdownDrainTimeout, for the creations
+// still queued or cloning, so VMs produced by this process get tagged and
+// tracked before the pod exits. A clone cut off mid-flight would otherwise
+// complete in vCenter but never join its node group. Creations that do not
+// finish in time are left behind: with persist-state enabled their pending
+// entries survive and the next run adopts the VMs through reconcileState,
+// without it the VMs may end up untagged and need manual cleanup.
+func (mgr *vsphereManagerGovmomi) drainInFlight() {
+	pending := mgr.creations.inFlight()
+	if pending == 0 {
+		return
+	}
+
+	klog.V(0).Infof("Waiting up to %s for %d in-flight VM creations before shutdown", shutdownDrainTimeout, pending)
+	deadline := time.Now().Add(shutdownDrainTimeout)
+	for time.Now().Before(deadline) {
+		if mgr.creations.inFlight() == 0 {
+			klog.V(0).Info("All in-flight VM creations finished")
+			return
+		}
+		time.Sleep(shutdownPollInterval)
+	}
+
+	var names []string
+	for _, task := range mgr.tasks.inFlightClones() {
+		names = append(names, task.nodeName)
+	}
+	if mgr.state != nil {
+		klog.Warningf("Leaving %d VM creations in flight (%s); their pending entries are persisted and the next run adopts the VMs",
+			mgr.creations.inFlight(), strings.Join(names, ", "))
+	} else {
+		klog.Warningf("Leaving %d VM creations in flight (%s); without persist-state their VMs may end up untagged",
+			mgr.creations.inFlight(), strings.Join(names, ", "))
+	}
+}
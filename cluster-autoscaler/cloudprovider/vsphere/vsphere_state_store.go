@@ -0,0 +1,230 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog"
+)
+
+const stateConfigMapKey = "state"
+
+// persistedNodeGroupState is the per node group state surviving restarts.
+type persistedNodeGroupState struct {
+	// TargetSize is the intended size of the node group.
+	TargetSize int `json:"targetSize"`
+	// PendingNodes maps node names with an in-flight clone to the vCenter
+	// task reference that is creating them.
+	PendingNodes map[string]string `json:"pendingNodes,omitempty"`
+}
+
+// persistedState is the provider state stored in the ConfigMap.
+type persistedState struct {
+	NodeGroups map[string]*persistedNodeGroupState `json:"nodeGroups"`
+	// IPAllocations maps node names to their statically allocated IPs.
+	IPAllocations map[string]string `json:"ipAllocations,omitempty"`
+}
+
+// stateStore persists provider state into a ConfigMap so that target sizes
+// and in-flight clones survive an autoscaler restart instead of causing
+// duplicate scale-ups.
+type stateStore struct {
+	sync.Mutex
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	state     persistedState
+}
+
+// newStateStore creates a store backed by a ConfigMap named after the
+// cluster, and loads any state a previous run left behind.
+func newStateStore(kubeConfigPath, clusterName string) (*stateStore, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not build kubernetes client config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not create kubernetes client: %v", err)
+	}
+
+	store := &stateStore{
+		client:    client,
+		namespace: metav1.NamespaceSystem,
+		name:      fmt.Sprintf("cluster-autoscaler-vsphere-state-%s", clusterName),
+		state:     persistedState{NodeGroups: make(map[string]*persistedNodeGroupState)},
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// load reads the ConfigMap into memory; a missing ConfigMap is not an error.
+func (s *stateStore) load() error {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(context.TODO(), s.name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("could not get state ConfigMap %s/%s: %v", s.namespace, s.name, err)
+	}
+	if err := json.Unmarshal([]byte(cm.Data[stateConfigMapKey]), &s.state); err != nil {
+		klog.Warningf("Could not parse persisted state, starting fresh: %v", err)
+		s.state = persistedState{NodeGroups: make(map[string]*persistedNodeGroupState)}
+		return nil
+	}
+	if s.state.NodeGroups == nil {
+		s.state.NodeGroups = make(map[string]*persistedNodeGroupState)
+	}
+	return nil
+}
+
+// save writes the in-memory state back to the ConfigMap, creating it if needed.
+func (s *stateStore) save() {
+	data, err := json.Marshal(&s.state)
+	if err != nil {
+		klog.Errorf("Could not marshal provider state: %v", err)
+		return
+	}
+	cm := &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.name,
+			Namespace: s.namespace,
+		},
+		Data: map[string]string{stateConfigMapKey: string(data)},
+	}
+
+	_, err = s.client.CoreV1().ConfigMaps(s.namespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = s.client.CoreV1().ConfigMaps(s.namespace).Create(context.TODO(), cm, metav1.CreateOptions{})
+	}
+	if err != nil {
+		klog.Errorf("Could not persist provider state to ConfigMap %s/%s: %v", s.namespace, s.name, err)
+	}
+}
+
+// groupState returns the state entry for a node group, creating it if
+// needed. Callers must hold the lock.
+func (s *stateStore) groupState(nodegroup string) *persistedNodeGroupState {
+	state, ok := s.state.NodeGroups[nodegroup]
+	if !ok {
+		state = &persistedNodeGroupState{PendingNodes: make(map[string]string)}
+		s.state.NodeGroups[nodegroup] = state
+	}
+	if state.PendingNodes == nil {
+		state.PendingNodes = make(map[string]string)
+	}
+	return state
+}
+
+// setTargetSize persists the intended size of a node group.
+func (s *stateStore) setTargetSize(nodegroup string, size int) {
+	s.Lock()
+	defer s.Unlock()
+	s.groupState(nodegroup).TargetSize = size
+	s.save()
+}
+
+// targetSize returns the persisted target size of a node group, if any.
+func (s *stateStore) targetSize(nodegroup string) (int, bool) {
+	s.Lock()
+	defer s.Unlock()
+	state, ok := s.state.NodeGroups[nodegroup]
+	if !ok {
+		return 0, false
+	}
+	return state.TargetSize, true
+}
+
+// addPendingNode records an in-flight clone.
+func (s *stateStore) addPendingNode(nodegroup, nodeName, taskRef string) {
+	s.Lock()
+	defer s.Unlock()
+	s.groupState(nodegroup).PendingNodes[nodeName] = taskRef
+	s.save()
+}
+
+// removePendingNode drops a clone that finished (or failed).
+func (s *stateStore) removePendingNode(nodegroup, nodeName string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.groupState(nodegroup).PendingNodes, nodeName)
+	s.save()
+}
+
+// pendingNodes returns the node names with in-flight clones from the
+// persisted state, e.g. after a restart.
+func (s *stateStore) pendingNodes(nodegroup string) map[string]string {
+	s.Lock()
+	defer s.Unlock()
+	result := make(map[string]string)
+	for name, taskRef := range s.groupState(nodegroup).PendingNodes {
+		result[name] = taskRef
+	}
+	return result
+}
+
+// setIPAllocation persists a node's statically allocated IP.
+func (s *stateStore) setIPAllocation(nodeName, address string) {
+	s.Lock()
+	defer s.Unlock()
+	if s.state.IPAllocations == nil {
+		s.state.IPAllocations = make(map[string]string)
+	}
+	s.state.IPAllocations[nodeName] = address
+	s.save()
+}
+
+// removeIPAllocation drops a node's IP allocation.
+func (s *stateStore) removeIPAllocation(nodeName string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.state.IPAllocations, nodeName)
+	s.save()
+}
+
+// ipAllocations returns the persisted IP allocations by node name.
+func (s *stateStore) ipAllocations() map[string]string {
+	s.Lock()
+	defer s.Unlock()
+	result := make(map[string]string)
+	for node, address := range s.state.IPAllocations {
+		result[node] = address
+	}
+	return result
+}
+
+// nodeGroups returns the node group names present in the persisted state.
+func (s *stateStore) nodeGroups() []string {
+	s.Lock()
+	defer s.Unlock()
+	var names []string
+	for name := range s.state.NodeGroups {
+		names = append(names, name)
+	}
+	return names
+}
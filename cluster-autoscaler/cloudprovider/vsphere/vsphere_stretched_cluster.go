@@ -0,0 +1,140 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// siteVMGroupName names the DRS VM group holding a node group's VMs on one
+// site of a stretched cluster, derived from the site's host group so the
+// groups read naturally next to each other in the vCenter UI.
+func siteVMGroupName(nodegroup, hostGroup string) string {
+	return fmt.Sprintf("k8s-%s-%s", nodegroup, hostGroup)
+}
+
+// pickSiteVMGroup chooses which site the next node goes to: the VM group
+// with fewer members, preferring the preferred site on ties. Counting
+// members instead of strictly alternating self-heals after a site failed
+// and its nodes were recreated elsewhere.
+func pickSiteVMGroup(preferred, secondary *types.ClusterVmGroup) string {
+	if len(secondary.Vm) < len(preferred.Vm) {
+		return secondary.Name
+	}
+	return preferred.Name
+}
+
+// siteVMGroup returns the named VM group from a cluster configuration, or
+// nil when it does not exist yet.
+func siteVMGroup(config *types.ClusterConfigInfoEx, name string) *types.ClusterVmGroup {
+	for _, group := range config.Group {
+		if vmGroup, ok := group.(*types.ClusterVmGroup); ok && vmGroup.Name == name {
+			return vmGroup
+		}
+	}
+	return nil
+}
+
+// siteRuleExists reports whether the cluster already has a VM-host rule of
+// the given name.
+func siteRuleExists(config *types.ClusterConfigInfoEx, name string) bool {
+	for _, rule := range config.Rule {
+		if rule.GetClusterRuleInfo().Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// assignSiteVMGroup places a freshly cloned VM on one of the two sites of
+// a vSAN stretched cluster by adding it to a per-site DRS VM group. The VM
+// groups and their should-run-on-hosts-in-group rules against the
+// configured host groups are created on first use; the host groups
+// themselves are the ones vSAN stretched clusters already require, so they
+// are expected to exist. Sites alternate by filling the emptier group.
+// Best effort like the anti-affinity hint: a node on the wrong site beats
+// no node at all, so trouble is logged rather than failing the scale-up.
+func (mgr *vsphereManagerGovmomi) assignSiteVMGroup(ctx context.Context, poolRef types.ManagedObjectReference, nodegroup string, vmRef types.ManagedObjectReference, name string) {
+	preferredHosts, secondaryHosts := mgr.config.siteHostGroupsFor(nodegroup)
+
+	collector := property.DefaultCollector(mgr.activeClient().Vim)
+	var pool mo.ResourcePool
+	if err := collector.RetrieveOne(ctx, poolRef, []string{"owner"}, &pool); err != nil {
+		klog.Warningf("Could not assign %s to a site VM group: %v", name, err)
+		return
+	}
+	cluster := object.NewClusterComputeResource(mgr.activeClient().Vim, pool.Owner)
+	config, err := cluster.Configuration(ctx)
+	if err != nil {
+		klog.Warningf("Could not assign %s to a site VM group: %v", name, err)
+		return
+	}
+
+	spec := types.ClusterConfigSpecEx{}
+	groups := make(map[string]*types.ClusterVmGroup)
+	for _, hostGroup := range []string{preferredHosts, secondaryHosts} {
+		groupName := siteVMGroupName(nodegroup, hostGroup)
+		group := siteVMGroup(config, groupName)
+		operation := types.ArrayUpdateOperationEdit
+		if group == nil {
+			group = &types.ClusterVmGroup{ClusterGroupInfo: types.ClusterGroupInfo{Name: groupName}}
+			operation = types.ArrayUpdateOperationAdd
+		}
+		groups[groupName] = group
+		spec.GroupSpec = append(spec.GroupSpec, types.ClusterGroupSpec{
+			ArrayUpdateSpec: types.ArrayUpdateSpec{Operation: operation},
+			Info:            group,
+		})
+		if !siteRuleExists(config, groupName) {
+			// A soft rule: DRS keeps the group on its site but may still
+			// move it when the site is down, which is the point of
+			// stretching the cluster.
+			spec.RulesSpec = append(spec.RulesSpec, types.ClusterRuleSpec{
+				ArrayUpdateSpec: types.ArrayUpdateSpec{Operation: types.ArrayUpdateOperationAdd},
+				Info: &types.ClusterVmHostRuleInfo{
+					ClusterRuleInfo: types.ClusterRuleInfo{
+						Name:      groupName,
+						Enabled:   types.NewBool(true),
+						Mandatory: types.NewBool(false),
+					},
+					VmGroupName:         groupName,
+					AffineHostGroupName: hostGroup,
+				},
+			})
+		}
+	}
+
+	site := pickSiteVMGroup(groups[siteVMGroupName(nodegroup, preferredHosts)], groups[siteVMGroupName(nodegroup, secondaryHosts)])
+	groups[site].Vm = append(groups[site].Vm, vmRef)
+
+	task, err := cluster.Reconfigure(ctx, &spec, true)
+	if err == nil {
+		err = task.Wait(ctx)
+	}
+	if err != nil {
+		klog.Warningf("Could not add %s to site VM group %s: %v", name, site, err)
+		return
+	}
+	klog.V(1).Infof("Added %s to site VM group %s", name, site)
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestPickSiteVMGroup(t *testing.T) {
+	group := func(name string, members int) *types.ClusterVmGroup {
+		g := &types.ClusterVmGroup{ClusterGroupInfo: types.ClusterGroupInfo{Name: name}}
+		for i := 0; i < members; i++ {
+			g.Vm = append(g.Vm, types.ManagedObjectReference{Type: "VirtualMachine"})
+		}
+		return g
+	}
+
+	// Ties go to the preferred site, otherwise the emptier group fills up.
+	assert.Equal(t, "preferred", pickSiteVMGroup(group("preferred", 0), group("secondary", 0)))
+	assert.Equal(t, "secondary", pickSiteVMGroup(group("preferred", 1), group("secondary", 0)))
+	assert.Equal(t, "preferred", pickSiteVMGroup(group("preferred", 1), group("secondary", 3)))
+}
+
+func TestSiteVMGroupLookup(t *testing.T) {
+	config := &types.ClusterConfigInfoEx{
+		Group: []types.BaseClusterGroupInfo{
+			&types.ClusterHostGroup{ClusterGroupInfo: types.ClusterGroupInfo{Name: "site-a-hosts"}},
+			&types.ClusterVmGroup{ClusterGroupInfo: types.ClusterGroupInfo{Name: "k8s-pool-site-a-hosts"}},
+		},
+		Rule: []types.BaseClusterRuleInfo{
+			&types.ClusterVmHostRuleInfo{ClusterRuleInfo: types.ClusterRuleInfo{Name: "k8s-pool-site-a-hosts"}},
+		},
+	}
+
+	assert.NotNil(t, siteVMGroup(config, "k8s-pool-site-a-hosts"))
+	// Host groups of the same name are not VM groups.
+	assert.Nil(t, siteVMGroup(config, "site-a-hosts"))
+	assert.True(t, siteRuleExists(config, "k8s-pool-site-a-hosts"))
+	assert.False(t, siteRuleExists(config, "k8s-pool-site-b-hosts"))
+}
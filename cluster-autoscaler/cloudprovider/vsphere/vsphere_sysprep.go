@@ -0,0 +1,128 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// windowsTimeZone is the sysprep time zone index applied to Windows
+// clones: 85 is GMT, matching the UTC clock Linux templates run on.
+const windowsTimeZone = 85
+
+// windowsComputerNameLimit is the NetBIOS limit sysprep enforces on
+// computer names.
+const windowsComputerNameLimit = 15
+
+// windowsCustomization builds the sysprep guest customization spec for a
+// Windows clone. Windows templates have no cloud-init to read guestinfo,
+// so the hostname and network settings go through guest customization
+// instead, and the group's user-data file is interpreted as a list of
+// first-logon commands (one per line, # comments ignored) run via the
+// sysprep GuiRunOnce mechanism after the same placeholder rendering Linux
+// user-data gets.
+func (mgr *vsphereManagerGovmomi) windowsCustomization(nodegroup, name string) (*types.CustomizationSpec, error) {
+	if len(name) > windowsComputerNameLimit {
+		// vCenter would reject the customization much later, at clone
+		// time; warn early and let sysprep truncate.
+		klog.Warningf("Node name %s exceeds the %d character Windows computer name limit", name, windowsComputerNameLimit)
+	}
+
+	sysprep := &types.CustomizationSysprep{
+		GuiUnattended: types.CustomizationGuiUnattended{
+			TimeZone: windowsTimeZone,
+		},
+		UserData: types.CustomizationUserData{
+			FullName:     "Kubernetes Node",
+			OrgName:      "cluster-autoscaler",
+			ComputerName: &types.CustomizationFixedName{Name: name},
+		},
+		Identification: types.CustomizationIdentification{
+			JoinWorkgroup: "WORKGROUP",
+		},
+	}
+
+	spec := &types.CustomizationSpec{
+		Identity: sysprep,
+		NicSettingMap: []types.CustomizationAdapterMapping{
+			{Adapter: types.CustomizationIPSettings{Ip: &types.CustomizationDhcpIpGenerator{}}},
+		},
+	}
+
+	// The IP is allocated before the user-data is rendered so the {{ip}}
+	// placeholder can refer to it.
+	ip := ""
+	if mgr.ipam != nil {
+		allocation, err := mgr.ipam.allocate(nodegroup, name)
+		if err != nil {
+			return nil, fmt.Errorf("could not allocate IP for %s: %v", name, err)
+		}
+		klog.V(2).Infof("Allocated IP %s/%d for %s", allocation.Address, allocation.Prefix, name)
+		ip = allocation.Address
+		adapter := types.CustomizationIPSettings{
+			Ip:         &types.CustomizationFixedIp{IpAddress: allocation.Address},
+			SubnetMask: net.IP(net.CIDRMask(allocation.Prefix, 32)).String(),
+		}
+		if allocation.Gateway != "" {
+			adapter.Gateway = []string{allocation.Gateway}
+		}
+		spec.NicSettingMap = []types.CustomizationAdapterMapping{{Adapter: adapter}}
+		mgr.registerDNS(name, allocation.Address)
+	}
+
+	if userDataFile := mgr.config.userDataFor(nodegroup); userDataFile != "" {
+		rawUserData, err := ioutil.ReadFile(userDataFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read user-data file %s: %v", userDataFile, err)
+		}
+		userData, err := mgr.renderUserData(nodegroup, name, ip, string(rawUserData))
+		if err != nil {
+			mgr.releaseIP(name)
+			mgr.deregisterDNS(name)
+			return nil, err
+		}
+		if commands := windowsRunOnceCommands(userData); len(commands) > 0 {
+			sysprep.GuiRunOnce = &types.CustomizationGuiRunOnce{CommandList: commands}
+			// GuiRunOnce commands only run at first logon, so sysprep
+			// must log on automatically once.
+			sysprep.GuiUnattended.AutoLogon = true
+			sysprep.GuiUnattended.AutoLogonCount = 1
+		}
+	}
+
+	return spec, nil
+}
+
+// windowsRunOnceCommands splits Windows user-data into the sysprep
+// first-logon command list, dropping blank lines and # comments.
+func windowsRunOnceCommands(userData string) []string {
+	var commands []string
+	for _, line := range strings.Split(userData, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		commands = append(commands, line)
+	}
+	return commands
+}
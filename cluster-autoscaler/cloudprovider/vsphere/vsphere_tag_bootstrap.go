@@ -0,0 +1,82 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/klog"
+)
+
+const (
+	// defaultTagCategory is the category the autoscaler's tags are created
+	// in unless tag-category is set in the config.
+	defaultTagCategory = "k8s-autoscaler"
+	// tagCategoryCardinality allows multiple tags of the category on one
+	// VM: every node carries at least the cluster and the node group tag.
+	tagCategoryCardinality = "MULTIPLE"
+)
+
+// bootstrapTags makes sure the tag category and the cluster, node group and
+// warm pool tags exist, creating the missing ones, so a fresh vCenter needs
+// no manual tag setup before the autoscaler can run. Idempotent; existing
+// categories and tags are left alone.
+func (mgr *vsphereManagerGovmomi) bootstrapTags(ctx context.Context) error {
+	categoryName := mgr.config.Global.TagCategory
+	if categoryName == "" {
+		categoryName = defaultTagCategory
+	}
+
+	categoryID, err := mgr.activeTags().GetCategoryID(ctx, categoryName)
+	if err != nil {
+		return err
+	}
+	if categoryID == "" {
+		categoryID, err = mgr.activeTags().CreateCategory(ctx, categoryName, tagCategoryCardinality, []string{"VirtualMachine"})
+		if err != nil {
+			return err
+		}
+		klog.V(1).Infof("Created tag category %s", categoryName)
+	}
+
+	tagNames := []string{mgr.clusterTagName()}
+	for name := range mgr.config.NodeGroup {
+		if mgr.config.membershipFor(name) != membershipTags {
+			continue
+		}
+		tagNames = append(tagNames, mgr.nodeGroupTagName(name))
+		if mgr.config.warmPoolSizeFor(name) > 0 || mgr.config.scaleDownModeFor(name) != scaleDownModeDestroy {
+			tagNames = append(tagNames, warmPoolTagPrefix+name)
+		}
+	}
+
+	for _, tagName := range tagNames {
+		_, err := mgr.activeTags().GetTagID(ctx, tagName)
+		if err == nil {
+			continue
+		}
+		if !isTagNotFound(err) {
+			return err
+		}
+		if _, err := mgr.activeTags().CreateTag(ctx, tagName, categoryID); err != nil {
+			return fmt.Errorf("could not bootstrap tag %s: %v", tagName, err)
+		}
+		klog.V(1).Infof("Created tag %s in category %s", tagName, categoryName)
+	}
+	return nil
+}
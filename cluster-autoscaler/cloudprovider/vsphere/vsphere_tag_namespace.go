@@ -0,0 +1,99 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// defaultTagPrefix is the leading component of the autoscaler's tag
+// names; tag-prefix overrides it.
+const defaultTagPrefix = "k8s"
+
+// tagPrefix returns the configured tag name prefix.
+func (mgr *vsphereManagerGovmomi) tagPrefix() string {
+	if prefix := mgr.config.Global.TagPrefix; prefix != "" {
+		return prefix
+	}
+	return defaultTagPrefix
+}
+
+// clusterTagName returns the name of the tag attached to all VMs of this
+// cluster.
+func (mgr *vsphereManagerGovmomi) clusterTagName() string {
+	return mgr.tagPrefix() + "-cluster-" + mgr.clusterName
+}
+
+// nodeGroupTagName returns the name of the tag attached to all VMs of a
+// node group. With isolate-tags the name carries the cluster name, so
+// equally named node groups of different clusters on the same vCenter
+// cannot collide.
+func (mgr *vsphereManagerGovmomi) nodeGroupTagName(nodegroup string) string {
+	if mgr.config.Global.IsolateTags {
+		return mgr.tagPrefix() + "-nodegroup-" + mgr.clusterName + "-" + nodegroup
+	}
+	return mgr.tagPrefix() + "-nodegroup-" + nodegroup
+}
+
+// validateTagNamespace refuses startup when isolate-tags is on but the
+// tag category still contains un-namespaced node group tags. Those are
+// either leftovers of this cluster from before isolation — which would
+// silently stop matching — or tags of another cluster sharing the
+// category, which isolation exists to keep apart.
+func (mgr *vsphereManagerGovmomi) validateTagNamespace(ctx context.Context) error {
+	if !mgr.config.Global.IsolateTags {
+		return nil
+	}
+	for name := range mgr.config.NodeGroup {
+		legacy := mgr.tagPrefix() + "-nodegroup-" + name
+		_, err := mgr.activeTags().GetTagID(ctx, legacy)
+		if isTagNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not check for tag %s: %v", legacy, err)
+		}
+		return fmt.Errorf("tag category contains the un-namespaced tag %s; migrate it to %s or remove it before enabling isolate-tags", legacy, mgr.nodeGroupTagName(name))
+	}
+	return nil
+}
+
+// checkClusterMembership refuses to touch a VM that does not carry this
+// cluster's tag. With isolation on, a VM name collision across clusters
+// must not let one autoscaler destroy another cluster's node.
+func (mgr *vsphereManagerGovmomi) checkClusterMembership(ctx context.Context, vmRef types.ManagedObjectReference, name string) error {
+	if !mgr.config.Global.IsolateTags {
+		return nil
+	}
+	clusterTagID, err := mgr.activeTags().GetTagID(ctx, mgr.clusterTagName())
+	if err != nil {
+		return fmt.Errorf("could not look up tag %s: %v", mgr.clusterTagName(), err)
+	}
+	refs, err := mgr.activeTags().ListAttachedObjects(ctx, clusterTagID)
+	if err != nil {
+		return fmt.Errorf("could not list objects with tag %s: %v", mgr.clusterTagName(), err)
+	}
+	for _, ref := range refs {
+		if ref.Reference() == vmRef {
+			return nil
+		}
+	}
+	return fmt.Errorf("VM %s is not tagged for cluster %s, refusing to touch it", name, mgr.clusterName)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagNames(t *testing.T) {
+	mgr := testManager(newFakeTagService())
+
+	assert.Equal(t, "k8s-cluster-test", mgr.clusterTagName())
+	assert.Equal(t, "k8s-nodegroup-ng1", mgr.nodeGroupTagName("ng1"))
+
+	mgr.config.Global.IsolateTags = true
+	assert.Equal(t, "k8s-cluster-test", mgr.clusterTagName())
+	assert.Equal(t, "k8s-nodegroup-test-ng1", mgr.nodeGroupTagName("ng1"))
+
+	mgr.config.Global.TagPrefix = "acme"
+	assert.Equal(t, "acme-cluster-test", mgr.clusterTagName())
+	assert.Equal(t, "acme-nodegroup-test-ng1", mgr.nodeGroupTagName("ng1"))
+}
+
+func TestValidateTagNamespace(t *testing.T) {
+	ctx := context.TODO()
+
+	tags := newFakeTagService("k8s-nodegroup-ng1")
+	mgr := testManager(tags)
+	mgr.config.NodeGroup["ng1"] = &ConfigNodeGroup{}
+
+	// Without isolation the legacy tag is the expected tag.
+	assert.NoError(t, mgr.validateTagNamespace(ctx))
+
+	// With isolation the leftover un-namespaced tag must be rejected.
+	mgr.config.Global.IsolateTags = true
+	err := mgr.validateTagNamespace(ctx)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "k8s-nodegroup-ng1")
+	assert.Contains(t, err.Error(), "k8s-nodegroup-test-ng1")
+
+	// Once migrated, startup succeeds.
+	delete(tags.tags, "k8s-nodegroup-ng1")
+	tags.tags["k8s-nodegroup-test-ng1"] = "tag-0"
+	assert.NoError(t, mgr.validateTagNamespace(ctx))
+}
+
+func TestCheckClusterMembership(t *testing.T) {
+	ctx := context.TODO()
+
+	tags := newFakeTagService("k8s-cluster-test")
+	mgr := testManager(tags)
+	tags.attachments["tag-0"] = append(tags.attachments["tag-0"], vmRef("vm-1"))
+
+	// Without isolation any VM may be touched.
+	assert.NoError(t, mgr.checkClusterMembership(ctx, vmRef("vm-2"), "other-node"))
+
+	mgr.config.Global.IsolateTags = true
+	assert.NoError(t, mgr.checkClusterMembership(ctx, vmRef("vm-1"), "my-node"))
+
+	err := mgr.checkClusterMembership(ctx, vmRef("vm-2"), "other-node")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "other-node")
+}
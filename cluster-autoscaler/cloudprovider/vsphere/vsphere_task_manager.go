@@ -0,0 +1,328 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/property"
+	vctask "github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	k8smetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog"
+)
+
+const (
+	taskKindClone  = "clone"
+	taskKindDelete = "delete"
+
+	// taskPollInterval is how often outstanding task states are refreshed.
+	taskPollInterval = 30 * time.Second
+	// succeededTaskRetention is how long finished successful tasks are kept.
+	succeededTaskRetention = 5 * time.Minute
+	// failedTaskRetention is how long failed tasks are kept, so their error
+	// stays visible in instance status and Debug() output.
+	failedTaskRetention = time.Hour
+)
+
+var (
+	tasksInFlightMetric = k8smetrics.NewGaugeVec(
+		&k8smetrics.GaugeOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "vsphere_tasks_in_flight",
+			Help:      "Number of outstanding vCenter tasks started by the vsphere provider.",
+		}, []string{"kind"},
+	)
+	taskFailuresMetric = k8smetrics.NewCounterVec(
+		&k8smetrics.CounterOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "vsphere_task_failures_total",
+			Help:      "Number of failed vCenter tasks started by the vsphere provider.",
+		}, []string{"kind"},
+	)
+
+	registerTaskMetricsOnce sync.Once
+)
+
+// nodeTask is a vCenter task started for a specific node.
+type nodeTask struct {
+	nodegroup string
+	nodeName  string
+	kind      string
+	ref       types.ManagedObjectReference
+	state     types.TaskInfoState
+	message   string
+	// err is the raw error the task finished with; unlike message it still
+	// carries the vSphere fault, for error classification.
+	err     error
+	updated time.Time
+}
+
+// taskManager records the outstanding vCenter tasks per node and polls
+// their status asynchronously, so instance states and failure messages are
+// available without blocking on task completion.
+type taskManager struct {
+	sync.Mutex
+	vim *vim25.Client
+	// tracer is nil unless trace-endpoint is configured; started tasks are
+	// recorded on the current operation span.
+	tracer *tracer
+	// tasks is keyed by node (VM) name; a node has at most one task of
+	// interest at a time.
+	tasks map[string]*nodeTask
+}
+
+// newTaskManager creates a taskManager and starts its poll loop.
+func newTaskManager(vim *vim25.Client, tracer *tracer) *taskManager {
+	registerTaskMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(tasksInFlightMetric)
+		legacyregistry.MustRegister(taskFailuresMetric)
+	})
+
+	tm := &taskManager{
+		vim:    vim,
+		tracer: tracer,
+		tasks:  make(map[string]*nodeTask),
+	}
+	go tm.pollLoop()
+	return tm
+}
+
+// track records a newly started task for a node.
+func (tm *taskManager) track(nodegroup, nodeName, kind string, ref types.ManagedObjectReference) {
+	tm.Lock()
+	defer tm.Unlock()
+	tm.tasks[nodeName] = &nodeTask{
+		nodegroup: nodegroup,
+		nodeName:  nodeName,
+		kind:      kind,
+		ref:       ref,
+		state:     types.TaskInfoStateRunning,
+		updated:   time.Now(),
+	}
+	tasksInFlightMetric.WithLabelValues(kind).Inc()
+	tm.tracer.recordTaskID(ref.Value)
+}
+
+// complete records the final result of a task whose completion was observed
+// directly, e.g. by an inline wait.
+func (tm *taskManager) complete(nodeName string, err error) {
+	tm.Lock()
+	defer tm.Unlock()
+	task, ok := tm.tasks[nodeName]
+	if !ok {
+		return
+	}
+	tm.finish(task, err)
+}
+
+// finish transitions a task to its terminal state. Callers must hold the lock.
+func (tm *taskManager) finish(task *nodeTask, err error) {
+	if task.state != types.TaskInfoStateRunning && task.state != types.TaskInfoStateQueued {
+		return
+	}
+	tasksInFlightMetric.WithLabelValues(task.kind).Dec()
+	task.updated = time.Now()
+	if err != nil {
+		task.state = types.TaskInfoStateError
+		task.message = err.Error()
+		task.err = err
+		taskFailuresMetric.WithLabelValues(task.kind).Inc()
+	} else {
+		task.state = types.TaskInfoStateSuccess
+	}
+}
+
+// pollLoop periodically refreshes outstanding task states from vCenter and
+// prunes finished records.
+func (tm *taskManager) pollLoop() {
+	for range time.Tick(taskPollInterval) {
+		if err := tm.poll(context.TODO()); err != nil {
+			klog.Warningf("Could not poll vCenter tasks: %v", err)
+		}
+	}
+}
+
+// poll fetches the TaskInfo of all outstanding tasks in one property
+// collector call and updates the records.
+func (tm *taskManager) poll(ctx context.Context) error {
+	tm.Lock()
+	var refs []types.ManagedObjectReference
+	byRef := make(map[types.ManagedObjectReference]*nodeTask)
+	for _, task := range tm.tasks {
+		if task.state == types.TaskInfoStateRunning || task.state == types.TaskInfoStateQueued {
+			refs = append(refs, task.ref)
+			byRef[task.ref] = task
+		}
+	}
+	tm.prune()
+	tm.Unlock()
+
+	if len(refs) == 0 {
+		return nil
+	}
+
+	var taskObjects []mo.Task
+	pc := property.DefaultCollector(tm.vim)
+	if err := pc.Retrieve(ctx, refs, []string{"info"}, &taskObjects); err != nil {
+		return err
+	}
+
+	tm.Lock()
+	defer tm.Unlock()
+	for _, taskObject := range taskObjects {
+		task, ok := byRef[taskObject.Self]
+		if !ok {
+			continue
+		}
+		switch taskObject.Info.State {
+		case types.TaskInfoStateSuccess:
+			tm.finish(task, nil)
+		case types.TaskInfoStateError:
+			if taskObject.Info.Error != nil {
+				// Keep the fault so the error can be classified.
+				tm.finish(task, vctask.Error{LocalizedMethodFault: taskObject.Info.Error})
+			} else {
+				tm.finish(task, fmt.Errorf("task failed"))
+			}
+		default:
+			task.state = taskObject.Info.State
+			task.updated = time.Now()
+		}
+	}
+	return nil
+}
+
+// prune drops finished task records past their retention. Callers must hold the lock.
+func (tm *taskManager) prune() {
+	for nodeName, task := range tm.tasks {
+		switch task.state {
+		case types.TaskInfoStateSuccess:
+			if time.Since(task.updated) > succeededTaskRetention {
+				delete(tm.tasks, nodeName)
+			}
+		case types.TaskInfoStateError:
+			if time.Since(task.updated) > failedTaskRetention {
+				delete(tm.tasks, nodeName)
+			}
+		}
+	}
+}
+
+// forget drops the task record for a node, e.g. once its VM is gone.
+func (tm *taskManager) forget(nodeName string) {
+	tm.Lock()
+	defer tm.Unlock()
+	task, ok := tm.tasks[nodeName]
+	if !ok {
+		return
+	}
+	if task.state == types.TaskInfoStateRunning || task.state == types.TaskInfoStateQueued {
+		tasksInFlightMetric.WithLabelValues(task.kind).Dec()
+	}
+	delete(tm.tasks, nodeName)
+}
+
+// instanceStatus derives the cloudprovider instance status of a node from
+// its outstanding or recently failed task, or nil if there is none.
+func (tm *taskManager) instanceStatus(nodeName string) *cloudprovider.InstanceStatus {
+	tm.Lock()
+	defer tm.Unlock()
+	task, ok := tm.tasks[nodeName]
+	if !ok {
+		return nil
+	}
+
+	status := &cloudprovider.InstanceStatus{}
+	switch task.kind {
+	case taskKindClone:
+		status.State = cloudprovider.InstanceCreating
+	case taskKindDelete:
+		status.State = cloudprovider.InstanceDeleting
+	}
+	switch task.state {
+	case types.TaskInfoStateSuccess:
+		if task.kind == taskKindClone {
+			status.State = cloudprovider.InstanceRunning
+		}
+	case types.TaskInfoStateError:
+		class, code := classifyError(task.err)
+		status.ErrorInfo = &cloudprovider.InstanceErrorInfo{
+			ErrorClass:   class,
+			ErrorCode:    code,
+			ErrorMessage: task.message,
+		}
+	}
+	return status
+}
+
+// snapshot returns copies of all task records, finished ones included,
+// for the debug endpoint.
+func (tm *taskManager) snapshot() []nodeTask {
+	tm.Lock()
+	defer tm.Unlock()
+	tasks := make([]nodeTask, 0, len(tm.tasks))
+	for _, task := range tm.tasks {
+		tasks = append(tasks, *task)
+	}
+	return tasks
+}
+
+// inFlightClones returns copies of the clone tasks still queued or
+// running, across all node groups.
+func (tm *taskManager) inFlightClones() []*nodeTask {
+	tm.Lock()
+	defer tm.Unlock()
+	var running []*nodeTask
+	for _, task := range tm.tasks {
+		if task.kind != taskKindClone {
+			continue
+		}
+		if task.state != types.TaskInfoStateRunning && task.state != types.TaskInfoStateQueued {
+			continue
+		}
+		taskCopy := *task
+		running = append(running, &taskCopy)
+	}
+	return running
+}
+
+// pendingClones returns the names of nodes with an unfinished or failed
+// clone task, i.e. nodes that do not have a usable VM yet.
+func (tm *taskManager) pendingClones(nodegroup string) []*nodeTask {
+	tm.Lock()
+	defer tm.Unlock()
+	var pending []*nodeTask
+	for _, task := range tm.tasks {
+		if task.nodegroup != nodegroup || task.kind != taskKindClone {
+			continue
+		}
+		if task.state == types.TaskInfoStateSuccess {
+			continue
+		}
+		taskCopy := *task
+		pending = append(pending, &taskCopy)
+	}
+	return pending
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// replicaSourceVersionKey is the extraConfig key on a template replica
+// recording the changeVersion of the source template it was cloned from,
+// used to detect stale replicas.
+const replicaSourceVersionKey = "cluster-autoscaler.replica-source-version"
+
+// prewarmedTemplate returns the replica of the node group's template on the
+// group's datastore, creating it on first use and recreating it when the
+// source template has changed since. Cloning from the local replica avoids
+// the slow cross-datastore copy on every scale-up. Without a configured
+// datastore the source template is returned unchanged.
+func (mgr *vsphereManagerGovmomi) prewarmedTemplate(ctx context.Context, finder *find.Finder, nodegroup string, template *object.VirtualMachine, templateName string) (*object.VirtualMachine, string, error) {
+	datastore := mgr.config.datastoreFor(nodegroup)
+	if datastore == "" {
+		return template, templateName, nil
+	}
+
+	// Serialize replica management so concurrent clones do not race to
+	// create or replace the same replica.
+	mgr.prewarmMutex.Lock()
+	defer mgr.prewarmMutex.Unlock()
+
+	sourceVersion, err := mgr.templateChangeVersion(ctx, template.Reference())
+	if err != nil {
+		return nil, "", err
+	}
+	replicaName := fmt.Sprintf("%s-replica-%s", path.Base(templateName), path.Base(datastore))
+
+	replica, err := finder.VirtualMachine(ctx, replicaName)
+	if err == nil {
+		replicaVersion, err := mgr.replicaSourceVersion(ctx, replica.Reference())
+		if err != nil {
+			return nil, "", err
+		}
+		if replicaVersion == sourceVersion {
+			return replica, replicaName, nil
+		}
+		klog.V(1).Infof("Template replica %s is stale (source changed), recreating it", replicaName)
+		task, err := replica.Destroy(ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not destroy stale replica %s: %v", replicaName, err)
+		}
+		if err := task.Wait(ctx); err != nil {
+			return nil, "", fmt.Errorf("could not destroy stale replica %s: %v", replicaName, err)
+		}
+	}
+
+	folder, err := finder.Folder(ctx, mgr.config.folderFor(nodegroup))
+	if err != nil {
+		return nil, "", fmt.Errorf("could not find folder %s: %v", mgr.config.folderFor(nodegroup), err)
+	}
+	ds, err := finder.Datastore(ctx, datastore)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not find datastore %s: %v", datastore, err)
+	}
+	pool, err := finder.ResourcePool(ctx, mgr.config.resourcePoolFor(nodegroup))
+	if err != nil {
+		return nil, "", fmt.Errorf("could not find resource pool %s: %v", mgr.config.resourcePoolFor(nodegroup), err)
+	}
+
+	dsRef := ds.Reference()
+	poolRef := pool.Reference()
+	spec := types.VirtualMachineCloneSpec{
+		Location: types.VirtualMachineRelocateSpec{
+			Datastore: &dsRef,
+			Pool:      &poolRef,
+		},
+		Template: true,
+		Config: &types.VirtualMachineConfigSpec{
+			ExtraConfig: []types.BaseOptionValue{
+				&types.OptionValue{Key: createdByKey, Value: createdByValue},
+				&types.OptionValue{Key: replicaSourceVersionKey, Value: sourceVersion},
+			},
+		},
+	}
+
+	klog.V(1).Infof("Pre-warming template replica %s on datastore %s", replicaName, datastore)
+	task, err := template.Clone(ctx, folder, replicaName, spec)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not start replica clone of %s: %v", templateName, err)
+	}
+	info, err := task.WaitForResult(ctx, nil)
+	if err != nil {
+		return nil, "", asAutoscalerError(err).AddPrefix("replica clone of %s failed: ", templateName)
+	}
+	replicaRef, ok := info.Result.(types.ManagedObjectReference)
+	if !ok {
+		return nil, "", fmt.Errorf("replica clone task returned unexpected result %T", info.Result)
+	}
+	return object.NewVirtualMachine(mgr.activeClient().Vim, replicaRef), replicaName, nil
+}
+
+// templateChangeVersion returns the changeVersion of a template, the marker
+// used to tell whether a replica is still current.
+func (mgr *vsphereManagerGovmomi) templateChangeVersion(ctx context.Context, ref types.ManagedObjectReference) (string, error) {
+	vms, err := mgr.retrieveVMProperties(ctx, []types.ManagedObjectReference{ref}, []string{"config.changeVersion"})
+	if err != nil {
+		return "", err
+	}
+	if len(vms) != 1 || vms[0].Config == nil {
+		return "", fmt.Errorf("could not get changeVersion of template %s", ref.Value)
+	}
+	return vms[0].Config.ChangeVersion, nil
+}
+
+// replicaSourceVersion returns the source changeVersion a replica was
+// stamped with at creation; empty when the stamp is missing.
+func (mgr *vsphereManagerGovmomi) replicaSourceVersion(ctx context.Context, ref types.ManagedObjectReference) (string, error) {
+	vms, err := mgr.retrieveVMProperties(ctx, []types.ManagedObjectReference{ref}, []string{"config.extraConfig"})
+	if err != nil {
+		return "", err
+	}
+	if len(vms) != 1 || vms[0].Config == nil {
+		return "", nil
+	}
+	for _, option := range vms[0].Config.ExtraConfig {
+		if value := option.GetOptionValue(); value.Key == replicaSourceVersionKey {
+			version, _ := value.Value.(string)
+			return version, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// templateCacheTTL is how long a resolved template pattern stays cached
+// before the inventory is consulted again.
+const templateCacheTTL = 5 * time.Minute
+
+// templateResolver resolves template patterns like ubuntu-k8s-1.27-* to the
+// newest matching template, so node groups always clone the latest
+// published image without config changes. Resolutions are cached and a log
+// line records every selection change.
+type templateResolver struct {
+	sync.Mutex
+	entries map[string]templateCacheEntry
+	// baselines records, per node group, the template identity seen on
+	// first use, for the verify-template option.
+	baselines map[string]templateBaseline
+}
+
+type templateCacheEntry struct {
+	name     string
+	resolved time.Time
+}
+
+// templateBaseline pins a node group to the template it started with: the
+// managed object reference catches a replaced template, the change version
+// catches an edited one.
+type templateBaseline struct {
+	ref           types.ManagedObjectReference
+	changeVersion string
+}
+
+func newTemplateResolver() *templateResolver {
+	return &templateResolver{
+		entries:   make(map[string]templateCacheEntry),
+		baselines: make(map[string]templateBaseline),
+	}
+}
+
+// isTemplatePattern returns whether a template option is a glob pattern
+// rather than a plain name.
+func isTemplatePattern(template string) bool {
+	return strings.ContainsAny(template, "*?[")
+}
+
+// resolve returns the name of the newest template matching the pattern.
+// Plain names are returned as-is.
+func (r *templateResolver) resolve(ctx context.Context, mgr *vsphereManagerGovmomi, finder *find.Finder, pattern string) (string, error) {
+	if !isTemplatePattern(pattern) {
+		return pattern, nil
+	}
+
+	r.Lock()
+	entry, ok := r.entries[pattern]
+	r.Unlock()
+	if ok && time.Since(entry.resolved) < templateCacheTTL {
+		return entry.name, nil
+	}
+
+	vms, err := finder.VirtualMachineList(ctx, pattern)
+	if err != nil {
+		return "", fmt.Errorf("could not list templates matching %s: %v", pattern, err)
+	}
+	refs := make([]types.ManagedObjectReference, 0, len(vms))
+	for _, vm := range vms {
+		refs = append(refs, vm.Reference())
+	}
+	candidates, err := mgr.retrieveVMProperties(ctx, refs, []string{"name", "config.template", "config.createDate"})
+	if err != nil {
+		return "", err
+	}
+
+	var newest string
+	var newestDate time.Time
+	for _, vm := range candidates {
+		if vm.Config == nil || !vm.Config.Template {
+			continue
+		}
+		var created time.Time
+		if vm.Config.CreateDate != nil {
+			created = *vm.Config.CreateDate
+		}
+		// Tie-break by name so the selection is deterministic on vCenters
+		// that do not report a creation date.
+		if newest == "" || created.After(newestDate) || (created.Equal(newestDate) && vm.Name > newest) {
+			newest = vm.Name
+			newestDate = created
+		}
+	}
+	if newest == "" {
+		return "", fmt.Errorf("no template matches pattern %s", pattern)
+	}
+
+	r.Lock()
+	previous := entry.name
+	r.entries[pattern] = templateCacheEntry{name: newest, resolved: time.Now()}
+	r.Unlock()
+	if previous != newest {
+		klog.V(0).Infof("Template pattern %s now resolves to %s (was %q)", pattern, newest, previous)
+	}
+	return newest, nil
+}
+
+// verify checks that a node group's template is still the one the
+// autoscaler first cloned from. The first call records the baseline; later
+// calls fail once the template was replaced or reconfigured, so a template
+// update mid-rollout stops the rollout instead of silently mixing images.
+func (r *templateResolver) verify(nodegroup string, ref types.ManagedObjectReference, changeVersion string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	baseline, ok := r.baselines[nodegroup]
+	if !ok {
+		r.baselines[nodegroup] = templateBaseline{ref: ref, changeVersion: changeVersion}
+		return nil
+	}
+	if baseline.ref != ref {
+		return fmt.Errorf("template of node group %s changed from %s to %s since first use", nodegroup, baseline.ref.Value, ref.Value)
+	}
+	if baseline.changeVersion != changeVersion {
+		return fmt.Errorf("template %s of node group %s was reconfigured since first use (change version %s, was %s)", ref.Value, nodegroup, changeVersion, baseline.changeVersion)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// tlsVersions maps the min-tls-version config values to crypto/tls.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion parses a min-tls-version value; empty means Go's
+// default and parses to zero.
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	if v, ok := tlsVersions[version]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown min-tls-version %q, expected one of 1.0, 1.1, 1.2, 1.3", version)
+}
+
+// parseTLSCipherSuites parses a comma-separated list of IANA cipher suite
+// names into crypto/tls IDs; empty means Go's default and parses to nil.
+func parseTLSCipherSuites(list string) ([]uint16, error) {
+	if list == "" {
+		return nil, nil
+	}
+	byName := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// validateTLSConfig checks the TLS options at startup. With hardened-tls
+// the check fails closed: insecure-flag, a minimum version below 1.2 and
+// cipher suites Go considers insecure are all rejected, so a config that
+// violates the hardening never connects at all — even to a lab vCenter.
+func validateTLSConfig(cfg ConfigGlobal) error {
+	version, err := parseTLSVersion(cfg.MinTLSVersion)
+	if err != nil {
+		return err
+	}
+	suites, err := parseTLSCipherSuites(cfg.TLSCipherSuites)
+	if err != nil {
+		return err
+	}
+	if !cfg.HardenedTLS {
+		return nil
+	}
+	if cfg.InsecureFlag {
+		return fmt.Errorf("hardened-tls forbids insecure-flag: verify the vCenter certificate instead of skipping verification")
+	}
+	if version != 0 && version < tls.VersionTLS12 {
+		return fmt.Errorf("hardened-tls requires min-tls-version 1.2 or higher, got %s", cfg.MinTLSVersion)
+	}
+	insecure := make(map[uint16]string)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.ID] = suite.Name
+	}
+	for _, id := range suites {
+		if name, ok := insecure[id]; ok {
+			return fmt.Errorf("hardened-tls forbids the insecure cipher suite %s", name)
+		}
+	}
+	return nil
+}
+
+// minTLSVersion returns the effective minimum TLS version for vCenter
+// connections: the configured one, or TLS 1.2 under hardened-tls when
+// none is set, or zero for Go's default.
+func minTLSVersion(cfg ConfigGlobal) (uint16, error) {
+	version, err := parseTLSVersion(cfg.MinTLSVersion)
+	if err != nil {
+		return 0, err
+	}
+	if version == 0 && cfg.HardenedTLS {
+		version = tls.VersionTLS12
+	}
+	return version, nil
+}
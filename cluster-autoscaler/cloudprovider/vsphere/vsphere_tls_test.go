@@ -0,0 +1,91 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	version, err := parseTLSVersion("")
+	assert.NoError(t, err)
+	assert.Zero(t, version)
+
+	version, err = parseTLSVersion("1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), version)
+
+	_, err = parseTLSVersion("1.4")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown min-tls-version")
+}
+
+func TestParseTLSCipherSuites(t *testing.T) {
+	suites, err := parseTLSCipherSuites("")
+	assert.NoError(t, err)
+	assert.Nil(t, suites)
+
+	suites, err = parseTLSCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, suites)
+
+	_, err = parseTLSCipherSuites("TLS_TOTALLY_MADE_UP")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown TLS cipher suite")
+}
+
+func TestValidateTLSConfig(t *testing.T) {
+	// Without hardening, anything that parses is accepted.
+	assert.NoError(t, validateTLSConfig(ConfigGlobal{InsecureFlag: true, MinTLSVersion: "1.0"}))
+	assert.Error(t, validateTLSConfig(ConfigGlobal{MinTLSVersion: "ssl3"}))
+
+	// Hardening fails closed on skip-verify.
+	err := validateTLSConfig(ConfigGlobal{HardenedTLS: true, InsecureFlag: true})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "forbids insecure-flag")
+
+	// ... on pre-1.2 TLS ...
+	err = validateTLSConfig(ConfigGlobal{HardenedTLS: true, MinTLSVersion: "1.1"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "1.2 or higher")
+
+	// ... and on cipher suites Go considers insecure.
+	err = validateTLSConfig(ConfigGlobal{HardenedTLS: true, TLSCipherSuites: "TLS_RSA_WITH_RC4_128_SHA"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "insecure cipher suite")
+
+	assert.NoError(t, validateTLSConfig(ConfigGlobal{HardenedTLS: true, MinTLSVersion: "1.2", TLSCipherSuites: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}))
+}
+
+func TestMinTLSVersion(t *testing.T) {
+	version, err := minTLSVersion(ConfigGlobal{})
+	assert.NoError(t, err)
+	assert.Zero(t, version)
+
+	// Hardening raises the default floor to 1.2 ...
+	version, err = minTLSVersion(ConfigGlobal{HardenedTLS: true})
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), version)
+
+	// ... but an explicit stricter version wins.
+	version, err = minTLSVersion(ConfigGlobal{HardenedTLS: true, MinTLSVersion: "1.3"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), version)
+}
@@ -0,0 +1,403 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/soap"
+	"k8s.io/klog"
+)
+
+const (
+	// traceExportTimeout bounds one OTLP export request.
+	traceExportTimeout = 10 * time.Second
+	// maxBufferedSpans caps how many finished spans are held between
+	// exports; beyond it new spans are dropped rather than growing without
+	// bound while the collector is down.
+	maxBufferedSpans = 512
+
+	// OTLP span kinds.
+	spanKindInternal = 1
+	spanKindClient   = 3
+	// OTLP status code for a failed span.
+	statusCodeError = 2
+)
+
+// traceSpan is one operation or vCenter call being traced. All methods are
+// safe on a nil span, so call sites do not need to check whether tracing
+// is configured.
+type traceSpan struct {
+	tracer   *tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mutex sync.Mutex
+	attrs []spanAttr
+	// taskIDs are the vCenter tasks started under this span, exported as
+	// one attribute.
+	taskIDs []string
+	ended   time.Time
+	err     error
+}
+
+type spanAttr struct {
+	key   string
+	value string
+}
+
+// setAttribute records a string attribute on the span.
+func (s *traceSpan) setAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.attrs = append(s.attrs, spanAttr{key: key, value: value})
+	s.mutex.Unlock()
+}
+
+// addTaskID records a vCenter task started under this span.
+func (s *traceSpan) addTaskID(taskID string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.taskIDs = append(s.taskIDs, taskID)
+	s.mutex.Unlock()
+}
+
+// end finishes the span with the operation's outcome and hands it to the
+// tracer for export.
+func (s *traceSpan) end(err error) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.ended = time.Now()
+	s.err = err
+	s.mutex.Unlock()
+	s.tracer.record(s)
+}
+
+// tracer buffers finished spans and exports them to an OTLP/HTTP endpoint.
+// One scale operation (or Refresh) is the root span; the vCenter calls it
+// makes become child spans, so a slow scale-up is attributable to the
+// individual round trips. Export failures only drop spans, they never fail
+// the operation being traced.
+type tracer struct {
+	endpoint string
+	client   *http.Client
+
+	mutex sync.Mutex
+	// active is the operation span vCenter call spans parent to. Scale
+	// operations are serialised by the node groups' update mutex, so a
+	// single slot is sufficient.
+	active   *traceSpan
+	finished []*traceSpan
+	dropped  int
+}
+
+// newTracer returns a tracer exporting to the given OTLP/HTTP endpoint;
+// spans are POSTed to <endpoint>/v1/traces.
+func newTracer(endpoint string) *tracer {
+	return &tracer{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: traceExportTimeout},
+	}
+}
+
+// startOperation starts a root span for a scale operation or Refresh and
+// makes it the parent of subsequent vCenter call spans.
+func (t *tracer) startOperation(name string) *traceSpan {
+	if t == nil {
+		return nil
+	}
+	span := &traceSpan{
+		tracer: t,
+		name:   name,
+		kind:   spanKindInternal,
+		start:  time.Now(),
+	}
+	fillRandom(span.traceID[:])
+	fillRandom(span.spanID[:])
+	t.mutex.Lock()
+	t.active = span
+	t.mutex.Unlock()
+	return span
+}
+
+// startCall starts a span for a single vCenter call, parented to the
+// active operation span when there is one.
+func (t *tracer) startCall(name string) *traceSpan {
+	if t == nil {
+		return nil
+	}
+	span := &traceSpan{
+		tracer: t,
+		name:   name,
+		kind:   spanKindClient,
+		start:  time.Now(),
+	}
+	t.mutex.Lock()
+	parent := t.active
+	t.mutex.Unlock()
+	if parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		fillRandom(span.traceID[:])
+	}
+	fillRandom(span.spanID[:])
+	return span
+}
+
+// recordTaskID attributes a started vCenter task to the active operation
+// span, if any.
+func (t *tracer) recordTaskID(taskID string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	active := t.active
+	t.mutex.Unlock()
+	active.addTaskID(taskID)
+}
+
+// record buffers a finished span for the next export.
+func (t *tracer) record(span *traceSpan) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.active == span {
+		t.active = nil
+	}
+	if len(t.finished) >= maxBufferedSpans {
+		t.dropped++
+		return
+	}
+	t.finished = append(t.finished, span)
+}
+
+// flush exports the buffered spans. The provider calls it once per loop;
+// a failed export drops the batch.
+func (t *tracer) flush() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	spans := t.finished
+	dropped := t.dropped
+	t.finished = nil
+	t.dropped = 0
+	t.mutex.Unlock()
+
+	if dropped > 0 {
+		klog.Warningf("Dropped %d trace spans: the buffer filled before the last export", dropped)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		klog.Errorf("Could not marshal trace spans: %v", err)
+		return
+	}
+	resp, err := t.client.Post(t.endpoint+"/v1/traces", "application/json", bytes.NewReader(body))
+	if err != nil {
+		klog.Warningf("Could not export %d trace spans to %s: %v", len(spans), t.endpoint, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		klog.Warningf("Could not export %d trace spans to %s: HTTP %d", len(spans), t.endpoint, resp.StatusCode)
+	}
+}
+
+// fillRandom fills a trace or span ID with random bytes.
+func fillRandom(id []byte) {
+	if _, err := rand.Read(id); err != nil {
+		// crypto/rand does not fail on supported platforms; IDs only need
+		// to be unique, so fall back to the clock.
+		now := time.Now().UnixNano()
+		for i := range id {
+			id[i] = byte(now >> (uint(i%8) * 8))
+		}
+	}
+}
+
+// The OTLP/HTTP JSON request layout (the JSON mapping of
+// ExportTraceServiceRequest), reduced to the fields the tracer emits.
+type otlpExport struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// otlpRequest converts finished spans to one OTLP export request.
+func otlpRequest(spans []*traceSpan) otlpExport {
+	converted := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		converted = append(converted, otlpFromSpan(span))
+	}
+	return otlpExport{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttr{{
+					Key:   "service.name",
+					Value: otlpValue{StringValue: "cluster-autoscaler"},
+				}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "cluster-autoscaler/cloudprovider/vsphere"},
+				Spans: converted,
+			}},
+		}},
+	}
+}
+
+func otlpFromSpan(span *traceSpan) otlpSpan {
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+
+	out := otlpSpan{
+		TraceID:           hex.EncodeToString(span.traceID[:]),
+		SpanID:            hex.EncodeToString(span.spanID[:]),
+		Name:              span.name,
+		Kind:              span.kind,
+		StartTimeUnixNano: fmt.Sprintf("%d", span.start.UnixNano()),
+		EndTimeUnixNano:   fmt.Sprintf("%d", span.ended.UnixNano()),
+	}
+	if span.parentID != [8]byte{} {
+		out.ParentSpanID = hex.EncodeToString(span.parentID[:])
+	}
+	for _, attr := range span.attrs {
+		out.Attributes = append(out.Attributes, otlpAttr{Key: attr.key, Value: otlpValue{StringValue: attr.value}})
+	}
+	if len(span.taskIDs) > 0 {
+		out.Attributes = append(out.Attributes, otlpAttr{Key: "vcenter.tasks", Value: otlpValue{StringValue: strings.Join(span.taskIDs, ",")}})
+	}
+	if span.err != nil {
+		out.Status = otlpStatus{Code: statusCodeError, Message: span.err.Error()}
+	}
+	return out
+}
+
+// operationTracer is implemented by managers that export trace spans; the
+// node groups and the provider wrap their operations in spans through it.
+type operationTracer interface {
+	startOperationSpan(name string) *traceSpan
+	flushSpans()
+}
+
+// startOperationSpan starts a root span, or returns a no-op span when
+// trace-endpoint is not configured.
+func (mgr *vsphereManagerGovmomi) startOperationSpan(name string) *traceSpan {
+	return mgr.tracer.startOperation(name)
+}
+
+// flushSpans exports the spans finished since the last loop.
+func (mgr *vsphereManagerGovmomi) flushSpans() {
+	mgr.tracer.flush()
+}
+
+// tracingRoundTripper traces every SOAP round trip as a client span named
+// after the vSphere method.
+type tracingRoundTripper struct {
+	next   soap.RoundTripper
+	tracer *tracer
+}
+
+func (rt *tracingRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	span := rt.tracer.startCall(soapCallName(req))
+	err := rt.next.RoundTrip(ctx, req, res)
+	span.end(err)
+	return err
+}
+
+// soapCallName derives the vSphere method name from the request body type,
+// e.g. *methods.CloneVM_TaskBody becomes vCenter.CloneVM_Task.
+func soapCallName(req soap.HasFault) string {
+	name := fmt.Sprintf("%T", req)
+	name = strings.TrimPrefix(name, "*")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return "vCenter." + strings.TrimSuffix(name, "Body")
+}
+
+// traceClient starts tracing the client's SOAP calls; a nil tracer leaves
+// the client untouched.
+func traceClient(c *VsphereClient, t *tracer) {
+	if t == nil {
+		return
+	}
+	c.Vim.RoundTripper = &tracingRoundTripper{next: c.Vim.RoundTripper, tracer: t}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+func TestTracerExport(t *testing.T) {
+	var exported otlpExport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(body, &exported))
+	}))
+	defer server.Close()
+
+	tracer := newTracer(server.URL)
+
+	op := tracer.startOperation("IncreaseSize")
+	op.setAttribute("nodegroup", "ng1")
+	call := tracer.startCall("vCenter.CloneVM_Task")
+	tracer.recordTaskID("task-42")
+	call.end(nil)
+	op.end(fmt.Errorf("clone failed"))
+	tracer.flush()
+
+	if !assert.Len(t, exported.ResourceSpans, 1) {
+		return
+	}
+	spans := exported.ResourceSpans[0].ScopeSpans[0].Spans
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	callSpan, opSpan := spans[0], spans[1]
+
+	assert.Equal(t, "vCenter.CloneVM_Task", callSpan.Name)
+	assert.Equal(t, opSpan.TraceID, callSpan.TraceID)
+	assert.Equal(t, opSpan.SpanID, callSpan.ParentSpanID)
+	assert.Equal(t, spanKindClient, callSpan.Kind)
+
+	assert.Equal(t, "IncreaseSize", opSpan.Name)
+	assert.Empty(t, opSpan.ParentSpanID)
+	assert.Contains(t, opSpan.Attributes, otlpAttr{Key: "nodegroup", Value: otlpValue{StringValue: "ng1"}})
+	assert.Contains(t, opSpan.Attributes, otlpAttr{Key: "vcenter.tasks", Value: otlpValue{StringValue: "task-42"}})
+	assert.Equal(t, statusCodeError, opSpan.Status.Code)
+	assert.Equal(t, "clone failed", opSpan.Status.Message)
+
+	// A flush with nothing buffered must not POST an empty batch.
+	exported = otlpExport{}
+	tracer.flush()
+	assert.Empty(t, exported.ResourceSpans)
+}
+
+func TestTracerDisabled(t *testing.T) {
+	// All tracing entry points must be no-ops when trace-endpoint is not
+	// configured, i.e. on a nil tracer and nil spans.
+	var tracer *tracer
+	span := tracer.startOperation("IncreaseSize")
+	span.setAttribute("nodegroup", "ng1")
+	tracer.recordTaskID("task-42")
+	tracer.startCall("vCenter.CloneVM_Task").end(nil)
+	span.end(nil)
+	tracer.flush()
+}
+
+// fakeCloneVMTaskBody stands in for a govmomi request body type.
+type fakeCloneVMTaskBody struct{}
+
+func (fakeCloneVMTaskBody) Fault() *soap.Fault { return nil }
+
+func TestSoapCallName(t *testing.T) {
+	assert.Equal(t, "vCenter.fakeCloneVMTask", soapCallName(&fakeCloneVMTaskBody{}))
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+)
+
+// parseLabels parses a comma separated list of key=value labels, as given
+// in the labels= option of a nodegroup config section.
+func parseLabels(labels string) (map[string]string, error) {
+	result := make(map[string]string)
+	if labels == "" {
+		return result, nil
+	}
+	for _, label := range strings.Split(labels, ",") {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", label)
+		}
+		result[parts[0]] = parts[1]
+	}
+	return result, nil
+}
+
+// parseTaints parses a comma separated list of key=value:Effect taints, as
+// given in the taints= option of a nodegroup config section.
+func parseTaints(taints string) ([]apiv1.Taint, error) {
+	var result []apiv1.Taint
+	if taints == "" {
+		return result, nil
+	}
+	for _, taint := range strings.Split(taints, ",") {
+		valueEffect := strings.SplitN(taint, "=", 2)
+		if len(valueEffect) != 2 || valueEffect[0] == "" {
+			return nil, fmt.Errorf("invalid taint %q, expected key=value:Effect", taint)
+		}
+		parts := strings.SplitN(valueEffect[1], ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid taint %q, expected key=value:Effect", taint)
+		}
+		result = append(result, apiv1.Taint{
+			Key:    valueEffect[0],
+			Value:  parts[0],
+			Effect: apiv1.TaintEffect(parts[1]),
+		})
+	}
+	return result, nil
+}
+
+// formatLabels formats labels as the comma separated key=value list passed
+// to the kubelet --node-labels flag, in deterministic order.
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, labels[key]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// formatTaints formats taints as the comma separated key=value:Effect list
+// passed to the kubelet --register-with-taints flag.
+func formatTaints(taints []apiv1.Taint) string {
+	pairs := make([]string, 0, len(taints))
+	for _, taint := range taints {
+		pairs = append(pairs, fmt.Sprintf("%s=%s:%s", taint.Key, taint.Value, taint.Effect))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// kubeletExtraArgs builds the kubelet flags that register a node with the
+// configured labels and taints and carry the node group's resource
+// reserves and eviction thresholds.
+func kubeletExtraArgs(labels map[string]string, taints []apiv1.Taint, kubeReserved, systemReserved, evictionHard string) string {
+	var args []string
+	if len(labels) > 0 {
+		args = append(args, fmt.Sprintf("--node-labels=%s", formatLabels(labels)))
+	}
+	if len(taints) > 0 {
+		args = append(args, fmt.Sprintf("--register-with-taints=%s", formatTaints(taints)))
+	}
+	if kubeReserved != "" {
+		args = append(args, fmt.Sprintf("--kube-reserved=%s", kubeReserved))
+	}
+	if systemReserved != "" {
+		args = append(args, fmt.Sprintf("--system-reserved=%s", systemReserved))
+	}
+	if evictionHard != "" {
+		args = append(args, fmt.Sprintf("--eviction-hard=%s", evictionHard))
+	}
+	return strings.Join(args, " ")
+}
+
+// nodegroupLabel is the label carrying the node group name. The node group
+// set comparator ignores it, so groups cloned from the same template size
+// are recognized as similar by --balance-similar-node-groups.
+const nodegroupLabel = "vsphere.vmware.com/nodegroup"
+
+// zoneLabels returns the topology labels for a zone and region, in both the
+// stable and the legacy beta form so selectors against either keep working.
+func zoneLabels(zone string, region string) map[string]string {
+	result := make(map[string]string)
+	if zone != "" {
+		result[apiv1.LabelZoneFailureDomain] = zone
+		result[apiv1.LabelZoneFailureDomainStable] = zone
+	}
+	if region != "" {
+		result[apiv1.LabelZoneRegion] = region
+		result[apiv1.LabelZoneRegionStable] = region
+	}
+	return result
+}
+
+// buildGenericLabels builds the basic labels every template node carries.
+// The template name doubles as the instance type, as it determines the
+// hardware shape of the cloned VMs. The os label is set in both the stable
+// and the legacy beta form, so Windows pods selecting either stick to
+// Windows groups in the scheduling simulation.
+func buildGenericLabels(nodegroup string, template string, nodeName string, guestOS string) map[string]string {
+	result := make(map[string]string)
+	result[kubeletapis.LabelArch] = cloudprovider.DefaultArch
+	result[kubeletapis.LabelOS] = guestOS
+	result[apiv1.LabelOSStable] = guestOS
+	result[apiv1.LabelInstanceType] = template
+	result[apiv1.LabelHostname] = nodeName
+	result[nodegroupLabel] = nodegroup
+	return result
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := parseLabels("pool=highmem,disk=ssd")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"pool": "highmem", "disk": "ssd"}, labels)
+
+	labels, err = parseLabels("")
+	assert.NoError(t, err)
+	assert.Empty(t, labels)
+
+	_, err = parseLabels("novalue")
+	assert.Error(t, err)
+
+	_, err = parseLabels("=missingkey")
+	assert.Error(t, err)
+}
+
+func TestParseTaints(t *testing.T) {
+	taints, err := parseTaints("dedicated=gpu:NoSchedule,other=thing:PreferNoSchedule")
+	assert.NoError(t, err)
+	assert.Equal(t, []apiv1.Taint{
+		{Key: "dedicated", Value: "gpu", Effect: apiv1.TaintEffectNoSchedule},
+		{Key: "other", Value: "thing", Effect: apiv1.TaintEffectPreferNoSchedule},
+	}, taints)
+
+	taints, err = parseTaints("")
+	assert.NoError(t, err)
+	assert.Empty(t, taints)
+
+	_, err = parseTaints("dedicated=gpu")
+	assert.Error(t, err)
+
+	_, err = parseTaints("dedicated:NoSchedule")
+	assert.Error(t, err)
+}
+
+func TestKubeletExtraArgs(t *testing.T) {
+	args := kubeletExtraArgs(
+		map[string]string{"pool": "highmem", "disk": "ssd"},
+		[]apiv1.Taint{{Key: "dedicated", Value: "gpu", Effect: apiv1.TaintEffectNoSchedule}},
+		"", "", "",
+	)
+	assert.Equal(t, "--node-labels=disk=ssd,pool=highmem --register-with-taints=dedicated=gpu:NoSchedule", args)
+
+	args = kubeletExtraArgs(nil, nil, "cpu=100m,memory=500Mi", "memory=200Mi", "memory.available<200Mi")
+	assert.Equal(t, "--kube-reserved=cpu=100m,memory=500Mi --system-reserved=memory=200Mi --eviction-hard=memory.available<200Mi", args)
+
+	assert.Equal(t, "", kubeletExtraArgs(nil, nil, "", "", ""))
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// validateConfig resolves everything the config names in the vCenter
+// inventory — template, resource pool, folder, datastore, template
+// snapshot — and checks the clone spec options for every configured node
+// group, returning one error listing all problems. This turns a typo in
+// the config into a clear startup failure instead of a failed scale-up
+// hours later.
+func (mgr *vsphereManagerGovmomi) validateConfig(ctx context.Context) error {
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		return fmt.Errorf("config validation failed: %v", err)
+	}
+
+	nodegroups := make([]string, 0, len(mgr.config.NodeGroup))
+	for name := range mgr.config.NodeGroup {
+		nodegroups = append(nodegroups, name)
+	}
+	if len(nodegroups) == 0 {
+		// No per-group sections: validate the global defaults once.
+		nodegroups = append(nodegroups, "")
+	}
+	sort.Strings(nodegroups)
+
+	var problems []string
+	report := func(nodegroup, format string, args ...interface{}) {
+		prefix := "global config"
+		if nodegroup != "" {
+			prefix = fmt.Sprintf("node group %s", nodegroup)
+		}
+		problems = append(problems, fmt.Sprintf("%s: %s", prefix, fmt.Sprintf(format, args...)))
+	}
+
+	for _, nodegroup := range nodegroups {
+		templateName, err := mgr.resolveTemplate(ctx, finder, nodegroup)
+		if err != nil {
+			report(nodegroup, "%v", err)
+		} else if template, err := finder.VirtualMachine(ctx, templateName); err != nil {
+			report(nodegroup, "template %s not found: %v", templateName, err)
+		} else if snapshot := mgr.config.templateSnapshotFor(nodegroup); snapshot != "" {
+			if _, err := template.FindSnapshot(ctx, snapshot); err != nil {
+				report(nodegroup, "snapshot %s of template %s not found: %v", snapshot, templateName, err)
+			}
+		}
+
+		if poolPath := mgr.config.resourcePoolFor(nodegroup); poolPath == "" {
+			report(nodegroup, "no resource pool configured")
+		} else if pool, err := finder.ResourcePool(ctx, poolPath); err != nil {
+			report(nodegroup, "resource pool %s not found: %v", poolPath, err)
+		} else if ng, ok := mgr.config.NodeGroup[nodegroup]; ok && ng.NUMANodeAffinity != "" {
+			// Check the NUMA affinity against the weakest host backing the
+			// pool, so a clone cannot name a NUMA node no host has.
+			nodes, err := parseNUMAAffinity(ng.NUMANodeAffinity)
+			if err != nil {
+				report(nodegroup, "%v", err)
+			} else if count, err := mgr.numaNodeCount(ctx, pool); err != nil {
+				report(nodegroup, "could not check numa-node-affinity: %v", err)
+			} else if count > 0 {
+				for _, node := range nodes {
+					if node >= count {
+						report(nodegroup, "numa-node-affinity names node %d but the smallest host of pool %s has only %d NUMA nodes", node, poolPath, count)
+					}
+				}
+			}
+		}
+
+		if folder := mgr.config.folderFor(nodegroup); folder == "" {
+			report(nodegroup, "no folder configured")
+		} else if _, err := finder.Folder(ctx, folder); err != nil {
+			report(nodegroup, "folder %s not found: %v", folder, err)
+		}
+
+		datastore := mgr.config.datastoreFor(nodegroup)
+		if datastore != "" {
+			if _, err := finder.Datastore(ctx, datastore); err != nil {
+				report(nodegroup, "datastore %s not found: %v", datastore, err)
+			}
+		}
+
+		switch provisioning := mgr.config.diskProvisioningFor(nodegroup); provisioning {
+		case "", diskProvisioningThin, diskProvisioningThick, diskProvisioningEagerZeroedThick:
+			if provisioning != "" && datastore == "" {
+				report(nodegroup, "disk-provisioning requires a datastore")
+			}
+		default:
+			report(nodegroup, "invalid disk-provisioning %q", provisioning)
+		}
+
+		switch firmware := mgr.config.firmwareFor(nodegroup); firmware {
+		case "", string(types.GuestOsDescriptorFirmwareTypeBios), string(types.GuestOsDescriptorFirmwareTypeEfi):
+			if mgr.config.secureBootFor(nodegroup) && firmware == string(types.GuestOsDescriptorFirmwareTypeBios) {
+				report(nodegroup, "secure-boot enabled but firmware set to bios")
+			}
+		default:
+			report(nodegroup, "invalid firmware %q, must be bios or efi", firmware)
+		}
+
+		if userDataFile := mgr.config.userDataFor(nodegroup); userDataFile != "" {
+			if _, err := ioutil.ReadFile(userDataFile); err != nil {
+				report(nodegroup, "could not read user-data file %s: %v", userDataFile, err)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("cloud config validation found %d problems:\n  %s", len(problems), strings.Join(problems, "\n  "))
+	}
+	klog.V(1).Infof("Cloud config validated, all inventory objects of %d node group configs resolved", len(nodegroups))
+	return nil
+}
+
+// numaNodeCount returns the smallest number of NUMA nodes across the hosts
+// backing a resource pool. Zero means no host reported NUMA information.
+func (mgr *vsphereManagerGovmomi) numaNodeCount(ctx context.Context, pool *object.ResourcePool) (int, error) {
+	pc := property.DefaultCollector(mgr.activeClient().Vim)
+
+	var poolProps mo.ResourcePool
+	if err := pc.RetrieveOne(ctx, pool.Reference(), []string{"owner"}, &poolProps); err != nil {
+		return 0, fmt.Errorf("could not get resource pool owner: %v", err)
+	}
+	var compute mo.ComputeResource
+	if err := pc.RetrieveOne(ctx, poolProps.Owner, []string{"host"}, &compute); err != nil {
+		return 0, fmt.Errorf("could not get compute resource hosts: %v", err)
+	}
+	if len(compute.Host) == 0 {
+		return 0, nil
+	}
+	var hosts []mo.HostSystem
+	if err := pc.Retrieve(ctx, compute.Host, []string{"hardware.numaInfo"}, &hosts); err != nil {
+		return 0, fmt.Errorf("could not get host NUMA info: %v", err)
+	}
+
+	count := 0
+	for _, host := range hosts {
+		if host.Hardware == nil || host.Hardware.NumaInfo == nil {
+			continue
+		}
+		if count == 0 || int(host.Hardware.NumaInfo.NumNodes) < count {
+			count = int(host.Hardware.NumaInfo.NumNodes)
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+// reconcileMoRefs re-resolves the inventory snapshot's managed object
+// references by instance UUID, fixing up VMs whose MoRef changed — a
+// cross-vCenter vMotion assigns the VM a new one, which would otherwise
+// make ID-based tracking report the node as gone. Run after migration
+// events; VMs whose UUID resolves nowhere are left for the regular gone
+// node handling.
+func (mgr *vsphereManagerGovmomi) reconcileMoRefs(ctx context.Context) {
+	if mgr.activeClient() == nil {
+		return
+	}
+	mgr.inventoryMutex.RLock()
+	snapshot := mgr.inventory
+	mgr.inventoryMutex.RUnlock()
+	if snapshot == nil || len(snapshot.uuidByRef) == 0 {
+		return
+	}
+
+	index := object.NewSearchIndex(mgr.activeClient().Vim)
+	instanceUUID := true
+	moved := make(map[types.ManagedObjectReference]types.ManagedObjectReference)
+	for ref, uuid := range snapshot.uuidByRef {
+		found, err := index.FindByUuid(ctx, nil, uuid, true, &instanceUUID)
+		if err != nil {
+			klog.Warningf("Could not re-resolve VM with instance UUID %s: %v", uuid, err)
+			continue
+		}
+		if found == nil {
+			continue
+		}
+		if found.Reference() != ref {
+			klog.V(0).Infof("VM with instance UUID %s migrated, MoRef %s -> %s", uuid, ref.Value, found.Reference().Value)
+			moved[ref] = found.Reference()
+		}
+	}
+	if len(moved) == 0 {
+		return
+	}
+
+	mgr.inventoryMutex.Lock()
+	defer mgr.inventoryMutex.Unlock()
+	if mgr.inventory != snapshot {
+		// A sweep replaced the snapshot meanwhile and saw the new MoRefs
+		// itself.
+		return
+	}
+	snapshot.applyMoRefChanges(moved)
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestApplyMoRefChanges(t *testing.T) {
+	snapshot := &inventorySnapshot{
+		vmsByGroup: map[string][]types.ManagedObjectReference{
+			"ng1": {vmRef("vm-1"), vmRef("vm-2")},
+			"ng2": {vmRef("vm-3")},
+		},
+		uuidByRef: map[types.ManagedObjectReference]string{
+			vmRef("vm-1"): "uuid-1",
+			vmRef("vm-2"): "uuid-2",
+			vmRef("vm-3"): "uuid-3",
+		},
+		taken: time.Now(),
+	}
+
+	// vm-2 migrated and came back with a new MoRef.
+	snapshot.applyMoRefChanges(map[types.ManagedObjectReference]types.ManagedObjectReference{
+		vmRef("vm-2"): vmRef("vm-99"),
+	})
+
+	assert.Equal(t, []types.ManagedObjectReference{vmRef("vm-1"), vmRef("vm-99")}, snapshot.vmsByGroup["ng1"])
+	assert.Equal(t, []types.ManagedObjectReference{vmRef("vm-3")}, snapshot.vmsByGroup["ng2"])
+	assert.Equal(t, "uuid-2", snapshot.uuidByRef[vmRef("vm-99")])
+	_, stale := snapshot.uuidByRef[vmRef("vm-2")]
+	assert.False(t, stale)
+}
@@ -0,0 +1,334 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+const (
+	// warmPoolTagPrefix is the prefix of the vSphere tag attached to
+	// powered-off standby VMs waiting in a node group's warm pool.
+	warmPoolTagPrefix = "k8s-warmpool-"
+	// pooledAtKey is the extraConfig key recording when a VM entered the
+	// warm pool, used to enforce the pool TTL.
+	pooledAtKey = "cluster-autoscaler.pooled-at"
+)
+
+// warmPoolEnabled returns whether a node group uses the warm pool, either
+// by keeping standby VMs or by hibernating on scale-down.
+func (mgr *vsphereManagerGovmomi) warmPoolEnabled(nodegroup string) bool {
+	return mgr.config.warmPoolSizeFor(nodegroup) > 0 ||
+		mgr.config.scaleDownModeFor(nodegroup) != scaleDownModeDestroy
+}
+
+// warmPoolVMs returns the references of the standby VMs in a node group's
+// warm pool.
+func (mgr *vsphereManagerGovmomi) warmPoolVMs(ctx context.Context, nodegroup string) ([]types.ManagedObjectReference, error) {
+	return mgr.taggedVMs(ctx, warmPoolTagPrefix+nodegroup)
+}
+
+// activateStandby takes one powered-off VM from the node group's warm pool,
+// refreshes its guestinfo (user-data may embed a short-lived bootstrap
+// token), moves it from the warm pool tag to the node group tag and powers
+// it on. Returns false when the pool is disabled or empty.
+func (mgr *vsphereManagerGovmomi) activateStandby(ctx context.Context, nodegroup string) (bool, error) {
+	if !mgr.warmPoolEnabled(nodegroup) {
+		return false, nil
+	}
+
+	mgr.warmPoolMutex.Lock()
+	defer mgr.warmPoolMutex.Unlock()
+
+	refs, err := mgr.warmPoolVMs(ctx, nodegroup)
+	if err != nil {
+		return false, err
+	}
+	if len(refs) == 0 {
+		return false, nil
+	}
+
+	ref := refs[0]
+	vms, err := mgr.retrieveVMProperties(ctx, []types.ManagedObjectReference{ref}, []string{"name"})
+	if err != nil || len(vms) != 1 {
+		return false, fmt.Errorf("could not get name of standby VM %s: %v", ref.Value, err)
+	}
+	name := vms[0].Name
+	vm := object.NewVirtualMachine(mgr.activeClient().Vim, ref)
+
+	configSpec, err := mgr.buildConfigSpec(nodegroup, name)
+	if err != nil {
+		return false, err
+	}
+	if configSpec != nil {
+		task, err := vm.Reconfigure(ctx, *configSpec)
+		if err != nil {
+			return false, fmt.Errorf("could not start reconfigure of standby VM %s: %v", name, err)
+		}
+		if err := task.Wait(ctx); err != nil {
+			return false, fmt.Errorf("reconfigure of standby VM %s failed: %v", name, err)
+		}
+	}
+
+	warmTagID, err := mgr.activeTags().GetTagID(ctx, warmPoolTagPrefix+nodegroup)
+	if err != nil {
+		return false, err
+	}
+	if err := mgr.tagNode(ctx, nodegroup, ref); err != nil {
+		return false, err
+	}
+	if err := mgr.activeTags().DetachTag(ctx, warmTagID, ref); err != nil {
+		return false, fmt.Errorf("could not detach warm pool tag from %s: %v", name, err)
+	}
+
+	task, err := vm.PowerOn(ctx)
+	if err != nil {
+		err = fmt.Errorf("could not start power on of standby VM %s: %v", name, err)
+		mgr.auditRecord("power-on", nodegroup, name, "scale-up", "", err)
+		return false, err
+	}
+	if err := task.Wait(ctx); err != nil {
+		err = fmt.Errorf("power on of standby VM %s failed: %v", name, err)
+		mgr.auditRecord("power-on", nodegroup, name, "scale-up", task.Reference().Value, err)
+		return false, err
+	}
+	mgr.auditRecord("power-on", nodegroup, name, "scale-up", task.Reference().Value, nil)
+
+	klog.V(1).Infof("Activated standby VM %s for node group %s", name, nodegroup)
+	return true, nil
+}
+
+// replenishWarmPool clones standby VMs until the node group's warm pool is
+// back at its configured size. Meant to run in the background; failures are
+// logged and retried on the next replenish.
+func (mgr *vsphereManagerGovmomi) replenishWarmPool(nodegroup string) {
+	target := mgr.config.warmPoolSizeFor(nodegroup)
+	if target == 0 && mgr.warmPoolTTL(nodegroup) == 0 {
+		return
+	}
+
+	mgr.warmPoolMutex.Lock()
+	defer mgr.warmPoolMutex.Unlock()
+
+	ctx := context.TODO()
+	refs, err := mgr.warmPoolVMs(ctx, nodegroup)
+	if err != nil {
+		klog.Errorf("Could not check warm pool of %s: %v", nodegroup, err)
+		return
+	}
+	refs = mgr.reapWarmPool(ctx, nodegroup, refs)
+
+	for i := len(refs); i < target; i++ {
+		if err := mgr.cloneStandby(ctx, nodegroup); err != nil {
+			klog.Errorf("Could not replenish warm pool of %s: %v", nodegroup, err)
+			return
+		}
+	}
+}
+
+// warmPoolTTL returns the configured pool TTL of a node group; zero means
+// pooled VMs are kept forever. Invalid values were rejected at parse time.
+func (mgr *vsphereManagerGovmomi) warmPoolTTL(nodegroup string) time.Duration {
+	ng, ok := mgr.config.NodeGroup[nodegroup]
+	if !ok {
+		return 0
+	}
+	return ng.WarmPoolTTL.value
+}
+
+// reapWarmPool destroys pooled VMs that have been idle longer than the
+// node group's TTL and returns the remaining references.
+func (mgr *vsphereManagerGovmomi) reapWarmPool(ctx context.Context, nodegroup string, refs []types.ManagedObjectReference) []types.ManagedObjectReference {
+	ttl := mgr.warmPoolTTL(nodegroup)
+	if ttl == 0 || len(refs) == 0 {
+		return refs
+	}
+
+	vms, err := mgr.retrieveVMProperties(ctx, refs, []string{"name", "config.extraConfig"})
+	if err != nil {
+		klog.Errorf("Could not check warm pool ages of %s: %v", nodegroup, err)
+		return refs
+	}
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		klog.Errorf("Could not reap warm pool of %s: %v", nodegroup, err)
+		return refs
+	}
+
+	var remaining []types.ManagedObjectReference
+	for _, vm := range vms {
+		pooledAt := pooledAtOf(&vm)
+		if pooledAt.IsZero() || time.Since(pooledAt) < ttl {
+			remaining = append(remaining, vm.Reference())
+			continue
+		}
+		klog.V(1).Infof("Destroying pooled VM %s of %s, idle since %s", vm.Name, nodegroup, pooledAt.Format(time.RFC3339))
+		if err := mgr.destroyVM(ctx, finder, nodegroup, vm.Name); err != nil {
+			klog.Errorf("Could not destroy expired pooled VM %s: %v", vm.Name, err)
+			remaining = append(remaining, vm.Reference())
+		}
+	}
+	return remaining
+}
+
+// pooledAtOf reads the pooled-at stamp of a VM; zero when absent.
+func pooledAtOf(vm *mo.VirtualMachine) time.Time {
+	if vm.Config == nil {
+		return time.Time{}
+	}
+	for _, option := range vm.Config.ExtraConfig {
+		value := option.GetOptionValue()
+		if value == nil || value.Key != pooledAtKey {
+			continue
+		}
+		if s, ok := value.Value.(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// stampPooledAt records when a VM entered the warm pool.
+func (mgr *vsphereManagerGovmomi) stampPooledAt(ctx context.Context, ref types.ManagedObjectReference) error {
+	vm := object.NewVirtualMachine(mgr.activeClient().Vim, ref)
+	task, err := vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{
+		ExtraConfig: []types.BaseOptionValue{
+			&types.OptionValue{Key: pooledAtKey, Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not stamp pooled VM: %v", err)
+	}
+	return task.Wait(ctx)
+}
+
+// hibernateVM powers off or suspends a node's VM and returns it to the
+// node group's warm pool instead of destroying it, for fast scale cycles.
+func (mgr *vsphereManagerGovmomi) hibernateVM(ctx context.Context, finder *find.Finder, nodegroup, name string) error {
+	vm, err := finder.VirtualMachine(ctx, name)
+	if err != nil {
+		return fmt.Errorf("could not find VM %s: %v", name, err)
+	}
+	if err := mgr.config.checkPathAllowed("VM", vm.InventoryPath); err != nil {
+		return err
+	}
+	if mgr.config.verifyIdentityFor(nodegroup) {
+		if err := mgr.verifyVMIdentity(ctx, vm.Reference(), nodegroup, name); err != nil {
+			return err
+		}
+	}
+	if err := mgr.checkCreatedByMarker(ctx, vm.Reference(), name); err != nil {
+		return err
+	}
+	if err := mgr.checkIgnoreTag(ctx, vm.Reference(), name); err != nil {
+		return err
+	}
+	if err := mgr.checkClusterMembership(ctx, vm.Reference(), name); err != nil {
+		return err
+	}
+	if err := mgr.checkGPUWorkloads(ctx, nodegroup, name); err != nil {
+		return err
+	}
+	ref := vm.Reference()
+
+	var task *object.Task
+	action := "power-off"
+	if mgr.config.scaleDownModeFor(nodegroup) == scaleDownModeSuspend {
+		task, err = vm.Suspend(ctx)
+		action = "suspend"
+	} else {
+		task, err = vm.PowerOff(ctx)
+	}
+	if err != nil {
+		err = fmt.Errorf("could not start hibernation of %s: %v", name, err)
+		mgr.auditRecord(action, nodegroup, name, "scale-down", "", err)
+		return err
+	}
+	if err := task.Wait(ctx); err != nil {
+		err = fmt.Errorf("hibernation of %s failed: %v", name, err)
+		mgr.auditRecord(action, nodegroup, name, "scale-down", task.Reference().Value, err)
+		return err
+	}
+	mgr.auditRecord(action, nodegroup, name, "scale-down", task.Reference().Value, nil)
+
+	if err := mgr.stampPooledAt(ctx, ref); err != nil {
+		return err
+	}
+
+	warmTagID, err := mgr.activeTags().GetTagID(ctx, warmPoolTagPrefix+nodegroup)
+	if err != nil {
+		return err
+	}
+	groupTagID, err := mgr.activeTags().GetTagID(ctx, mgr.nodeGroupTagName(nodegroup))
+	if err != nil {
+		return err
+	}
+	if err := mgr.activeTags().AttachTag(ctx, warmTagID, ref); err != nil {
+		return fmt.Errorf("could not attach warm pool tag to %s: %v", name, err)
+	}
+	if err := mgr.activeTags().DetachTag(ctx, groupTagID, ref); err != nil {
+		return fmt.Errorf("could not detach node group tag from %s: %v", name, err)
+	}
+	mgr.invalidateInventory()
+
+	klog.V(1).Infof("Returned VM %s to the warm pool of %s", name, nodegroup)
+	return nil
+}
+
+// cloneStandby clones one powered-off standby VM into the warm pool.
+func (mgr *vsphereManagerGovmomi) cloneStandby(ctx context.Context, nodegroup string) error {
+	name := fmt.Sprintf("%s-%s", nodegroup, uuid.NewV4().String()[:8])
+	klog.V(2).Infof("Cloning standby VM %s into warm pool of %s", name, nodegroup)
+
+	vmRef, err := mgr.cloneVM(ctx, nodegroup, name, false)
+	if err != nil {
+		return err
+	}
+
+	clusterTagID, err := mgr.activeTags().GetTagID(ctx, mgr.clusterTagName())
+	if err != nil {
+		return err
+	}
+	warmTagID, err := mgr.activeTags().GetTagID(ctx, warmPoolTagPrefix+nodegroup)
+	if err != nil {
+		return err
+	}
+	if err := mgr.activeTags().AttachTag(ctx, clusterTagID, vmRef); err != nil {
+		return fmt.Errorf("could not attach cluster tag to standby VM %s: %v", name, err)
+	}
+	if err := mgr.activeTags().AttachTag(ctx, warmTagID, vmRef); err != nil {
+		return fmt.Errorf("could not attach warm pool tag to standby VM %s: %v", name, err)
+	}
+	if err := mgr.stampPooledAt(ctx, vmRef); err != nil {
+		return err
+	}
+	if mgr.state != nil {
+		mgr.state.removePendingNode(nodegroup, name)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/types"
+	"k8s.io/klog"
+)
+
+const (
+	// watcherRetryDelay is how long the inventory watcher waits before
+	// re-establishing a dropped WaitForUpdatesEx session.
+	watcherRetryDelay = 30 * time.Second
+
+	// watcherMaxInventoryAge replaces maxInventoryAge while the watcher is
+	// live. The watcher invalidates the snapshot on VM changes, so the
+	// age bound only guards against tag changes made behind our back.
+	watcherMaxInventoryAge = 5 * time.Minute
+)
+
+// inventoryWatcher tracks whether the WaitForUpdatesEx session on the
+// cluster folder is currently live. While it is, the inventory snapshot
+// stays valid until the watcher invalidates it, instead of expiring by
+// age, and the per-loop tag sweep can be skipped.
+type inventoryWatcher struct {
+	mutex sync.Mutex
+	live  bool
+}
+
+func (w *inventoryWatcher) setLive(live bool) {
+	w.mutex.Lock()
+	w.live = live
+	w.mutex.Unlock()
+}
+
+func (w *inventoryWatcher) isLive() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.live
+}
+
+// startInventoryWatcher runs watchInventory forever, re-establishing the
+// session after errors. Run as a goroutine from the constructor when
+// inventory-watcher is enabled.
+func (mgr *vsphereManagerGovmomi) startInventoryWatcher() {
+	for {
+		err := mgr.watchInventory(context.Background())
+		mgr.watcher.setLive(false)
+		klog.Warningf("Inventory watcher stopped: %v, retrying in %s", err, watcherRetryDelay)
+		time.Sleep(watcherRetryDelay)
+	}
+}
+
+// watchInventory opens a WaitForUpdatesEx session on the VMs below the
+// cluster folder and invalidates the inventory snapshot whenever one of
+// them changes, so lookups between sweeps never serve stale data. Only
+// returns on error.
+func (mgr *vsphereManagerGovmomi) watchInventory(ctx context.Context) error {
+	finder, err := mgr.finder(ctx)
+	if err != nil {
+		return err
+	}
+	folder, err := finder.FolderOrDefault(ctx, mgr.config.Global.Folder)
+	if err != nil {
+		return fmt.Errorf("could not find folder to watch: %v", err)
+	}
+
+	// Recurse from the folder through nested folders down to the VMs.
+	folderTraversal := &types.TraversalSpec{
+		SelectionSpec: types.SelectionSpec{Name: "folders"},
+		Type:          "Folder",
+		Path:          "childEntity",
+		SelectSet: []types.BaseSelectionSpec{
+			&types.SelectionSpec{Name: "folders"},
+		},
+	}
+	filter := new(property.WaitFilter).Add(folder.Reference(), "VirtualMachine", []string{"name", "runtime.powerState"}, folderTraversal)
+
+	pc := property.DefaultCollector(mgr.activeClient().Vim)
+	first := true
+	return property.WaitForUpdates(ctx, pc, filter, func(updates []types.ObjectUpdate) bool {
+		if first {
+			// The opening update set enumerates the existing VMs; only
+			// later sets are changes.
+			first = false
+			mgr.watcher.setLive(true)
+			klog.V(2).Infof("Inventory watcher live on folder %s, watching %d VMs", folder.InventoryPath, len(updates))
+			return false
+		}
+		klog.V(4).Infof("Inventory watcher saw %d VM updates", len(updates))
+		mgr.invalidateInventory()
+		return false
+	})
+}
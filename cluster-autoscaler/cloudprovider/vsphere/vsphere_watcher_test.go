@@ -0,0 +1,51 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestWatcherExtendsSnapshotAge(t *testing.T) {
+	mgr := testManager(newFakeTagService())
+	mgr.inventory = &inventorySnapshot{
+		vmsByGroup: map[string][]types.ManagedObjectReference{
+			"ng1": {vmRef("vm-1")},
+		},
+		taken: time.Now().Add(-2 * time.Minute),
+	}
+
+	// Older than maxInventoryAge: a plain sweep result has expired.
+	_, ok := mgr.cachedNodeGroupVMs("ng1")
+	assert.False(t, ok)
+
+	// A live watcher would have invalidated the snapshot on any change,
+	// so the same snapshot is still trusted.
+	mgr.watcher.setLive(true)
+	vms, ok := mgr.cachedNodeGroupVMs("ng1")
+	assert.True(t, ok)
+	assert.Len(t, vms, 1)
+
+	// Until the watcher reports a change.
+	mgr.invalidateInventory()
+	_, ok = mgr.cachedNodeGroupVMs("ng1")
+	assert.False(t, ok)
+}
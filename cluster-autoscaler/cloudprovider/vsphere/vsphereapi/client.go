@@ -0,0 +1,273 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphereapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"k8s.io/klog"
+)
+
+// ConnectOptions are the parameters for connecting to vCenter.
+type ConnectOptions struct {
+	// VCenter is the vCenter URL or host name.
+	VCenter string
+	// User is the vCenter user name.
+	User string
+	// Password is the vCenter password.
+	Password string
+	// Insecure skips TLS certificate verification when true.
+	Insecure bool
+	// MinTLSVersion is the lowest TLS protocol version accepted, as a
+	// crypto/tls VersionTLS constant; zero keeps Go's default.
+	MinTLSVersion uint16
+	// CipherSuites restricts the offered TLS cipher suites to the given
+	// crypto/tls IDs; nil keeps Go's default selection.
+	CipherSuites []uint16
+}
+
+// CallRecorder receives a callback for every vSphere Automation (vAPI)
+// call a Client makes, so embedders can account API usage.
+type CallRecorder interface {
+	// RecordVAPICall is called after each vAPI call with its duration.
+	RecordVAPICall(elapsed time.Duration)
+}
+
+// Client holds the SOAP and REST clients used to talk to vCenter. It
+// implements TagService.
+type Client struct {
+	// Vim is the client for the vSphere SOAP API.
+	Vim *vim25.Client
+	// Rest is the client for the vSphere Automation (REST) API.
+	Rest *rest.Client
+	// Tags is the manager for the vSphere tagging service.
+	Tags *tags.Manager
+
+	// recorder, when set, accounts the vAPI calls made through this client.
+	recorder CallRecorder
+
+	// tagIDs caches tag name→ID lookups, with negative entries.
+	tagIDs tagIDCache
+
+	// tagCategory, when set, scopes tag sweeps to one category; see
+	// ScopeTagsToCategory.
+	tagCategory string
+	// tagCategoryID is the lazily resolved ID of tagCategory.
+	tagCategoryID string
+}
+
+// Connect connects and logs in to vCenter, for both the SOAP and the
+// REST endpoints.
+func Connect(ctx context.Context, opts ConnectOptions) (*Client, error) {
+	u, err := soap.ParseURL(opts.VCenter)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse vcenter URL %s: %v", opts.VCenter, err)
+	}
+	u.User = url.UserPassword(opts.User, opts.Password)
+
+	soapClient := soap.NewClient(u, opts.Insecure)
+	if opts.MinTLSVersion != 0 || len(opts.CipherSuites) > 0 {
+		// The REST client reuses this transport, so the settings cover
+		// both endpoints.
+		tlsConfig := soapClient.Transport.(*http.Transport).TLSClientConfig
+		tlsConfig.MinVersion = opts.MinTLSVersion
+		tlsConfig.CipherSuites = opts.CipherSuites
+	}
+	vimClient, err := vim25.NewClient(ctx, soapClient)
+	if err != nil {
+		return nil, fmt.Errorf("could not create vim25 client: %v", err)
+	}
+
+	sessionManager := session.NewManager(vimClient)
+	if err := sessionManager.Login(ctx, u.User); err != nil {
+		return nil, fmt.Errorf("could not login to vcenter %s: %v", u.Host, err)
+	}
+
+	restClient := rest.NewClient(vimClient)
+	if err := restClient.Login(ctx, u.User); err != nil {
+		return nil, fmt.Errorf("could not login to vcenter REST endpoint %s: %v", u.Host, err)
+	}
+
+	klog.V(4).Infof("Logged in to vcenter %s", u.Host)
+
+	return &Client{
+		Vim:  vimClient,
+		Rest: restClient,
+		Tags: tags.NewManager(restClient),
+	}, nil
+}
+
+// SetCallRecorder starts reporting this client's vAPI calls to the given
+// recorder. SOAP calls can be accounted by wrapping Vim.RoundTripper.
+func (c *Client) SetCallRecorder(recorder CallRecorder) {
+	c.recorder = recorder
+}
+
+// recordVAPI accounts one vAPI call that started at the given time; a nop
+// when no recorder is set.
+func (c *Client) recordVAPI(start time.Time) {
+	if c.recorder != nil {
+		c.recorder.RecordVAPICall(time.Since(start))
+	}
+}
+
+// ScopeTagsToCategory restricts tag name lookups to the given category:
+// GetTagID sweeps only the category's tags instead of every tag in the
+// vCenter, which on inventories with tens of thousands of tags is the
+// difference between a handful of calls and a timeout. Names not found in
+// the category still fall back to the full sweep, so tags living in other
+// categories keep resolving, just slower.
+func (c *Client) ScopeTagsToCategory(categoryName string) {
+	c.tagCategory = categoryName
+}
+
+// sweepTagIDs lists the tag IDs a GetTagID sweep has to inspect: the
+// configured category's tags when scoped, every tag otherwise. A scoped
+// sweep against a category that does not exist yet returns no IDs.
+func (c *Client) sweepTagIDs(ctx context.Context, scoped bool) ([]string, error) {
+	if !scoped {
+		return c.Tags.ListTags(ctx)
+	}
+	if c.tagCategoryID == "" {
+		id, err := c.GetCategoryID(ctx, c.tagCategory)
+		if err != nil {
+			return nil, err
+		}
+		c.tagCategoryID = id
+	}
+	if c.tagCategoryID == "" {
+		return nil, nil
+	}
+	return c.Tags.ListTagsForCategory(ctx, c.tagCategoryID)
+}
+
+// GetTagID returns the ID of the tag with the given name, or a
+// TagNotFoundError if no such tag exists. Lookups are served from an
+// expiring cache, with a shorter TTL for cached misses; a cache miss
+// sweeps the scoped category first, then all tags, and caches every tag
+// it sees on the way, so one sweep serves the sibling lookups too.
+func (c *Client) GetTagID(ctx context.Context, tagName string) (string, error) {
+	if id, ok := c.tagIDs.get(tagName); ok {
+		if id == "" {
+			return "", &TagNotFoundError{Name: tagName}
+		}
+		return id, nil
+	}
+	defer c.recordVAPI(time.Now())
+
+	scopes := []bool{false}
+	if c.tagCategory != "" {
+		scopes = []bool{true, false}
+	}
+	for _, scoped := range scopes {
+		ids, err := c.sweepTagIDs(ctx, scoped)
+		if err != nil {
+			return "", fmt.Errorf("could not list tags: %v", err)
+		}
+		found := ""
+		for _, id := range ids {
+			tag, err := c.Tags.GetTag(ctx, id)
+			if err != nil {
+				return "", fmt.Errorf("could not get tag %s: %v", id, err)
+			}
+			c.tagIDs.put(tag.Name, tag.ID)
+			if tag.Name == tagName {
+				found = tag.ID
+			}
+		}
+		if found != "" {
+			return found, nil
+		}
+	}
+	c.tagIDs.put(tagName, "")
+	return "", &TagNotFoundError{Name: tagName}
+}
+
+// AttachTag attaches a tag to an object.
+func (c *Client) AttachTag(ctx context.Context, tagID string, ref mo.Reference) error {
+	defer c.recordVAPI(time.Now())
+	return c.Tags.AttachTag(ctx, tagID, ref)
+}
+
+// DetachTag detaches a tag from an object.
+func (c *Client) DetachTag(ctx context.Context, tagID string, ref mo.Reference) error {
+	defer c.recordVAPI(time.Now())
+	return c.Tags.DetachTag(ctx, tagID, ref)
+}
+
+// ListAttachedObjects returns the objects a tag is attached to.
+func (c *Client) ListAttachedObjects(ctx context.Context, tagID string) ([]mo.Reference, error) {
+	defer c.recordVAPI(time.Now())
+	return c.Tags.ListAttachedObjects(ctx, tagID)
+}
+
+// GetCategoryID returns the ID of the tag category with the given name, or
+// an empty string if no such category exists.
+func (c *Client) GetCategoryID(ctx context.Context, categoryName string) (string, error) {
+	defer c.recordVAPI(time.Now())
+	categories, err := c.Tags.GetCategories(ctx)
+	if err != nil {
+		return "", fmt.Errorf("could not list tag categories: %v", err)
+	}
+	for _, category := range categories {
+		if category.Name == categoryName {
+			return category.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// CreateCategory creates a tag category and returns its ID.
+func (c *Client) CreateCategory(ctx context.Context, categoryName, cardinality string, associableTypes []string) (string, error) {
+	defer c.recordVAPI(time.Now())
+	id, err := c.Tags.CreateCategory(ctx, &tags.Category{
+		Name:            categoryName,
+		Description:     "Managed by the cluster autoscaler",
+		Cardinality:     cardinality,
+		AssociableTypes: associableTypes,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not create tag category %s: %v", categoryName, err)
+	}
+	return id, nil
+}
+
+// CreateTag creates a tag in the given category and returns its ID.
+func (c *Client) CreateTag(ctx context.Context, tagName, categoryID string) (string, error) {
+	defer c.recordVAPI(time.Now())
+	id, err := c.Tags.CreateTag(ctx, &tags.Tag{
+		Name:        tagName,
+		Description: "Managed by the cluster autoscaler",
+		CategoryID:  categoryID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not create tag %s: %v", tagName, err)
+	}
+	// Drop a possible negative cache entry from before the creation.
+	c.tagIDs.invalidate(tagName)
+	return id, nil
+}
@@ -0,0 +1,28 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vsphereapi is the exported, embeddable layer of the cluster
+// autoscaler's vSphere integration. It provides the vCenter connection
+// (Client, Connect, ConnectOptions), the tagging service abstraction
+// (TagService, with typed TagNotFoundError and a tag-ID cache) and the
+// Manager interface describing the node lifecycle operations the
+// autoscaler's managers implement.
+//
+// The cloudprovider/vsphere package builds its provider on top of this
+// package; other tools that want to reuse the clone, tag or inventory
+// logic can embed it without pulling in the cloudprovider glue. Use
+// vsphere.NewManagerAPI to obtain a Manager from a cloud config.
+package vsphereapi
@@ -0,0 +1,47 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphereapi
+
+// NodeRef identifies one node VM of a node group.
+type NodeRef struct {
+	// Name is the VM (and node) name.
+	Name string
+	// MachineID is the Kubernetes machine ID, when known.
+	MachineID string
+	// ProviderID is the node's provider ID, when known.
+	ProviderID string
+	// IPs are the node's addresses, when known.
+	IPs []string
+}
+
+// Manager is the node lifecycle surface of the autoscaler's vSphere
+// managers, for tools that want to drive node groups without going
+// through the cloudprovider interfaces. All operations are keyed by the
+// node group name from the cloud config.
+//
+// Use vsphere.NewManagerAPI to obtain an implementation from a cloud
+// config; the same config file the autoscaler runs with works unchanged.
+type Manager interface {
+	// NodeGroupSize returns the current number of VMs in a node group.
+	NodeGroupSize(nodegroup string) (int, error)
+	// CreateNodes adds the given number of nodes to a node group.
+	CreateNodes(nodegroup string, count int) error
+	// DeleteNodes deletes the given nodes from a node group.
+	DeleteNodes(nodegroup string, nodes []NodeRef) error
+	// NodeNames returns the names of a node group's current nodes.
+	NodeNames(nodegroup string) ([]string, error)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphereapi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+// TagService is the subset of the vSphere tagging API the autoscaler
+// depends on, as an interface so tests and embedders can substitute an
+// in-memory implementation. Client implements it against vCenter.
+type TagService interface {
+	// GetTagID returns the ID of the tag with the given name, or a
+	// TagNotFoundError if no such tag exists.
+	GetTagID(ctx context.Context, tagName string) (string, error)
+	// AttachTag attaches a tag to an object.
+	AttachTag(ctx context.Context, tagID string, ref mo.Reference) error
+	// DetachTag detaches a tag from an object.
+	DetachTag(ctx context.Context, tagID string, ref mo.Reference) error
+	// ListAttachedObjects returns the objects a tag is attached to.
+	ListAttachedObjects(ctx context.Context, tagID string) ([]mo.Reference, error)
+	// GetCategoryID returns the ID of the tag category with the given
+	// name, or an empty string if no such category exists.
+	GetCategoryID(ctx context.Context, categoryName string) (string, error)
+	// CreateCategory creates a tag category and returns its ID.
+	CreateCategory(ctx context.Context, categoryName, cardinality string, associableTypes []string) (string, error)
+	// CreateTag creates a tag in the given category and returns its ID.
+	CreateTag(ctx context.Context, tagName, categoryID string) (string, error)
+}
+
+// TagNotFoundError is returned by GetTagID when no tag with the requested
+// name exists, so callers can tell a missing tag apart from a lookup
+// failure.
+type TagNotFoundError struct {
+	// Name is the tag name that was looked up.
+	Name string
+}
+
+// Error implements the error interface.
+func (e *TagNotFoundError) Error() string {
+	return fmt.Sprintf("tag %s not found", e.Name)
+}
+
+// IsTagNotFound reports whether an error is a TagNotFoundError.
+func IsTagNotFound(err error) bool {
+	_, ok := err.(*TagNotFoundError)
+	return ok
+}
+
+const (
+	// tagIDCacheTTL is how long a resolved tag ID is served from the
+	// cache. Tag IDs never change for a given tag; the TTL only bounds
+	// how long a deleted and recreated tag is served under its old ID.
+	tagIDCacheTTL = 10 * time.Minute
+
+	// tagIDNegativeCacheTTL is how long a failed lookup is cached. Kept
+	// short so a tag created out of band is picked up quickly.
+	tagIDNegativeCacheTTL = 30 * time.Second
+)
+
+// tagIDCache caches tag name to ID lookups with expiry, including
+// negative entries for names that did not resolve.
+type tagIDCache struct {
+	mutex   sync.Mutex
+	entries map[string]tagIDCacheEntry
+}
+
+type tagIDCacheEntry struct {
+	id      string
+	expires time.Time
+}
+
+// get returns the cached ID for a tag name. ok is false when the name was
+// never looked up or the entry expired; ok with an empty id means the
+// last lookup found no such tag.
+func (c *tagIDCache) get(name string) (id string, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[name]
+	if !ok || time.Now().After(entry.expires) {
+		return "", false
+	}
+	return entry.id, true
+}
+
+// put caches a lookup result; an empty id is a negative entry with the
+// shorter TTL.
+func (c *tagIDCache) put(name, id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]tagIDCacheEntry)
+	}
+	ttl := tagIDCacheTTL
+	if id == "" {
+		ttl = tagIDNegativeCacheTTL
+	}
+	c.entries[name] = tagIDCacheEntry{id: id, expires: time.Now().Add(ttl)}
+}
+
+// invalidate drops the entry for a tag name, if any.
+func (c *tagIDCache) invalidate(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, name)
+}
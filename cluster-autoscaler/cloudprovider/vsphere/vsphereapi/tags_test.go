@@ -0,0 +1,59 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphereapi
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagIDCache(t *testing.T) {
+	cache := &tagIDCache{}
+
+	_, ok := cache.get("k8s-cluster-test")
+	assert.False(t, ok, "empty cache has no entries")
+
+	cache.put("k8s-cluster-test", "tag-42")
+	id, ok := cache.get("k8s-cluster-test")
+	assert.True(t, ok)
+	assert.Equal(t, "tag-42", id)
+
+	// A negative entry is a valid result with an empty ID.
+	cache.put("k8s-adopt-pool-a", "")
+	id, ok = cache.get("k8s-adopt-pool-a")
+	assert.True(t, ok)
+	assert.Empty(t, id)
+
+	// Creating the tag invalidates the cached miss.
+	cache.invalidate("k8s-adopt-pool-a")
+	_, ok = cache.get("k8s-adopt-pool-a")
+	assert.False(t, ok)
+
+	// Expired entries are not served.
+	cache.entries["k8s-cluster-test"] = tagIDCacheEntry{id: "tag-42"}
+	_, ok = cache.get("k8s-cluster-test")
+	assert.False(t, ok)
+}
+
+func TestIsTagNotFound(t *testing.T) {
+	assert.True(t, IsTagNotFound(&TagNotFoundError{Name: "k8s-cluster-test"}))
+	assert.False(t, IsTagNotFound(nil))
+	assert.False(t, IsTagNotFound(fmt.Errorf("could not list tags: 503")))
+	assert.Contains(t, (&TagNotFoundError{Name: "k8s-cluster-test"}).Error(), "k8s-cluster-test")
+}
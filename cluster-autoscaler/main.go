@@ -305,6 +305,8 @@ func buildAutoscaler() (core.Autoscaler, error) {
 		nodeInfoComparatorBuilder = nodegroupset.CreateAzureNodeInfoComparator
 	} else if autoscalingOptions.CloudProviderName == cloudprovider.AwsProviderName {
 		nodeInfoComparatorBuilder = nodegroupset.CreateAwsNodeInfoComparator
+	} else if autoscalingOptions.CloudProviderName == cloudprovider.VsphereProviderName {
+		nodeInfoComparatorBuilder = nodegroupset.CreateVsphereNodeInfoComparator
 	}
 
 	opts.Processors.NodeGroupSetProcessor = &nodegroupset.BalancingNodeGroupSetProcessor{
@@ -378,6 +380,9 @@ func main() {
 			defaultMetricsHandler(w, req)
 		})
 		pathRecorderMux.HandleFunc("/health-check", healthCheck.ServeHTTP)
+		// Serve handlers components registered on the default mux, such as
+		// cloud provider debug endpoints.
+		pathRecorderMux.NotFoundHandler(http.DefaultServeMux)
 		if *enableProfiling {
 			routes.Profiling{}.Install(pathRecorderMux)
 		}
@@ -0,0 +1,45 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodegroupset
+
+import (
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+// VsphereNodegroupLabel is the label the vsphere cloud provider puts on
+// template nodes with the name of their node group.
+const VsphereNodegroupLabel = "vsphere.vmware.com/nodegroup"
+
+// CreateVsphereNodeInfoComparator returns a comparator that checks if two nodes
+// should be considered part of the same NodeGroupSet. Node groups cloned from
+// the same template size carry the same instance type and hardware, so they
+// compare as similar once the vsphere node group label is ignored; this lets
+// --balance-similar-node-groups spread scale-ups across resource pools or sites.
+func CreateVsphereNodeInfoComparator(extraIgnoredLabels []string) NodeInfoComparator {
+	vsphereIgnoredLabels := make(map[string]bool)
+	for k, v := range BasicIgnoredLabels {
+		vsphereIgnoredLabels[k] = v
+	}
+	vsphereIgnoredLabels[VsphereNodegroupLabel] = true
+	for _, k := range extraIgnoredLabels {
+		vsphereIgnoredLabels[k] = true
+	}
+
+	return func(n1, n2 *schedulernodeinfo.NodeInfo) bool {
+		return IsCloudProviderNodeInfoSimilar(n1, n2, vsphereIgnoredLabels)
+	}
+}
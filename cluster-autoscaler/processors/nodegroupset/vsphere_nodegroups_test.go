@@ -0,0 +1,47 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodegroupset
+
+import (
+	"testing"
+
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+)
+
+func TestIsVsphereNodeInfoSimilar(t *testing.T) {
+	comparator := CreateVsphereNodeInfoComparator([]string{"example.com/ready"})
+	n1 := BuildTestNode("node1", 1000, 2000)
+	n2 := BuildTestNode("node2", 1000, 2000)
+	// Different node group labels, everything else equal.
+	n1.ObjectMeta.Labels[VsphereNodegroupLabel] = "pool-a"
+	n2.ObjectMeta.Labels[VsphereNodegroupLabel] = "pool-b"
+	checkNodesSimilar(t, n1, n2, comparator, true)
+	// Different instance types (template sizes) are not similar.
+	n1.ObjectMeta.Labels["beta.kubernetes.io/instance-type"] = "template-small"
+	n2.ObjectMeta.Labels["beta.kubernetes.io/instance-type"] = "template-large"
+	checkNodesSimilar(t, n1, n2, comparator, false)
+	// Same template size is similar again.
+	n2.ObjectMeta.Labels["beta.kubernetes.io/instance-type"] = "template-small"
+	checkNodesSimilar(t, n1, n2, comparator, true)
+	// Extra ignored labels do not break similarity.
+	n1.ObjectMeta.Labels["example.com/ready"] = "true"
+	n2.ObjectMeta.Labels["example.com/ready"] = "false"
+	checkNodesSimilar(t, n1, n2, comparator, true)
+	// Other differing labels do.
+	n1.ObjectMeta.Labels["custom"] = "value"
+	checkNodesSimilar(t, n1, n2, comparator, false)
+}